@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// The ♿ panel, and `mdview a11y` for docs CI, check a document for what
+// makes it hard to read with a screen reader or poor eyesight: images
+// without alt text, links whose text is a bare URL (or nothing), inline
+// HTML colored with too little contrast against the light or dark page,
+// and headings that skip a level. An image with an explicit alt="" is
+// decorative and passes.
+
+// An a11yIssue is one problem found, at a line of the source.
+type a11yIssue struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"` // image-alt, link-text, contrast or heading-order
+	Message string `json:"message"`
+}
+
+// minContrast is WCAG AA's ratio for body text.
+const minContrast = 4.5
+
+var (
+	htmlImgPattern   = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	htmlAltPattern   = regexp.MustCompile(`(?i)\balt\s*=`)
+	htmlStylePattern = regexp.MustCompile(`(?i)\bstyle\s*=\s*("[^"]*"|'[^']*')`)
+	cssColorPattern  = regexp.MustCompile(`(?i)(?:^|;)\s*(color|background-color|background)\s*:\s*([^;]+)`)
+	urlTextPattern   = regexp.MustCompile(`^(?i)(https?://|www\.)\S+$`)
+)
+
+// accessibilityIssues returns the problems in src, in source order.
+func accessibilityIssues(src []byte) []a11yIssue {
+	doc := markdown().Parser().Parse(text.NewReader(src), parseOptions(nil)...)
+	issues := []a11yIssue{}
+	add := func(at int, rule, msg string) {
+		issues = append(issues, a11yIssue{Line: bytes.Count(src[:at], []byte("\n")) + 1, Rule: rule, Message: msg})
+	}
+	lastLevel := 0
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			if lastLevel > 0 && n.Level > lastLevel+1 {
+				add(nodeStart(n), "heading-order", fmt.Sprintf("h%d follows h%d, skipping a level", n.Level, lastLevel))
+			}
+			lastLevel = n.Level
+		case *ast.Image:
+			if strings.TrimSpace(string(n.Text(src))) == "" {
+				add(nodeStart(n), "image-alt", "image "+string(n.Destination)+" has no alt text")
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL {
+				add(nodeStart(n), "link-text", "link text is the bare URL "+string(n.URL(src)))
+			}
+		case *ast.Link:
+			label := strings.TrimSpace(string(n.Text(src)))
+			if label == "" && n.FirstChild() == nil {
+				add(nodeStart(n), "link-text", "link to "+string(n.Destination)+" has no text")
+			} else if urlTextPattern.MatchString(label) {
+				add(nodeStart(n), "link-text", "link text is the bare URL "+label)
+			}
+		case *ast.RawHTML:
+			for i := 0; i < n.Segments.Len(); i++ {
+				s := n.Segments.At(i)
+				htmlIssues(s.Value(src), s.Start, add)
+			}
+		case *ast.HTMLBlock:
+			for i := 0; i < n.Lines().Len(); i++ {
+				s := n.Lines().At(i)
+				htmlIssues(s.Value(src), s.Start, add)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return issues
+}
+
+// nodeStart returns where n starts in the source: its first line, or its
+// first text for inline nodes.
+func nodeStart(n ast.Node) int {
+	for c := n; c != nil; c = c.Parent() {
+		if c.Type() == ast.TypeBlock && c.Lines().Len() > 0 {
+			start := c.Lines().At(0).Start
+			if c != n {
+				start = textStart(n, start)
+			}
+			return start
+		}
+	}
+	return 0
+}
+
+// textStart returns where the first text under n starts, or else fallback.
+func textStart(n ast.Node, fallback int) int {
+	start := fallback
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := c.(*ast.Text); ok && entering {
+			start = t.Segment.Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return start
+}
+
+// htmlIssues checks raw HTML found at offset.
+func htmlIssues(raw []byte, offset int, add func(at int, rule, msg string)) {
+	for _, loc := range htmlImgPattern.FindAllIndex(raw, -1) {
+		if !htmlAltPattern.Match(raw[loc[0]:loc[1]]) {
+			add(offset+loc[0], "image-alt", "<img> has no alt attribute")
+		}
+	}
+	for _, m := range htmlStylePattern.FindAllSubmatchIndex(raw, -1) {
+		style := string(raw[m[2]+1 : m[3]-1])
+		var fg, bg []float64
+		for _, d := range cssColorPattern.FindAllStringSubmatch(style, -1) {
+			c := parseCSSColor(d[2])
+			if c == nil {
+				continue
+			}
+			if strings.EqualFold(d[1], "color") {
+				fg = c
+			} else {
+				bg = c
+			}
+		}
+		if fg == nil {
+			continue
+		}
+		type page struct {
+			name string
+			bg   []float64
+		}
+		against := []page{{"the light page", []float64{255, 255, 255}}, {"the dark page", []float64{13, 17, 23}}}
+		if bg != nil {
+			against = []page{{"its background", bg}}
+		}
+		for _, p := range against {
+			if r := contrastRatio(fg, p.bg); r < minContrast {
+				add(offset+m[0], "contrast", fmt.Sprintf("text color has %.1f:1 contrast against %s (%.1f:1 needed)", r, p.name, minContrast))
+				break
+			}
+		}
+	}
+}
+
+// cssColorNames are the named colors parseCSSColor knows.
+var cssColorNames = map[string][]float64{
+	"black": {0, 0, 0}, "white": {255, 255, 255}, "gray": {128, 128, 128}, "grey": {128, 128, 128},
+	"silver": {192, 192, 192}, "lightgray": {211, 211, 211}, "lightgrey": {211, 211, 211},
+	"darkgray": {169, 169, 169}, "darkgrey": {169, 169, 169}, "red": {255, 0, 0}, "green": {0, 128, 0},
+	"blue": {0, 0, 255}, "yellow": {255, 255, 0}, "orange": {255, 165, 0}, "purple": {128, 0, 128},
+	"navy": {0, 0, 128}, "maroon": {128, 0, 0}, "lime": {0, 255, 0}, "aqua": {0, 255, 255},
+	"cyan": {0, 255, 255}, "fuchsia": {255, 0, 255}, "magenta": {255, 0, 255}, "pink": {255, 192, 203},
+}
+
+// parseCSSColor returns the RGB of a #hex, rgb() or named CSS color, or
+// nil for anything else.
+func parseCSSColor(v string) []float64 {
+	v = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "!important")))
+	if c, ok := cssColorNames[v]; ok {
+		return c
+	}
+	if strings.HasPrefix(v, "#") {
+		h := v[1:]
+		if len(h) == 3 {
+			h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+		}
+		if len(h) != 6 {
+			return nil
+		}
+		n, err := strconv.ParseUint(h, 16, 32)
+		if err != nil {
+			return nil
+		}
+		return []float64{float64(n >> 16), float64(n >> 8 & 0xff), float64(n & 0xff)}
+	}
+	if strings.HasPrefix(v, "rgb(") && strings.HasSuffix(v, ")") {
+		parts := strings.FieldsFunc(v[4:len(v)-1], func(r rune) bool { return r == ',' || r == ' ' })
+		if len(parts) != 3 {
+			return nil
+		}
+		c := make([]float64, 3)
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return nil
+			}
+			c[i] = f
+		}
+		return c
+	}
+	return nil
+}
+
+// contrastRatio is WCAG's contrast between two RGB colors.
+func contrastRatio(a, b []float64) float64 {
+	luminance := func(c []float64) float64 {
+		var l [3]float64
+		for i, v := range c {
+			v /= 255
+			if v <= 0.03928 {
+				l[i] = v / 12.92
+			} else {
+				l[i] = math.Pow((v+0.055)/1.055, 2.4)
+			}
+		}
+		return 0.2126*l[0] + 0.7152*l[1] + 0.0722*l[2]
+	}
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// handleA11y serves /api/a11y[?page=route]: the document's accessibility
+// issues.
+func handleA11y(w http.ResponseWriter, r *http.Request) {
+	if isLocked() || dirRoot != "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"issues": accessibilityIssues(docs.Get(requestedDocument(r)).Content)})
+}
+
+// runA11y implements `mdview a11y`.
+func runA11y(argv []string) error {
+	fs := flag.NewFlagSet("mdview a11y", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the issues as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdview a11y [options] [file or directory ...]\n\n")
+		fmt.Fprintf(os.Stderr, "Checks Markdown documents for images without alt text, links whose\n")
+		fmt.Fprintf(os.Stderr, "text is a bare URL, low-contrast inline HTML and skipped heading levels.\n")
+		fmt.Fprintf(os.Stderr, "Exits with status 1 when any are found.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	targets := parseCommandFlags(fs, argv)
+	if len(targets) == 0 {
+		targets = []string{"."}
+	}
+	var paths []string
+	for _, t := range targets {
+		if !isDirectory(t) {
+			paths = append(paths, t)
+			continue
+		}
+		files, err := listMarkdown(os.DirFS(t))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			paths = append(paths, filepath.Join(t, filepath.FromSlash(f.rel)))
+		}
+	}
+
+	all := []a11yIssue{}
+	for _, p := range paths {
+		src, err := readSource(p)
+		if err != nil {
+			return err
+		}
+		for _, issue := range accessibilityIssues(src) {
+			issue.File = p
+			all = append(all, issue)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(all); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range all {
+			fmt.Printf("%s:%d: %s: %s\n", issue.File, issue.Line, issue.Rule, issue.Message)
+		}
+	}
+	if len(all) > 0 {
+		return fmt.Errorf("%d accessibility issue(s) in %d document(s)", len(all), len(paths))
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// The "abc" block renderer engraves ```abc fences (ABC music notation) as
+// SVG: one treble staff per line of music, with key and time signatures,
+// notes, rests, accidentals, chords and bar lines. Ornaments, slurs, ties,
+// grace notes and multiple voices are read past but not drawn; the ABC
+// source stays available under the score.
+
+// An abcNote is a note, chord (several pitches) or rest (none).
+type abcNote struct {
+	steps       []int // diatonic steps above middle C
+	accidentals []string
+	length      float64 // in whole notes
+	chord       string  // "Am" above the staff
+}
+
+// An abcItem is a note or a bar line ("|", "||", "|]", "|:", ":|").
+type abcItem struct {
+	note *abcNote
+	bar  string
+}
+
+type abcTune struct {
+	title, composer, meter, key string
+	lines                       [][]abcItem
+}
+
+// abcSharpKeys gives the sharps (positive) or flats of each major key.
+var abcSharpKeys = map[string]int{
+	"C": 0, "G": 1, "D": 2, "A": 3, "E": 4, "B": 5, "F#": 6, "C#": 7,
+	"F": -1, "Bb": -2, "Eb": -3, "Ab": -4, "Db": -5, "Gb": -6, "Cb": -7,
+}
+
+// abcRelativeMajor maps minor keys to the major sharing their signature.
+var abcRelativeMajor = map[string]string{
+	"A": "C", "E": "G", "B": "D", "F#": "A", "C#": "E", "G#": "B", "D#": "F#", "A#": "C#",
+	"D": "F", "G": "Bb", "C": "Eb", "F": "Ab", "Bb": "Db", "Eb": "Gb", "Ab": "Cb",
+}
+
+// keySignature returns the sharps (or, negative, flats) of an ABC K: field.
+func keySignature(k string) int {
+	k = strings.TrimSpace(k)
+	if k == "" || strings.EqualFold(k, "none") {
+		return 0
+	}
+	tonic := k[:1]
+	rest := k[1:]
+	if len(rest) > 0 && (rest[0] == '#' || rest[0] == 'b') {
+		tonic += rest[:1]
+		rest = rest[1:]
+	}
+	mode := strings.ToLower(strings.TrimSpace(rest))
+	if strings.HasPrefix(mode, "m") && !strings.HasPrefix(mode, "mix") || strings.HasPrefix(mode, "aeo") {
+		tonic = abcRelativeMajor[tonic]
+	}
+	return abcSharpKeys[tonic]
+}
+
+// parseABC reads the header fields and the tune body. It returns nil when
+// there are no notes.
+func parseABC(src string) *abcTune {
+	t := &abcTune{key: "C"}
+	unit := 0.0
+	inBody := false
+	notes := 0
+	for _, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		if i := strings.IndexByte(line, '%'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		// Fields are a letter and a colon (w: lyrics too), but not a:|.
+		if len(line) >= 2 && line[1] == ':' && (line[0] >= 'A' && line[0] <= 'Z' || line[0] >= 'a' && line[0] <= 'z') && !strings.HasPrefix(line[2:], "|") {
+			value := strings.TrimSpace(line[2:])
+			switch line[0] {
+			case 'T':
+				if t.title == "" {
+					t.title = value
+				}
+			case 'C':
+				t.composer = value
+			case 'M':
+				t.meter = value
+			case 'L':
+				if n, d, ok := strings.Cut(value, "/"); ok {
+					nn, _ := strconv.Atoi(n)
+					dd, _ := strconv.Atoi(d)
+					if nn > 0 && dd > 0 {
+						unit = float64(nn) / float64(dd)
+					}
+				}
+			case 'K':
+				t.key = value
+				inBody = true
+			}
+			continue
+		}
+		if !inBody || line == "" {
+			continue
+		}
+		if unit == 0 {
+			// The default unit note length follows the meter.
+			unit = 1.0 / 8
+			if n, d, ok := strings.Cut(t.meter, "/"); ok {
+				nn, _ := strconv.Atoi(n)
+				dd, _ := strconv.Atoi(d)
+				if dd > 0 && float64(nn)/float64(dd) < 0.75 {
+					unit = 1.0 / 16
+				}
+			}
+		}
+		items := parseABCLine(strings.TrimSuffix(line, `\`), unit)
+		for _, it := range items {
+			if it.note != nil {
+				notes++
+			}
+		}
+		if len(items) > 0 {
+			t.lines = append(t.lines, items)
+		}
+	}
+	if notes == 0 {
+		return nil
+	}
+	return t
+}
+
+// parseABCLine reads one line of music.
+func parseABCLine(s string, unit float64) []abcItem {
+	var items []abcItem
+	chord := ""
+	i := 0
+	// pitch reads an accidental, note letter and octave marks at i.
+	pitch := func() (step int, acc string, ok bool) {
+		j := i
+		for j < len(s) && strings.IndexByte("^_=", s[j]) >= 0 {
+			j++
+		}
+		if j >= len(s) || strings.IndexByte("ABCDEFGabcdefg", s[j]) < 0 {
+			return 0, "", false
+		}
+		acc = s[i:j]
+		// C is middle C and c the octave above; A and B sit above C.
+		step = strings.IndexByte("CDEFGABcdefgab", s[j])
+		j++
+		for j < len(s) && (s[j] == '\'' || s[j] == ',') {
+			if s[j] == '\'' {
+				step += 7
+			} else {
+				step -= 7
+			}
+			j++
+		}
+		i = j
+		return step, acc, true
+	}
+	// length reads a length multiplier ("3", "/2", "3/2", "//") at i.
+	length := func() float64 {
+		j := i
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		num := 1.0
+		if j > i {
+			num, _ = strconv.ParseFloat(s[i:j], 64)
+		}
+		den := 1.0
+		for j < len(s) && s[j] == '/' {
+			j++
+			k := j
+			for k < len(s) && s[k] >= '0' && s[k] <= '9' {
+				k++
+			}
+			if k > j {
+				d, _ := strconv.ParseFloat(s[j:k], 64)
+				den *= d
+			} else {
+				den *= 2
+			}
+			j = k
+		}
+		i = j
+		if num <= 0 || den <= 0 {
+			return unit
+		}
+		return unit * num / den
+	}
+	skipTo := func(end byte) {
+		if k := strings.IndexByte(s[i+1:], end); k >= 0 {
+			i += k + 2
+		} else {
+			i = len(s)
+		}
+	}
+
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '"':
+			start := i + 1
+			skipTo('"')
+			if text := s[start:max(start, i-1)]; text != "" && strings.IndexByte("^_<>@", text[0]) < 0 {
+				chord = text
+			}
+		case c == '!' || c == '+' && strings.IndexByte(s[i+1:], '+') >= 0:
+			skipTo(c) // decorations
+		case c == '{':
+			skipTo('}') // grace notes
+		case c == '[' && i+2 < len(s) && s[i+2] == ':':
+			skipTo(']') // inline field
+		case c == '|' || c == ':' && i+1 < len(s) && s[i+1] == '|' || c == '[' && i+1 < len(s) && s[i+1] == '|':
+			j := i
+			for j < len(s) && strings.IndexByte("|:[]", s[j]) >= 0 {
+				j++
+			}
+			bar := s[i:j]
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++ // first and second endings
+			}
+			items = append(items, abcItem{bar: bar})
+			i = j
+		case c == '[':
+			// A chord: [CEG]2
+			i++
+			n := &abcNote{chord: chord}
+			var inner float64
+			for i < len(s) && s[i] != ']' {
+				step, acc, ok := pitch()
+				if !ok {
+					i++
+					continue
+				}
+				n.steps = append(n.steps, step)
+				n.accidentals = append(n.accidentals, acc)
+				if l := length(); inner == 0 {
+					inner = l
+				}
+			}
+			i++
+			if inner == 0 {
+				inner = unit
+			}
+			n.length = inner * length() / unit
+			if len(n.steps) > 0 {
+				items = append(items, abcItem{note: n})
+				chord = ""
+			}
+		case c == 'z' || c == 'x':
+			i++
+			n := &abcNote{length: length(), chord: chord}
+			chord = ""
+			if c == 'z' {
+				items = append(items, abcItem{note: n})
+			}
+		default:
+			if step, acc, ok := pitch(); ok {
+				n := &abcNote{steps: []int{step}, accidentals: []string{acc}, length: length(), chord: chord}
+				chord = ""
+				items = append(items, abcItem{note: n})
+				continue
+			}
+			i++ // spaces, ties, slurs, tuplets, broken rhythm
+		}
+	}
+	return items
+}
+
+// Engraving geometry: the staff gap is the distance between staff lines;
+// a diatonic step is half of it.
+const (
+	abcGap       = 8.0
+	abcStaff     = 4 * abcGap
+	abcSystem    = abcStaff + 6*abcGap // staff plus room for ledger lines and chords
+	abcMargin    = 12.0
+	abcClefWidth = 28.0
+	abcKeyStep   = 7.0
+)
+
+// Treble clef positions (steps above middle C) of the key signature.
+var (
+	abcSharpSteps = []int{10, 7, 11, 8, 5, 9, 6}
+	abcFlatSteps  = []int{6, 9, 5, 8, 4, 7, 3}
+)
+
+var abcAccidentalGlyphs = map[string]string{"^": "♯", "^^": "𝄪", "_": "♭", "__": "𝄫", "=": "♮"}
+
+// abcSVG engraves t.
+func abcSVG(t *abcTune) string {
+	sig := keySignature(t.key)
+	var body strings.Builder
+	width := 0.0
+	for li, line := range t.lines {
+		top := abcMargin + 2*abcGap + float64(li)*abcSystem
+		if t.title != "" {
+			top += 2 * abcGap
+		}
+		bottom := top + abcStaff
+		stepY := func(step int) float64 { return bottom - float64(step-2)*abcGap/2 }
+
+		x := abcMargin
+		fmt.Fprintf(&body, `<text class="abc-clef" x="%.1f" y="%.1f">𝄞</text>`, x, bottom+abcGap/2)
+		x += abcClefWidth
+		for k := 0; k < sig || k < -sig; k++ {
+			glyph, steps := "♯", abcSharpSteps
+			if sig < 0 {
+				glyph, steps = "♭", abcFlatSteps
+			}
+			fmt.Fprintf(&body, `<text class="abc-acc" x="%.1f" y="%.1f">%s</text>`, x, stepY(steps[k])+3, glyph)
+			x += abcKeyStep
+		}
+		if li == 0 && t.meter != "" {
+			if n, d, ok := strings.Cut(t.meter, "/"); ok {
+				fmt.Fprintf(&body, `<text class="abc-meter" x="%.1f" y="%.1f">%s</text><text class="abc-meter" x="%.1f" y="%.1f">%s</text>`,
+					x+4, top+abcStaff/2-1, html.EscapeString(n), x+4, bottom-1, html.EscapeString(d))
+			} else {
+				fmt.Fprintf(&body, `<text class="abc-meter" x="%.1f" y="%.1f">%s</text>`, x+4, top+abcStaff/2+5, html.EscapeString(t.meter))
+			}
+			x += 20
+		}
+		x += 8
+
+		for _, it := range line {
+			if it.bar != "" {
+				writeABCBar(&body, it.bar, x, top, bottom)
+				x += 12
+				continue
+			}
+			x += writeABCNote(&body, it.note, x, top, bottom, stepY)
+		}
+		for l := 0; l < 5; l++ {
+			y := top + float64(l)*abcGap
+			fmt.Fprintf(&body, `<line class="abc-staff" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, abcMargin, y, x, y)
+		}
+		width = math.Max(width, x+abcMargin)
+	}
+
+	height := abcMargin*2 + float64(len(t.lines))*abcSystem
+	var s strings.Builder
+	fmt.Fprintf(&s, `<svg xmlns="http://www.w3.org/2000/svg" class="abc-svg" viewBox="0 0 %.0f %.0f" width="%.0f" role="img" aria-label="%s">`,
+		width, height+2*abcGap, width, html.EscapeString(strings.TrimSpace("Score "+t.title)))
+	if t.title != "" {
+		fmt.Fprintf(&s, `<text class="abc-title" x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, width/2, abcMargin+abcGap, html.EscapeString(t.title))
+	}
+	if t.composer != "" {
+		fmt.Fprintf(&s, `<text class="abc-composer" x="%.1f" y="%.1f" text-anchor="end">%s</text>`, width-abcMargin, abcMargin+2*abcGap, html.EscapeString(t.composer))
+	}
+	s.WriteString(body.String())
+	s.WriteString("</svg>")
+	return s.String()
+}
+
+func writeABCBar(b *strings.Builder, bar string, x, top, bottom float64) {
+	fmt.Fprintf(b, `<line class="abc-bar" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, x, top, x, bottom)
+	if strings.Contains(bar, "||") || strings.Contains(bar, "|]") || strings.Contains(bar, "[|") {
+		fmt.Fprintf(b, `<line class="abc-bar heavy" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, x+3, top, x+3, bottom)
+	}
+	// Repeat dots sit on the side the colon is on.
+	for _, side := range []struct {
+		colon bool
+		dx    float64
+	}{{strings.HasPrefix(bar, ":"), -4}, {strings.HasSuffix(bar, ":"), 7}} {
+		if side.colon {
+			fmt.Fprintf(b, `<circle class="abc-dot" cx="%.1f" cy="%.1f" r="1.5"/><circle class="abc-dot" cx="%.1f" cy="%.1f" r="1.5"/>`,
+				x+side.dx, top+1.5*abcGap, x+side.dx, top+2.5*abcGap)
+		}
+	}
+}
+
+// writeABCNote draws a note, chord or rest at x and returns its advance.
+func writeABCNote(b *strings.Builder, n *abcNote, x, top, bottom float64, stepY func(int) float64) float64 {
+	// Longer notes get more room, roughly like hand engraving.
+	advance := 14 + 26*math.Sqrt(math.Min(n.length, 1)*2)
+	if n.chord != "" {
+		fmt.Fprintf(b, `<text class="abc-chord" x="%.1f" y="%.1f">%s</text>`, x, top-2*abcGap, html.EscapeString(n.chord))
+		advance = math.Max(advance, float64(len([]rune(n.chord)))*7+4)
+	}
+
+	// Base value and dot: 1.5 × a power of two is dotted.
+	base := math.Pow(2, math.Floor(math.Log2(n.length)))
+	dotted := n.length >= base*1.5
+	if len(n.steps) == 0 {
+		y := top + 2*abcGap
+		switch {
+		case base >= 1:
+			fmt.Fprintf(b, `<rect class="abc-rest" x="%.1f" y="%.1f" width="8" height="4"/>`, x, top+abcGap)
+		case base >= 0.5:
+			fmt.Fprintf(b, `<rect class="abc-rest" x="%.1f" y="%.1f" width="8" height="4"/>`, x, y-4)
+		default:
+			fmt.Fprintf(b, `<text class="abc-rest-glyph" x="%.1f" y="%.1f">%s</text>`, x, y+4, map[bool]string{true: "𝄽", false: "𝄾"}[base >= 0.25])
+		}
+		return advance
+	}
+
+	headX := x + 6
+	if len(n.accidentals) > 0 {
+		for i, acc := range n.accidentals {
+			if g, ok := abcAccidentalGlyphs[acc]; ok {
+				fmt.Fprintf(b, `<text class="abc-acc" x="%.1f" y="%.1f">%s</text>`, x, stepY(n.steps[i])+3, g)
+				headX = x + 12
+			}
+		}
+	}
+	lo, hi := n.steps[0], n.steps[0]
+	for _, st := range n.steps {
+		lo, hi = min(lo, st), max(hi, st)
+		y := stepY(st)
+		// Ledger lines below (middle C and down) and above the staff.
+		for l := 0; l >= st; l -= 2 {
+			fmt.Fprintf(b, `<line class="abc-staff" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, headX-7, stepY(l), headX+7, stepY(l))
+		}
+		for l := 12; l <= st; l += 2 {
+			fmt.Fprintf(b, `<line class="abc-staff" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, headX-7, stepY(l), headX+7, stepY(l))
+		}
+		class := "abc-head"
+		if base >= 0.5 {
+			class += " open"
+		}
+		fmt.Fprintf(b, `<ellipse class="%s" cx="%.1f" cy="%.1f" rx="4.6" ry="3.4" transform="rotate(-20 %.1f %.1f)"/>`, class, headX, y, headX, y)
+		if dotted {
+			fmt.Fprintf(b, `<circle class="abc-dot" cx="%.1f" cy="%.1f" r="1.5"/>`, headX+8, y-2)
+		}
+	}
+	if base >= 1 {
+		return advance
+	}
+
+	// Stems go down from notes on or above the middle line (B, step 6).
+	up := (lo+hi)/2 < 6
+	var sx, y1, y2 float64
+	if up {
+		sx, y1, y2 = headX+4.2, stepY(lo), stepY(hi)-3.5*abcGap
+	} else {
+		sx, y1, y2 = headX-4.2, stepY(hi), stepY(lo)+3.5*abcGap
+	}
+	fmt.Fprintf(b, `<line class="abc-stem" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, sx, y1, sx, y2)
+	for f := 0; base <= 1.0/8/math.Pow(2, float64(f)) && f < 3; f++ {
+		dy := float64(f) * 6
+		if up {
+			fmt.Fprintf(b, `<path class="abc-flag" d="M%.1f,%.1f q6,6 6,14"/>`, sx, y2+dy)
+		} else {
+			fmt.Fprintf(b, `<path class="abc-flag" d="M%.1f,%.1f q6,-6 6,-14"/>`, sx, y2-dy)
+		}
+	}
+	return advance
+}
+
+// abcBlock engraves ```abc fences, with the source folded underneath.
+type abcBlock struct{}
+
+func init() {
+	registerBlock("abc", []string{"abc"}, true, abcBlock{})
+}
+
+func (abcBlock) Prepare(fcb *ast.FencedCodeBlock, lang string, code []byte) interface{} {
+	t := parseABC(string(code))
+	if t == nil {
+		return nil
+	}
+	return abcSVG(t)
+}
+
+func (abcBlock) Render(w util.BufWriter, state interface{}, entering bool) ast.WalkStatus {
+	if !entering {
+		w.WriteString("</details>\n</figure>\n")
+		return ast.WalkContinue
+	}
+	w.WriteString(`<figure class="abc">`)
+	w.WriteString(state.(string))
+	w.WriteString("\n<details class=\"abc-source\"><summary>ABC source</summary>\n")
+	return ast.WalkContinue
+}
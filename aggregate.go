@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fragmentTypes are the towncrier fragment types in release-notes order,
+// with their section titles. Other types follow alphabetically.
+var fragmentTypes = []struct{ name, title string }{
+	{"feature", "Features"},
+	{"bugfix", "Bugfixes"},
+	{"doc", "Improved Documentation"},
+	{"removal", "Deprecations and Removals"},
+	{"misc", "Misc"},
+}
+
+// A fragment is one change file such as changes/123.feature.md.
+type fragment struct {
+	path    string
+	issue   string // "123"; towncrier orphan fragments start with "+"
+	typ     string // "feature"
+	modTime time.Time
+	body    []byte
+}
+
+// parseFragmentName splits "123.feature.md" into issue and type.
+func parseFragmentName(path string) (issue, typ string) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return name, "misc"
+	}
+	typ = strings.ToLower(name[i+1:])
+	issue = name[:i]
+	// Towncrier allows a counter suffix: 123.feature.1.md.
+	if _, err := strconv.Atoi(typ); err == nil {
+		if j := strings.LastIndexByte(issue, '.'); j >= 0 {
+			typ = strings.ToLower(issue[j+1:])
+			issue = issue[:j]
+		}
+	}
+	return issue, typ
+}
+
+// loadFragments expands patterns and reads the matching fragments.
+func loadFragments(patterns []string) ([]fragment, error) {
+	seen := make(map[string]bool)
+	var frags []fragment
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			info, err := os.Stat(m)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(m)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", m, err)
+			}
+			issue, typ := parseFragmentName(m)
+			frags = append(frags, fragment{
+				path:    m,
+				issue:   issue,
+				typ:     typ,
+				modTime: info.ModTime(),
+				body:    bytes.TrimSpace(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))),
+			})
+		}
+	}
+	return frags, nil
+}
+
+// sortFragments orders fragments by modification time ("date") or by issue
+// ("name", numerically when both issues are numbers).
+func sortFragments(frags []fragment, by string) {
+	sort.SliceStable(frags, func(i, j int) bool {
+		if by == "date" && !frags[i].modTime.Equal(frags[j].modTime) {
+			return frags[i].modTime.Before(frags[j].modTime)
+		}
+		a, errA := strconv.Atoi(frags[i].issue)
+		b, errB := strconv.Atoi(frags[j].issue)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return frags[i].issue < frags[j].issue
+	})
+}
+
+// aggregateFragments renders fragments as one Markdown document with a
+// section per type. Each fragment becomes a list item referencing its issue.
+func aggregateFragments(title string, frags []fragment) []byte {
+	groups := make(map[string][]fragment)
+	for _, f := range frags {
+		groups[f.typ] = append(groups[f.typ], f)
+	}
+
+	var order []string
+	titles := make(map[string]string)
+	for _, t := range fragmentTypes {
+		order = append(order, t.name)
+		titles[t.name] = t.title
+	}
+	var extra []string
+	for typ := range groups {
+		if _, ok := titles[typ]; !ok {
+			extra = append(extra, typ)
+			titles[typ] = strings.ToUpper(typ[:1]) + typ[1:]
+		}
+	}
+	sort.Strings(extra)
+	order = append(order, extra...)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s\n", title)
+	for _, typ := range order {
+		if len(groups[typ]) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n## %s\n\n", titles[typ])
+		for _, f := range groups[typ] {
+			lines := strings.Split(string(f.body), "\n")
+			if f.issue != "" && !strings.HasPrefix(f.issue, "+") {
+				ref := f.issue
+				if _, err := strconv.Atoi(ref); err == nil {
+					ref = "#" + ref
+				}
+				lines[len(lines)-1] += " (" + ref + ")"
+			}
+			buf.WriteString("- " + lines[0] + "\n")
+			for _, l := range lines[1:] {
+				if strings.TrimSpace(l) == "" {
+					buf.WriteString("\n")
+					continue
+				}
+				buf.WriteString("  " + l + "\n")
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// runAggregate implements `mdview aggregate`: merge towncrier-style change
+// fragments into one release-notes document, preview it live or export it.
+func runAggregate(argv []string) error {
+	fs := flag.NewFlagSet("mdview aggregate", flag.ContinueOnError)
+	addRenderFlags(fs)
+	sortBy := fs.String("sort", "name", "order fragments within a section by `name` or date")
+	title := fs.String("title", "Release Notes", "document title")
+	mdOut := fs.String("md-out", "", "write the merged Markdown to `file` (- for stdout) instead of serving it")
+	fs.StringVar(&exportPath, "export", "", "write the document as a self-contained HTML `file` (- for stdout) and exit")
+	fs.StringVar(&exportPath, "o", "", "shorthand for --export")
+	fs.StringVar(&pdfPath, "pdf", "", "write the document as a PDF `file` through headless Chrome and exit")
+	fs.StringVar(&chromePath, "chrome", "", "Chrome, Chromium or Edge `binary` for PDF output (default: search PATH)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdview aggregate [options] <glob> [glob ...]\n\n")
+		fmt.Fprintf(os.Stderr, "Merges change fragments such as changes/123.feature.md into one\n")
+		fmt.Fprintf(os.Stderr, "document grouped by type, with live reload as fragments change.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	patterns := parseCommandFlags(fs, argv)
+	if len(patterns) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *sortBy != "name" && *sortBy != "date" {
+		return fmt.Errorf("--sort must be name or date, got %q", *sortBy)
+	}
+
+	build := func() ([]byte, time.Time, error) {
+		frags, err := loadFragments(patterns)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		sortFragments(frags, *sortBy)
+		var latest time.Time
+		for _, f := range frags {
+			if f.modTime.After(latest) {
+				latest = f.modTime
+			}
+		}
+		return aggregateFragments(*title, frags), latest, nil
+	}
+
+	doc, latest, err := build()
+	if err != nil {
+		return err
+	}
+	if *mdOut == "-" {
+		_, err := os.Stdout.Write(doc)
+		return err
+	}
+	if *mdOut != "" {
+		return os.WriteFile(*mdOut, doc, 0o644)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(patterns[0]))
+	if err != nil {
+		return err
+	}
+	baseDir = dir
+	docs.Set(mainDocument, Document{Path: *title + ".md", Content: doc, Modified: latest})
+	if pdfPath != "" {
+		return exportPDF(pdfPath)
+	}
+	if exportPath != "" {
+		return exportStandalone(exportPath)
+	}
+
+	return serve(nil, func(ctx context.Context) {
+		go watchFragments(ctx, patterns, build)
+	})
+}
+
+// watchFragments re-globs patterns on every tick so added and removed
+// fragments are picked up, rebuilding the document when anything changed.
+func watchFragments(ctx context.Context, patterns []string, build func() ([]byte, time.Time, error)) {
+	signature := func() string {
+		var sb strings.Builder
+		for _, p := range patterns {
+			matches, _ := filepath.Glob(p)
+			for _, m := range matches {
+				if info, err := os.Stat(m); err == nil {
+					fmt.Fprintf(&sb, "%s:%d:%d;", m, info.ModTime().UnixNano(), info.Size())
+				}
+			}
+		}
+		return sb.String()
+	}
+
+	last := signature()
+	ticker := time.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sig := signature()
+			if sig == last {
+				continue
+			}
+			last = sig
+			doc, latest, err := build()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "aggregate: %v\n", err)
+				continue
+			}
+			docs.Update(mainDocument, func(cur Document) (Document, bool) {
+				if bytes.Equal(doc, cur.Content) {
+					return cur, false // touched but not edited
+				}
+				cur.Content, cur.Modified = doc, latest
+				return cur, true
+			})
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// spanAttributeTransformer attaches `{#id .class key=val}` attribute lists
+// written directly after an image or link to that node. Heading attributes
+// are handled by goldmark itself (parser.WithAttribute) and fenced code block
+// attributes by the highlighting wrapper in codeblock.go.
+type spanAttributeTransformer struct{}
+
+func (t *spanAttributeTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n.Kind() {
+		case ast.KindImage, ast.KindLink:
+			applySpanAttributes(n, source)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// applySpanAttributes parses an attribute list from the text following n,
+// moves the attributes onto n and strips the consumed text. Emphasis
+// delimiters such as `_` split text nodes, so the list is read from the
+// source and may span several sibling text nodes.
+func applySpanAttributes(n ast.Node, source []byte) {
+	next, ok := n.NextSibling().(*ast.Text)
+	if !ok {
+		return
+	}
+	start := next.Segment.Start
+	if start >= len(source) || source[start] != '{' {
+		return
+	}
+	lineEnd := bytes.IndexByte(source[start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(source) - start
+	}
+	r := text.NewReader(source[start : start+lineEnd])
+	attrs, ok := parser.ParseAttributes(r)
+	if !ok {
+		return
+	}
+	_, pos := r.Position()
+	end := start + pos.Start
+
+	// Only plain text may sit inside the attribute list.
+	var covered []*ast.Text
+	for s := ast.Node(next); s != nil; s = s.NextSibling() {
+		t, ok := s.(*ast.Text)
+		if !ok {
+			return
+		}
+		covered = append(covered, t)
+		if t.Segment.Stop >= end {
+			break
+		}
+	}
+	for _, attr := range attrs {
+		n.SetAttribute(attr.Name, attrValue(attr.Value))
+	}
+	parent := n.Parent()
+	for _, t := range covered {
+		if t.Segment.Stop > end || t.SoftLineBreak() || t.HardLineBreak() {
+			if t.Segment.Start < end {
+				t.Segment = t.Segment.WithStart(end)
+			}
+			continue
+		}
+		parent.RemoveChild(parent, t)
+	}
+}
+
+var spanAttributes = util.Prioritized(&spanAttributeTransformer{}, 500)
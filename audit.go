@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// runAudit implements `mdview audit`: report documents whose review date
+// or last commit is older than --stale-after, for doc hygiene in CI.
+func runAudit(argv []string) error {
+	fs := flag.NewFlagSet("mdview audit", flag.ContinueOnError)
+	maxAge := 180 * 24 * time.Hour
+	fs.Var(ageFlag{&maxAge}, "stale-after", "report documents older than this `age` (e.g. 180d, 26w)")
+	all := fs.Bool("all", false, "list every document, not only stale ones")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdview audit [options] [file or directory ...]\n\n")
+		fmt.Fprintf(os.Stderr, "Lists Markdown documents whose front matter `reviewed:` date, or else\n")
+		fmt.Fprintf(os.Stderr, "last git commit or modification time, is older than --stale-after.\n")
+		fmt.Fprintf(os.Stderr, "Exits with status 1 when any are stale.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	targets := parseCommandFlags(fs, argv)
+	if len(targets) == 0 {
+		targets = []string{"."}
+	}
+
+	type entry struct {
+		path string
+		age  docAge
+	}
+	var entries []entry
+	for _, t := range targets {
+		root, err := filepath.Abs(t)
+		if err != nil {
+			return err
+		}
+		var paths []string
+		commits := map[string]time.Time{}
+		if isDirectory(t) {
+			files, err := listMarkdown(os.DirFS(root))
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				paths = append(paths, filepath.Join(t, filepath.FromSlash(f.rel)))
+			}
+			commits = gitLastCommits(root)
+		} else {
+			paths = []string{t}
+			commits = gitLastCommits(filepath.Dir(root), filepath.Base(root))
+		}
+		for _, p := range paths {
+			age, err := ageOf(p, commits)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{p, age})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].age.When.Before(entries[j].age.When) })
+
+	stale := 0
+	for _, e := range entries {
+		old := time.Since(e.age.When) >= maxAge
+		if old {
+			stale++
+		} else if !*all {
+			continue
+		}
+		mark := "ok   "
+		if old {
+			mark = "STALE"
+		}
+		days := int(time.Since(e.age.When).Hours() / 24)
+		fmt.Printf("%s  %s  %-11s  %4dd  %s\n", mark, e.age.When.Format("2006-01-02"), e.age.Source, days, e.path)
+	}
+	if stale > 0 {
+		return fmt.Errorf("%d of %d document(s) not reviewed in %s", stale, len(entries), ageFlag{&maxAge})
+	}
+	return nil
+}
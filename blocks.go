@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Fenced block renderers turn code blocks of particular languages into
+// visualizations: API references, trees, diagrams, sheet music. Each one
+// lives in its own file and registers itself from init with
+// registerBlock; the Blocks extension swaps matching blocks for a
+// VisualBlock at parse time and hands rendering back to the renderer.
+// --blocks turns them on and off by name.
+
+// A BlockRenderer renders one kind of fenced code block.
+type BlockRenderer interface {
+	// Prepare runs at parse time for each matching block, with the block
+	// (for its info string attributes), its language in lower case, and
+	// its code. It returns the state Render needs, or nil to leave the
+	// block as ordinary code.
+	Prepare(block *ast.FencedCodeBlock, lang string, code []byte) interface{}
+
+	// Render writes the visualization around the original code block,
+	// which is the VisualBlock's only child: once entering, before the
+	// code, and once after it. Returning ast.WalkSkipChildren when
+	// entering leaves the code out.
+	Render(w util.BufWriter, state interface{}, entering bool) ast.WalkStatus
+}
+
+// A blockType is a registered renderer and the fence languages it takes.
+type blockType struct {
+	name      string
+	languages []string
+	enabled   bool
+	renderer  BlockRenderer
+}
+
+var blockTypes = make(map[string]*blockType)
+
+// registerBlock adds a renderer for fenced blocks in languages. Renderers
+// that are expensive or surprising register disabled and are turned on
+// with --blocks.
+func registerBlock(name string, languages []string, enabled bool, r BlockRenderer) {
+	if _, dup := blockTypes[name]; dup {
+		panic("block renderer registered twice: " + name)
+	}
+	blockTypes[name] = &blockType{name: name, languages: languages, enabled: enabled, renderer: r}
+}
+
+// blockNames returns the registered renderer names, sorted.
+func blockNames() []string {
+	names := make([]string, 0, len(blockTypes))
+	for name := range blockTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// blockTypeFor returns the enabled renderer for a fence language, if any.
+func blockTypeFor(lang string) *blockType {
+	for _, t := range blockTypes {
+		if !t.enabled {
+			continue
+		}
+		for _, l := range t.languages {
+			if l == lang {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+func setBlockEnabled(name string, on bool) error {
+	t, ok := blockTypes[name]
+	if !ok {
+		return fmt.Errorf("unknown block renderer %q (have %s)", name, strings.Join(blockNames(), ", "))
+	}
+	t.enabled = on
+	return nil
+}
+
+// blocksFlag implements --blocks: renderer names to enable, or to disable
+// with a leading "-", comma-separated ("schema,abc,-tree").
+type blocksFlag struct{}
+
+func (blocksFlag) String() string {
+	var on []string
+	for _, name := range blockNames() {
+		if blockTypes[name].enabled {
+			on = append(on, name)
+		}
+	}
+	return strings.Join(on, ",")
+}
+
+func (blocksFlag) Set(v string) error {
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		off := strings.HasPrefix(name, "-")
+		if err := setBlockEnabled(strings.TrimPrefix(name, "-"), !off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blocksUsage describes --blocks with the registered renderers.
+func blocksUsage() string {
+	var parts []string
+	for _, name := range blockNames() {
+		t := blockTypes[name]
+		part := name + " (" + strings.Join(t.languages, "/") + ")"
+		if !t.enabled {
+			part += " off by default"
+		}
+		parts = append(parts, part)
+	}
+	return "enable or disable (-name) fenced block renderers, as a comma-separated `list`: " + strings.Join(parts, ", ")
+}
+
+// fencedCode returns the code inside a fenced block.
+func fencedCode(fcb *ast.FencedCodeBlock, src []byte) []byte {
+	var code []byte
+	lines := fcb.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		code = append(code, seg.Value(src)...)
+	}
+	return code
+}
+
+// KindVisualBlock is the node kind of a fenced block with a renderer.
+var KindVisualBlock = ast.NewNodeKind("VisualBlock")
+
+// A VisualBlock wraps a fenced code block that a registered renderer draws.
+type VisualBlock struct {
+	ast.BaseBlock
+	BlockType *blockType
+	State     interface{}
+}
+
+// Kind implements ast.Node.
+func (n *VisualBlock) Kind() ast.NodeKind { return KindVisualBlock }
+
+// Dump implements ast.Node.
+func (n *VisualBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Type": n.BlockType.name}, nil)
+}
+
+type blockTransformer struct{}
+
+func (t *blockTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	src := reader.Source()
+	var blocks []*ast.FencedCodeBlock
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fcb, ok := n.(*ast.FencedCodeBlock); ok && entering && fcb.Info != nil {
+			blocks = append(blocks, fcb)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, fcb := range blocks {
+		lang := strings.ToLower(string(fcb.Language(src)))
+		bt := blockTypeFor(lang)
+		if bt == nil {
+			continue
+		}
+		state := bt.renderer.Prepare(fcb, lang, fencedCode(fcb, src))
+		if state == nil {
+			continue
+		}
+		n := &VisualBlock{BlockType: bt, State: state}
+		parent := fcb.Parent()
+		parent.ReplaceChild(parent, fcb, n)
+		n.AppendChild(n, fcb)
+	}
+}
+
+type blockRenderer struct{}
+
+func (r *blockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindVisualBlock, r.renderBlock)
+}
+
+func (r *blockRenderer) renderBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*VisualBlock)
+	return n.BlockType.renderer.Render(w, n.State, entering), nil
+}
+
+type blockExtension struct{}
+
+// Blocks is a goldmark.Extender applying the registered block renderers.
+var Blocks goldmark.Extender = &blockExtension{}
+
+func (e *blockExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		// After codeInfoAttributes, so renderers see src= and friends.
+		util.Prioritized(&blockTransformer{}, 550),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&blockRenderer{}, 500),
+	))
+}
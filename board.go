@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// The board view shows task list items as cards, in columns per section or
+// per status tag. With --editable, dropping a card on another column
+// rewrites the Markdown; the watcher then pushes the change as usual.
+
+// statusTags are the board columns in tag mode. Untagged items go to
+// "done" when checked and "todo" otherwise.
+var statusTags = []string{"todo", "doing", "done"}
+
+var statusTagRe = regexp.MustCompile(`(^|\s)#(todo|doing|done)\b`)
+
+// taskLineRe splits a task list line into marker, checkbox state and rest.
+var taskLineRe = regexp.MustCompile(`^(\s*(?:[-*+]|\d+[.)])\s+\[)([ xX])(\].*)$`)
+
+var errBadSection = errors.New("no such section")
+
+// A boardItem is one task list item.
+type boardItem struct {
+	File        string `json:"file"`
+	Line        int    `json:"line"` // 1-based line of the item in File
+	Source      string `json:"source"`
+	Text        string `json:"text"`
+	Checked     bool   `json:"checked"`
+	Status      string `json:"status"`
+	Section     string `json:"section"`
+	SectionLine int    `json:"sectionLine"` // 0 before the first heading
+}
+
+// boardItems lists the task items of one document with their sections.
+func boardItems(file string, src []byte) []boardItem {
+	doc := markdown().Parser().Parse(text.NewReader(src))
+	lines := bytes.Split(src, []byte("\n"))
+	var items []boardItem
+	section, sectionLine := "", 0
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if h, ok := n.(*ast.Heading); ok {
+			section = string(h.Text(src))
+			sectionLine = lineOf(src, h)
+			continue
+		}
+		ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			box, ok := n.(*east.TaskCheckBox)
+			if !entering || !ok {
+				return ast.WalkContinue, nil
+			}
+			line := lineOf(src, box.Parent())
+			if line == 0 || line > len(lines) {
+				return ast.WalkContinue, nil
+			}
+			source := string(bytes.TrimSuffix(lines[line-1], []byte("\r")))
+			m := taskLineRe.FindStringSubmatch(source)
+			if m == nil {
+				return ast.WalkContinue, nil
+			}
+			body := strings.TrimPrefix(m[3], "]")
+			status := "todo"
+			if box.IsChecked {
+				status = "done"
+			}
+			if tm := statusTagRe.FindStringSubmatch(body); tm != nil {
+				status = tm[2]
+			}
+			items = append(items, boardItem{
+				File:        file,
+				Line:        line,
+				Source:      source,
+				Text:        strings.TrimSpace(statusTagRe.ReplaceAllString(body, "$1")),
+				Checked:     box.IsChecked,
+				Status:      status,
+				Section:     section,
+				SectionLine: sectionLine,
+			})
+			return ast.WalkSkipChildren, nil
+		})
+	}
+	return items
+}
+
+// lineOf returns the 1-based source line where block n starts, or 0.
+func lineOf(src []byte, n ast.Node) int {
+	if n.Lines().Len() == 0 {
+		return 0
+	}
+	return bytes.Count(src[:n.Lines().At(0).Start], []byte("\n")) + 1
+}
+
+// itemEnd returns the index after the last line belonging to the list item
+// starting at lines[i]: continuation lines and nested lists are indented
+// deeper than its marker.
+func itemEnd(lines []string, i int) int {
+	indent := len(lines[i]) - len(strings.TrimLeft(lines[i], " \t"))
+	end := i + 1
+	for j := i + 1; j < len(lines); j++ {
+		l := strings.TrimRight(lines[j], "\r")
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		if len(l)-len(strings.TrimLeft(l, " \t")) <= indent {
+			break
+		}
+		end = j + 1
+	}
+	return end
+}
+
+// retag rewrites a task line for a new status column.
+func retag(source, status string) string {
+	m := taskLineRe.FindStringSubmatch(source)
+	if m == nil {
+		return source
+	}
+	box := " "
+	if status == "done" {
+		box = "x"
+	}
+	rest := strings.TrimRight(statusTagRe.ReplaceAllString(strings.TrimPrefix(m[3], "]"), "$1"), " ")
+	if status != "todo" && status != "done" {
+		rest += " #" + status
+	}
+	return m[1] + box + "]" + rest
+}
+
+// handleBoard serves GET /api/board with the task items of every input.
+func handleBoard(w http.ResponseWriter, r *http.Request) {
+	if isLocked() {
+		http.NotFound(w, r)
+		return
+	}
+	var items []boardItem
+	if len(inputPaths) == 0 {
+		items = boardItems("", docs.Get(mainDocument).Content)
+	}
+	for _, p := range inputPaths {
+		data, err := readSource(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, boardItems(p, data)...)
+	}
+	writeJSON(w, map[string]interface{}{"items": items, "statuses": statusTags})
+}
+
+// handleBoardMove serves POST /api/board/move, moving one item to another
+// status ({"status": "doing"}) or to the end of another section's tasks in
+// the same file ({"sectionLine": 12}). source must still match the file, so a stale
+// board can't clobber an edit made in the meantime.
+func handleBoardMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if !canEdit(r) {
+		http.Error(w, "start mdview with --editable to modify files", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		File        string `json:"file"`
+		Line        int    `json:"line"`
+		Source      string `json:"source"`
+		Status      string `json:"status"`
+		SectionLine *int   `json:"sectionLine"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	known := false
+	for _, p := range inputPaths {
+		known = known || p == req.File
+	}
+	if !known || isEncrypted(req.File) || isEmail(req.File) {
+		http.Error(w, "unknown or read-only file", http.StatusForbidden)
+		return
+	}
+	editMu.Lock()
+	defer editMu.Unlock()
+	data, err := os.ReadFile(req.File)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	lines := strings.Split(string(data), "\n")
+	if req.Line < 1 || req.Line > len(lines) || strings.TrimRight(lines[req.Line-1], "\r") != req.Source {
+		http.Error(w, "the file changed; reload the board", http.StatusConflict)
+		return
+	}
+	cr := ""
+	if strings.HasSuffix(lines[req.Line-1], "\r") {
+		cr = "\r"
+	}
+
+	i := req.Line - 1
+	switch {
+	case req.Status != "":
+		valid := false
+		for _, s := range statusTags {
+			valid = valid || s == req.Status
+		}
+		if !valid {
+			http.Error(w, "unknown status", http.StatusBadRequest)
+			return
+		}
+		lines[i] = retag(req.Source, req.Status) + cr
+	case req.SectionLine != nil:
+		lines, err = moveItem(data, lines, i, *req.SectionLine)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "nothing to do", http.StatusBadRequest)
+		return
+	}
+	if err := writeFileAtomic(req.File, []byte(strings.Join(lines, "\n"))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]bool{"ok": true})
+}
+
+// moveItem moves the item at lines[i], with its continuation lines, after
+// the last task under the heading on sectionLine.
+func moveItem(data []byte, lines []string, i, sectionLine int) ([]string, error) {
+	end := itemEnd(lines, i)
+	at := -1
+	for _, it := range boardItems("", data) {
+		if it.SectionLine == sectionLine && it.Line-1 != i {
+			at = itemEnd(lines, it.Line-1)
+		}
+	}
+	if at < 0 {
+		return nil, errBadSection
+	}
+	if at >= i && at <= end {
+		return lines, nil
+	}
+
+	out := make([]string, 0, len(lines))
+	for j := 0; j <= len(lines); j++ {
+		if j == at {
+			out = append(out, lines[i:end]...)
+		}
+		if j < len(lines) && (j < i || j >= end) {
+			out = append(out, lines[j])
+		}
+	}
+	return out, nil
+}
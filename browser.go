@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// The page opens in the system's default browser unless --browser, or else
+// $BROWSER, names another. Either is a command line, so a profile or app
+// mode can be passed along ("firefox -P work", "chromium --app={url}");
+// {url} or $BROWSER's %s is replaced by the URL, which is otherwise
+// appended. $BROWSER may list several, separated like PATH, and the first
+// that is installed is used. Common names work on every system: chrome
+// finds Chrome or Chromium on Linux, and on macOS a name not in PATH is
+// opened as an application ("firefox" through open -a Firefox).
+
+// browserCommand is --browser.
+var browserCommand string
+
+// noBrowser is --no-browser: the page is opened by hand, from wherever the
+// URL can be reached. Nothing shuts the server down when no tab is open,
+// so it waits for that as long as it takes.
+var noBrowser bool
+
+// envBool reports whether the environment variable name is set to a true
+// value (1, true, yes, on); unset or unparsable is false.
+func envBool(name string) bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+	if v == "yes" || v == "on" {
+		return true
+	}
+	on, _ := strconv.ParseBool(v)
+	return on
+}
+
+// browserAliases are the executables a browser's common name stands for
+// on Linux, and its application name on macOS.
+var browserAliases = map[string]struct {
+	linux []string
+	mac   string
+}{
+	"chrome":   {[]string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser"}, "Google Chrome"},
+	"chromium": {[]string{"chromium", "chromium-browser"}, "Chromium"},
+	"firefox":  {[]string{"firefox", "firefox-esr"}, "Firefox"},
+	"edge":     {[]string{"microsoft-edge", "microsoft-edge-stable"}, "Microsoft Edge"},
+	"brave":    {[]string{"brave-browser", "brave"}, "Brave Browser"},
+	"safari":   {nil, "Safari"},
+}
+
+// browser returns the browser command line, or "" for the system's
+// default browser.
+func browser() string {
+	if strings.TrimSpace(browserCommand) != "" {
+		return browserCommand
+	}
+	var first string
+	for _, b := range strings.Split(os.Getenv("BROWSER"), string(os.PathListSeparator)) {
+		if strings.TrimSpace(b) == "" {
+			continue
+		}
+		if first == "" {
+			first = b
+		}
+		if _, err := browserExecutable(strings.Fields(b)[0]); err == nil {
+			return b
+		}
+	}
+	return first
+}
+
+// browserExecutable resolves a browser's name to the program to run.
+func browserExecutable(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err == nil {
+		return path, nil
+	}
+	if runtime.GOOS == "linux" {
+		for _, alt := range browserAliases[strings.ToLower(name)].linux {
+			if path, err := exec.LookPath(alt); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", err
+}
+
+// browserArgs returns the command that opens url in browser.
+func browserArgs(browser, url string) []string {
+	args := strings.Fields(browser)
+	if strings.Contains(browser, "{url}") || strings.Contains(browser, "%s") {
+		for i, a := range args {
+			args[i] = strings.NewReplacer("{url}", url, "%s", url).Replace(a)
+		}
+	} else {
+		args = append(args, url)
+	}
+	if path, err := browserExecutable(args[0]); err == nil {
+		args[0] = path
+		return args
+	}
+	if runtime.GOOS == "darwin" && !strings.Contains(args[0], "/") {
+		// An application rather than a program: open -a, with the rest of
+		// the command line passed to it.
+		app := args[0]
+		if a := browserAliases[strings.ToLower(app)].mac; a != "" {
+			app = a
+		}
+		rest := args[1:]
+		for i, a := range rest {
+			if a == url {
+				rest = append(rest[:i:i], rest[i+1:]...)
+				break
+			}
+		}
+		open := []string{"open", "-a", app, url}
+		if len(rest) > 0 {
+			open = append(append(open, "--args"), rest...)
+		}
+		return open
+	}
+	return args
+}
+
+func openBrowser(url string) error {
+	if b := browser(); b != "" {
+		args := browserArgs(b, url)
+		return exec.Command(args[0], args[1:]...).Start()
+	}
+
+	var cmd string
+	var args []string
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = "open"
+		args = []string{url}
+	case "linux":
+		cmd = "xdg-open"
+		args = []string{url}
+	case "windows":
+		// Unlike `cmd /c start`, this needs no quoting for URLs with
+		// spaces, & or ^.
+		cmd = "rundll32"
+		args = []string{"url.dll,FileProtocolHandler", url}
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+
+	return exec.Command(cmd, args...).Start()
+}
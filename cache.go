@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cachedRender holds the HTML of one content version, shared by all clients.
+type cachedRender struct {
+	mu      sync.Mutex
+	version uint64
+	html    []byte
+	valid   bool
+}
+
+var renderCache cachedRender
+
+func (c *cachedRender) get(version uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.valid || c.version != version {
+		return nil, false
+	}
+	return c.html, true
+}
+
+// put stores html for version. Only the newest version is kept, so memory
+// stays bounded by one rendering no matter how many clients are connected.
+func (c *cachedRender) put(version uint64, html []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.valid && c.version > version {
+		return
+	}
+	c.version, c.html, c.valid = version, html, true
+}
+
+// metrics are process counters exposed at /metrics.
+var metrics struct {
+	renders     atomic.Int64 // Markdown conversions of the main document
+	cacheHits   atomic.Int64 // requests served from renderCache
+	notModified atomic.Int64 // 304 responses
+	sseClients  atomic.Int64 // currently connected SSE clients
+	sseRejected atomic.Int64 // SSE connections refused by --max-clients
+
+	eventsDropped atomic.Int64 // events discarded for clients that fell behind
+
+	renderMicros    atomic.Int64 // time spent in Markdown conversions
+	pages           atomic.Int64 // live pages written
+	streamedPages   atomic.Int64 // of those, sent shell first (see firstpaint.go)
+	firstByteMicros atomic.Int64 // time from request to the first bytes of a page
+	pageMicros      atomic.Int64 // time from request to the whole page
+}
+
+var (
+	// maxClients caps concurrent SSE connections (--max-clients, 0 = no cap).
+	maxClients int
+
+	// startedAt makes ETags unique per process, since versions restart at 0.
+	startedAt = strconv.FormatInt(time.Now().UnixNano(), 36)
+)
+
+// contentETag returns the ETag of the current content version.
+func contentETag() string {
+	return versionETag(docs.Version())
+}
+
+// versionETag returns the ETag of document version v.
+func versionETag(v uint64) string {
+	return fmt.Sprintf(`"%s-%d"`, startedAt, v)
+}
+
+// checkNotModified sets the ETag header and answers 304 when the client
+// already has this version, so reconnecting viewers don't re-download it.
+func checkNotModified(w http.ResponseWriter, r *http.Request) bool {
+	return checkETag(w, r, contentETag())
+}
+
+// checkETag is checkNotModified for a given ETag.
+func checkETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if encrypted {
+		return false
+	}
+	if isPlain(r) {
+		// A plain page is a different rendering of the same version.
+		etag = strings.TrimSuffix(etag, `"`) + `-plain"`
+	}
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		metrics.notModified.Add(1)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// handleMetrics serves the counters in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range []struct {
+		name, typ, help string
+		value           int64
+	}{
+		{"mdview_renders_total", "counter", "Markdown conversions of the main document.", metrics.renders.Load()},
+		{"mdview_render_cache_hits_total", "counter", "Requests served from the shared render cache.", metrics.cacheHits.Load()},
+		{"mdview_not_modified_total", "counter", "Requests answered with 304 Not Modified.", metrics.notModified.Load()},
+		{"mdview_sse_clients", "gauge", "Connected live-reload clients.", metrics.sseClients.Load()},
+		{"mdview_sse_rejected_total", "counter", "Live-reload connections refused by --max-clients.", metrics.sseRejected.Load()},
+		{"mdview_events_dropped_total", "counter", "Live-reload events discarded for clients that fell behind.", metrics.eventsDropped.Load()},
+		{"mdview_render_microseconds_total", "counter", "Time spent converting Markdown.", metrics.renderMicros.Load()},
+		{"mdview_pages_total", "counter", "Live pages served.", metrics.pages.Load()},
+		{"mdview_pages_streamed_total", "counter", "Live pages sent with the shell ahead of the content.", metrics.streamedPages.Load()},
+		{"mdview_page_first_byte_microseconds_total", "counter", "Time from request to the first bytes of live pages.", metrics.firstByteMicros.Load()},
+		{"mdview_page_microseconds_total", "counter", "Time from request to the last byte of live pages.", metrics.pageMicros.Load()},
+		{"mdview_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", int64(ms.HeapAlloc)},
+		{"mdview_sys_bytes", "gauge", "Bytes obtained from the OS.", int64(ms.Sys)},
+		{"mdview_goroutines", "gauge", "Number of goroutines.", int64(runtime.NumGoroutine())},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.typ, m.name, m.value)
+	}
+}
+
+// byteSizeFlag parses sizes like 512MiB or 1G and applies them as the Go
+// runtime's soft memory limit, making the GC work harder before RSS grows.
+type byteSizeFlag struct{}
+
+func (byteSizeFlag) String() string { return "" }
+
+func (byteSizeFlag) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		debug.SetMemoryLimit(n)
+	}
+	return nil
+}
+
+// parseByteSize parses a decimal size with an optional K/M/G (1000) or
+// KiB/MiB/GiB (1024) suffix; a trailing "B" is optional.
+func parseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(s, "B")
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KI", 1 << 10}, {"MI", 1 << 20}, {"GI", 1 << 30},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// --chat adds a sidebar for quick messages between everyone looking at the
+// document, such as reviewers on a call with --share. Messages can point at
+// a heading and are relayed over the live-reload stream. The server keeps
+// only the latest few in memory for late joiners, unless --chat-log names
+// a file to append them to.
+
+var (
+	chatEnabled bool   // --chat
+	chatLogPath string // --chat-log
+)
+
+const (
+	chatBacklog = 100  // messages kept for tabs that join later
+	chatMaxText = 1000 // runes per message
+	chatMaxName = 40
+)
+
+type chatMessage struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Text   string    `json:"text"`
+	Anchor string    `json:"anchor,omitempty"` // heading id
+	Title  string    `json:"title,omitempty"`  // its text, as the sender saw it
+	Time   time.Time `json:"time"`
+}
+
+var chat struct {
+	sync.Mutex
+	nextID int
+	recent []chatMessage
+}
+
+func postChat(m chatMessage) chatMessage {
+	chat.Lock()
+	defer chat.Unlock()
+	chat.nextID++
+	m.ID = chat.nextID
+	m.Time = time.Now()
+	chat.recent = append(chat.recent, m)
+	if len(chat.recent) > chatBacklog {
+		chat.recent = chat.recent[len(chat.recent)-chatBacklog:]
+	}
+	// Published under the lock so every tab gets messages in order.
+	bus.Publish(Event{Type: EventChat, Data: m})
+	if chatLogPath != "" {
+		if f, err := os.OpenFile(chatLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600); err == nil {
+			json.NewEncoder(f).Encode(m)
+			f.Close()
+		}
+	}
+	return m
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// handleChat serves /api/chat: GET returns the recent messages, POST
+// {"name", "text", "anchor", "title"} sends one.
+func handleChat(w http.ResponseWriter, r *http.Request) {
+	if !chatEnabled || isLocked() {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		chat.Lock()
+		recent := append([]chatMessage{}, chat.recent...)
+		chat.Unlock()
+		writeJSON(w, map[string]interface{}{"messages": recent})
+	case http.MethodPost:
+		if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req chatMessage
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		m := chatMessage{
+			Name:   truncateRunes(strings.TrimSpace(req.Name), chatMaxName),
+			Text:   truncateRunes(strings.TrimSpace(req.Text), chatMaxText),
+			Anchor: truncateRunes(req.Anchor, 200),
+			Title:  truncateRunes(strings.TrimSpace(req.Title), 200),
+		}
+		if m.Text == "" {
+			http.Error(w, "empty message", http.StatusBadRequest)
+			return
+		}
+		if m.Name == "" {
+			m.Name = "Anonymous"
+		}
+		writeJSON(w, postChat(m))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
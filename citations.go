@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Pandoc-style citations: `[@smith2020]`, `[see @smith2020, p. 3; @doe2019]`
+// and in-text `@smith2020`, resolved against a --bibliography file (BibTeX
+// or CSL-JSON) and rendered author-date with a references section.
+
+// A bibEntry is the part of a bibliography record that gets rendered.
+type bibEntry struct {
+	Key       string
+	Authors   []string // family names
+	FullNames string   // as written, for the references list
+	Year      string
+	Title     string
+	Container string // journal, book or publisher
+	URL       string
+}
+
+var bibliography map[string]*bibEntry
+
+// loadBibliography reads a CSL-JSON (.json) or BibTeX file.
+func loadBibliography(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading bibliography: %w", err)
+	}
+	var entries []*bibEntry
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		entries, err = parseCSLJSON(data)
+	} else {
+		entries, err = parseBibTeX(data)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing bibliography %s: %w", path, err)
+	}
+	bibliography = make(map[string]*bibEntry, len(entries))
+	for _, e := range entries {
+		bibliography[e.Key] = e
+	}
+	return nil
+}
+
+// bibliographyFlag loads the bibliography as soon as the flag is parsed.
+type bibliographyFlag struct{}
+
+func (bibliographyFlag) String() string { return "" }
+
+func (bibliographyFlag) Set(v string) error { return loadBibliography(v) }
+
+// parseBibTeX reads @type{key, field = {value}, ...} records. String
+// macros and concatenation are not supported.
+func parseBibTeX(data []byte) ([]*bibEntry, error) {
+	var entries []*bibEntry
+	s := string(data)
+	for {
+		at := strings.IndexByte(s, '@')
+		if at < 0 {
+			return entries, nil
+		}
+		s = s[at+1:]
+		open := strings.IndexAny(s, "{(")
+		if open < 0 {
+			return entries, nil
+		}
+		typ := strings.ToLower(strings.TrimSpace(s[:open]))
+		body, rest, ok := bibGroup(s[open:])
+		if !ok {
+			return nil, fmt.Errorf("unterminated @%s entry", typ)
+		}
+		s = rest
+		if typ == "comment" || typ == "string" || typ == "preamble" {
+			continue
+		}
+		key, fields, _ := strings.Cut(body, ",")
+		e := &bibEntry{Key: strings.TrimSpace(key)}
+		f := bibFields(fields)
+		e.FullNames = strings.Join(strings.Split(f["author"], " and "), "; ")
+		for _, a := range strings.Split(f["author"], " and ") {
+			if a = strings.TrimSpace(a); a != "" {
+				e.Authors = append(e.Authors, familyName(a))
+			}
+		}
+		e.Year = f["year"]
+		if e.Year == "" && len(f["date"]) >= 4 {
+			e.Year = f["date"][:4]
+		}
+		e.Title = f["title"]
+		for _, c := range []string{"journal", "booktitle", "publisher", "howpublished"} {
+			if e.Container == "" {
+				e.Container = f[c]
+			}
+		}
+		if f["doi"] != "" {
+			e.URL = "https://doi.org/" + f["doi"]
+		} else {
+			e.URL = f["url"]
+		}
+		entries = append(entries, e)
+	}
+}
+
+// bibGroup splits "{...}rest" at the matching close brace or parenthesis.
+func bibGroup(s string) (body, rest string, ok bool) {
+	closer := byte('}')
+	if s[0] == '(' {
+		closer = ')'
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '{' || s[i] == '(' && closer == ')' && depth == 0:
+			depth++
+		case s[i] == '}' || s[i] == closer:
+			depth--
+			if depth == 0 {
+				return s[1:i], s[i+1:], true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// bibFields parses `name = {value}` / `name = "value"` / `name = 2020` pairs.
+func bibFields(s string) map[string]string {
+	fields := make(map[string]string)
+	for {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return fields
+		}
+		name := strings.ToLower(strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(s[:eq]), ",")))
+		s = strings.TrimSpace(s[eq+1:])
+		var value string
+		switch {
+		case strings.HasPrefix(s, "{"):
+			body, rest, ok := bibGroup(s)
+			if !ok {
+				return fields
+			}
+			value, s = body, rest
+		case strings.HasPrefix(s, `"`):
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return fields
+			}
+			value, s = s[1:end+1], s[end+2:]
+		default:
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		value = strings.NewReplacer("{", "", "}", "", "\n", " ").Replace(value)
+		fields[name] = strings.Join(strings.Fields(value), " ")
+	}
+}
+
+// familyName picks the family name from "Last, First" or "First Last".
+func familyName(name string) string {
+	if last, _, ok := strings.Cut(name, ","); ok {
+		return strings.TrimSpace(last)
+	}
+	parts := strings.Fields(name)
+	return parts[len(parts)-1]
+}
+
+// parseCSLJSON reads a CSL-JSON array as written by Zotero and pandoc.
+func parseCSLJSON(data []byte) ([]*bibEntry, error) {
+	var items []struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Author []struct {
+			Family  string `json:"family"`
+			Given   string `json:"given"`
+			Literal string `json:"literal"`
+		} `json:"author"`
+		Issued struct {
+			DateParts [][]interface{} `json:"date-parts"`
+		} `json:"issued"`
+		Container string `json:"container-title"`
+		Publisher string `json:"publisher"`
+		URL       string `json:"URL"`
+		DOI       string `json:"DOI"`
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	var entries []*bibEntry
+	for _, it := range items {
+		e := &bibEntry{Key: it.ID, Title: it.Title, Container: it.Container, URL: it.URL}
+		if e.Container == "" {
+			e.Container = it.Publisher
+		}
+		if it.DOI != "" {
+			e.URL = "https://doi.org/" + it.DOI
+		}
+		var full []string
+		for _, a := range it.Author {
+			family := a.Family
+			if family == "" {
+				family = a.Literal
+			}
+			e.Authors = append(e.Authors, family)
+			full = append(full, strings.TrimSpace(strings.TrimSuffix(family+", "+a.Given, ", ")))
+		}
+		e.FullNames = strings.Join(full, "; ")
+		if len(it.Issued.DateParts) > 0 && len(it.Issued.DateParts[0]) > 0 {
+			e.Year = fmt.Sprint(it.Issued.DateParts[0][0])
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// authorLabel formats the family names for a citation.
+func (e *bibEntry) authorLabel() string {
+	switch len(e.Authors) {
+	case 0:
+		return e.Title
+	case 1:
+		return e.Authors[0]
+	case 2:
+		return e.Authors[0] + " and " + e.Authors[1]
+	}
+	return e.Authors[0] + " et al."
+}
+
+// KindCitation is the node kind of a citation.
+var KindCitation = ast.NewNodeKind("Citation")
+
+// A citeItem is one reference inside a citation.
+type citeItem struct {
+	Prefix   string // "see"
+	Key      string
+	Locator  string // "p. 3"
+	YearOnly bool   // [-@key]
+}
+
+// A Citation is `[@key ...]`, or `@key` in the text when InText is set.
+type Citation struct {
+	ast.BaseInline
+	Items  []citeItem
+	InText bool
+}
+
+// Kind implements ast.Node.
+func (n *Citation) Kind() ast.NodeKind { return KindCitation }
+
+// Dump implements ast.Node.
+func (n *Citation) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"InText": strconv.FormatBool(n.InText)}, nil)
+}
+
+// KindReferences is the node kind of the generated references section.
+var KindReferences = ast.NewNodeKind("References")
+
+// References lists the cited entries at the end of the document.
+type References struct {
+	ast.BaseBlock
+	Keys []string
+}
+
+// Kind implements ast.Node.
+func (n *References) Kind() ast.NodeKind { return KindReferences }
+
+// Dump implements ast.Node.
+func (n *References) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+var (
+	citeKeyRe  = regexp.MustCompile(`^@([A-Za-z0-9_][A-Za-z0-9_:.#$%&+?<>~/-]*[A-Za-z0-9_])`)
+	citeItemRe = regexp.MustCompile(`^\s*(.*?)\s*(-?)@([A-Za-z0-9_][A-Za-z0-9_:.#$%&+?<>~/-]*[A-Za-z0-9_]|[A-Za-z0-9_])\s*(?:,\s*(.*?))?\s*$`)
+)
+
+type citationParser struct{}
+
+func (p *citationParser) Trigger() []byte { return []byte{'[', '@'} }
+
+func (p *citationParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if bibliography == nil {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	if line[0] == '@' {
+		// In-text citations only for known keys, and never inside words
+		// (e-mail addresses, @mentions of unknown names).
+		if prev := block.PrecendingCharacter(); util.IsAlphaNumeric(byte(prev)) && prev < 128 {
+			return nil
+		}
+		m := citeKeyRe.FindSubmatch(line)
+		if m == nil || bibliography[string(m[1])] == nil {
+			return nil
+		}
+		block.Advance(len(m[0]))
+		return &Citation{Items: []citeItem{{Key: string(m[1])}}, InText: true}
+	}
+
+	end := bytes.IndexByte(line, ']')
+	if end < 0 || !bytes.Contains(line[:end], []byte("@")) {
+		return nil
+	}
+	// [@key](url) and [@key][ref] are links.
+	if end+1 < len(line) && (line[end+1] == '(' || line[end+1] == '[') {
+		return nil
+	}
+	var items []citeItem
+	for _, part := range strings.Split(string(line[1:end]), ";") {
+		m := citeItemRe.FindStringSubmatch(part)
+		if m == nil {
+			return nil
+		}
+		items = append(items, citeItem{Prefix: m[1], Key: m[3], Locator: m[4], YearOnly: m[2] != ""})
+	}
+	block.Advance(end + 1)
+	return &Citation{Items: items}
+}
+
+type referencesTransformer struct{}
+
+func (t *referencesTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	if bibliography == nil {
+		return
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if c, ok := n.(*Citation); ok && entering {
+			for _, it := range c.Items {
+				if bibliography[it.Key] != nil && !seen[it.Key] {
+					seen[it.Key] = true
+					keys = append(keys, it.Key)
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	if len(keys) == 0 {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := bibliography[keys[i]], bibliography[keys[j]]
+		if a.authorLabel() != b.authorLabel() {
+			return a.authorLabel() < b.authorLabel()
+		}
+		return a.Year < b.Year
+	})
+	doc.AppendChild(doc, &References{Keys: keys})
+}
+
+type citationRenderer struct{}
+
+func (r *citationRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindCitation, r.renderCitation)
+	reg.Register(KindReferences, r.renderReferences)
+}
+
+func (r *citationRenderer) renderCitation(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	c := node.(*Citation)
+	// No nested anchors when the citation is itself a link's text.
+	link := true
+	for p := node.Parent(); p != nil; p = p.Parent() {
+		if _, ok := p.(*ast.Link); ok {
+			link = false
+		}
+	}
+	w.WriteString(`<span class="citation">`)
+	if !c.InText {
+		w.WriteString("(")
+	}
+	for i, it := range c.Items {
+		if i > 0 {
+			w.WriteString("; ")
+		}
+		e := bibliography[it.Key]
+		if e == nil {
+			w.WriteString(`<span class="citation-missing" title="Not in the bibliography">`)
+			w.Write(util.EscapeHTML([]byte(it.Key + "?")))
+			w.WriteString("</span>")
+			continue
+		}
+		if it.Prefix != "" {
+			w.Write(util.EscapeHTML([]byte(it.Prefix + " ")))
+		}
+		label := e.authorLabel() + " " + e.Year
+		switch {
+		case it.YearOnly:
+			label = e.Year
+		case c.InText:
+			label = e.authorLabel() + " (" + e.Year + ")"
+		}
+		if link {
+			w.WriteString(`<a href="#ref-`)
+			w.Write(util.URLEscape([]byte(it.Key), false))
+			w.WriteString(`">`)
+		}
+		w.Write(util.EscapeHTML([]byte(label)))
+		if link {
+			w.WriteString("</a>")
+		}
+		if it.Locator != "" {
+			w.Write(util.EscapeHTML([]byte(", " + it.Locator)))
+		}
+	}
+	if !c.InText {
+		w.WriteString(")")
+	}
+	w.WriteString("</span>")
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *citationRenderer) renderReferences(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	w.WriteString("<section class=\"references\">\n<h2>References</h2>\n<ul>\n")
+	for _, k := range node.(*References).Keys {
+		e := bibliography[k]
+		w.WriteString(`<li id="ref-`)
+		w.Write(util.EscapeHTML([]byte(k)))
+		w.WriteString(`">`)
+		names := e.FullNames
+		if names == "" {
+			names = e.authorLabel()
+		}
+		w.Write(util.EscapeHTML([]byte(names + " (" + e.Year + "). ")))
+		if e.Title != "" {
+			w.WriteString("<em>")
+			w.Write(util.EscapeHTML([]byte(e.Title)))
+			w.WriteString("</em>. ")
+		}
+		if e.Container != "" {
+			w.Write(util.EscapeHTML([]byte(e.Container + ". ")))
+		}
+		// The file may come with the document, so its links are checked
+		// like the document's own.
+		if e.URL != "" && !safeURL(e.URL) {
+			w.Write(util.EscapeHTML([]byte(e.URL)))
+		} else if e.URL != "" {
+			w.WriteString(`<a href="`)
+			w.Write(util.EscapeHTML(util.URLEscape([]byte(e.URL), false)))
+			w.WriteString(`">`)
+			w.Write(util.EscapeHTML([]byte(e.URL)))
+			w.WriteString("</a>")
+		}
+		w.WriteString("</li>\n")
+	}
+	w.WriteString("</ul>\n</section>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+type citationExtension struct{}
+
+// Citations is a goldmark.Extender for Pandoc-style citations.
+var Citations goldmark.Extender = &citationExtension{}
+
+func (e *citationExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		// Ahead of the link parser, which also starts at '['.
+		parser.WithInlineParsers(util.Prioritized(&citationParser{}, 150)),
+		parser.WithASTTransformers(util.Prioritized(&referencesTransformer{}, 900)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&citationRenderer{}, 500),
+	))
+}
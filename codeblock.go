@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// highlightAttrs are fenced code block attributes consumed by the
+// highlighting extension itself; they are not echoed into the HTML.
+var highlightAttrs = map[string]bool{
+	"hl_lines":    true,
+	"linenos":     true,
+	"linenostart": true,
+	"style":       true,
+	"nohl":        true,
+}
+
+// fileAttrs name the file a code block represents (```yaml title=deploy.yaml).
+var fileAttrs = []string{"title", "filename", "file"}
+
+// codeInfoTransformer moves the attributes of a fenced code block's info
+// string onto the node: both a `{...}` list and bare key=value pairs after the
+// language. The highlighting extension reads node attributes in preference
+// to re-parsing the info string, so hl_lines and friends keep working.
+type codeInfoTransformer struct{}
+
+func (t *codeInfoTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if fcb, ok := n.(*ast.FencedCodeBlock); ok && fcb.Info != nil {
+			applyInfoAttributes(fcb, fcb.Info.Segment.Value(source))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+func applyInfoAttributes(n *ast.FencedCodeBlock, info []byte) {
+	rest := info
+	if i := bytes.IndexAny(rest, " \t{"); i >= 0 {
+		rest = rest[i:]
+	} else {
+		return
+	}
+	if i := bytes.IndexByte(rest, '{'); i >= 0 {
+		if attrs, ok := parser.ParseAttributes(text.NewReader(rest[i:])); ok {
+			for _, attr := range attrs {
+				n.SetAttribute(attr.Name, attr.Value)
+			}
+		}
+		rest = rest[:i]
+	}
+	for _, field := range splitInfoFields(string(rest)) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			continue
+		}
+		n.SetAttributeString(key, []byte(strings.Trim(value, `"'`)))
+	}
+}
+
+// splitInfoFields splits s on whitespace, keeping quoted values together.
+func splitInfoFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			cur.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+var codeInfoAttributes = util.Prioritized(&codeInfoTransformer{}, 500)
+
+// echoedAttribute reports whether the attribute name from an info string
+// may be copied onto the code block's div with raw HTML off: ids, titles
+// and data-*, never event handlers, styles or URLs.
+func echoedAttribute(name string) bool {
+	name = strings.ToLower(name)
+	return name == "id" || name == "title" || name == "lang" || name == "dir" || strings.HasPrefix(name, "data-")
+}
+
+// codeBlockWrapper returns wrapCodeBlock for raw HTML on (unsafe) or off.
+func codeBlockWrapper(unsafe bool) highlighting.WrapperRenderer {
+	return func(w util.BufWriter, ctx highlighting.CodeBlockContext, entering bool) {
+		wrapCodeBlock(w, ctx, entering, unsafe)
+	}
+}
+
+// wrapCodeBlock wraps every fenced code block in a div carrying the block's
+// info string attributes, followed by a toolbar with copy and download
+// buttons. Unless unsafe, only the echoedAttribute ones are carried.
+func wrapCodeBlock(w util.BufWriter, ctx highlighting.CodeBlockContext, entering, unsafe bool) {
+	lang, _ := ctx.Language()
+	if !entering {
+		if !ctx.Highlighted() {
+			w.WriteString("</code></pre>")
+		}
+		w.WriteString("</div>\n")
+		return
+	}
+
+	var filename []byte
+	w.WriteString(`<div class="code-block`)
+	if attrs := ctx.Attributes(); attrs != nil {
+		if v, ok := attrs.GetString("class"); ok {
+			w.WriteString(" ")
+			w.Write(util.EscapeHTML(attrValue(v)))
+		}
+		w.WriteString(`"`)
+		for _, name := range fileAttrs {
+			if v, ok := attrs.GetString(name); ok && filename == nil {
+				filename = attrValue(v)
+			}
+		}
+		for _, attr := range attrs.All() {
+			name := string(attr.Name)
+			if name == "class" || highlightAttrs[name] || isFileAttr(name) || !unsafe && !echoedAttribute(name) {
+				continue
+			}
+			fmt.Fprintf(w, ` %s="%s"`, util.EscapeHTML(attr.Name), util.EscapeHTML(attrValue(attr.Value)))
+		}
+	} else {
+		w.WriteString(`"`)
+	}
+	w.WriteString(">")
+
+	if lang != nil || filename != nil {
+		writeCodeHeader(w, string(lang), string(filename))
+	}
+	if filename == nil {
+		filename = []byte("snippet." + snippetExt(string(lang)))
+	}
+	w.WriteString(`<div class="code-toolbar"><button class="code-copy" type="button" title="Copy">⧉</button><button class="code-download" type="button" title="Download as file" data-filename="`)
+	w.Write(util.EscapeHTML(filename))
+	w.WriteString(`">⤓</button></div>`)
+
+	if !ctx.Highlighted() {
+		w.WriteString("<pre><code")
+		if lang != nil {
+			w.WriteString(` class="language-`)
+			w.Write(util.EscapeHTML(lang))
+			w.WriteString(`"`)
+		}
+		w.WriteString(">")
+	}
+}
+
+// writeCodeHeader writes the bar above a code block naming its file and
+// language. It carries the comment line the copy button can put first
+// when the reader asks for it.
+func writeCodeHeader(w util.BufWriter, lang, filename string) {
+	label := filename
+	if label == "" {
+		label = lang
+	}
+	w.WriteString(`<div class="code-header"`)
+	if c := codeComment(lang, label); c != "" {
+		w.WriteString(` data-copy-header="`)
+		w.Write(util.EscapeHTML([]byte(c)))
+		w.WriteString(`"`)
+	}
+	w.WriteString(">")
+	if filename != "" {
+		w.WriteString(`<span class="code-filename">`)
+		w.Write(util.EscapeHTML([]byte(filename)))
+		w.WriteString(`</span>`)
+	}
+	if lang != "" {
+		w.WriteString(`<span class="code-lang">`)
+		w.Write(util.EscapeHTML([]byte(lang)))
+		w.WriteString(`</span>`)
+	}
+	w.WriteString(`</div>`)
+}
+
+// commentStyles are the line comment markers of fence languages; a
+// second element closes the comment.
+var commentStyles = map[string][]string{
+	"#":    {"python", "py", "sh", "bash", "shell", "zsh", "fish", "yaml", "yml", "toml", "ruby", "rb", "perl", "r", "dockerfile", "makefile", "make", "ini", "conf", "powershell", "ps1", "nix", "elixir", "julia", "tcl", "hcl", "terraform"},
+	"//":   {"go", "golang", "javascript", "js", "jsx", "typescript", "ts", "tsx", "c", "cpp", "c++", "cc", "h", "hpp", "java", "kotlin", "kt", "scala", "swift", "rust", "rs", "csharp", "cs", "c#", "dart", "php", "groovy", "zig", "protobuf", "proto", "json5", "jsonc", "solidity"},
+	"--":   {"sql", "lua", "haskell", "hs", "elm", "ada", "plsql", "postgresql", "mysql"},
+	";":    {"lisp", "clojure", "clj", "scheme", "elisp", "asm", "nasm"},
+	"%":    {"tex", "latex", "erlang", "matlab", "prolog"},
+	"/*":   {"css", "scss", "less"},
+	"<!--": {"html", "xml", "svg", "markdown", "md", "vue", "svelte"},
+}
+
+// commentClosers end the comments that need it.
+var commentClosers = map[string]string{"/*": " */", "<!--": " -->"}
+
+// codeComment returns text as a comment in lang, or "" for a language
+// without comments (or one mdview doesn't know).
+func codeComment(lang, text string) string {
+	lang = strings.ToLower(lang)
+	for marker, langs := range commentStyles {
+		for _, l := range langs {
+			if l == lang {
+				return marker + " " + text + commentClosers[marker]
+			}
+		}
+	}
+	return ""
+}
+
+func isFileAttr(name string) bool {
+	for _, a := range fileAttrs {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// snippetExts maps fence languages to file extensions where they differ.
+var snippetExts = map[string]string{
+	"bash":       "sh",
+	"shell":      "sh",
+	"zsh":        "sh",
+	"console":    "txt",
+	"python":     "py",
+	"javascript": "js",
+	"typescript": "ts",
+	"ruby":       "rb",
+	"rust":       "rs",
+	"markdown":   "md",
+	"yml":        "yaml",
+	"golang":     "go",
+	"text":       "txt",
+	"plaintext":  "txt",
+}
+
+// snippetExt returns a file extension for a fence language.
+func snippetExt(lang string) string {
+	lang = strings.ToLower(lang)
+	if ext, ok := snippetExts[lang]; ok {
+		return ext
+	}
+	if lang == "" || strings.ContainsAny(lang, `/\. `) {
+		return "txt"
+	}
+	return lang
+}
+
+// attrValue converts a parsed attribute value to its textual form.
+func attrValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindCollapsible is the node kind of a `???` collapsible block.
+var KindCollapsible = ast.NewNodeKind("Collapsible")
+
+// A Collapsible is a MkDocs-style collapsible block:
+//
+//	??? note "Title"
+//	    Indented content.
+//
+// `???+` renders the block expanded by default.
+type Collapsible struct {
+	ast.BaseBlock
+	Name  string
+	Title []byte
+	Open  bool
+}
+
+// Kind implements ast.Node.
+func (n *Collapsible) Kind() ast.NodeKind { return KindCollapsible }
+
+// Dump implements ast.Node.
+func (n *Collapsible) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": n.Name}, nil)
+}
+
+// collapsibleIndent is the indentation of a collapsible block's content.
+const collapsibleIndent = 4
+
+type collapsibleParser struct{}
+
+func (p *collapsibleParser) Trigger() []byte { return []byte{'?'} }
+
+func (p *collapsibleParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("???")) {
+		return nil, parser.NoChildren
+	}
+	rest := line[pos+3:]
+	open := false
+	if len(rest) > 0 && rest[0] == '+' {
+		open = true
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || !util.IsSpace(rest[0]) {
+		return nil, parser.NoChildren
+	}
+	rest = bytes.TrimSpace(rest)
+	if len(rest) == 0 {
+		return nil, parser.NoChildren
+	}
+	name := rest
+	var title []byte
+	if i := bytes.IndexAny(rest, " \t"); i >= 0 {
+		name = rest[:i]
+		title = bytes.Trim(bytes.TrimSpace(rest[i:]), `"`)
+	}
+	node := &Collapsible{
+		Name:  strings.ToLower(string(name)),
+		Title: append([]byte(nil), title...),
+		Open:  open,
+	}
+	if len(node.Title) == 0 {
+		node.Title = []byte(strings.ToUpper(node.Name[:1]) + node.Name[1:])
+	}
+	reader.Advance(lineLength(line))
+	return node, parser.HasChildren
+}
+
+func (p *collapsibleParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, _ := reader.PeekLine()
+	if util.IsBlank(line) {
+		reader.Advance(len(line) - 1)
+		return parser.Continue | parser.HasChildren
+	}
+	indent, _ := util.IndentWidth(line, reader.LineOffset())
+	if indent < collapsibleIndent {
+		return parser.Close
+	}
+	pos, padding := util.IndentPosition(line, reader.LineOffset(), collapsibleIndent)
+	reader.AdvanceAndSetPadding(pos, padding)
+	return parser.Continue | parser.HasChildren
+}
+
+func (p *collapsibleParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *collapsibleParser) CanInterruptParagraph() bool { return true }
+
+func (p *collapsibleParser) CanAcceptIndentedLine() bool { return false }
+
+type collapsibleRenderer struct{}
+
+func (r *collapsibleRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindCollapsible, r.render)
+}
+
+func (r *collapsibleRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	c := node.(*Collapsible)
+	if !entering {
+		w.WriteString("</details>\n")
+		return ast.WalkContinue, nil
+	}
+	class, ok := containerClasses[c.Name]
+	if !ok {
+		class = "custom-block " + c.Name
+	}
+	w.WriteString(`<details class="`)
+	w.Write(util.EscapeHTML([]byte(class)))
+	w.WriteString(`"`)
+	if c.Open {
+		w.WriteString(" open")
+	}
+	w.WriteString("><summary>")
+	w.Write(util.EscapeHTML(c.Title))
+	w.WriteString("</summary>\n")
+	return ast.WalkContinue, nil
+}
+
+type collapsibleExtension struct{}
+
+// Collapsibles is a goldmark.Extender adding `???` collapsible blocks,
+// rendered as <details> elements styled like ::: containers.
+var Collapsibles goldmark.Extender = &collapsibleExtension{}
+
+func (e *collapsibleExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(
+		util.Prioritized(&collapsibleParser{}, 90),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&collapsibleRenderer{}, 500),
+	))
+}
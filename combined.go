@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	// fileSections maps the heading ID of each generated file header to the
+	// prefix given to the headings of that file. It is nil for a single input.
+	fileSections map[string]string
+
+	// fileIDs holds the header ID of each combined input, numbered where
+	// two paths slug alike (a-b.md and a.b.md).
+	fileIDs map[string]string
+)
+
+// fileHeaderID returns the ID of the generated header for path.
+func fileHeaderID(path string) string {
+	if id, ok := fileIDs[path]; ok {
+		return id
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(filepath.ToSlash(path)) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else if b.Len() > 0 && !strings.HasSuffix(b.String(), "-") {
+			b.WriteByte('-')
+		}
+	}
+	return "file-" + strings.TrimSuffix(b.String(), "-")
+}
+
+// setFileSections prepares heading namespaces for a combined document.
+func setFileSections(paths []string) {
+	fileSections, fileIDs = nil, nil
+	if len(paths) < 2 {
+		return
+	}
+	sections := make(map[string]string, len(paths))
+	ids := make(map[string]string, len(paths))
+	for _, p := range paths {
+		base := fileHeaderID(p)
+		id := base
+		for n := 2; sections[id] != ""; n++ {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		ids[p] = id
+		sections[id] = strings.TrimPrefix(id, "file-") + "--"
+	}
+	fileSections, fileIDs = sections, ids
+}
+
+// combinedLinks points the links of a combined document at its headings as
+// namespaced there: in c1.md, "#setup" goes to "#c1-md--setup", and
+// "c2.md#setup" to "#c2-md--setup" (or "c2.md" to its header) when c2.md
+// is combined too.
+var combinedLinks = util.Prioritized(&combinedLinkTransformer{}, 1100)
+
+type combinedLinkTransformer struct{}
+
+func (t *combinedLinkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ids, ok := pc.IDs().(*headingIDs)
+	if !ok || len(ids.sections) == 0 {
+		return
+	}
+	byHeader := make(map[string]string, len(inputPaths)) // header ID → input
+	byPath := make(map[string]string, len(inputPaths))   // absolute path → input
+	for _, p := range inputPaths {
+		byHeader[fileHeaderID(p)] = p
+		if abs, err := filepath.Abs(p); err == nil {
+			byPath[abs] = p
+		}
+	}
+	current := ""
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if h, ok := n.(*ast.Heading); ok {
+			if id, ok := h.AttributeString("id"); ok {
+				if p, ok := byHeader[string(attrValue(id))]; ok {
+					current = p
+				}
+			}
+			return ast.WalkContinue, nil
+		}
+		link, ok := n.(*ast.Link)
+		if !ok || current == "" {
+			return ast.WalkContinue, nil
+		}
+		u, err := url.Parse(string(link.Destination))
+		if err != nil || u.Scheme != "" || u.Host != "" || u.RawQuery != "" {
+			return ast.WalkContinue, nil
+		}
+		target := current
+		if u.Path != "" {
+			from := filepath.Dir(current)
+			if strings.HasPrefix(u.Path, "/") {
+				from = baseDir
+			}
+			abs, err := filepath.Abs(filepath.Join(from, filepath.FromSlash(u.Path)))
+			if err != nil {
+				return ast.WalkContinue, nil
+			}
+			if target, ok = byPath[abs]; !ok {
+				return ast.WalkContinue, nil
+			}
+		}
+		header := fileHeaderID(target)
+		switch id := ids.sections[header] + u.Fragment; {
+		case u.Fragment != "" && ids.values[id]:
+			link.Destination = []byte("#" + id)
+		case u.Path == "":
+			// An explicit {#id}, a file header or a broken link: as written.
+		case u.Fragment != "" && ids.values[u.Fragment]:
+			link.Destination = []byte("#" + u.Fragment)
+		default:
+			link.Destination = []byte("#" + header)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// combineInputs joins the inputs into one document. With several files,
+// each starts with an H1 naming it, separated by a rule.
+func combineInputs(paths []string, data [][]byte) []byte {
+	if len(data) == 1 {
+		return data[0]
+	}
+	var buf bytes.Buffer
+	for i, p := range paths {
+		if i > 0 {
+			buf.WriteString("\n\n---\n\n")
+		}
+		buf.WriteString("# " + escapeMarkdown(filepath.ToSlash(p)))
+		buf.WriteString(" {#" + fileHeaderID(p) + " .file-header}\n\n")
+		buf.Write(data[i])
+	}
+	return buf.Bytes()
+}
+
+// escapeMarkdown backslash-escapes ASCII punctuation so s renders verbatim.
+func escapeMarkdown(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 128 && strings.ContainsRune("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
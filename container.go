@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// containerClasses maps a container name (`::: warning`) to the CSS classes
+// of the rendered div. Names without an entry render as "custom-block <name>".
+// Entries can be added or overridden with --container name=classes.
+var containerClasses = map[string]string{
+	"note":      "custom-block note",
+	"info":      "custom-block info",
+	"tip":       "custom-block tip",
+	"important": "custom-block important",
+	"warning":   "custom-block warning",
+	"danger":    "custom-block danger",
+	"caution":   "custom-block danger",
+}
+
+// containerFlag is a repeatable name=classes flag updating containerClasses.
+type containerFlag struct{}
+
+func (containerFlag) String() string { return "" }
+
+func (containerFlag) Set(v string) error {
+	name, class, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=classes, got %q", v)
+	}
+	containerClasses[strings.ToLower(name)] = class
+	return nil
+}
+
+// KindContainer is the node kind of a fenced container block.
+var KindContainer = ast.NewNodeKind("Container")
+
+// A Container is a `::: name [title]` ... `:::` fenced block whose contents
+// are parsed as regular Markdown.
+type Container struct {
+	ast.BaseBlock
+	Name  string
+	Title []byte
+
+	fence int
+}
+
+// Kind implements ast.Node.
+func (n *Container) Kind() ast.NodeKind { return KindContainer }
+
+// Dump implements ast.Node.
+func (n *Container) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": n.Name}, nil)
+}
+
+type containerParser struct{}
+
+func (p *containerParser) Trigger() []byte { return []byte{':'} }
+
+// fenceLength returns the number of leading colons of line if it is a
+// container fence (at least three colons), otherwise 0.
+func fenceLength(line []byte) int {
+	i := 0
+	for i < len(line) && line[i] == ':' {
+		i++
+	}
+	if i < 3 {
+		return 0
+	}
+	return i
+}
+
+// lineLength returns the length of line without its trailing newline.
+func lineLength(line []byte) int {
+	n := len(line)
+	if n > 0 && line[n-1] == '\n' {
+		n--
+		if n > 0 && line[n-1] == '\r' {
+			n--
+		}
+	}
+	return n
+}
+
+func (p *containerParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	fence := fenceLength(line[pos:])
+	if fence == 0 {
+		return nil, parser.NoChildren
+	}
+	rest := bytes.TrimSpace(line[pos+fence:])
+	if len(rest) == 0 {
+		// A bare fence only closes containers.
+		return nil, parser.NoChildren
+	}
+	name := rest
+	var title []byte
+	if i := bytes.IndexAny(rest, " \t"); i >= 0 {
+		name = rest[:i]
+		title = bytes.TrimSpace(rest[i:])
+	}
+	if len(title) >= 2 && title[0] == '"' && title[len(title)-1] == '"' {
+		title = title[1 : len(title)-1]
+	}
+	node := &Container{
+		Name:  strings.ToLower(string(name)),
+		Title: append([]byte(nil), title...),
+		fence: fence,
+	}
+	reader.Advance(lineLength(line))
+	return node, parser.HasChildren
+}
+
+func (p *containerParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	c := node.(*Container)
+	line, _ := reader.PeekLine()
+	w, pos := util.IndentWidth(line, reader.LineOffset())
+	if w < 4 {
+		if n := fenceLength(line[pos:]); n >= c.fence && util.IsBlank(line[pos+n:]) {
+			reader.Advance(lineLength(line))
+			return parser.Close
+		}
+	}
+	return parser.Continue | parser.HasChildren
+}
+
+func (p *containerParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *containerParser) CanInterruptParagraph() bool { return true }
+
+func (p *containerParser) CanAcceptIndentedLine() bool { return false }
+
+type containerRenderer struct{}
+
+func (r *containerRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindContainer, r.render)
+}
+
+func (r *containerRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	c := node.(*Container)
+	if !entering {
+		w.WriteString("</div>\n")
+		return ast.WalkContinue, nil
+	}
+	class, ok := containerClasses[c.Name]
+	if !ok {
+		class = "custom-block " + c.Name
+	}
+	w.WriteString(`<div class="`)
+	w.Write(util.EscapeHTML([]byte(class)))
+	w.WriteString(`">` + "\n")
+	if len(c.Title) > 0 {
+		w.WriteString(`<p class="custom-block-title">`)
+		w.Write(util.EscapeHTML(c.Title))
+		w.WriteString("</p>\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+type containerExtension struct{}
+
+// Containers is a goldmark.Extender adding `:::` fenced container blocks.
+// Nested containers need a longer outer fence (`::::` around `:::`).
+var Containers goldmark.Extender = &containerExtension{}
+
+func (e *containerExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(
+		util.Prioritized(&containerParser{}, 90),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&containerRenderer{}, 500),
+	))
+}
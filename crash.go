@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Crash reports stay on this machine: a panic is written to a text file
+// under the user cache directory and its path printed, so it can be
+// attached to a bug report. Reports carry the stack, command line, version
+// and platform, never document content.
+
+func crashDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mdview", "crashes"), nil
+}
+
+// writeCrashReport saves a report for the panic value v, with context such
+// as the request being served, and returns its path.
+func writeCrashReport(v interface{}, stack []byte, context string) (string, error) {
+	dir, err := crashDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	rev, date := buildInfo()
+	var b strings.Builder
+	fmt.Fprintf(&b, "mdview crash report, %s\n\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "version:  %s", version)
+	if rev != "" {
+		fmt.Fprintf(&b, " (%s %s)", rev, date)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "platform: %s/%s %s\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	fmt.Fprintf(&b, "args:     %q\n", os.Args[1:])
+	if context != "" {
+		fmt.Fprintf(&b, "during:   %s\n", context)
+	}
+	fmt.Fprintf(&b, "\npanic: %v\n\n%s", v, stack)
+	path := filepath.Join(dir, "crash-"+now.UTC().Format("20060102T150405.000Z")+".txt")
+	return path, os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+func reportCrash(v interface{}, context string) {
+	path, err := writeCrashReport(v, debug.Stack(), context)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mdview crashed (%v) and could not save a report: %v\n", v, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "mdview crashed: %v\nA report was saved to %s (nothing was sent anywhere).\n", v, path)
+}
+
+// recoverCrash is deferred by main and the long-running goroutines: it
+// turns a panic into a saved report and exit status 2.
+func recoverCrash() {
+	if v := recover(); v != nil {
+		reportCrash(v, "")
+		os.Exit(2)
+	}
+}
+
+// recoverHandler reports a panic in a request handler and answers 500;
+// the server keeps running. The query is left out, as it may hold a share
+// token.
+func recoverHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				reportCrash(v, r.Method+" "+r.URL.Path)
+				http.Error(w, "internal error; see the terminal running mdview", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
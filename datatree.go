@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v3"
+)
+
+// treeLines is the size from which fenced json/yaml blocks render as a
+// collapsible tree with search (--tree-lines; 0 disables). The highlighted
+// source stays one click away.
+var treeLines = 40
+
+// treeOpenDepth is how many levels of a tree start expanded.
+const treeOpenDepth = 2
+
+// parseDataDocs parses every document of a YAML stream (JSON included).
+func parseDataDocs(src []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(src))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+}
+
+// dataTreeBlock renders large json/yaml fences as a tree.
+type dataTreeBlock struct{}
+
+func init() {
+	registerBlock("tree", []string{"json", "yaml", "yml"}, true, dataTreeBlock{})
+}
+
+// Prepare returns the parsed documents. Small blocks, and blocks that
+// don't parse, stay plain code.
+func (dataTreeBlock) Prepare(fcb *ast.FencedCodeBlock, lang string, code []byte) interface{} {
+	if treeLines <= 0 || fcb.Lines().Len() < treeLines {
+		return nil
+	}
+	docs, err := parseDataDocs(code)
+	if err != nil || len(docs) == 0 {
+		return nil
+	}
+	return docs
+}
+
+func (dataTreeBlock) Render(w util.BufWriter, state interface{}, entering bool) ast.WalkStatus {
+	if !entering {
+		w.WriteString("</div>\n</div>\n")
+		return ast.WalkContinue
+	}
+	w.WriteString(`<div class="data-tree">` + "\n")
+	w.WriteString(`<div class="data-tree-bar"><input type="search" placeholder="Search keys and values" aria-label="Search block">` +
+		`<span class="data-tree-count"></span>` +
+		`<button type="button" data-tree="expand">Expand all</button>` +
+		`<button type="button" data-tree="collapse">Collapse all</button>` +
+		`<button type="button" data-tree="raw">Source</button></div>` + "\n")
+	w.WriteString(`<div class="data-tree-view">` + "\n")
+	for _, doc := range state.([]*yaml.Node) {
+		if len(doc.Content) == 0 {
+			continue
+		}
+		w.WriteString(`<ul class="tree">`)
+		writeTreeValue(w, "", doc.Content[0], 0)
+		w.WriteString("</ul>\n")
+	}
+	w.WriteString("</div>\n")
+	// The original block, highlighted as usual, for the Source toggle.
+	w.WriteString(`<div class="data-tree-source" hidden>` + "\n")
+	return ast.WalkContinue
+}
+
+// writeTreeValue writes one tree item: key (empty at the root and for
+// sequence items, which show their index) and value.
+func writeTreeValue(w util.BufWriter, key string, n *yaml.Node, depth int) {
+	label := ""
+	if key != "" {
+		label = `<span class="k">` + html.EscapeString(key) + `</span>`
+	}
+	switch n.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		open, brackets, count := "", "[%d]", len(n.Content)
+		if depth < treeOpenDepth {
+			open = " open"
+		}
+		if n.Kind == yaml.MappingNode {
+			brackets, count = "{%d}", len(n.Content)/2
+		}
+		fmt.Fprintf(w, `<li><details%s><summary>%s <span class="n">%s</span></summary><ul>`, open, label, fmt.Sprintf(brackets, count))
+		if n.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				writeTreeValue(w, n.Content[i].Value, n.Content[i+1], depth+1)
+			}
+		} else {
+			for i, c := range n.Content {
+				writeTreeValue(w, strconv.Itoa(i), c, depth+1)
+			}
+		}
+		w.WriteString("</ul></details></li>")
+	case yaml.AliasNode:
+		fmt.Fprintf(w, `<li>%s <span class="v alias">*%s</span></li>`, label, html.EscapeString(n.Value))
+	default:
+		class, value := "str", n.Value
+		switch n.ShortTag() {
+		case "!!int", "!!float":
+			class = "num"
+		case "!!bool":
+			class = "bool"
+		case "!!null":
+			class, value = "null", "null"
+		default:
+			value = strconv.Quote(value)
+		}
+		if label != "" {
+			label += " "
+		}
+		fmt.Fprintf(w, `<li>%s<span class="v %s">%s</span></li>`, label, class, html.EscapeString(value))
+	}
+}
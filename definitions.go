@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// In directory mode the page offers a "go to definition" palette: every
+// heading and every defined term across the directory, where a term is a
+// paragraph or list item led by bold text and a colon ("**Idempotent**: ...").
+
+// definitionTerm returns the term a paragraph (or the text of a tight list
+// item) defines, or "".
+func definitionTerm(n ast.Node, src []byte) string {
+	if n == nil || n.Kind() != ast.KindParagraph && n.Kind() != ast.KindTextBlock {
+		return ""
+	}
+	strong, ok := n.FirstChild().(*ast.Emphasis)
+	if !ok || strong.Level != 2 {
+		return ""
+	}
+	term := strings.TrimSpace(string(strong.Text(src)))
+	if t, ok := strings.CutSuffix(term, ":"); ok {
+		return strings.TrimSpace(t)
+	}
+	next, ok := strong.NextSibling().(*ast.Text)
+	if !ok || !bytes.HasPrefix(next.Segment.Value(src), []byte(":")) {
+		return ""
+	}
+	return term
+}
+
+// definitionTransformer gives definition paragraphs, or the list items they
+// open, an ID (def-<term>) so the palette and ordinary links can point at
+// them.
+type definitionTransformer struct{}
+
+func (t *definitionTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	src := reader.Source()
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Type() != ast.TypeBlock {
+			return ast.WalkContinue, nil
+		}
+		term := definitionTerm(n, src)
+		if term == "" {
+			return ast.WalkContinue, nil
+		}
+		// A text block renders no element of its own.
+		target := n
+		if li, ok := n.Parent().(*ast.ListItem); ok && li.FirstChild() == n {
+			target = li
+		}
+		if _, has := target.AttributeString("id"); !has {
+			target.SetAttributeString("id", pc.IDs().Generate([]byte("def-"+term), ast.KindParagraph))
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+var definitionAttributes = util.Prioritized(&definitionTransformer{}, 750)
+
+// A definition is one palette entry.
+type definition struct {
+	Term   string `json:"term"`
+	File   string `json:"file"` // slash-separated, relative to dirRoot
+	Anchor string `json:"anchor"`
+	Kind   string `json:"kind"` // "heading" or "term"
+}
+
+// defIndex caches the index until a file is added, removed or modified.
+var defIndex struct {
+	sync.Mutex
+	stamp string
+	defs  []definition
+}
+
+// definitions indexes the headings and terms of the files in fsys.
+// Files that cannot be read (deleted meanwhile, say) are left out.
+func definitions(fsys fs.FS) ([]definition, error) {
+	files, err := listMarkdown(fsys)
+	if err != nil {
+		return nil, err
+	}
+	var stamp strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&stamp, "%s\x00%d\x00", f.rel, f.modTime.UnixNano())
+	}
+	defIndex.Lock()
+	defer defIndex.Unlock()
+	if defIndex.defs != nil && defIndex.stamp == stamp.String() {
+		return defIndex.defs, nil
+	}
+
+	defs := []definition{}
+	for _, f := range files {
+		src, err := fs.ReadFile(fsys, f.rel)
+		if err != nil {
+			continue
+		}
+		// Same options as handlePage, so the anchors match the rendered page.
+		doc := markdown().Parser().Parse(text.NewReader(src), parseOptions(nil)...)
+		ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			id, ok := n.AttributeString("id")
+			if !ok {
+				return ast.WalkContinue, nil
+			}
+			switch n := n.(type) {
+			case *ast.Heading:
+				defs = append(defs, definition{string(n.Text(src)), f.rel, string(attrValue(id)), "heading"})
+				return ast.WalkSkipChildren, nil
+			case *ast.Paragraph, *ast.ListItem:
+				term := definitionTerm(n, src)
+				if _, ok := n.(*ast.ListItem); ok {
+					term = definitionTerm(n.FirstChild(), src)
+				}
+				if term != "" {
+					defs = append(defs, definition{term, f.rel, string(attrValue(id)), "term"})
+				}
+				return ast.WalkSkipChildren, nil
+			}
+			return ast.WalkContinue, nil
+		})
+	}
+	defIndex.stamp, defIndex.defs = stamp.String(), defs
+	return defs, nil
+}
+
+// handleDefinitions serves GET /api/definitions in directory mode.
+func handleDefinitions(w http.ResponseWriter, r *http.Request) {
+	if dirRoot == "" {
+		http.NotFound(w, r)
+		return
+	}
+	defs, err := definitions(docStore)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"definitions": defs})
+}
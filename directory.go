@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Directory mode (`mdview docs/`) serves an index of the Markdown files
+// under a directory (or another document store, see store.go), with a
+// heatmap of recent activity taken from git history when available and
+// file mtimes otherwise. A directory with a README opens that instead,
+// unless --browse is given.
+
+var (
+	dirRoot   string // absolute root (or store spec) in directory mode, "" otherwise
+	browseDir bool   // --browse
+)
+
+// heatmapWeeks is how far back the activity heatmap reaches.
+const heatmapWeeks = 53
+
+// A docFile is one Markdown file in the directory index.
+type docFile struct {
+	rel     string // slash-separated path in the store
+	modTime time.Time
+	days    map[string]bool // "2006-01-02" days with changes
+}
+
+// skipDir reports whether the index should not descend into name.
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "node_modules" || name == "vendor"
+}
+
+func isMarkdown(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// listMarkdown returns the Markdown files in fsys, sorted by path.
+// Symlinks are left out: one could lead outside the tree, and the index,
+// definitions and audits read what they list.
+func listMarkdown(fsys fs.FS) ([]*docFile, error) {
+	var files []*docFile
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != "." && skipDir(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !isMarkdown(d.Name()) || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, &docFile{rel: path, modTime: info.ModTime(), days: map[string]bool{}})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+	return files, err
+}
+
+// addActivity fills in the days each file changed: commit dates from git
+// when root is in a repository, else the modification date alone.
+func addActivity(root string, files []*docFile) {
+	byRel := make(map[string]*docFile, len(files))
+	for _, f := range files {
+		byRel[f.rel] = f
+	}
+	since := time.Now().AddDate(0, 0, -7*heatmapWeeks).Format("2006-01-02")
+	cmd := exec.Command("git", "-C", root, "-c", "core.quotePath=false", "log", "--since="+since, "--date=short",
+		"--format=@%ad", "--name-only", "--relative", "--", "*.md", "*.markdown")
+	out, err := cmd.Output()
+	if err == nil {
+		var day string
+		sc := bufio.NewScanner(bytes.NewReader(out))
+		for sc.Scan() {
+			line := sc.Text()
+			if strings.HasPrefix(line, "@") {
+				day = line[1:]
+			} else if f := byRel[line]; f != nil && day != "" {
+				f.days[day] = true
+			}
+		}
+	}
+	for _, f := range files {
+		// Uncommitted edits count too.
+		f.days[f.modTime.Format("2006-01-02")] = true
+	}
+}
+
+// heatLevel buckets a day's change count into the heatmap's five shades.
+func heatLevel(n int) int {
+	switch {
+	case n == 0:
+		return 0
+	case n == 1:
+		return 1
+	case n <= 3:
+		return 2
+	case n <= 6:
+		return 3
+	}
+	return 4
+}
+
+// renderDirectory writes the index body: heatmap, then the file list.
+func renderDirectory(root string, files []*docFile) []byte {
+	counts := make(map[string]int)
+	for _, f := range files {
+		for d := range f.days {
+			counts[d]++
+		}
+	}
+
+	var b bytes.Buffer
+	if repo != nil {
+		fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(displayName(repo.label)))
+		b.WriteString(repo.refPicker())
+	} else {
+		fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(displayName(filepath.Base(root))))
+	}
+
+	// Columns are weeks, Sunday first, ending with the current week.
+	today := time.Now()
+	start := today.AddDate(0, 0, -7*(heatmapWeeks-1)-int(today.Weekday()))
+	b.WriteString(`<div class="heatmap" role="grid" aria-label="Documentation activity">` + "\n")
+	for d := start; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		n := counts[day]
+		fmt.Fprintf(&b, `<button type="button" class="l%d" data-day="%s" title="%s: %d file(s) changed"></button>`,
+			heatLevel(n), day, d.Format("Jan 2, 2006"), n)
+	}
+	b.WriteString("</div>\n")
+	b.WriteString(`<p class="heatmap-filter" hidden>Showing files changed on <span></span> <button type="button">Show all</button></p>` + "\n")
+
+	b.WriteString(`<ul class="dir-index">` + "\n")
+	for _, f := range files {
+		days := make([]string, 0, len(f.days))
+		for d := range f.days {
+			days = append(days, d)
+		}
+		sort.Strings(days)
+		u := url.URL{Path: siteSlug(docStore, f.rel)}
+		fmt.Fprintf(&b, `<li data-days="%s"><a href="%s">%s</a> <time datetime="%s">%s</time></li>`+"\n",
+			strings.Join(days, " "), html.EscapeString(u.EscapedPath()), html.EscapeString(displayName(f.rel)),
+			f.modTime.Format(time.RFC3339), f.modTime.Format("Jan 2, 2006"))
+	}
+	b.WriteString("</ul>\n")
+	if len(files) == 0 {
+		b.WriteString("<p>No Markdown files found.</p>\n")
+	}
+	return b.Bytes()
+}
+
+// handleDirectory serves the directory index at "/".
+func handleDirectory(w http.ResponseWriter, r *http.Request) {
+	if b, ok := docStore.(*bucketFS); ok {
+		if err := b.Refresh(); err != nil {
+			fmt.Fprintf(os.Stderr, "mdview: %v\n", err)
+		}
+	}
+	files, err := listMarkdown(docStore)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isDirectory(dirRoot) {
+		addActivity(dirRoot, files)
+	} else {
+		for _, f := range files {
+			f.days[f.modTime.Format("2006-01-02")] = true
+		}
+	}
+	var latest time.Time
+	for _, f := range files {
+		if f.modTime.After(latest) {
+			latest = f.modTime
+		}
+	}
+	name := dirRoot
+	if repo != nil {
+		name = repo.label
+	}
+	writePage(w, r, name, nil, renderDirectory(dirRoot, files), latest, false)
+}
+
+// readmeNames are the files findReadme looks for, in order, compared
+// case-insensitively.
+var readmeNames = []string{"README.md", "README.markdown", "index.md", "docs/README.md", "docs/index.md"}
+
+// findReadme returns the path of dir's README, as a repository host would
+// show it, or "" if it has none.
+func findReadme(dir string) string {
+	for _, name := range readmeNames {
+		parent, base := filepath.Split(filepath.FromSlash(name))
+		entries, err := os.ReadDir(filepath.Join(dir, parent))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() && strings.EqualFold(e.Name(), base) {
+				return filepath.Join(dir, parent, e.Name())
+			}
+		}
+	}
+	return ""
+}
+
+// isDirectory reports whether path names a directory.
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
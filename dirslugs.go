@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// In directory mode, Markdown files are served at readable slugs: "Getting
+// Started.md" in "User Guide" is /user-guide/getting-started, not
+// /User%20Guide/Getting%20Started.md. The index links to slugs, and a
+// request for a file's own path (a link in a document, an old bookmark) is
+// redirected to its slug. Files whose slugs collide are numbered in path
+// order: the first keeps getting-started, the next is getting-started-2.
+// Directories are slugged the same way, and a URL under a slugged
+// directory maps back to the real one, so a page's relative images still
+// load. A path that names a real file always serves that file.
+
+// sitePathSlugs caches the slugs until a file is added, removed or renamed.
+var sitePathSlugs struct {
+	sync.Mutex
+	stamp  string
+	toSlug map[string]string // rel → slug, files and directories
+	files  map[string]string // slug → rel
+	dirs   map[string]string // slug → rel
+}
+
+// pathSlug turns one path segment into its slug: lowercase letters and
+// digits, other runs of characters as one "-".
+func pathSlug(seg string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range transliterate(seg) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return seg
+	}
+	return b.String()
+}
+
+// siteSlugs returns the slug maps for the Markdown files in fsys and their
+// directories.
+func siteSlugs(fsys fs.FS) (toSlug, files, dirs map[string]string) {
+	list, _ := listMarkdown(fsys)
+	var stamp strings.Builder
+	for _, f := range list {
+		fmt.Fprintf(&stamp, "%s\x00", f.rel)
+	}
+	sitePathSlugs.Lock()
+	defer sitePathSlugs.Unlock()
+	if sitePathSlugs.toSlug != nil && sitePathSlugs.stamp == stamp.String() {
+		return sitePathSlugs.toSlug, sitePathSlugs.files, sitePathSlugs.dirs
+	}
+
+	toSlug, files, dirs = map[string]string{".": ""}, map[string]string{}, map[string]string{}
+	// slugFor assigns rel (a directory when dir is set) the first free slug
+	// in its parent's slugged directory.
+	var slugFor func(rel string, dir bool) string
+	slugFor = func(rel string, dir bool) string {
+		if s, ok := toSlug[rel]; ok {
+			return s
+		}
+		parent, base := path.Split(rel)
+		prefix := slugFor(path.Clean(parent), true)
+		if prefix != "" {
+			prefix += "/"
+		}
+		taken := dirs
+		if !dir {
+			base = strings.TrimSuffix(base, path.Ext(base))
+			taken = files
+		}
+		slug := prefix + pathSlug(base)
+		for n := 2; taken[slug] != ""; n++ {
+			slug = fmt.Sprintf("%s%s-%d", prefix, pathSlug(base), n)
+		}
+		toSlug[rel], taken[slug] = slug, rel
+		return slug
+	}
+	for _, f := range list {
+		slugFor(f.rel, false)
+	}
+	sitePathSlugs.stamp, sitePathSlugs.toSlug = stamp.String(), toSlug
+	sitePathSlugs.files, sitePathSlugs.dirs = files, dirs
+	return toSlug, files, dirs
+}
+
+// siteSlug returns the URL path of the Markdown file rel in fsys.
+func siteSlug(fsys fs.FS, rel string) string {
+	toSlug, _, _ := siteSlugs(fsys)
+	if s, ok := toSlug[rel]; ok {
+		return "/" + s
+	}
+	return "/" + rel
+}
+
+// resolveSlug returns the file of fsys that the URL path rel (cleaned, no
+// leading slash) names: rel itself if it exists, else the Markdown file
+// with that slug, else rel under the real directories of its slugged ones.
+func resolveSlug(fsys fs.FS, rel string) string {
+	if _, err := fs.Stat(fsys, rel); err == nil {
+		return rel
+	}
+	_, files, dirs := siteSlugs(fsys)
+	if real, ok := files[rel]; ok {
+		return real
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if real, ok := dirs[dir]; ok {
+			return real + strings.TrimPrefix(rel, dir)
+		}
+	}
+	return rel
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// With --discovery-port every instance registers the document it serves in
+// a per-user directory, and whichever instance holds the well-known port
+// answers "where is this document now?" for tabs orphaned by a restart.
+
+var discoveryPort int
+
+// An instance is one registry entry.
+type instance struct {
+	URL      string `json:"url"`
+	Document string `json:"document"`
+	Started  int64  `json:"started"`
+}
+
+func registryDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mdview", "instances"), nil
+}
+
+// registerInstance records this process in the registry and returns a
+// function that removes the entry again.
+func registerInstance(serverURL, document string) (func(), error) {
+	dir, err := registryDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(instance{URL: serverURL, Document: document, Started: time.Now().UnixNano()})
+	path := filepath.Join(dir, strconv.Itoa(os.Getpid())+".json")
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// lookupInstance returns the newest live instance serving document. Entries
+// left behind by crashed processes are removed as they are found.
+func lookupInstance(document string) (instance, bool) {
+	dir, err := registryDir()
+	if err != nil {
+		return instance{}, false
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	var best instance
+	for _, path := range entries {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var in instance
+		if json.Unmarshal(data, &in) != nil || in.Document != document || in.Started < best.Started {
+			continue
+		}
+		if !instanceAlive(in.URL) {
+			os.Remove(path)
+			continue
+		}
+		best = in
+	}
+	return best, best.URL != ""
+}
+
+func instanceAlive(serverURL string) bool {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 200*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// serveDiscovery keeps trying to bind the discovery port, so another
+// instance takes over when the current holder exits.
+func serveDiscovery(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/instances", handleInstances)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	addr := fmt.Sprintf("localhost:%d", discoveryPort)
+	for {
+		if listener, err := net.Listen("tcp", addr); err == nil {
+			server.Serve(listener)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// handleInstances serves GET /instances?document=... to pages on other
+// localhost ports. Other origins are refused so websites can't probe which
+// files are open.
+func handleInstances(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if u, err := url.Parse(origin); origin == "" || err != nil || !isLoopbackHost(u.Hostname()) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Vary", "Origin")
+	in, ok := lookupInstance(r.URL.Query().Get("document"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, map[string]string{"url": in.URL})
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
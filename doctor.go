@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// runDoctor implements `mdview doctor`: check the things mdview relies on
+// from its environment and say what to do about the ones that are missing.
+func runDoctor(argv []string) error {
+	fs := flag.NewFlagSet("mdview doctor", flag.ContinueOnError)
+	port := fs.Int("port", 0, "also check that this `port` can be bound")
+	parseFlags(fs, argv)
+
+	rev, _ := buildInfo()
+	fmt.Printf("mdview %s %s %s/%s %s\n\n", version, rev, runtime.GOOS, runtime.GOARCH, runtime.Version())
+
+	failed := false
+	report := func(status, check, detail string) {
+		if status == "FAIL" {
+			failed = true
+		}
+		fmt.Printf("%-5s %-16s %s\n", status, check, detail)
+	}
+
+	// Browser opener, as used by openBrowser.
+	opener := map[string]string{"darwin": "open", "linux": "xdg-open", "windows": "rundll32"}[runtime.GOOS]
+	if b := browser(); b != "" {
+		args := browserArgs(b, "URL")
+		if _, err := exec.LookPath(args[0]); err != nil {
+			report("warn", "browser", strings.Fields(b)[0]+" not found (--browser or $BROWSER)")
+		} else {
+			report("ok", "browser", strings.Join(args, " "))
+		}
+	} else if opener == "" {
+		report("warn", "browser", "no opener for "+runtime.GOOS+"; open the printed URL yourself")
+	} else if path, err := exec.LookPath(opener); err != nil {
+		report("warn", "browser", opener+" not found; open the printed URL yourself (on Linux, install xdg-utils)")
+	} else {
+		report("ok", "browser", path)
+	}
+
+	// Port binding: the loopback interface, the port remembered for this
+	// directory, and --port.
+	if l, err := net.Listen("tcp", "localhost:0"); err != nil {
+		report("FAIL", "port", "cannot listen on localhost: "+err.Error())
+	} else {
+		l.Close()
+		report("ok", "port", "can listen on localhost")
+	}
+	cwd, _ := os.Getwd()
+	ports := map[string]int{}
+	if p := rememberedPorts()[cwd]; p != 0 {
+		ports["remembered"] = p
+	}
+	if *port != 0 {
+		ports["--port"] = *port
+	}
+	for what, p := range ports {
+		if l, err := net.Listen("tcp", "localhost:"+strconv.Itoa(p)); err != nil {
+			status := "warn"
+			if what == "--port" {
+				status = "FAIL"
+			}
+			report(status, "port "+strconv.Itoa(p), fmt.Sprintf("%s port is busy (%v); mdview falls back to a nearby one unless --strict-port", what, err))
+		} else {
+			l.Close()
+			report("ok", "port "+strconv.Itoa(p), what+" port is free")
+		}
+	}
+
+	// File watching, for documents in this directory.
+	if fsType, ok := networkFilesystem(cwd); ok {
+		report("warn", "file watching", "this directory is on a "+fsType+" filesystem; changes are found by polling")
+	} else if w, err := fsnotify.NewWatcher(); err != nil {
+		report("warn", "file watching", "notifications unavailable ("+err.Error()+"); changes are found by polling")
+	} else {
+		w.Close()
+		report("ok", "file watching", "filesystem notifications")
+	}
+
+	// The descriptor limit bounds how many tabs can hold a live-reload
+	// connection.
+	if n, ok := openFileLimit(); ok {
+		if n < 256 {
+			report("warn", "open files", fmt.Sprintf("limit is %d; raise it (ulimit -n) if many tabs stay open", n))
+		} else {
+			report("ok", "open files", fmt.Sprintf("limit is %d", n))
+		}
+	}
+
+	// Cache directory, for remembered ports, snapshots and crash reports.
+	if dir, err := os.UserCacheDir(); err != nil {
+		report("warn", "cache dir", err.Error()+"; ports and snapshots are not remembered")
+	} else {
+		dir = filepath.Join(dir, "mdview")
+		f, err := os.CreateTemp(dir, ".doctor-*")
+		if os.IsNotExist(err) {
+			if err = os.MkdirAll(dir, 0o700); err == nil {
+				f, err = os.CreateTemp(dir, ".doctor-*")
+			}
+		}
+		if err != nil {
+			report("warn", "cache dir", dir+" is not writable: "+err.Error())
+		} else {
+			f.Close()
+			os.Remove(f.Name())
+			report("ok", "cache dir", dir)
+		}
+	}
+
+	// Optional tools.
+	for _, t := range []struct{ name, use string }{
+		{"git", "directory heatmaps, staleness banners and git repository URLs"},
+		{"dot", "```dot blocks (Graphviz)"},
+		{"plantuml", "```plantuml blocks with --plantuml local"},
+	} {
+		if path, err := exec.LookPath(t.name); err != nil {
+			report("warn", t.name, "not found; needed for "+t.use)
+		} else {
+			report("ok", t.name, path)
+		}
+	}
+
+	if dir, err := crashDir(); err == nil {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+			report("info", "crash reports", fmt.Sprintf("%d in %s", len(entries), dir))
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("doctor found problems")
+	}
+	return nil
+}
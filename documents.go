@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The loaded documents live in a DocumentStore. A stored Document is never
+// modified: a change swaps in a new one, so a handler that took one sees
+// matching content, path and version however long it runs. Subscribers
+// hear about every swap; serve turns that into reload events.
+
+// mainDocument is the key of the document being viewed: the input files
+// combined, stdin or the aggregated fragments.
+const mainDocument = "main"
+
+// A Document is one loaded source at one point in time.
+type Document struct {
+	Path     string // first input file, for display and settings; "" for stdin
+	Content  []byte
+	Modified time.Time
+	Version  uint64 // set by the store, unique across it; keys the render cache
+}
+
+// A DocumentStore holds documents by key. It is safe for concurrent use.
+type DocumentStore struct {
+	mu      sync.RWMutex
+	docs    map[string]Document
+	version uint64 // last version handed out
+
+	subs    map[int]func(key string, d Document)
+	nextSub int
+}
+
+func NewDocumentStore() *DocumentStore {
+	return &DocumentStore{
+		docs: make(map[string]Document),
+		subs: make(map[int]func(string, Document)),
+	}
+}
+
+// docs holds this process's documents.
+var docs = NewDocumentStore()
+
+// Get returns the document stored under key, or a zero Document.
+func (s *DocumentStore) Get(key string) Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.docs[key]
+}
+
+// Set stores d under key with a new version and returns it as stored.
+func (s *DocumentStore) Set(key string, d Document) Document {
+	d, _ = s.Update(key, func(Document) (Document, bool) { return d, true })
+	return d
+}
+
+// Update replaces the document under key with what fn makes of the current
+// one, atomically; when fn reports false nothing changes. fn runs with the
+// store locked and must not use it.
+func (s *DocumentStore) Update(key string, fn func(cur Document) (Document, bool)) (Document, bool) {
+	s.mu.Lock()
+	cur := s.docs[key]
+	next, ok := fn(cur)
+	if !ok {
+		s.mu.Unlock()
+		return cur, false
+	}
+	s.version++
+	next.Version = s.version
+	s.docs[key] = next
+	subs := s.subscribers()
+	s.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(key, next)
+	}
+	return next, true
+}
+
+// Invalidate gives every document a new version without changing it, for
+// changes to how they all render (the renderer options, a lock).
+func (s *DocumentStore) Invalidate() {
+	s.mu.Lock()
+	s.version++ // the ETag moves even with nothing stored
+	changed := make(map[string]Document, len(s.docs))
+	for key, d := range s.docs {
+		s.version++
+		d.Version = s.version
+		s.docs[key] = d
+		changed[key] = d
+	}
+	subs := s.subscribers()
+	s.mu.Unlock()
+
+	for key, d := range changed {
+		for _, fn := range subs {
+			fn(key, d)
+		}
+	}
+}
+
+// Version returns the last version handed out; it changes with any swap.
+func (s *DocumentStore) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// Subscribe calls fn after every swap, outside the store's lock, until the
+// returned function is called.
+func (s *DocumentStore) Subscribe(fn func(key string, d Document)) (unsubscribe func()) {
+	s.mu.Lock()
+	id := s.nextSub
+	s.nextSub++
+	s.subs[id] = fn
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		delete(s.subs, id)
+		s.mu.Unlock()
+	}
+}
+
+// subscribers returns the callbacks; s.mu must be held.
+func (s *DocumentStore) subscribers() []func(string, Document) {
+	subs := make([]func(string, Document), 0, len(s.subs))
+	for _, fn := range s.subs {
+		subs = append(subs, fn)
+	}
+	return subs
+}
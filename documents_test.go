@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDocumentStoreUpdate(t *testing.T) {
+	s := NewDocumentStore()
+	var seen []uint64
+	var seenMu sync.Mutex
+	unsubscribe := s.Subscribe(func(key string, d Document) {
+		seenMu.Lock()
+		seen = append(seen, d.Version)
+		seenMu.Unlock()
+	})
+
+	// Concurrent appends all land, as with --follow and a reader racing.
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Update("doc", func(cur Document) (Document, bool) {
+				cur.Content = append(cur.Content, 'x')
+				return cur, true
+			})
+			_ = s.Get("doc").Content
+		}()
+	}
+	wg.Wait()
+	if got := len(s.Get("doc").Content); got != 50 {
+		t.Errorf("content has %d bytes after 50 appends", got)
+	}
+	if len(seen) != 50 {
+		t.Errorf("subscriber saw %d swaps, want 50", len(seen))
+	}
+
+	// Declining an update changes nothing and tells nobody.
+	before := s.Get("doc")
+	if _, ok := s.Update("doc", func(cur Document) (Document, bool) { return cur, false }); ok {
+		t.Error("declined update reported a change")
+	}
+	if s.Get("doc").Version != before.Version || len(seen) != 50 {
+		t.Error("declined update swapped the document")
+	}
+
+	unsubscribe()
+	s.Set("doc", Document{Path: "new.md"})
+	if len(seen) != 50 {
+		t.Error("subscriber called after unsubscribing")
+	}
+}
+
+func TestDocumentStoreInvalidate(t *testing.T) {
+	s := NewDocumentStore()
+	a := s.Set("a", Document{Content: []byte("a")})
+	b := s.Set("b", Document{Content: []byte("b")})
+	version := s.Version()
+
+	s.Invalidate()
+	if s.Version() == version {
+		t.Error("store version unchanged")
+	}
+	for _, old := range []Document{a, b} {
+		key := string(old.Content)
+		d := s.Get(key)
+		if d.Version == old.Version || string(d.Content) != key {
+			t.Errorf("%s: version %d -> %d, content %q", key, old.Version, d.Version, d.Content)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"golang.org/x/term"
+)
+
+// The toolbar's ✎ button opens the source in an editor at the block in
+// view. The renderer tags top-level blocks and list items with data-line,
+// the source line they start on; the page sends the line of the first one
+// on screen to /api/edit, which maps it back to an input file and runs the
+// editor: --editor, else $VISUAL, else $EDITOR. The editor opens on the
+// machine mdview runs on, so only requests from there may ask.
+
+// editorCommand is --editor.
+var editorCommand string
+
+// sourceLineTransformer sets data-line on the top-level blocks and list
+// items.
+type sourceLineTransformer struct{}
+
+func (t *sourceLineTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	src := reader.Source()
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Type() != ast.TypeBlock || n == doc {
+			return ast.WalkContinue, nil
+		}
+		if n.Parent() != doc && n.Kind() != ast.KindListItem {
+			return ast.WalkContinue, nil
+		}
+		// Containers have no lines of their own; use their first block's.
+		for c := n; c != nil && c.Type() == ast.TypeBlock; c = c.FirstChild() {
+			if line := lineOf(src, c); line > 0 {
+				n.SetAttributeString("data-line", []byte(strconv.Itoa(line)))
+				break
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+var sourceLines = util.Prioritized(&sourceLineTransformer{}, 1000)
+
+// sourceLocation maps line of the combined document src back to the input
+// file holding it and the line there. Each input after the first starts at
+// the header combineInputs wrote for it.
+func sourceLocation(src []byte, paths []string, line int) (string, int) {
+	if len(paths) < 2 {
+		return paths[0], line
+	}
+	path, start := paths[0], 1
+	for _, p := range paths {
+		header := []byte("# " + escapeMarkdown(filepath.ToSlash(p)) + " {#" + fileHeaderID(p) + " .file-header}\n")
+		at := 0
+		if !bytes.HasPrefix(src, header) {
+			i := bytes.Index(src, append([]byte("\n"), header...))
+			if i < 0 {
+				continue
+			}
+			at = i + 1
+		}
+		// The file's own lines start after the header and a blank line.
+		headerLine := bytes.Count(src[:at], []byte("\n")) + 1
+		if headerLine > line {
+			break
+		}
+		path, start = p, headerLine+2
+	}
+	if line < start {
+		line = start
+	}
+	return path, line - start + 1
+}
+
+// editor returns the editor command line, or "" when none is set.
+func editor() string {
+	for _, e := range []string{editorCommand, os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+		if strings.TrimSpace(e) != "" {
+			return e
+		}
+	}
+	return ""
+}
+
+// terminalEditors run in the terminal rather than a window of their own.
+var terminalEditors = map[string]bool{
+	"vi": true, "vim": true, "nvim": true, "nano": true, "micro": true, "kak": true,
+	"hx": true, "helix": true, "joe": true, "jed": true, "ne": true, "emacs": true,
+}
+
+// editorArgs returns the command that opens path at line in editor. With
+// {file} and {line} in editor they are replaced; otherwise the position is
+// passed the way the editor is known to take it, or left out.
+func editorArgs(editor, path string, line int) []string {
+	args := strings.Fields(editor)
+	l := strconv.Itoa(line)
+	if strings.Contains(editor, "{file}") {
+		for i, a := range args {
+			args[i] = strings.NewReplacer("{file}", path, "{line}", l).Replace(a)
+		}
+		return args
+	}
+	switch strings.TrimSuffix(filepath.Base(args[0]), ".exe") {
+	case "code", "code-insiders", "codium", "cursor":
+		return append(args, "--goto", path+":"+l)
+	case "subl", "sublime_text", "zed", "hx", "helix":
+		return append(args, path+":"+l)
+	case "mate", "idea", "goland", "pycharm", "webstorm", "clion", "rider":
+		return append(args, "--line", l, path)
+	case "vi", "vim", "nvim", "gvim", "mvim", "emacs", "emacsclient", "nano", "micro", "kak", "joe", "jed", "ne":
+		return append(args, "+"+l, path)
+	}
+	return append(args, path)
+}
+
+// terminalEditor is held while an editor runs in mdview's terminal.
+var terminalEditor sync.Mutex
+
+// openEditor starts the editor on path at line. A terminal editor gets
+// mdview's terminal until it exits; a GUI one is left running.
+func openEditor(path string, line int) error {
+	args := editorArgs(editor(), path, line)
+	cmd := exec.Command(args[0], args[1:]...)
+	if !terminalEditors[strings.TrimSuffix(filepath.Base(args[0]), ".exe")] {
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		go cmd.Wait()
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("%s runs in a terminal and mdview has none; set --editor to a GUI editor", args[0])
+	}
+	if !terminalEditor.TryLock() {
+		return fmt.Errorf("an editor is already open in mdview's terminal")
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		terminalEditor.Unlock()
+		return err
+	}
+	go func() {
+		defer terminalEditor.Unlock()
+		cmd.Wait()
+	}()
+	return nil
+}
+
+// canOpenEditor reports whether r may use the ✎ button.
+func canOpenEditor(r *http.Request) bool {
+	if editor() == "" || encrypted || isViewer(r) || docs.Get(mainDocument).Path == "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	return err == nil && isLoopbackHost(host)
+}
+
+// handleEdit serves POST /api/edit?line=N[&page=route], opening the input
+// file holding line N of the document at that line.
+func handleEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" || !canOpenEditor(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	line, err := strconv.Atoi(r.URL.Query().Get("line"))
+	if err != nil || line < 1 {
+		http.Error(w, "invalid line", http.StatusBadRequest)
+		return
+	}
+	key := requestedDocument(r)
+	d := docs.Get(key)
+	paths := []string{d.Path}
+	if key == mainDocument && len(inputPaths) > 0 && inputPages == nil {
+		paths = inputPaths
+	}
+	path, line := sourceLocation(d.Content, paths, line)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if err := openEditor(path, line); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSourceLocation(t *testing.T) {
+	paths := []string{"a.md", "docs/b.md"}
+	src := combineInputs(paths, [][]byte{[]byte("# A\n\nline 3 of a\n"), []byte("one\ntwo\nthree\n")})
+	for _, tc := range []struct {
+		line     int
+		path     string
+		fileLine int
+	}{
+		{1, "a.md", 1}, // a's header
+		{5, "a.md", 3},
+		{10, "docs/b.md", 1}, // b's header
+		{12, "docs/b.md", 1},
+		{14, "docs/b.md", 3},
+	} {
+		path, line := sourceLocation(src, paths, tc.line)
+		if path != tc.path || line != tc.fileLine {
+			t.Errorf("line %d: got %s:%d, want %s:%d", tc.line, path, line, tc.path, tc.fileLine)
+		}
+	}
+	if path, line := sourceLocation([]byte("x\ny\n"), []string{"only.md"}, 2); path != "only.md" || line != 2 {
+		t.Errorf("single input: got %s:%d", path, line)
+	}
+}
+
+func TestEditorArgs(t *testing.T) {
+	for editor, want := range map[string]string{
+		"code --wait":               "[code --wait --goto /d/a.md:7]",
+		"/usr/bin/nvim":             "[/usr/bin/nvim +7 /d/a.md]",
+		"subl":                      "[subl /d/a.md:7]",
+		"idea":                      "[idea --line 7 /d/a.md]",
+		"myeditor -n {file}@{line}": "[myeditor -n /d/a.md@7]",
+		"unknown":                   "[unknown /d/a.md]",
+	} {
+		if got := fmt.Sprint(editorArgs(editor, "/d/a.md", 7)); got != want {
+			t.Errorf("editorArgs(%q) = %s, want %s", editor, got, want)
+		}
+	}
+}
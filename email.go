@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"gopkg.in/yaml.v3"
+)
+
+// Markdown that arrives by email can be opened as is: an .eml file (or an
+// .mbox of several messages) is read as its Markdown part — text/markdown,
+// an attached .md file, or else the text/plain body — with the subject and
+// sender as front matter. Images sent along are inlined as data URLs where
+// the text refers to them, by cid: or file name; inline images it doesn't
+// mention follow the text. The message is read-only: find/replace and the
+// task board leave it alone.
+
+// isEmail reports whether path names a mail message or mailbox.
+func isEmail(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".eml", ".mbox":
+		return true
+	}
+	return false
+}
+
+// A mailPart is one leaf of a MIME message, decoded.
+type mailPart struct {
+	mediaType string
+	filename  string
+	contentID string
+	inline    bool
+	data      []byte
+}
+
+// readEmail returns the Markdown of the message, or of each message of the
+// mailbox, in path.
+func readEmail(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(path)) != ".mbox" {
+		return emailMarkdown(data)
+	}
+	var msgs [][]byte
+	for _, msg := range splitMbox(data) {
+		md, err := emailMarkdown(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, md)
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("no messages")
+	}
+	// Each message is a section; the front matter of the first names the page.
+	out := msgs[0]
+	for _, d := range msgs[1:] {
+		out = append(out, "\n\n---\n\n"...)
+		out = append(out, emailSectionHeading(d)...)
+	}
+	return out, nil
+}
+
+// splitMbox splits an mbox file at its "From " separator lines.
+func splitMbox(data []byte) [][]byte {
+	var msgs [][]byte
+	var cur []byte
+	in := false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, len(data)+1)
+	for s.Scan() {
+		line := s.Bytes()
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if in {
+				msgs = append(msgs, cur)
+			}
+			cur, in = nil, true
+			continue
+		}
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			line = line[1:] // mboxrd quoting
+		}
+		cur = append(append(cur, line...), '\n')
+	}
+	if in {
+		msgs = append(msgs, cur)
+	}
+	return msgs
+}
+
+// emailSectionHeading turns a later message's front matter into a heading,
+// as a mailbox page can have only one.
+func emailSectionHeading(md []byte) []byte {
+	raw, n, ok := splitFrontMatter(md)
+	var meta struct {
+		Title  string `yaml:"title"`
+		Author string `yaml:"author"`
+		Date   string `yaml:"date"`
+	}
+	if !ok || yaml.Unmarshal(raw, &meta) != nil || meta.Title == "" {
+		return md
+	}
+	head := "## " + meta.Title + "\n\n"
+	if by := strings.Trim(meta.Author+", "+meta.Date, ", "); by != "" {
+		head += "*" + by + "*\n\n"
+	}
+	return append([]byte(head), md[n:]...)
+}
+
+var mailImageRef = regexp.MustCompile(`(\]\(\s*<?|\bsrc=["']?)([^)\s"'>]+)`)
+
+// emailMarkdown returns the Markdown of one message.
+func emailMarkdown(raw []byte) ([]byte, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("reading the message: %w", err)
+	}
+	var parts []mailPart
+	if err := readMailPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Header.Get("Content-Disposition"), "", msg.Body, &parts); err != nil {
+		return nil, err
+	}
+
+	var text *mailPart
+	rank := func(p mailPart) int {
+		switch {
+		case p.mediaType == "text/markdown" || p.mediaType == "text/x-markdown":
+			return 3
+		case isMarkdown(p.filename):
+			return 2
+		case p.mediaType == "text/plain" && p.filename == "":
+			return 1
+		}
+		return 0
+	}
+	for i := range parts {
+		if r := rank(parts[i]); r > 0 && (text == nil || r > rank(*text)) {
+			text = &parts[i]
+		}
+	}
+	if text == nil {
+		return nil, fmt.Errorf("the message has no Markdown or plain text part")
+	}
+	body := string(text.data)
+
+	// Inline the images the text refers to; list the other inline ones.
+	used := make(map[int]bool)
+	body = mailImageRef.ReplaceAllStringFunc(body, func(m string) string {
+		sub := mailImageRef.FindStringSubmatch(m)
+		ref := sub[2]
+		for i, p := range parts {
+			if !strings.HasPrefix(p.mediaType, "image/") {
+				continue
+			}
+			if ref == "cid:"+p.contentID && p.contentID != "" || p.filename != "" && strings.TrimPrefix(ref, "./") == p.filename {
+				used[i] = true
+				return sub[1] + dataURL(p)
+			}
+		}
+		return m
+	})
+	for i, p := range parts {
+		if strings.HasPrefix(p.mediaType, "image/") && (p.inline || p.contentID != "") && !used[i] {
+			alt := p.filename
+			if alt == "" {
+				alt = "image"
+			}
+			body += fmt.Sprintf("\n\n![%s](%s)\n", strings.NewReplacer("[", "", "]", "").Replace(alt), dataURL(p))
+		}
+	}
+
+	if strings.HasPrefix(body, "---\n") {
+		return []byte(body), nil // the author's own front matter wins
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	meta := map[string]string{}
+	if subject != "" {
+		meta["title"] = subject
+	}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		meta["author"] = from.Name
+		if from.Name == "" {
+			meta["author"] = from.Address
+		}
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		meta["date"] = date.Format("2006-01-02 15:04")
+	}
+	if len(meta) == 0 {
+		return []byte(body), nil
+	}
+	fm, err := yaml.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("---\n" + string(fm) + "---\n\n" + body), nil
+}
+
+// readMailPart decodes one MIME entity into parts, descending into
+// multipart ones.
+func readMailPart(contentType, encoding, disposition, contentID string, body io.Reader, parts *[]mailPart) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("reading the message: %w", err)
+			}
+			// NextPart already undoes quoted-printable, and drops the header.
+			if err := readMailPart(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p.Header.Get("Content-Disposition"), p.Header.Get("Content-Id"), p, parts); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("reading the message: %w", err)
+	}
+	part := mailPart{mediaType: mediaType, contentID: strings.Trim(contentID, "<> "), data: data}
+	if d, dparams, err := mime.ParseMediaType(disposition); err == nil {
+		part.inline = d == "inline"
+		part.filename = dparams["filename"]
+	}
+	if part.filename == "" {
+		part.filename = params["name"]
+	}
+	part.filename = filepath.Base(part.filename)
+	if part.filename == "." {
+		part.filename = ""
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		if cs := strings.ToLower(params["charset"]); cs != "" && cs != "utf-8" && cs != "us-ascii" {
+			if enc, err := htmlindex.Get(cs); err == nil {
+				if utf, err := enc.NewDecoder().Bytes(data); err == nil {
+					part.data = utf
+				}
+			}
+		}
+		part.data = bytes.ReplaceAll(part.data, []byte("\r\n"), []byte("\n"))
+	}
+	*parts = append(*parts, part)
+	return nil
+}
+
+// dataURL returns p as a data: URL.
+func dataURL(p mailPart) string {
+	return "data:" + p.mediaType + ";base64," + base64.StdEncoding.EncodeToString(p.data)
+}
@@ -0,0 +1,22 @@
+//go:build embeddocs
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Built with -tags embeddocs, mdview carries the embedded-docs directory
+// and serves it with `mdview embed:`.
+//
+//go:embed all:embedded-docs
+var embeddedFiles embed.FS
+
+func init() {
+	sub, err := fs.Sub(embeddedFiles, "embedded-docs")
+	if err != nil {
+		panic(err)
+	}
+	embeddedDocs = sub
+}
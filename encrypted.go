@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"golang.org/x/term"
+)
+
+// Encrypted documents (.age, .gpg, .asc) are decrypted in memory only; the
+// plaintext never touches the disk and responses are marked no-store so the
+// browser doesn't cache it either.
+
+var (
+	identityFile string        // age identity file (--identity)
+	lockAfter    time.Duration // lock an encrypted session after this much inactivity
+
+	keyring      []age.Identity // guarded by mu; wiped when the session locks
+	locked       bool           // guarded by mu
+	encrypted    bool           // set at startup when any input is encrypted
+	lastActivity atomic.Int64   // unix nanoseconds of the last HTTP request
+)
+
+var errLocked = errors.New("session is locked")
+
+// isEncrypted reports whether path names an encrypted document.
+func isEncrypted(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".age", ".gpg", ".asc":
+		return true
+	}
+	return false
+}
+
+// readSource reads a Markdown input, decrypting it or taking it out of an
+// email when necessary.
+func readSource(path string) ([]byte, error) {
+	if isEmail(path) {
+		return readEmail(path)
+	}
+	if !isEncrypted(path) {
+		return os.ReadFile(path)
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".age" {
+		mu.RLock()
+		ids := keyring
+		mu.RUnlock()
+		if len(ids) == 0 {
+			return nil, errLocked
+		}
+		return decryptAge(path, ids)
+	}
+	return decryptGPG(path)
+}
+
+func decryptAge(path string, ids []age.Identity) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+	r, err := age.Decrypt(src, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", path, err)
+	}
+	return io.ReadAll(r)
+}
+
+// decryptGPG shells out to gpg, which prompts through its own agent. The
+// plaintext is read from gpg's stdout and never written to a file.
+func decryptGPG(path string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--quiet", "--batch", "--yes", "--decrypt", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("decrypting %s: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// loadKeyring builds the age identities for the session, either from
+// identityFile or from a passphrase (prompted on the terminal when empty).
+func loadKeyring(passphrase string) error {
+	var ids []age.Identity
+	if identityFile != "" {
+		f, err := os.Open(identityFile)
+		if err != nil {
+			return fmt.Errorf("reading identity: %w", err)
+		}
+		defer f.Close()
+		ids, err = age.ParseIdentities(bufio.NewReader(f))
+		if err != nil {
+			return fmt.Errorf("parsing identity %s: %w", identityFile, err)
+		}
+	} else {
+		if passphrase == "" {
+			p, err := promptPassphrase("Passphrase: ")
+			if err != nil {
+				return err
+			}
+			passphrase = p
+		}
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return err
+		}
+		ids = []age.Identity{id}
+	}
+	mu.Lock()
+	keyring = ids
+	mu.Unlock()
+	return nil
+}
+
+// promptPassphrase reads a passphrase from the controlling terminal without
+// echoing it, so stdin stays usable for piped input.
+func promptPassphrase(prompt string) (string, error) {
+	ttyName := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		ttyName = "CONIN$"
+	}
+	// CONIN$ must be opened read-write for ReadPassword to switch off echo.
+	tty, err := os.OpenFile(ttyName, os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to prompt for a passphrase (use --identity): %w", err)
+	}
+	defer tty.Close()
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+	return string(pass), nil
+}
+
+// isLocked reports whether the session has been locked for inactivity.
+func isLocked() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return locked
+}
+
+// trackActivity records request times for the inactivity lock and keeps
+// decrypted pages out of the browser cache.
+func trackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastActivity.Store(time.Now().UnixNano())
+		if encrypted {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchInactivity locks the session once no request has arrived for
+// lockAfter, dropping the plaintext and the keys from memory.
+func watchInactivity(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, lastActivity.Load()))
+			if idle < lockAfter || isLocked() {
+				continue
+			}
+			mu.Lock()
+			locked = true
+			keyring = nil
+			mu.Unlock()
+			docs.Update(mainDocument, func(cur Document) (Document, bool) {
+				cur.Content = nil
+				return cur, true
+			})
+			fmt.Fprintf(os.Stderr, "Session locked after %s of inactivity.\n", lockAfter)
+		}
+	}
+}
+
+// handleUnlock re-derives the keys from the submitted passphrase (or the
+// identity file) and decrypts the inputs again. The lock page posts
+// {"passphrase": "..."}.
+func handleUnlock(paths []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Passphrase string `json:"passphrase"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if identityFile == "" && needsKeyring(paths) && req.Passphrase == "" {
+			http.Error(w, "passphrase required", http.StatusBadRequest)
+			return
+		}
+		if needsKeyring(paths) {
+			if err := loadKeyring(req.Passphrase); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		combined, latestMod, err := readInputs(paths)
+		if err != nil {
+			mu.Lock()
+			keyring = nil
+			mu.Unlock()
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		docs.Update(mainDocument, func(cur Document) (Document, bool) {
+			cur.Content, cur.Modified = combined, latestMod
+			return cur, true
+		})
+		mu.Lock()
+		locked = false
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// needsKeyring reports whether any of paths is an age file.
+func needsKeyring(paths []string) bool {
+	for _, p := range paths {
+		if strings.ToLower(filepath.Ext(p)) == ".age" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLockPage renders the unlock form shown while the session is locked.
+func writeLockPage(w http.ResponseWriter) {
+	css, _ := styleFS.ReadFile("style.css")
+	executeTemplate(w, "lock.html", map[string]interface{}{
+		"CSS":        template.CSS(css),
+		"Passphrase": identityFile == "",
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Open pages get live updates over one SSE stream each (/events). Whatever
+// needs to reach them publishes an Event on the bus, and every stream
+// subscribes to the types its page handles. Each subscriber has a bounded
+// queue: for state events only the newest pending one is kept, and a tab
+// that falls further behind has its queue replaced by a single reload,
+// which brings it back in sync.
+
+// An EventType is the SSE event name the page listens for.
+type EventType string
+
+const (
+	EventReload EventType = "reload" // the document changed; the page refetches /raw
+	EventToast  EventType = "toast"  // a short notice; Data is the text
+	EventChat   EventType = "chat"   // a --chat message; Data is the chatMessage
+	EventStyle  EventType = "style"  // the --css stylesheet changed; Data is its new URL
+)
+
+// latestWins are the event types where a newer event makes an undelivered
+// one pointless.
+var latestWins = map[EventType]bool{EventReload: true, EventStyle: true}
+
+// eventQueueSize is how many events may wait for one subscriber.
+const eventQueueSize = 32
+
+// An Event is one message for the open pages.
+type Event struct {
+	Type EventType
+	Data interface{} // sent as JSON; nil sends the type name
+}
+
+// sse formats e for an event stream.
+func (e Event) sse() string {
+	data := string(e.Type)
+	if e.Data != nil {
+		b, err := json.Marshal(e.Data)
+		if err != nil {
+			return ""
+		}
+		data = string(b)
+	}
+	return "event: " + string(e.Type) + "\ndata: " + data + "\n\n"
+}
+
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*eventSubscription]struct{}
+}
+
+// bus carries the events of this process.
+var bus = &eventBus{subs: make(map[*eventSubscription]struct{})}
+
+// An eventSubscription queues the events of some types for one client.
+type eventSubscription struct {
+	types map[EventType]bool
+	ready chan struct{} // has a value while the queue is non-empty
+
+	mu    sync.Mutex
+	queue []Event
+}
+
+// subscribe registers a subscriber for types. The returned func
+// unregisters it.
+func (b *eventBus) subscribe(types ...EventType) (*eventSubscription, func()) {
+	s := &eventSubscription{types: make(map[EventType]bool), ready: make(chan struct{}, 1)}
+	for _, t := range types {
+		s.types[t] = true
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s, func() {
+		b.mu.Lock()
+		delete(b.subs, s)
+		b.mu.Unlock()
+	}
+}
+
+// Publish queues e for every subscriber of its type. It never blocks.
+func (b *eventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if s.types[e.Type] {
+			s.push(e)
+		}
+	}
+}
+
+func (s *eventSubscription) push(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if latestWins[e.Type] {
+		for i := range s.queue {
+			if s.queue[i].Type == e.Type {
+				s.queue[i] = e
+				return
+			}
+		}
+	}
+	if len(s.queue) >= eventQueueSize {
+		metrics.eventsDropped.Add(int64(len(s.queue)))
+		s.queue = s.queue[:0]
+		if s.types[EventReload] && e.Type != EventReload {
+			s.queue = append(s.queue, Event{Type: EventReload})
+		}
+	}
+	s.queue = append(s.queue, e)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Ready receives a value when events are waiting; take them with Take.
+func (s *eventSubscription) Ready() <-chan struct{} { return s.ready }
+
+// Take returns and clears the queued events, oldest first.
+func (s *eventSubscription) Take() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue
+	s.queue = nil
+	return q
+}
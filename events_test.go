@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	b := &eventBus{subs: make(map[*eventSubscription]struct{})}
+	pages, unsubscribe := b.subscribe(EventReload, EventToast)
+	defer unsubscribe()
+	chat, unsubscribeChat := b.subscribe(EventChat)
+	defer unsubscribeChat()
+
+	// A newer reload replaces the one still waiting; toasts all queue.
+	b.Publish(Event{Type: EventReload})
+	b.Publish(Event{Type: EventToast, Data: "one"})
+	b.Publish(Event{Type: EventReload})
+	b.Publish(Event{Type: EventToast, Data: "two"})
+	b.Publish(Event{Type: EventChat, Data: "hi"})
+	select {
+	case <-pages.Ready():
+	default:
+		t.Fatal("subscriber not ready after publishing")
+	}
+	var got []string
+	for _, e := range pages.Take() {
+		got = append(got, e.sse())
+	}
+	want := []string{
+		"event: reload\ndata: reload\n\n",
+		"event: toast\ndata: \"one\"\n\n",
+		"event: toast\ndata: \"two\"\n\n",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("queued %q, want %q", got, want)
+	}
+	if q := chat.Take(); len(q) != 1 || q[0].Type != EventChat {
+		t.Errorf("chat subscriber got %v", q)
+	}
+}
+
+func TestEventBusOverflow(t *testing.T) {
+	b := &eventBus{subs: make(map[*eventSubscription]struct{})}
+	s, unsubscribe := b.subscribe(EventReload, EventToast)
+	defer unsubscribe()
+
+	// A client that stops reading is caught up with a reload instead of
+	// the backlog.
+	dropped := metrics.eventsDropped.Load()
+	for i := 0; i <= eventQueueSize; i++ {
+		b.Publish(Event{Type: EventToast, Data: i})
+	}
+	q := s.Take()
+	if len(q) != 2 || q[0].Type != EventReload || q[1].Data != eventQueueSize {
+		t.Fatalf("after overflow the queue is %v, want a reload and the newest toast", q)
+	}
+	if n := metrics.eventsDropped.Load() - dropped; n != eventQueueSize {
+		t.Errorf("eventsDropped went up by %d, want %d", n, eventQueueSize)
+	}
+	if q := s.Take(); len(q) != 0 {
+		t.Errorf("Take after Take returned %v", q)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// --exec 'go doc ./...' renders a command's output: its stdout is the
+// document, run again every --every and whenever a --watch file changes,
+// which makes mdview a live dashboard for anything that prints Markdown.
+// A failing run leaves the last output up and says so once in the
+// terminal and on open pages, until a run succeeds again. The output is
+// the user's own, so raw HTML passes through as for their files.
+
+var (
+	execCommand string        // --exec
+	execEvery   time.Duration // --every
+)
+
+var execState struct {
+	sync.Mutex
+	failing bool
+}
+
+// runExec runs --exec and returns its stdout.
+func runExec() ([]byte, error) {
+	var out bytes.Buffer
+	cmd := shellCommand(execCommand)
+	cmd.Stdout, cmd.Stderr = &out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w", execCommand, err)
+	}
+	return out.Bytes(), nil
+}
+
+// refreshExec runs --exec again and updates the document if the output
+// changed. Runs from the timer and the watcher take turns.
+func refreshExec() {
+	execState.Lock()
+	defer execState.Unlock()
+	out, err := runExec()
+	if err != nil {
+		if !execState.failing {
+			fmt.Fprintf(os.Stderr, "mdview: %v; keeping the last output\n", err)
+			bus.Publish(Event{Type: EventToast, Data: err.Error()})
+		}
+		execState.failing = true
+		return
+	}
+	if execState.failing {
+		fmt.Fprintf(os.Stderr, "mdview: %s succeeded again\n", execCommand)
+	}
+	execState.failing = false
+	docs.Update(mainDocument, func(cur Document) (Document, bool) {
+		if bytes.Equal(cur.Content, out) {
+			return cur, false
+		}
+		cur.Content, cur.Modified = out, time.Now()
+		return cur, true
+	})
+}
+
+// execLoop runs --exec every --every until ctx is done.
+func execLoop(ctx context.Context) {
+	defer recoverCrash()
+	if execEvery <= 0 {
+		return
+	}
+	ticker := time.NewTicker(execEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshExec()
+		}
+	}
+}
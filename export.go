@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var exportPath string // --export / -o
+
+// exportDefaults returns the sections to preselect for export, from an
+// `export:` front matter line in the first of paths that has one:
+//
+//	export: Overview, api-reference
+//
+// Sections are named by heading text or anchor; a flow list
+// ("[Overview, API]") works too. The page matches names case-insensitively.
+func exportDefaults(paths []string) []string {
+	for _, p := range paths {
+		src, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		v, ok := frontMatterField(src, "export")
+		if !ok {
+			continue
+		}
+		var sections []string
+		for _, s := range strings.Split(strings.Trim(v, "[]"), ",") {
+			if s = strings.Trim(strings.TrimSpace(s), `"'`); s != "" {
+				sections = append(sections, s)
+			}
+		}
+		return sections
+	}
+	return nil
+}
+
+var (
+	imgSrcPattern = regexp.MustCompile(`(<img\b[^>]*?\bsrc=")([^"]*)(")`)
+	h1Pattern     = regexp.MustCompile(`(?s)<h1[^>]*>(.*?)</h1>`)
+	tagPattern    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// exportStandalone writes the loaded document as one HTML file that opens
+// anywhere. path "-" writes to stdout.
+func exportStandalone(path string) (err error) {
+	if path != "-" {
+		defer func() { notifyExport(path, err) }()
+	}
+	if err := applyConfig(); err != nil {
+		return err
+	}
+	ctx, stop := exportContext()
+	defer stop()
+	_, p, _ := activeProfile()
+	dir, label := "", "Rendering"
+	if path != "-" {
+		dir, _ = filepath.Abs(filepath.Dir(path))
+		label += " " + filepath.Base(path)
+	}
+	done := showProgress(label)
+	page, err := standaloneHTML(profileTheme(p), currentRenderer().Highlight, modeHTML, dir)
+	done()
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return errCancelled
+	}
+	if path == "-" {
+		_, err = os.Stdout.Write(page)
+		return err
+	}
+	if err := writeFileAtomic(path, page); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s (%s)\n", path, formatSize(int64(len(page))))
+	return nil
+}
+
+// An exportMode is what a standalone page is for.
+type exportMode int
+
+const (
+	modeHTML  exportMode = iota // an HTML file
+	modePDF                     // printed to PDF by Chrome
+	modePrint                   // /print, for the browser to print
+)
+
+// standaloneHTML renders the watched document as a page with the
+// stylesheet inlined, local images embedded as data URIs and no scripts.
+// theme (a theme name, or "" to follow the system) and highlight (a
+// Chroma style, or "" for the built-in one) are fixed in the page. A PDF
+// keeps backgrounds and colors when printed; the print view adds page
+// rules and lists link URLs as notes. Links are rewritten for a file
+// written to dir ("" for the document's directory).
+func standaloneHTML(theme, highlight string, mode exportMode, dir string) ([]byte, error) {
+	d := docs.Get(mainDocument)
+	rendered, err := renderDocument(d)
+	if err != nil {
+		return nil, err
+	}
+	rendered = inlineImages(rendered, baseDir)
+	switch mode {
+	case modeHTML:
+		rendered = exportLinks(rendered, ".html", dir)
+	case modePDF:
+		rendered = exportLinks(rendered, ".pdf", dir)
+	case modePrint:
+		rendered = printLinkNotes(rendered)
+	}
+
+	title := strings.TrimSuffix(filepath.Base(d.Path), filepath.Ext(d.Path))
+	if fm, _ := documentFrontMatter(d.Content); fm.Title != "" {
+		title = fm.Title
+	} else if m := h1Pattern.FindSubmatch(rendered); m != nil {
+		title = html.UnescapeString(strings.TrimSpace(tagPattern.ReplaceAllString(string(m[1]), "")))
+	}
+	if title == "" || title == "." {
+		title = "Document"
+	}
+
+	css, _ := styleFS.ReadFile("style.css")
+	var hlCSS []byte
+	if highlight != "" {
+		hlCSS, _ = highlightCSS(highlight)
+	}
+	t, err := loadTemplates()
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	err = t.ExecuteTemplate(&b, "export.html", map[string]interface{}{
+		"Theme":        pageTheme(theme),
+		"Highlight":    highlight,
+		"Title":        title,
+		"CSS":          template.CSS(css),
+		"UserCSS":      template.CSS(userCSS()),
+		"HighlightCSS": template.CSS(hlCSS),
+		"PDF":          mode == modePDF,
+		"PrintView":    mode == modePrint,
+		"Content":      template.HTML(rendered),
+	})
+	return b.Bytes(), err
+}
+
+// inlineImages replaces local image sources with data URIs of the files
+// under dir. Remote images are left alone; missing ones are reported.
+func inlineImages(rendered []byte, dir string) []byte {
+	return imgSrcPattern.ReplaceAllFunc(rendered, func(m []byte) []byte {
+		parts := imgSrcPattern.FindSubmatch(m)
+		src := html.UnescapeString(string(parts[2]))
+		u, err := url.Parse(src)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+			return m
+		}
+		// As when served, "/img.png" is relative to the document's directory.
+		file := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(u.Path, "/")))
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mdview: not embedding image: %v\n", err)
+			return m
+		}
+		typ := mime.TypeByExtension(strings.ToLower(filepath.Ext(file)))
+		if typ == "" {
+			typ = http.DetectContentType(data)
+		}
+		uri := "data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(data)
+		return []byte(string(parts[1]) + uri + string(parts[3]))
+	})
+}
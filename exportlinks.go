@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Exported HTML and PDF files leave the server behind, so their links are
+// rewritten for where the file is written: a link to another Markdown file
+// goes to its exported counterpart (design.md becomes design.html or
+// design.pdf next to it), one to a file of a combined export to that
+// file's section, and other local files are linked relative to the
+// output. Links that won't resolve in the artifact — missing files,
+// missing anchors — are reported and get the broken-link class.
+
+var (
+	exportLinkPattern = regexp.MustCompile(`<h1 id="(file-[^"]*)"|(<a\b[^>]*?\bhref=")([^"]*)("[^>]*>)`)
+	idAttrPattern     = regexp.MustCompile(`\bid="([^"]*)"`)
+	classAttrPattern  = regexp.MustCompile(`\bclass="`)
+)
+
+// exportLinks rewrites the links in rendered, the loaded document, for an
+// artifact with extension ext (".html" or ".pdf") written to dir.
+func exportLinks(rendered []byte, ext, dir string) []byte {
+	ids := make(map[string]bool)
+	for _, m := range idAttrPattern.FindAllSubmatch(rendered, -1) {
+		ids[html.UnescapeString(string(m[1]))] = true
+	}
+	// In a combined document, each file's section starts with its header.
+	inputs := make(map[string]string) // absolute path → input path
+	headers := make(map[string]string)
+	for _, p := range inputPaths {
+		if abs, err := filepath.Abs(p); err == nil {
+			inputs[abs] = p
+		}
+		headers[fileHeaderID(p)] = p
+	}
+	current := docs.Get(mainDocument).Path
+	if dir == "" {
+		dir = baseDir
+	}
+
+	reported := make(map[string]bool)
+	broken := func(tag []byte, href string) []byte {
+		if !reported[href] {
+			reported[href] = true
+			fmt.Fprintf(os.Stderr, "mdview: link to %s won't resolve in the export\n", href)
+		}
+		if loc := classAttrPattern.FindIndex(tag); loc != nil {
+			return []byte(string(tag[:loc[1]]) + "broken-link " + string(tag[loc[1]:]))
+		}
+		return []byte(strings.Replace(string(tag), "<a ", `<a class="broken-link" `, 1))
+	}
+	// section returns the anchor of frag in the section of input p.
+	section := func(p, frag string) (string, bool) {
+		if fileSections == nil {
+			return frag, frag == "" || ids[frag]
+		}
+		if frag == "" {
+			return fileHeaderID(p), true
+		}
+		id := fileSections[fileHeaderID(p)] + frag
+		return id, ids[id]
+	}
+
+	return exportLinkPattern.ReplaceAllFunc(rendered, func(m []byte) []byte {
+		parts := exportLinkPattern.FindSubmatch(m)
+		if parts[1] != nil {
+			if p, ok := headers[string(parts[1])]; ok && fileSections != nil {
+				current = p
+			}
+			return m
+		}
+		href := html.UnescapeString(string(parts[3]))
+		u, err := url.Parse(href)
+		if err != nil || u.Scheme != "" || u.Host != "" {
+			return m
+		}
+		rewrite := func(to string) []byte {
+			return []byte(string(parts[2]) + html.EscapeString(to) + string(parts[4]))
+		}
+		if u.Path == "" {
+			if u.Fragment == "" || ids[u.Fragment] {
+				return m
+			}
+			if id, ok := section(current, u.Fragment); ok {
+				return rewrite("#" + id)
+			}
+			return broken(m, href)
+		}
+		if baseDir == "" {
+			return m // stdin: there is no directory to resolve against
+		}
+		from := baseDir
+		if current != "" {
+			if abs, err := filepath.Abs(current); err == nil {
+				from = filepath.Dir(abs)
+			}
+		}
+		target := filepath.Join(from, filepath.FromSlash(u.Path))
+		if strings.HasPrefix(u.Path, "/") {
+			// As when served, "/x.md" is relative to the document's directory.
+			target = filepath.Join(baseDir, filepath.FromSlash(u.Path))
+		}
+		if p, ok := inputs[target]; ok && (fileSections != nil || len(inputPaths) == 1) {
+			if id, ok := section(p, u.Fragment); ok {
+				if id == "" {
+					return rewrite("#")
+				}
+				return rewrite("#" + id)
+			}
+			return broken(m, href)
+		}
+		if _, err := os.Stat(target); err != nil {
+			return broken(m, href)
+		}
+		if isMarkdown(target) {
+			target = strings.TrimSuffix(target, filepath.Ext(target)) + ext
+		}
+		rel, err := filepath.Rel(dir, target)
+		if err != nil {
+			return m
+		}
+		out := url.URL{Path: filepath.ToSlash(rel), RawQuery: u.RawQuery, Fragment: u.Fragment}
+		return rewrite(out.String())
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Long reports can open with a list of their figures and tables, switched
+// on in front matter:
+//
+//	list-of-figures: true
+//	list-of-tables: true
+//
+// With list-of-figures, an image alone in a paragraph is a numbered figure
+// captioned with its title, or else its alt text. With list-of-tables, a
+// paragraph starting "Table:" right before or after a table is that
+// table's numbered caption. The lists follow the front matter and link to
+// each; exports and prints carry them like the rest of the document.
+
+// KindFigure is the node kind of a numbered figure.
+var KindFigure = ast.NewNodeKind("Figure")
+
+// A Figure holds an image that stood alone in its paragraph.
+type Figure struct {
+	ast.BaseBlock
+	Number  int
+	Caption string
+}
+
+// Kind implements ast.Node.
+func (n *Figure) Kind() ast.NodeKind { return KindFigure }
+
+// Dump implements ast.Node.
+func (n *Figure) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Caption": n.Caption}, nil)
+}
+
+// KindTableCaption is the node kind of a numbered table caption.
+var KindTableCaption = ast.NewNodeKind("TableCaption")
+
+// A TableCaption is a "Table:" paragraph next to a table, without the
+// prefix.
+type TableCaption struct {
+	ast.BaseBlock
+	Number int
+}
+
+// Kind implements ast.Node.
+func (n *TableCaption) Kind() ast.NodeKind { return KindTableCaption }
+
+// Dump implements ast.Node.
+func (n *TableCaption) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// KindCaptionList is the node kind of a generated list of figures or
+// tables.
+var KindCaptionList = ast.NewNodeKind("CaptionList")
+
+// A CaptionList is "List of Figures" or "List of Tables".
+type CaptionList struct {
+	ast.BaseBlock
+	Title   string
+	ID      string
+	Entries []captionEntry
+}
+
+type captionEntry struct {
+	id, label, caption string
+}
+
+// Kind implements ast.Node.
+func (n *CaptionList) Kind() ast.NodeKind { return KindCaptionList }
+
+// Dump implements ast.Node.
+func (n *CaptionList) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Title": n.Title}, nil)
+}
+
+type figureTransformer struct{}
+
+func (t *figureTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var fm *FrontMatter
+	for n := doc.FirstChild(); n != nil && fm == nil; n = n.NextSibling() {
+		fm, _ = n.(*FrontMatter)
+	}
+	if fm == nil || !fm.Meta.ListOfFigures && !fm.Meta.ListOfTables {
+		return
+	}
+	src := reader.Source()
+
+	var figures, tables []ast.Node
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n.Kind() {
+		case ast.KindParagraph:
+			if fm.Meta.ListOfFigures && figureImage(n) != nil {
+				figures = append(figures, n)
+			} else if fm.Meta.ListOfTables && tableCaptionText(n, src) != nil && (isTable(n.PreviousSibling()) || isTable(n.NextSibling())) {
+				tables = append(tables, n)
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	lists := []ast.Node{}
+	if fm.Meta.ListOfFigures && len(figures) > 0 {
+		list := &CaptionList{Title: "List of Figures", ID: "list-of-figures"}
+		for i, p := range figures {
+			img := figureImage(p)
+			caption := string(img.Title)
+			if caption == "" {
+				caption = string(img.Text(src))
+			}
+			f := &Figure{Number: i + 1, Caption: caption}
+			id := fmt.Sprintf("figure-%d", f.Number)
+			f.SetAttributeString("id", []byte(id))
+			replaceBlock(p, f)
+			list.Entries = append(list.Entries, captionEntry{id, fmt.Sprintf("Figure %d", f.Number), caption})
+		}
+		lists = append(lists, list)
+	}
+	if fm.Meta.ListOfTables && len(tables) > 0 {
+		list := &CaptionList{Title: "List of Tables", ID: "list-of-tables"}
+		for i, p := range tables {
+			first := tableCaptionText(p, src)
+			value := first.Segment.Value(src)
+			rest := bytes.TrimLeft(bytes.TrimPrefix(value, []byte("Table:")), " \t")
+			first.Segment = first.Segment.WithStart(first.Segment.Stop - len(rest))
+			c := &TableCaption{Number: i + 1}
+			id := fmt.Sprintf("table-%d", c.Number)
+			c.SetAttributeString("id", []byte(id))
+			replaceBlock(p, c)
+			list.Entries = append(list.Entries, captionEntry{id, fmt.Sprintf("Table %d", c.Number), strings.TrimSpace(string(c.Text(src)))})
+		}
+		lists = append(lists, list)
+	}
+
+	// After the front matter's title block, ahead of the content.
+	at := ast.Node(fm)
+	for _, l := range lists {
+		fm.Parent().InsertAfter(fm.Parent(), at, l)
+		at = l
+	}
+}
+
+// figureImage returns the image p consists of, or nil.
+func figureImage(p ast.Node) *ast.Image {
+	var img *ast.Image
+	for c := p.FirstChild(); c != nil; c = c.NextSibling() {
+		if i, ok := c.(*ast.Image); ok && img == nil {
+			img = i
+			continue
+		}
+		if t, ok := c.(*ast.Text); ok && t.Segment.Len() == 0 {
+			continue // a line break
+		}
+		return nil
+	}
+	return img
+}
+
+// tableCaptionText returns the text node a "Table:" paragraph starts with,
+// or nil.
+func tableCaptionText(p ast.Node, src []byte) *ast.Text {
+	t, ok := p.FirstChild().(*ast.Text)
+	if !ok || !bytes.HasPrefix(t.Segment.Value(src), []byte("Table:")) {
+		return nil
+	}
+	return t
+}
+
+func isTable(n ast.Node) bool {
+	return n != nil && n.Kind() == east.KindTable
+}
+
+// replaceBlock puts to where the paragraph from was, with its inline
+// content, lines and attributes.
+func replaceBlock(from, to ast.Node) {
+	to.SetLines(from.Lines())
+	for _, a := range from.Attributes() {
+		if _, ok := to.Attribute(a.Name); !ok {
+			to.SetAttribute(a.Name, a.Value)
+		}
+	}
+	for c := from.FirstChild(); c != nil; {
+		next := c.NextSibling()
+		to.AppendChild(to, c)
+		c = next
+	}
+	from.Parent().ReplaceChild(from.Parent(), from, to)
+}
+
+type figureRenderer struct{}
+
+func (r *figureRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindFigure, r.renderFigure)
+	reg.Register(KindTableCaption, r.renderTableCaption)
+	reg.Register(KindCaptionList, r.renderList)
+}
+
+func (r *figureRenderer) renderFigure(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Figure)
+	if entering {
+		w.WriteString("<figure")
+		html.RenderAttributes(w, n, nil)
+		w.WriteString(">")
+		return ast.WalkContinue, nil
+	}
+	fmt.Fprintf(w, "<figcaption><span class=\"caption-label\">Figure %d:</span> ", n.Number)
+	w.Write(util.EscapeHTML([]byte(n.Caption)))
+	w.WriteString("</figcaption></figure>\n")
+	return ast.WalkContinue, nil
+}
+
+func (r *figureRenderer) renderTableCaption(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*TableCaption)
+	if entering {
+		w.WriteString(`<p class="table-caption"`)
+		html.RenderAttributes(w, n, nil)
+		fmt.Fprintf(w, "><span class=\"caption-label\">Table %d:</span> ", n.Number)
+		return ast.WalkContinue, nil
+	}
+	w.WriteString("</p>\n")
+	return ast.WalkContinue, nil
+}
+
+func (r *figureRenderer) renderList(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*CaptionList)
+	fmt.Fprintf(w, "<section class=\"caption-list\">\n<h2 id=\"%s\">%s</h2>\n<ul>\n", n.ID, n.Title)
+	for _, e := range n.Entries {
+		fmt.Fprintf(w, `<li><a href="#%s">%s</a>: `, e.id, e.label)
+		w.Write(util.EscapeHTML([]byte(e.caption)))
+		w.WriteString("</li>\n")
+	}
+	w.WriteString("</ul>\n</section>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+type figureExtension struct{}
+
+// Figures is a goldmark.Extender numbering figures and tables and listing
+// them when the front matter asks.
+var Figures goldmark.Extender = &figureExtension{}
+
+func (e *figureExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		// After attribute lists are applied, ahead of sourceLines.
+		util.Prioritized(&figureTransformer{}, 850),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&figureRenderer{}, 500),
+	))
+}
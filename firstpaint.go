@@ -0,0 +1,70 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A large document takes a while to render, and used to keep the browser
+// waiting on a blank tab until it had. Now its page goes out in two parts:
+// everything up to the content (the stylesheet, toolbar and panels) is
+// flushed first, so the browser paints the page chrome while the server
+// renders, and the content and scripts follow. Cached renders and small
+// documents are written in one piece as before. In the page, the blocks of
+// a large document skip layout until they scroll near (content-visibility),
+// which is most of the browser's first-render time for one. /metrics has the
+// time to the first byte and to the whole page, and the render time.
+
+// largeDocument is the source size from which a page streams in.
+const largeDocument = 256 << 10
+
+// contentMarker stands in for the content in a streamed page's template.
+const contentMarker = "<!--mdview:content-->"
+
+// servePage renders d and writes its live page.
+func servePage(w http.ResponseWriter, r *http.Request, d Document) {
+	start := time.Now()
+	defer func() {
+		metrics.pages.Add(1)
+		metrics.pageMicros.Add(time.Since(start).Microseconds())
+	}()
+
+	flusher, ok := w.(http.Flusher)
+	if _, cached := renderCache.get(d.Version); cached || !ok || len(d.Content) < largeDocument {
+		rendered, err := renderDocument(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writePage(w, r, d.Path, d.Content, rendered, d.Modified, true)
+		metrics.firstByteMicros.Add(time.Since(start).Microseconds())
+		return
+	}
+
+	data := pageFor(r, d.Path, d.Content, d.Modified, true)
+	data.Content = template.HTML(contentMarker)
+	page, err := renderTemplate("page.html", data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	head, tail, _ := strings.Cut(string(page), contentMarker)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(head))
+	flusher.Flush()
+	metrics.firstByteMicros.Add(time.Since(start).Microseconds())
+	metrics.streamedPages.Add(1)
+
+	rendered, err := renderDocument(d)
+	if err != nil {
+		// Too late for a 500.
+		rendered = []byte(`<p class="render-error">` + html.EscapeString(err.Error()) + "</p>\n")
+	} else if isPlain(r) {
+		rendered = plainHTML(rendered)
+	}
+	w.Write(rendered)
+	w.Write([]byte(tail))
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// `mdview fix-links [dir]` repairs the relative links and images a move
+// broke. Every file under dir is indexed by name; a link whose target is
+// gone is pointed at the file of that name, and when there are several,
+// at the one whose path ends most like the old link, then the nearest.
+// Without --write it only prints the changes as a diff. Links in code are
+// left alone, and so are absolute and site-rooted ones.
+
+// fixLinkPattern matches the destination of an inline link or image, a
+// link reference definition (not a footnote's), or an HTML src or href.
+var fixLinkPattern = regexp.MustCompile(`(?m)(\]\(\s*<?|^ {0,3}\[[^\]\n^][^\]\n]*\]:[ \t]*<?|\b(?:src|href)=["'])([^)\s"'<>]+)`)
+
+// A linkFix is one broken link and what it should be.
+type linkFix struct {
+	start, end int    // of the destination in the source
+	line       int    // 1-based
+	old, new   string // new is "" when no file matches
+	candidates []string
+}
+
+// runFixLinks implements `mdview fix-links`.
+func runFixLinks(argv []string) error {
+	fs := flag.NewFlagSet("mdview fix-links", flag.ContinueOnError)
+	write := fs.Bool("write", false, "rewrite the files instead of printing a diff")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdview fix-links [options] [directory]\n\n")
+		fmt.Fprintf(os.Stderr, "Finds relative links and images in the directory's Markdown files whose\n")
+		fmt.Fprintf(os.Stderr, "target is gone and points them at the file of the same name elsewhere\n")
+		fmt.Fprintf(os.Stderr, "in the tree. Prints the changes as a diff unless --write is given, and\n")
+		fmt.Fprintf(os.Stderr, "exits with status 1 when any link is left broken.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	args := parseCommandFlags(fs, argv)
+	if len(args) > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	root := "."
+	if len(args) == 1 {
+		root = args[0]
+	}
+	if !isDirectory(root) {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+	index, err := fileIndex(os.DirFS(root))
+	if err != nil {
+		return err
+	}
+	files, err := listMarkdown(os.DirFS(root))
+	if err != nil {
+		return err
+	}
+
+	fixed, broken := 0, 0
+	for _, f := range files {
+		path := filepath.Join(root, filepath.FromSlash(f.rel))
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fixes := brokenLinks(src, f.rel, root, index)
+		if len(fixes) == 0 {
+			continue
+		}
+		out := append([]byte(nil), src...)
+		changed := 0
+		for i := len(fixes) - 1; i >= 0; i-- {
+			// From the end, so the earlier offsets still hold.
+			if x := fixes[i]; x.new != "" {
+				out = append(out[:x.start:x.start], append([]byte(x.new), out[x.end:]...)...)
+				changed++
+			}
+		}
+		for _, x := range fixes {
+			switch {
+			case x.new != "":
+			case len(x.candidates) > 1:
+				fmt.Fprintf(os.Stderr, "%s:%d: %s could be any of %s\n", path, x.line, x.old, strings.Join(x.candidates, ", "))
+				broken++
+			default:
+				fmt.Fprintf(os.Stderr, "%s:%d: %s: no file of that name\n", path, x.line, x.old)
+				broken++
+			}
+		}
+		if changed == 0 {
+			continue
+		}
+		fixed += changed
+		if *write {
+			if err := os.WriteFile(path, out, 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Fixed %d link(s) in %s\n", changed, path)
+			continue
+		}
+		printLineDiff(path, src, out)
+	}
+
+	if !*write && fixed > 0 {
+		fmt.Fprintf(os.Stderr, "%d link(s) can be fixed; run with --write to apply.\n", fixed)
+		broken += fixed
+	}
+	if broken > 0 {
+		return fmt.Errorf("%d broken link(s)", broken)
+	}
+	return nil
+}
+
+// fileIndex maps each file name in fsys to the paths of the files with it.
+func fileIndex(fsys fs.FS) (map[string][]string, error) {
+	index := make(map[string][]string)
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != "." && skipDir(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		index[d.Name()] = append(index[d.Name()], path)
+		return nil
+	})
+	return index, err
+}
+
+// brokenLinks returns the links in src, the file rel under root, whose
+// targets don't exist, in order, with a replacement where one is found.
+func brokenLinks(src []byte, rel, root string, index map[string][]string) []linkFix {
+	code := codeRanges(src)
+	inCode := func(at int) bool {
+		for _, r := range code {
+			if at >= r[0] && at < r[1] {
+				return true
+			}
+		}
+		return false
+	}
+	dir := filepath.ToSlash(filepath.Dir(rel))
+
+	var fixes []linkFix
+	for _, m := range fixLinkPattern.FindAllSubmatchIndex(src, -1) {
+		start, end := m[4], m[5]
+		if inCode(start) {
+			continue
+		}
+		dest := string(src[start:end])
+		u, err := url.Parse(dest)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+			continue
+		}
+		target := filepath.ToSlash(filepath.Join(dir, filepath.FromSlash(u.Path)))
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(target))); err == nil {
+			continue
+		}
+		x := linkFix{start: start, end: end, line: bytes.Count(src[:start], []byte("\n")) + 1, old: dest}
+		x.candidates = bestMatches(target, dir, index[filepath.Base(target)])
+		if len(x.candidates) == 1 {
+			to, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(x.candidates[0]))
+			if err == nil {
+				fixed := url.URL{Path: filepath.ToSlash(to), RawQuery: u.RawQuery, Fragment: u.Fragment}
+				x.new = fixed.String()
+				if strings.Contains(to, ":") && !strings.HasPrefix(to, ".") {
+					x.new = "./" + x.new // not a scheme
+				}
+			}
+		}
+		fixes = append(fixes, x)
+	}
+	return fixes
+}
+
+// bestMatches returns the candidate paths for the missing target, a path
+// from the root, that end the most like it, closest to dir first; one when
+// the choice is clear.
+func bestMatches(target, dir string, candidates []string) []string {
+	if len(candidates) <= 1 {
+		return candidates
+	}
+	suffix := func(p string) int {
+		a, b := strings.Split(p, "/"), strings.Split(target, "/")
+		n := 0
+		for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+			n++
+		}
+		return n
+	}
+	distance := func(p string) int {
+		to, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(p))
+		if err != nil {
+			return 1 << 20
+		}
+		return strings.Count(filepath.ToSlash(to), "/")
+	}
+	sorted := append([]string(nil), candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if si, sj := suffix(sorted[i]), suffix(sorted[j]); si != sj {
+			return si > sj
+		}
+		return distance(sorted[i]) < distance(sorted[j])
+	})
+	if suffix(sorted[0]) == suffix(sorted[1]) && distance(sorted[0]) == distance(sorted[1]) {
+		return sorted
+	}
+	return sorted[:1]
+}
+
+// codeRanges returns the byte ranges of src's code blocks and code spans.
+func codeRanges(src []byte) [][2]int {
+	doc := markdown().Parser().Parse(text.NewReader(src), parseOptions(nil)...)
+	var ranges [][2]int
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n.Kind() {
+		case ast.KindFencedCodeBlock, ast.KindCodeBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				ranges = append(ranges, [2]int{lines.At(i).Start, lines.At(i).Stop})
+			}
+			return ast.WalkSkipChildren, nil
+		case ast.KindCodeSpan:
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if t, ok := c.(*ast.Text); ok {
+					ranges = append(ranges, [2]int{t.Segment.Start, t.Segment.Stop})
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return ranges
+}
+
+// printLineDiff prints the lines that differ between old and updated,
+// which have the same number of lines, as a unified diff of path.
+func printLineDiff(path string, old, updated []byte) {
+	a, b := strings.Split(string(old), "\n"), strings.Split(string(updated), "\n")
+	fmt.Printf("--- %s\n+++ %s\n", path, path)
+	for i := range a {
+		if i < len(b) && a[i] != b[i] {
+			fmt.Printf("@@ -%d +%d @@\n-%s\n+%s\n", i+1, i+1, a[i], b[i])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	followStdin bool // --follow: render stdin as it arrives
+	exitOnEOF   bool // --exit-on-eof: stop serving once stdin closes
+)
+
+// A streamStatus describes the --follow input, shown as a banner when the
+// pipeline has ended or failed so a frozen page is not mistaken for a slow
+// producer.
+type streamStatus struct {
+	State string `json:"state"` // "following", "ended" or "error"
+	Error string `json:"error,omitempty"`
+}
+
+var stream streamStatus // guarded by mu; zero unless --follow
+
+// quit asks serve to shut down as if interrupted.
+var quit = make(chan struct{}, 1)
+
+// followInput appends r to the document as data arrives. When r closes the
+// page switches to static mode, or the server exits with --exit-on-eof.
+func followInput(r io.Reader) {
+	defer recoverCrash()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			docs.Update(mainDocument, func(cur Document) (Document, bool) {
+				// Earlier documents share the array; append only writes
+				// past their length, so they are unaffected.
+				cur.Content, cur.Modified = append(cur.Content, buf[:n]...), time.Now()
+				return cur, true
+			})
+		}
+		if err == nil {
+			continue
+		}
+
+		status := streamStatus{State: "ended"}
+		if !errors.Is(err, io.EOF) {
+			status = streamStatus{State: "error", Error: err.Error()}
+			fmt.Fprintf(os.Stderr, "reading stdin: %v\n", err)
+		}
+		mu.Lock()
+		stream = status
+		mu.Unlock()
+		docs.Invalidate()
+		if exitOnEOF {
+			// Give open tabs a moment to fetch the final output.
+			time.Sleep(time.Second)
+			fmt.Fprintf(os.Stderr, "Input closed, exiting.\n")
+			quit <- struct{}{}
+		}
+		return
+	}
+}
+
+func currentStream() streamStatus {
+	mu.RLock()
+	defer mu.RUnlock()
+	return stream
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v3"
+)
+
+// showFrontMatter renders the raw front matter in a collapsible panel
+// (--front-matter).
+var showFrontMatter bool
+
+// frontMatter is the part of a document's YAML front matter mdview shows.
+type frontMatter struct {
+	Title  string  `yaml:"title"`
+	Author authors `yaml:"author"`
+	Date   string  `yaml:"date"`
+
+	ListOfFigures bool `yaml:"list-of-figures"` // see figures.go
+	ListOfTables  bool `yaml:"list-of-tables"`
+}
+
+// authors is an `author:` given as one name or a list of them.
+type authors []string
+
+func (a *authors) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*a = authors{n.Value}
+		return nil
+	}
+	return n.Decode((*[]string)(a))
+}
+
+func (a authors) String() string { return strings.Join(a, ", ") }
+
+// splitFrontMatter returns the YAML between a "---" line at the start of
+// src and the "---" or "..." line closing it, and the length of the whole
+// block. ok is false unless the block is there and holds a YAML mapping.
+func splitFrontMatter(src []byte) (raw []byte, n int, ok bool) {
+	line, rest, _ := bytes.Cut(src, []byte("\n"))
+	if string(bytes.TrimRight(line, " \t\r")) != "---" {
+		return nil, 0, false
+	}
+	start := len(src) - len(rest)
+	for i := start; i < len(src); {
+		line, _, found := bytes.Cut(src[i:], []byte("\n"))
+		end := i + len(line)
+		if found {
+			end++
+		}
+		if l := string(bytes.TrimRight(line, " \t\r")); l == "---" || l == "..." {
+			raw = src[start:i]
+			var m map[string]interface{}
+			if yaml.Unmarshal(raw, &m) != nil {
+				return nil, 0, false
+			}
+			return raw, end, true
+		}
+		i = end
+	}
+	return nil, 0, false
+}
+
+// documentFrontMatter parses the front matter at the start of src.
+func documentFrontMatter(src []byte) (frontMatter, bool) {
+	var fm frontMatter
+	raw, _, ok := splitFrontMatter(bytes.TrimPrefix(src, []byte("\xef\xbb\xbf")))
+	if !ok || yaml.Unmarshal(raw, &fm) != nil {
+		return frontMatter{}, false
+	}
+	return fm, true
+}
+
+// KindFrontMatter is the node kind of a document's YAML front matter.
+var KindFrontMatter = ast.NewNodeKind("FrontMatter")
+
+// A FrontMatter is the "---" fenced YAML block starting a document, or
+// starting one of the files of a combined document.
+type FrontMatter struct {
+	ast.BaseBlock
+	Raw  []byte
+	Meta frontMatter
+
+	end int // offset of the first byte after the block
+}
+
+// Kind implements ast.Node.
+func (n *FrontMatter) Kind() ast.NodeKind { return KindFrontMatter }
+
+// Dump implements ast.Node.
+func (n *FrontMatter) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Title": n.Meta.Title}, nil)
+}
+
+type frontMatterParser struct{}
+
+func (p *frontMatterParser) Trigger() []byte { return []byte{'-'} }
+
+func (p *frontMatterParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	_, segment := reader.PeekLine()
+	if pc.BlockOffset() != 0 || parent.Kind() != ast.KindDocument {
+		return nil, parser.NoChildren
+	}
+	// Only where a file starts: the document itself, or under the header
+	// combineInputs gives each file.
+	src := reader.Source()
+	before := bytes.TrimPrefix(src[:segment.Start], []byte("\xef\xbb\xbf"))
+	if len(before) > 0 {
+		if h, ok := parent.LastChild().(*ast.Heading); !ok || h.Level != 1 || !bytes.HasSuffix(before, []byte(" .file-header}\n\n")) {
+			return nil, parser.NoChildren
+		}
+	}
+	raw, n, ok := splitFrontMatter(src[segment.Start:])
+	if !ok {
+		return nil, parser.NoChildren
+	}
+	node := &FrontMatter{Raw: raw, end: segment.Start + n}
+	yaml.Unmarshal(raw, &node.Meta)
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return node, parser.NoChildren
+}
+
+func (p *frontMatterParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	_, segment := reader.PeekLine()
+	if segment.Start >= node.(*FrontMatter).end {
+		return parser.Close
+	}
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (p *frontMatterParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *frontMatterParser) CanInterruptParagraph() bool { return false }
+
+func (p *frontMatterParser) CanAcceptIndentedLine() bool { return true }
+
+type frontMatterRenderer struct{}
+
+func (r *frontMatterRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindFrontMatter, r.render)
+}
+
+// render writes the title, authors and date as a header block, and with
+// --front-matter the YAML itself under a <details>. Front matter with
+// neither renders nothing.
+func (r *frontMatterRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*FrontMatter)
+	m := n.Meta
+	header := m.Title != "" || len(m.Author) > 0 || m.Date != ""
+	if !header && !showFrontMatter {
+		return ast.WalkSkipChildren, nil
+	}
+	w.WriteString(`<div class="front-matter"`)
+	html.RenderAttributes(w, n, nil)
+	w.WriteString(">\n")
+	if header {
+		w.WriteString("<header>\n")
+		if m.Title != "" {
+			w.WriteString(`<p class="front-matter-title">`)
+			w.Write(util.EscapeHTML([]byte(m.Title)))
+			w.WriteString("</p>\n")
+		}
+		if len(m.Author) > 0 || m.Date != "" {
+			w.WriteString(`<p class="byline">`)
+			if len(m.Author) > 0 {
+				w.WriteString(`<span class="author">`)
+				w.Write(util.EscapeHTML([]byte(m.Author.String())))
+				w.WriteString("</span>")
+			}
+			if len(m.Author) > 0 && m.Date != "" {
+				w.WriteString(" · ")
+			}
+			if m.Date != "" {
+				w.WriteString(`<span class="date">`)
+				w.Write(util.EscapeHTML([]byte(m.Date)))
+				w.WriteString("</span>")
+			}
+			w.WriteString("</p>\n")
+		}
+		w.WriteString("</header>\n")
+	}
+	if showFrontMatter {
+		w.WriteString("<details class=\"front-matter-raw\"><summary>Front matter</summary>\n<pre><code class=\"language-yaml\">")
+		w.Write(util.EscapeHTML(n.Raw))
+		w.WriteString("</code></pre>\n</details>\n")
+	}
+	w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+type frontMatterExtension struct{}
+
+// FrontMatters is a goldmark.Extender reading the YAML front matter that
+// starts a document instead of rendering it as a rule and a heading.
+var FrontMatters goldmark.Extender = &frontMatterExtension{}
+
+func (e *frontMatterExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(
+		// Ahead of thematic breaks and setext headings.
+		util.Prioritized(&frontMatterParser{}, 50),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&frontMatterRenderer{}, 500),
+	))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Fuzz targets for the code that sees untrusted input: the renderer, URL
+// paths, front matter, sizes and repository specs from the command line,
+// and the JSON APIs. go test runs them over their seeds; to search for new
+// failures, run one at a time:
+//
+//	go test -run '^$' -fuzz FuzzRender -fuzztime 1m
+//
+// Failing inputs are saved under testdata/fuzz and replayed by go test
+// from then on.
+
+func FuzzRender(f *testing.F) {
+	fixtures, _ := filepath.Glob(filepath.Join("testdata", "golden", "*.md"))
+	for _, fixture := range fixtures {
+		if src, err := os.ReadFile(fixture); err == nil {
+			f.Add(src)
+		}
+	}
+	for _, s := range []string{
+		"---\ntitle: x\n---\n# Heading {#id .class key=val}\n",
+		"::: warning\n??? note \"x\"\n    ::: tip\n",
+		"| a | b |\n|---|---|\n| `x|y` | [^1] |\n\n[^1]: note\n",
+		"```dot engine=neato\ndigraph { a -> b }\n```\n",
+		"```openapi\nopenapi: 3.0.0\npaths:\n  /x:\n    get: {}\n```\n",
+		"## 2024-02-30\n\nterm\n: definition\n",
+		strings.Repeat("> ", 100) + "deep\n",
+		strings.Repeat("- ", 100) + "deep\n",
+	} {
+		f.Add([]byte(s))
+	}
+
+	// Every optional extension on, so their parsers are exercised too.
+	all := defaultRenderer
+	all.Extensions = nil
+	for _, e := range optionalExtensions {
+		all.Extensions = append(all.Extensions, e.name)
+	}
+	m := newMarkdown(all)
+	f.Setenv("PATH", "") // Graphviz blocks fall back to their source
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		var buf bytes.Buffer
+		if err := m.Convert(src, &buf); err != nil {
+			t.Fatal(err)
+		}
+		// Heading IDs as combined documents assign them.
+		buf.Reset()
+		if err := m.Convert(src, &buf, parseOptions(map[string]string{})...); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzSiteFile(f *testing.F) {
+	for _, s := range []string{
+		"/doc.md", "/img/a.png", "/../secret", "/a/../../b", "//etc/passwd",
+		"/.git/config", "/a/.env", "/a/./b", "/%2e%2e/x", `/..\x`, "/a\x00b",
+	} {
+		f.Add(s)
+	}
+	dir := f.TempDir()
+	os.MkdirAll(filepath.Join(dir, "img"), 0o755)
+	os.WriteFile(filepath.Join(dir, "doc.md"), []byte("# Doc\n"), 0o644)
+	oldDir := baseDir
+	baseDir = dir
+	f.Cleanup(func() { baseDir = oldDir })
+
+	f.Fuzz(func(t *testing.T, urlPath string) {
+		_, rel, name, ok := siteFile(urlPath)
+		if !ok {
+			return
+		}
+		for _, seg := range strings.Split(rel, "/") {
+			if seg == ".." || strings.HasPrefix(seg, ".") {
+				t.Fatalf("siteFile(%q) = %q, which has a %q segment", urlPath, rel, seg)
+			}
+		}
+		if r, err := filepath.Rel(dir, name); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			t.Fatalf("siteFile(%q) = %q, outside %s", urlPath, name, dir)
+		}
+	})
+}
+
+func FuzzFrontMatter(f *testing.F) {
+	for _, s := range []string{
+		"---\nreviewed: 2024-05-01\nexport: [Overview, \"API\"]\n---\n# Doc\n",
+		"\xef\xbb\xbf---\r\nreviewed: '2024-05-01T10:00:00Z'\r\n...\r\n",
+		"---\n: \n---", "---", "--- \nexport:\n",
+	} {
+		f.Add([]byte(s), "reviewed")
+		f.Add([]byte(s), "export")
+	}
+	f.Fuzz(func(t *testing.T, src []byte, key string) {
+		if v, ok := frontMatterField(src, key); ok && strings.ContainsAny(v, "\n") {
+			t.Fatalf("frontMatterField(%q, %q) = %q, spanning lines", src, key, v)
+		}
+		reviewedAt(src)
+		dir := t.TempDir()
+		path := filepath.Join(dir, "doc.md")
+		if err := os.WriteFile(path, src, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range exportDefaults([]string{path}) {
+			if s == "" {
+				t.Fatalf("exportDefaults(%q) has an empty section", src)
+			}
+		}
+	})
+}
+
+func FuzzParseByteSize(f *testing.F) {
+	for _, s := range []string{"512MiB", "32M", "1 GiB", "10kb", "0", "-1", "9223372036854775807", "99999999999GiB"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if n, err := parseByteSize(s); err == nil && n < 0 {
+			t.Fatalf("parseByteSize(%q) = %d", s, n)
+		}
+	})
+}
+
+func FuzzParseRepoSpec(f *testing.F) {
+	for _, s := range []string{
+		"github.com/org/repo", "github.com/org/repo@v1.2", "https://gitlab.com/a/b.git",
+		"git@github.com:org/repo.git@main", "file:///tmp/repo", "-upload-pack=x", "@", "docs/",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, arg string) {
+		cloneURL, _, _, ok := parseRepoSpec(arg)
+		// The URL ends up on git's command line.
+		if ok && strings.HasPrefix(cloneURL, "-") {
+			t.Fatalf("parseRepoSpec(%q) clone URL %q looks like an option", arg, cloneURL)
+		}
+	})
+}
+
+// FuzzAPI posts arbitrary bodies to the JSON endpoints that change what
+// every viewer sees; bad input must be refused, not crash the server.
+func FuzzAPI(f *testing.F) {
+	for _, s := range []string{
+		`{"unsafe": false, "extensions": ["table"], "highlight": "monokai"}`,
+		`{"extensions": ["nope"]}`, `{"highlight": "../../etc"}`,
+		`{"name": "slides"}`, `{"name": ""}`, `{"name": "missing"}`,
+		`{`, `null`, `[]`, `{"extensions": null}`,
+	} {
+		f.Add(s)
+	}
+	if err := applyConfig(); err != nil {
+		f.Fatal(err)
+	}
+	f.Cleanup(func() { switchProfile(""); setRenderer(defaultRenderer) })
+
+	endpoints := map[string]http.HandlerFunc{"/api/renderer": handleRenderer, "/api/profile": handleProfile}
+	f.Fuzz(func(t *testing.T, body string) {
+		for path, handler := range endpoints {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != http.StatusOK && rec.Code != http.StatusBadRequest {
+				t.Fatalf("POST %s %q: status %d", path, body, rec.Code)
+			}
+		}
+		if err := currentRenderer().validate(); err != nil {
+			t.Fatalf("after %q the renderer options are invalid: %v", body, err)
+		}
+	})
+}
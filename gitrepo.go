@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// A repository argument (github.com/org/repo, an https URL on a known
+// forge, git@host:org/repo.git or anything ending in .git) is shallow-cloned
+// into a temporary directory and served in directory mode. The index offers
+// the remote's branches and tags; picking one fetches it into the same
+// clone, so the server keeps its root.
+
+// gitHosts are forges whose plain repository URLs are recognized without a
+// .git suffix.
+var gitHosts = []string{"github.com/", "gitlab.com/", "bitbucket.org/", "codeberg.org/"}
+
+// repo is the cloned repository, or nil when not serving one.
+var repo *gitRepo
+
+type gitRepo struct {
+	url   string // what git clones from
+	label string // org/repo, for headings and titles
+	dir   string // the clone
+
+	mu   sync.Mutex
+	ref  string   // checked-out branch or tag
+	refs *gitRefs // from ls-remote, loaded on first use
+}
+
+type gitRefs struct {
+	Branches []string `json:"branches"`
+	Tags     []string `json:"tags"`
+}
+
+// parseRepoSpec reports whether arg names a git repository, returning the
+// clone URL, a display label and a ref given as a trailing "@ref"
+// (github.com/org/repo@v1.2).
+func parseRepoSpec(arg string) (cloneURL, label, ref string, ok bool) {
+	spec := arg
+	if i := strings.LastIndex(spec, "@"); i > strings.LastIndex(spec, "/") && i > 0 {
+		spec, ref = spec[:i], spec[i+1:]
+	}
+	lower := strings.ToLower(spec)
+	switch {
+	case strings.HasPrefix(lower, "git@"), strings.HasPrefix(lower, "ssh://"), strings.HasPrefix(lower, "git://"), strings.HasPrefix(lower, "file://"):
+		cloneURL = spec
+	case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "http://"):
+		rest := lower[strings.Index(lower, "://")+3:]
+		if !strings.HasSuffix(lower, ".git") && !hasGitHost(rest) {
+			return "", "", "", false
+		}
+		cloneURL = spec
+	case hasGitHost(lower):
+		cloneURL = "https://" + spec
+	default:
+		return "", "", "", false
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return "", "", "", false // a local path that happens to look like one
+	}
+
+	label = strings.TrimSuffix(strings.TrimSuffix(cloneURL, "/"), ".git")
+	if i := strings.LastIndexAny(label, ":/"); i >= 0 {
+		if j := strings.LastIndexAny(label[:i], ":/"); j >= 0 {
+			label = label[j+1:]
+		}
+	}
+	return cloneURL, label, ref, true
+}
+
+func isRepoSpec(arg string) bool {
+	_, _, _, ok := parseRepoSpec(arg)
+	return ok
+}
+
+func hasGitHost(s string) bool {
+	for _, h := range gitHosts {
+		if strings.HasPrefix(s, h) && strings.Count(strings.Trim(s[len(h):], "/"), "/") >= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// git runs git with args, returning its trimmed output or an error that
+// includes what it printed.
+func git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// cloneRepo shallow-clones cloneURL (at ref, if set) into a new temporary
+// directory. The caller removes r.dir when done.
+func cloneRepo(cloneURL, label, ref string) (*gitRepo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("opening a repository needs git in PATH")
+	}
+	dir, err := os.MkdirTemp("", "mdview-repo-")
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	fmt.Fprintf(os.Stderr, "Cloning %s...\n", cloneURL)
+	if _, err := git(append(args, "--", cloneURL, dir)...); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if ref == "" {
+		ref, _ = git("-C", dir, "rev-parse", "--abbrev-ref", "HEAD")
+	}
+	return &gitRepo{url: cloneURL, label: label, dir: dir, ref: ref}, nil
+}
+
+// listRefs returns the remote's branches and tags, newest tags first. The
+// result is cached for the session.
+func (g *gitRepo) listRefs() (*gitRefs, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.refs != nil {
+		return g.refs, nil
+	}
+	out, err := git("-C", g.dir, "ls-remote", "--heads", "--tags", "--refs", "--sort=-v:refname", "origin")
+	if err != nil {
+		return nil, err
+	}
+	refs := &gitRefs{Branches: []string{}, Tags: []string{}}
+	for _, line := range strings.Split(out, "\n") {
+		_, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if b, ok := strings.CutPrefix(name, "refs/heads/"); ok {
+			refs.Branches = append(refs.Branches, b)
+		} else if t, ok := strings.CutPrefix(name, "refs/tags/"); ok && len(refs.Tags) < 200 {
+			refs.Tags = append(refs.Tags, t)
+		}
+	}
+	g.refs = refs
+	return refs, nil
+}
+
+func (g *gitRepo) currentRef() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.ref
+}
+
+// checkout fetches ref into the clone and switches to it. ref must be one
+// the remote listed, which also keeps option-like names away from git.
+func (g *gitRepo) checkout(ref string) error {
+	refs, err := g.listRefs()
+	if err != nil {
+		return err
+	}
+	full := ""
+	for _, b := range refs.Branches {
+		if b == ref {
+			full = "refs/heads/" + b
+		}
+	}
+	for _, t := range refs.Tags {
+		if t == ref && full == "" {
+			full = "refs/tags/" + t
+		}
+	}
+	if full == "" {
+		return fmt.Errorf("unknown branch or tag %q", ref)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := git("-C", g.dir, "fetch", "--quiet", "--depth", "1", "origin", full); err != nil {
+		return err
+	}
+	if _, err := git("-C", g.dir, "checkout", "--quiet", "--force", "--detach", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	g.ref = ref
+	return nil
+}
+
+// refPicker renders the branch/tag select for the directory index.
+func (g *gitRepo) refPicker() string {
+	current := g.currentRef()
+	refs, err := g.listRefs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mdview: %v\n", err)
+		refs = &gitRefs{}
+	}
+	var b strings.Builder
+	b.WriteString(`<p class="repo-ref"><label>Branch or tag <select>`)
+	found := false
+	group := func(label string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, `<optgroup label="%s">`, label)
+		for _, n := range names {
+			sel := ""
+			if n == current && !found {
+				sel, found = " selected", true
+			}
+			fmt.Fprintf(&b, `<option value="%s"%s>%s</option>`, html.EscapeString(n), sel, html.EscapeString(n))
+		}
+		b.WriteString(`</optgroup>`)
+	}
+	if !contains(refs.Branches, current) && !contains(refs.Tags, current) {
+		fmt.Fprintf(&b, `<option selected>%s</option>`, html.EscapeString(current))
+		found = true
+	}
+	group("Branches", refs.Branches)
+	group("Tags", refs.Tags)
+	fmt.Fprintf(&b, `</select></label> <span class="repo-url">%s</span> <span class="repo-status" role="status"></span></p>`+"\n", html.EscapeString(g.url))
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRepoRef serves /api/repo/ref: GET returns the current ref and the
+// remote's branches and tags, POST {"ref": "..."} switches to another.
+func handleRepoRef(w http.ResponseWriter, r *http.Request) {
+	if repo == nil || isLocked() {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		refs, err := repo.listRefs()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ref": repo.currentRef(), "branches": refs.Branches, "tags": refs.Tags})
+	case http.MethodPost:
+		if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" || isViewer(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Ref string `json:"ref"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := repo.checkout(req.Ref); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ref": repo.currentRef()})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
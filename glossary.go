@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// glossary maps terms to definitions (--glossary). Occurrences in the text
+// become <abbr> tooltips and the terms used are listed in an appendix that
+// only shows when printing.
+var (
+	glossary   map[string]string
+	glossaryRe *regexp.Regexp
+)
+
+// loadGlossary reads a glossary file: a JSON object, or one
+// "term: definition" per line with # comments.
+func loadGlossary(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading glossary: %w", err)
+	}
+	terms := make(map[string]string)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &terms); err != nil {
+			return fmt.Errorf("parsing glossary %s: %w", path, err)
+		}
+	} else {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			term, def, ok := strings.Cut(line, ":")
+			if !ok || strings.TrimSpace(term) == "" {
+				return fmt.Errorf("%s:%d: expected \"term: definition\"", path, n)
+			}
+			terms[strings.TrimSpace(term)] = strings.TrimSpace(def)
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// Longest first, so "HTTP/2" wins over "HTTP".
+	names := make([]string, 0, len(terms))
+	for t := range terms {
+		names = append(names, regexp.QuoteMeta(t))
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	re, err := regexp.Compile(`(?:^|\b)(` + strings.Join(names, "|") + `)(?:\b|$)`)
+	if err != nil {
+		return err
+	}
+	glossary, glossaryRe = terms, re
+	return nil
+}
+
+// glossaryFlag loads the glossary as soon as the flag is parsed.
+type glossaryFlag struct{}
+
+func (glossaryFlag) String() string { return "" }
+
+func (glossaryFlag) Set(v string) error { return loadGlossary(v) }
+
+// KindGlossaryTerm is the node kind of an annotated glossary term.
+var KindGlossaryTerm = ast.NewNodeKind("GlossaryTerm")
+
+// A GlossaryTerm wraps the text of one occurrence of a glossary term.
+type GlossaryTerm struct {
+	ast.BaseInline
+	Term string
+}
+
+// Kind implements ast.Node.
+func (n *GlossaryTerm) Kind() ast.NodeKind { return KindGlossaryTerm }
+
+// Dump implements ast.Node.
+func (n *GlossaryTerm) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Term": n.Term}, nil)
+}
+
+// KindGlossaryAppendix is the node kind of the generated glossary list.
+var KindGlossaryAppendix = ast.NewNodeKind("GlossaryAppendix")
+
+// A GlossaryAppendix lists the glossary terms used in the document.
+type GlossaryAppendix struct {
+	ast.BaseBlock
+	Terms []string
+}
+
+// Kind implements ast.Node.
+func (n *GlossaryAppendix) Kind() ast.NodeKind { return KindGlossaryAppendix }
+
+// Dump implements ast.Node.
+func (n *GlossaryAppendix) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type glossaryTransformer struct{}
+
+func (t *glossaryTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	if glossaryRe == nil {
+		return
+	}
+	src := reader.Source()
+	var texts []*ast.Text
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link, *ast.AutoLink, *ast.CodeSpan, *ast.Heading, *ast.RawHTML, *GlossaryTerm:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			texts = append(texts, n)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	used := make(map[string]bool)
+	var order []string
+	for _, n := range texts {
+		seg := n.Segment
+		matches := glossaryRe.FindAllSubmatchIndex(seg.Value(src), -1)
+		if matches == nil {
+			continue
+		}
+		parent := n.Parent()
+		pos := seg.Start
+		for _, m := range matches {
+			start, stop := seg.Start+m[2], seg.Start+m[3]
+			if start > pos {
+				parent.InsertBefore(parent, n, ast.NewTextSegment(text.NewSegment(pos, start)))
+			}
+			term := string(src[start:stop])
+			g := &GlossaryTerm{Term: term}
+			g.AppendChild(g, ast.NewTextSegment(text.NewSegment(start, stop)))
+			parent.InsertBefore(parent, n, g)
+			if !used[term] {
+				used[term] = true
+				order = append(order, term)
+			}
+			pos = stop
+		}
+		// The remainder keeps the original node, and with it any line break.
+		n.Segment = text.NewSegment(pos, seg.Stop)
+		if pos == seg.Stop && !n.SoftLineBreak() && !n.HardLineBreak() {
+			parent.RemoveChild(parent, n)
+		}
+	}
+	if len(order) > 0 {
+		sort.Slice(order, func(i, j int) bool { return strings.ToLower(order[i]) < strings.ToLower(order[j]) })
+		doc.AppendChild(doc, &GlossaryAppendix{Terms: order})
+	}
+}
+
+type glossaryRenderer struct{}
+
+func (r *glossaryRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindGlossaryTerm, r.renderTerm)
+	reg.Register(KindGlossaryAppendix, r.renderAppendix)
+}
+
+func (r *glossaryRenderer) renderTerm(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		w.WriteString("</abbr>")
+		return ast.WalkContinue, nil
+	}
+	w.WriteString(`<abbr class="glossary-term" title="`)
+	w.Write(util.EscapeHTML([]byte(glossary[node.(*GlossaryTerm).Term])))
+	w.WriteString(`">`)
+	return ast.WalkContinue, nil
+}
+
+func (r *glossaryRenderer) renderAppendix(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	w.WriteString("<section class=\"glossary-appendix\">\n<h2>Glossary</h2>\n<dl>\n")
+	for _, t := range node.(*GlossaryAppendix).Terms {
+		w.WriteString("<dt>")
+		w.Write(util.EscapeHTML([]byte(t)))
+		w.WriteString("</dt><dd>")
+		w.Write(util.EscapeHTML([]byte(glossary[t])))
+		w.WriteString("</dd>\n")
+	}
+	w.WriteString("</dl>\n</section>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+type glossaryExtension struct{}
+
+// Glossary is a goldmark.Extender annotating --glossary terms.
+var Glossary goldmark.Extender = &glossaryExtension{}
+
+func (e *glossaryExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		// After the other transformers, so attribute lists are gone.
+		util.Prioritized(&glossaryTransformer{}, 800),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&glossaryRenderer{}, 500),
+	))
+}
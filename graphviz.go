@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"html"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// Fenced `dot`/`graphviz` blocks render as SVG through the local Graphviz
+// `dot` binary. ```dot engine=neato picks another layout engine. When dot
+// is missing or the graph is invalid, the error is shown above the source.
+
+// graphvizEngines are the layout engines accepted for engine=.
+var graphvizEngines = map[string]bool{
+	"dot": true, "neato": true, "fdp": true, "sfdp": true, "circo": true, "twopi": true, "osage": true, "patchwork": true,
+}
+
+// graphvizTimeout bounds one layout; large graphs can take a while in dot,
+// but a reload should never hang on one.
+const graphvizTimeout = 10 * time.Second
+
+// graphvizCache keeps rendered SVG by engine and source, so live reloads
+// only lay out the graphs that changed.
+var graphvizCache = struct {
+	sync.Mutex
+	svg map[[32]byte][]byte
+}{svg: make(map[[32]byte][]byte)}
+
+var errNoDot = errors.New("dot not found in PATH; install Graphviz to render this graph")
+
+// renderDot lays out src with Graphviz and returns the SVG element.
+func renderDot(engine string, src []byte) ([]byte, error) {
+	key := sha256.Sum256(append([]byte(engine+"\x00"), src...))
+	graphvizCache.Lock()
+	svg, ok := graphvizCache.svg[key]
+	graphvizCache.Unlock()
+	if ok {
+		return svg, nil
+	}
+
+	path, err := exec.LookPath("dot")
+	if err != nil {
+		return nil, errNoDot
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), graphvizTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, "-K"+engine, "-Tsvg")
+	cmd.Stdin = bytes.NewReader(src)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("layout timed out after %s", graphvizTimeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	// Drop the XML declaration and doctype; the page is HTML.
+	if i := bytes.Index(out, []byte("<svg")); i >= 0 {
+		out = out[i:]
+	}
+
+	graphvizCache.Lock()
+	if len(graphvizCache.svg) >= 256 {
+		graphvizCache.svg = make(map[[32]byte][]byte)
+	}
+	graphvizCache.svg[key] = out
+	graphvizCache.Unlock()
+	return out, nil
+}
+
+// graphvizState is one graph: its layout engine and source.
+type graphvizState struct {
+	engine string
+	source []byte
+}
+
+// graphvizBlock renders dot/graphviz fences. The fenced code is only
+// shown when layout fails.
+type graphvizBlock struct{}
+
+func init() {
+	registerBlock("graphviz", []string{"dot", "graphviz"}, true, graphvizBlock{})
+}
+
+func (graphvizBlock) Prepare(fcb *ast.FencedCodeBlock, lang string, code []byte) interface{} {
+	st := &graphvizState{engine: "dot", source: code}
+	if v, ok := fcb.AttributeString("engine"); ok {
+		st.engine = string(attrValue(v))
+	}
+	return st
+}
+
+func (graphvizBlock) Render(w util.BufWriter, state interface{}, entering bool) ast.WalkStatus {
+	if !entering {
+		return ast.WalkContinue
+	}
+	st := state.(*graphvizState)
+	var svg []byte
+	err := fmt.Errorf("unknown layout engine %q", st.engine)
+	if graphvizEngines[st.engine] {
+		svg, err = renderDot(st.engine, st.source)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "<div class=\"graphviz-error\">Graphviz: %s</div>\n", html.EscapeString(err.Error()))
+		return ast.WalkContinue
+	}
+	w.WriteString(`<div class="graphviz">`)
+	w.Write(svg)
+	w.WriteString("</div>\n")
+	return ast.WalkSkipChildren
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// A highlight setting names one Chroma style, or two as "light,dark": the
+// first for light pages, the second for dark ones, following the theme and
+// the system preference the way the built-in style does.
+
+// handleHighlightCSS serves the Chroma stylesheet for ?style=name. Selectors
+// are scoped to :root[data-hl="name"] so the chosen style wins over the
+// embedded light/dark rules, which are equally specific but come earlier.
+func handleHighlightCSS(w http.ResponseWriter, r *http.Request) {
+	css, err := highlightCSS(r.URL.Query().Get("style"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write(css)
+}
+
+// checkHighlight reports an unknown style in a highlight setting.
+func checkHighlight(name string) error {
+	light, dark, _ := strings.Cut(name, ",")
+	for _, s := range []string{light, dark} {
+		if _, ok := styles.Registry[strings.ToLower(s)]; s != "" && !ok {
+			return fmt.Errorf("unknown highlight style %q", s)
+		}
+	}
+	if strings.HasSuffix(name, ",") {
+		return fmt.Errorf("highlight %q is missing its dark style", name)
+	}
+	return nil
+}
+
+// highlightCSS returns the scoped stylesheet for a highlight setting.
+func highlightCSS(name string) ([]byte, error) {
+	if err := checkHighlight(name); err != nil || name == "" {
+		return nil, fmt.Errorf("unknown highlight style %q", name)
+	}
+	light, dark, pair := strings.Cut(name, ",")
+	root := `:root[data-hl="` + name + `"]`
+	css, err := chromaCSS(light)
+	if err != nil {
+		return nil, err
+	}
+	out := scopeCSS(css, root+" ")
+	if pair {
+		if css, err = chromaCSS(dark); err != nil {
+			return nil, err
+		}
+		out = append(out, scopeCSS(css, root+`[data-theme="dark"] `)...)
+		out = append(out, "@media (prefers-color-scheme: dark) {\n"...)
+		out = append(out, scopeCSS(css, root+`:not([data-theme="light"]) `)...)
+		out = append(out, "}\n"...)
+	}
+	return out, nil
+}
+
+// chromaCSS returns Chroma's stylesheet for the named style.
+func chromaCSS(name string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(&buf, styles.Registry[strings.ToLower(name)])
+	return buf.Bytes(), err
+}
+
+// scopeCSS prefixes every selector of Chroma's generated stylesheet, which
+// emits one "/* Comment */ selector { ... }" rule per line.
+func scopeCSS(css []byte, prefix string) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(css), "\n") {
+		rule := line
+		comment := ""
+		if i := strings.Index(line, "*/"); i >= 0 {
+			comment, rule = line[:i+2], line[i+2:]
+		}
+		brace := strings.IndexByte(rule, '{')
+		if brace < 0 {
+			out.WriteString(line + "\n")
+			continue
+		}
+		sels := strings.Split(rule[:brace], ",")
+		for i, s := range sels {
+			s = strings.TrimSpace(s)
+			// .bg styles the pre itself; Chroma puts both classes on it.
+			if s == ".bg" {
+				s = ".chroma"
+			}
+			sels[i] = prefix + s
+		}
+		out.WriteString(comment + " " + strings.Join(sels, ", ") + " " + rule[brace:] + "\n")
+	}
+	return out.Bytes()
+}
+
+// documentKey identifies the document for per-document browser settings.
+func documentKey(name string) string {
+	if name == "" {
+		return "stdin"
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}
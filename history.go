@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// When the document on screen is a file in a git repository, the 🕰 button
+// opens its history: a slider across the file's commits, oldest to newest,
+// that renders the file as it was at each one. Renames are followed. The
+// page keeps the versions it has rendered and fetches the commits either
+// side of the one shown ahead of time, so scrubbing stays quick; the
+// server keeps the sources of the last few it read from git.
+
+// historyLimit caps how far back the slider goes.
+const historyLimit = 200
+
+// A fileCommit is one commit that changed the file.
+type fileCommit struct {
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Subject string    `json:"subject"`
+	path    string    // the file in that commit, from the repository root
+}
+
+// gitAvailable reports whether git is installed, for the page's button.
+func gitAvailable() bool {
+	_, err := exec.LookPath("git")
+	return err == nil
+}
+
+var commitHashPattern = regexp.MustCompile(`^[0-9a-f]{40,64}$`)
+
+// fileHistory returns the commits that changed path, newest first.
+func fileHistory(path string) ([]fileCommit, error) {
+	out, err := exec.Command("git", "-C", filepath.Dir(path), "-c", "core.quotePath=false", "log", "--follow",
+		"-n", strconv.Itoa(historyLimit), "--format=%x01%H%x00%ct%x00%an%x00%s", "--name-only", "--", filepath.Base(path)).Output()
+	if err != nil {
+		return nil, err
+	}
+	list := []fileCommit{}
+	for _, entry := range strings.Split(string(out), "\x01")[1:] {
+		head, names, _ := strings.Cut(entry, "\n")
+		f := strings.SplitN(head, "\x00", 4)
+		if len(f) < 4 {
+			continue
+		}
+		secs, _ := strconv.ParseInt(f[1], 10, 64)
+		c := fileCommit{Hash: f[0], Time: time.Unix(secs, 0), Author: f[2], Subject: f[3]}
+		for _, name := range strings.Split(names, "\n") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.path = name
+				break
+			}
+		}
+		if c.path != "" {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+// historySources caches file contents by commit and path; they never
+// change, and the slider asks for the same few again and again.
+var historySources struct {
+	sync.Mutex
+	keys []string
+	data map[string][]byte
+}
+
+const historySourceCache = 32
+
+// sourceAt returns the file's content at commit c.
+func sourceAt(path string, c fileCommit) ([]byte, error) {
+	key := c.Hash + ":" + c.path
+	historySources.Lock()
+	if data, ok := historySources.data[key]; ok {
+		historySources.Unlock()
+		return data, nil
+	}
+	historySources.Unlock()
+
+	// Without a leading ./ the path is from the repository root.
+	out, err := exec.Command("git", "-C", filepath.Dir(path), "show", c.Hash+":"+c.path).Output()
+	if err != nil {
+		return nil, err
+	}
+	out = bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n"))
+
+	historySources.Lock()
+	defer historySources.Unlock()
+	if historySources.data == nil {
+		historySources.data = make(map[string][]byte)
+	}
+	if _, ok := historySources.data[key]; !ok {
+		if len(historySources.keys) == historySourceCache {
+			delete(historySources.data, historySources.keys[0])
+			historySources.keys = historySources.keys[1:]
+		}
+		historySources.keys = append(historySources.keys, key)
+		historySources.data[key] = out
+	}
+	return out, nil
+}
+
+// historyFile returns the file whose history r asks for, or "" when the
+// document has none to show.
+func historyFile(r *http.Request) string {
+	key := requestedDocument(r)
+	if key == mainDocument && (len(inputPaths) != 1 || inputPages != nil) {
+		return ""
+	}
+	path := docs.Get(key).Path
+	if path == "" || isEmail(path) {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path
+}
+
+// handleHistory serves /api/history[?page=route]: GET lists the file's
+// commits, and GET ?commit= returns the file rendered as of one of them.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if isLocked() || dirRoot != "" {
+		http.NotFound(w, r)
+		return
+	}
+	if encrypted {
+		http.Error(w, "history is disabled for encrypted documents", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := historyFile(r)
+	if path == "" {
+		http.NotFound(w, r)
+		return
+	}
+	list, err := fileHistory(path)
+	if err != nil {
+		// Not in a repository, or no git: nothing to scrub through.
+		list = []fileCommit{}
+	}
+	hash := r.URL.Query().Get("commit")
+	if hash == "" {
+		writeJSON(w, map[string]interface{}{"commits": list})
+		return
+	}
+	if !commitHashPattern.MatchString(hash) {
+		http.Error(w, "invalid commit", http.StatusBadRequest)
+		return
+	}
+	for _, c := range list {
+		if c.Hash != hash {
+			continue
+		}
+		src, err := sourceAt(path, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rendered, err := convertLimited(src, parseOptions(nil)...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"commit": c, "html": string(rendered)})
+		return
+	}
+	http.NotFound(w, r)
+}
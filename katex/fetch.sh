@@ -0,0 +1,17 @@
+#!/bin/sh
+# Fetches the KaTeX release that math.go embeds: the minified script and
+# stylesheet, the woff2 fonts and the license. Run through go generate.
+set -eu
+
+version=${1:?usage: fetch.sh <katex version>}
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+curl -fsSL "https://registry.npmjs.org/katex/-/katex-$version.tgz" | tar -xz -C "$tmp"
+dist=$tmp/package/dist
+cp "$dist/katex.min.js" "$dist/katex.min.css" "$tmp/package/LICENSE" .
+rm -rf fonts
+mkdir fonts
+cp "$dist"/fonts/*.woff2 fonts/
+echo "$version" > VERSION
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Limits keep a pathological or hostile document from taking the server
+// down. Past them the page shows what could be rendered, with a warning:
+// the start of an oversized file, nesting cut off at the maximum depth, or
+// the escaped source when rendering takes too long.
+
+var (
+	maxInputSize  int64 = 32 << 20 // --max-input-size; 0 disables
+	maxRenderTime       = 10 * time.Second
+	maxNesting          = 64
+)
+
+// maxArchiveSize is how much may be extracted from an archive in all.
+func maxArchiveSize() int64 { return 16 * maxInputSize }
+
+// readAtMost reads r to the end, failing if it holds more than limit bytes
+// (0 is no limit).
+func readAtMost(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("larger than %s (--max-input-size)", sizeFlag{&limit})
+	}
+	return data, nil
+}
+
+// fallbackSourceSize is how much source is shown when rendering times out.
+const fallbackSourceSize = 256 << 10
+
+// slowRenders holds the sources whose render timed out and is still going.
+// Goldmark can't be stopped mid-render, so until it ends those sources are
+// shown as source straight away rather than rendered again by every reload.
+var slowRenders = struct {
+	sync.Mutex
+	running map[[32]byte]bool
+}{running: make(map[[32]byte]bool)}
+
+// sizeFlag parses sizes like 512MiB into an int64.
+type sizeFlag struct{ p *int64 }
+
+func (f sizeFlag) String() string {
+	if f.p == nil {
+		return ""
+	}
+	switch n := *f.p; {
+	case n > 0 && n%(1<<20) == 0:
+		return strconv.FormatInt(n>>20, 10) + "MiB"
+	case n > 0 && n%(1<<10) == 0:
+		return strconv.FormatInt(n>>10, 10) + "KiB"
+	default:
+		return strconv.FormatInt(n, 10)
+	}
+}
+
+func (f sizeFlag) Set(v string) error {
+	n, err := parseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*f.p = n
+	return nil
+}
+
+// depthLimit is an AST transformer that drops whatever is nested deeper
+// than maxNesting, leaving a marker in its place. It runs before the
+// others so they never walk the full depth.
+var depthLimit = util.Prioritized(depthLimiter{}, 100)
+
+type depthLimiter struct{}
+
+// prunedKey marks the document as cut, for convertLimited's warning.
+var prunedKey = []byte("mdview-pruned")
+
+func (depthLimiter) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	if maxNesting <= 0 {
+		return
+	}
+	pruned := false
+	var walk func(n ast.Node, depth int)
+	walk = func(n ast.Node, depth int) {
+		if depth >= maxNesting && n.HasChildren() {
+			n.RemoveChildren(n)
+			marker := ast.NewString([]byte("[…]"))
+			if n.Type() == ast.TypeBlock && !isTextBlock(n) {
+				p := ast.NewParagraph()
+				p.AppendChild(p, marker)
+				n.AppendChild(n, p)
+			} else {
+				n.AppendChild(n, marker)
+			}
+			pruned = true
+			return
+		}
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			walk(c, depth+1)
+		}
+	}
+	walk(doc, 0)
+	if pruned {
+		doc.SetAttribute(prunedKey, true)
+	}
+}
+
+// isTextBlock reports whether n holds inlines rather than blocks.
+func isTextBlock(n ast.Node) bool {
+	switch n.Kind() {
+	case ast.KindParagraph, ast.KindTextBlock, ast.KindHeading:
+		return true
+	}
+	return false
+}
+
+// convertLimited renders src like md.Convert within the limits, prefixing
+// a warning when one was hit.
+func convertLimited(src []byte, opts ...parser.ParseOption) ([]byte, error) {
+	var warnings []string
+	if maxInputSize > 0 && int64(len(src)) > maxInputSize {
+		cut := src[:maxInputSize]
+		if i := bytes.LastIndexByte(cut, '\n'); i > 0 {
+			cut = cut[:i+1]
+		}
+		warnings = append(warnings, fmt.Sprintf("This document is %s; only the first %s are shown (--max-input-size).",
+			formatSize(int64(len(src))), formatSize(int64(len(cut)))))
+		src = cut
+	}
+
+	key := sha256.Sum256(src)
+	slowRenders.Lock()
+	slow := slowRenders.running[key]
+	slowRenders.Unlock()
+	if slow {
+		return renderTimedOut(src, warnings), nil
+	}
+
+	ctx := context.Background()
+	if maxRenderTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxRenderTime)
+		defer cancel()
+	}
+	type result struct {
+		html   []byte
+		pruned bool
+		err    error
+	}
+	m := markdown()
+	done := make(chan result, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer func() {
+			if v := recover(); v != nil {
+				reportCrash(v, "rendering")
+				done <- result{err: fmt.Errorf("rendering failed: %v", v)}
+			}
+		}()
+		doc := m.Parser().Parse(text.NewReader(src), opts...)
+		if ctx.Err() != nil {
+			return // timed out while parsing; nobody is waiting
+		}
+		_, pruned := doc.Attribute(prunedKey)
+		var buf bytes.Buffer
+		err := m.Renderer().Render(&buf, src, doc)
+		done <- result{buf.Bytes(), pruned, err}
+	}()
+
+	var out bytes.Buffer
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		if res.pruned {
+			warnings = append(warnings, fmt.Sprintf("Content nested more than %d levels deep is not shown (--max-nesting).", maxNesting))
+		}
+		writeLimitWarnings(&out, warnings)
+		out.Write(res.html)
+	case <-ctx.Done():
+		slowRenders.Lock()
+		select {
+		case <-finished:
+		default:
+			slowRenders.running[key] = true
+			go func() {
+				<-finished
+				slowRenders.Lock()
+				delete(slowRenders.running, key)
+				slowRenders.Unlock()
+			}()
+		}
+		slowRenders.Unlock()
+		return renderTimedOut(src, warnings), nil
+	}
+	return out.Bytes(), nil
+}
+
+// renderTimedOut returns the escaped start of src under the warnings and
+// the timeout's.
+func renderTimedOut(src []byte, warnings []string) []byte {
+	var out bytes.Buffer
+	warnings = append(warnings, fmt.Sprintf("Rendering took longer than %s, so the source is shown instead (--max-render-time).", maxRenderTime))
+	writeLimitWarnings(&out, warnings)
+	if len(src) > fallbackSourceSize {
+		src = src[:fallbackSourceSize]
+	}
+	fmt.Fprintf(&out, "<pre class=\"render-limit-source\">%s</pre>\n", html.EscapeString(string(src)))
+	return out.Bytes()
+}
+
+func writeLimitWarnings(b *bytes.Buffer, warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(b, "<p class=\"render-limit\" role=\"alert\">%s</p>\n", html.EscapeString(w))
+	}
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A relative link from an input file to another Markdown file, such as
+// [design](./design.md), opens that file as a live page of its own: it is
+// read into the store and joins the watch set the first time someone
+// follows the link. In directory mode every file of the tree is served
+// already (see store.go).
+
+// linkedPages are the linked files opened so far, by route.
+var linkedPages = struct {
+	sync.Mutex
+	byRoute map[string]inputPage
+}{byRoute: make(map[string]inputPage)}
+
+// linkedFiles hands newly opened linked files to the watcher.
+var linkedFiles = make(chan inputPage)
+
+// followsLinks reports whether linked Markdown files are served live: with
+// files given on the command line, which aren't encrypted.
+func followsLinks() bool {
+	return dirRoot == "" && len(inputPaths) > 0 && !encrypted
+}
+
+// openLinked returns the linked page at route, reading it the first time.
+// It reports false for anything but a Markdown file under the inputs'
+// directory.
+func openLinked(route string) (inputPage, bool) {
+	if !followsLinks() || !isMarkdown(route) {
+		return inputPage{}, false
+	}
+	fsys, rel, name, ok := siteFile(route)
+	if !ok {
+		return inputPage{}, false
+	}
+	route = "/" + rel
+	linkedPages.Lock()
+	defer linkedPages.Unlock()
+	if p, ok := linkedPages.byRoute[route]; ok {
+		return p, true
+	}
+	if info, err := fs.Stat(fsys, rel); err != nil || info.IsDir() {
+		return inputPage{}, false
+	}
+	data, modTime, err := readInputs([]string{name})
+	if err != nil {
+		return inputPage{}, false
+	}
+	p := inputPage{Path: name, Route: route, Key: "linked:" + route}
+	docs.Set(p.Key, Document{Path: name, Content: data, Modified: modTime})
+	linkedPages.byRoute[route] = p
+	go func() { linkedFiles <- p }()
+	return p, true
+}
+
+// linkedPage returns the linked page already opened at route.
+func linkedPage(route string) (inputPage, bool) {
+	linkedPages.Lock()
+	defer linkedPages.Unlock()
+	p, ok := linkedPages.byRoute[route]
+	return p, ok
+}
+
+// linkedRoute returns the route of the linked page for the file at path,
+// or "".
+func linkedRoute(path string) string {
+	linkedPages.Lock()
+	defer linkedPages.Unlock()
+	for _, p := range linkedPages.byRoute {
+		if p.Path == path {
+			return p.Route
+		}
+	}
+	return ""
+}
+
+// linkedPageList returns the linked pages opened so far, by route.
+func linkedPageList() []inputPage {
+	linkedPages.Lock()
+	defer linkedPages.Unlock()
+	pages := make([]inputPage, 0, len(linkedPages.byRoute))
+	for _, p := range linkedPages.byRoute {
+		pages = append(pages, p)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Route < pages[j].Route })
+	return pages
+}
+
+// isInput reports whether path names one of the files given on the command
+// line.
+func isInput(path string) bool {
+	for _, p := range inputPaths {
+		if abs, err := filepath.Abs(p); err == nil && abs == path {
+			return true
+		}
+	}
+	return false
+}
+
+// handleLinked serves the linked page at r's path, reporting false when
+// there is none. A link back to a combined input goes to /.
+func handleLinked(w http.ResponseWriter, r *http.Request) bool {
+	if followsLinks() && inputPages == nil {
+		if _, _, name, ok := siteFile(r.URL.Path); ok && isInput(name) {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return true
+		}
+	}
+	p, ok := openLinked(r.URL.Path)
+	if !ok {
+		return false
+	}
+	if checkNotModified(w, r) {
+		return true
+	}
+	servePage(w, r, docs.Get(p.Key))
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// externalLinkOptions controls how links leaving the preview are rendered.
+type externalLinkOptions struct {
+	Icon    bool // decorate external links with an icon
+	NewTab  bool // open in a new tab with rel="noopener noreferrer"
+	Confirm bool // ask before navigating away in the same tab
+}
+
+var externalLinks externalLinkOptions
+
+// isExternalURL reports whether dest points outside the preview server.
+func isExternalURL(dest []byte) bool {
+	u, err := url.Parse(string(dest))
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return u.Host != ""
+	case "":
+		// Protocol-relative URLs (//host/path) leave the server too.
+		return u.Host != ""
+	}
+	return false
+}
+
+// externalLinkTransformer marks links to other sites with the "external"
+// class and applies externalLinks.
+type externalLinkTransformer struct{}
+
+func (t *externalLinkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var dest []byte
+		switch n := n.(type) {
+		case *ast.Link:
+			dest = n.Destination
+		case *ast.AutoLink:
+			dest = n.URL(reader.Source())
+			if n.AutoLinkType != ast.AutoLinkURL {
+				return ast.WalkContinue, nil
+			}
+		default:
+			return ast.WalkContinue, nil
+		}
+		if !isExternalURL(dest) {
+			return ast.WalkContinue, nil
+		}
+		class := "external"
+		if externalLinks.Icon {
+			class += " external-icon"
+		}
+		if v, ok := n.AttributeString("class"); ok {
+			class = string(attrValue(v)) + " " + class
+		}
+		n.SetAttributeString("class", []byte(class))
+		if externalLinks.NewTab {
+			n.SetAttributeString("target", []byte("_blank"))
+			n.SetAttributeString("rel", []byte("noopener noreferrer"))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// Runs after spanAttributes (lower values run first) so `{.class}` lists are kept.
+var externalLinkAttributes = util.Prioritized(&externalLinkTransformer{}, 600)
@@ -1,30 +1,28 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"embed"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/fsnotify/fsnotify"
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
-	highlighting "github.com/yuin/goldmark-highlighting/v2"
 )
 
 //go:embed style.css
@@ -33,119 +31,396 @@ var styleFS embed.FS
 var (
 	md goldmark.Markdown
 
-	filePath     string
-	baseDir      string
-	content      []byte
-	lastModified time.Time
-	mu           sync.RWMutex
+	// watchDebounce is how long input files must stay unchanged before a
+	// save is picked up, so multi-chunk writes cause a single reload.
+	watchDebounce time.Duration
 
-	clients   = make(map[chan struct{}]struct{})
-	clientsMu sync.Mutex
+	// tlsCert and tlsKey switch the server to HTTPS, which also enables
+	// HTTP/2 so many SSE streams share one connection per browser.
+	tlsCert, tlsKey string
+
+	// vimKeys enables Vim-style navigation unless the reader turned it off.
+	vimKeys bool
+
+	// baseDir is the directory of the input files; relative links and
+	// images are served from it.
+	baseDir string
+
+	// mu guards the renderer and session state (md, the active profile, the
+	// keyring...). The documents have their own lock; see documents.go.
+	mu sync.RWMutex
 )
 
 func init() {
-	md = goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			extension.TaskList,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle("github"),
-				highlighting.WithFormatOptions(
-					chromahtml.WithClasses(true),
-				),
-			),
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithUnsafe(),
-		),
-	)
+	md, renderOptions = newMarkdown(defaultRenderer), defaultRenderer
 }
 
 func main() {
+	defer recoverCrash()
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "mdview: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// addRenderFlags registers the options shared by every mode that renders
+// and serves a document.
+func addRenderFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configPath, "config", "", "read renderer options (unsafe, extensions, highlight) from this YAML `file`; re-read on SIGHUP")
+	fs.StringVar(&userCSSPath, "css", "", "add this stylesheet `file` after the built-in one; edits reload in the browser")
+	fs.Func("highlight-style", "Chroma `style` for code blocks, or light,dark for one per color scheme (e.g. github,monokai)", func(v string) error {
+		if err := checkHighlight(v); err != nil {
+			return err
+		}
+		highlightFlag = v
+		return nil
+	})
+	fs.StringVar(&themeFlag, "theme", "", "default color `theme`: github, github-dark, solarized, dracula or sepia (default: follow the system)")
+	fs.StringVar(&profileName, "profile", "", "start with this settings `profile`: writing, review, slides or one from --config")
+	fs.Var(containerFlag{}, "container", "set the CSS classes of a ::: container as `name=classes` (repeatable)")
+	fs.BoolVar(&externalLinks.Icon, "external-icon", false, "mark external links with an icon")
+	fs.BoolVar(&externalLinks.NewTab, "external-new-tab", false, "open external links in a new tab (target=_blank, rel=noopener)")
+	fs.BoolVar(&externalLinks.Confirm, "external-confirm", false, "confirm before navigating away to an external link")
+	fs.Var(glossaryFlag{}, "glossary", "annotate terms from this `file` (\"term: definition\" lines or JSON) with tooltips")
+	fs.Var(bibliographyFlag{}, "bibliography", "resolve [@key] citations against this BibTeX or CSL-JSON `file`")
+	fs.IntVar(&treeLines, "tree-lines", treeLines, "show fenced json/yaml blocks of at least this many `lines` as a collapsible tree (0 disables)")
+	fs.Var(blocksFlag{}, "blocks", blocksUsage())
+	fs.BoolFunc("schema-diagrams", "same as --blocks schema", func(v string) error {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		return setBlockEnabled("schema", on)
+	})
+	fs.Var(anchorFlag{}, "anchors", "heading anchor `style`: ascii, translit (é→e) or unicode")
+	fs.Var(ageFlag{&staleAfter}, "stale-after", "show a \"possibly outdated\" banner on documents not reviewed or changed for this `age` (e.g. 180d)")
+	fs.Var(sizeFlag{&maxInputSize}, "max-input-size", "render only the first `size` of larger documents, e.g. 8MiB (0 = no limit)")
+	fs.DurationVar(&maxRenderTime, "max-render-time", maxRenderTime, "show the source instead of a render that takes longer than this (0 = no limit)")
+	fs.IntVar(&maxNesting, "max-nesting", maxNesting, "drop content nested deeper than this many levels (0 = no limit)")
+	fs.BoolVar(&showFrontMatter, "front-matter", false, "show each document's YAML front matter in a collapsible panel above it")
+	fs.BoolVar(&plainMode, "plain", false, "serve low-bandwidth pages: images as alt text, no KaTeX or highlight stylesheet, compressed (?plain=0 turns it off per browser)")
+	fs.BoolFunc("safe", "sanitize raw HTML in the documents (the default for stdin, git repositories and buckets)", func(v string) error {
+		return setHTMLMode(v, "safe")
+	})
+	fs.BoolFunc("unsafe", "pass raw HTML through, scripts included, even for stdin and remote documents", func(v string) error {
+		return setHTMLMode(v, "unsafe")
+	})
+	fs.BoolVar(&vimKeys, "vim", false, "enable Vim-style navigation keys by default (j/k, gg/G, Ctrl-d/u, /, n/N)")
+}
+
+// parseFlags parses args into fs, exiting on -h or a usage error.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+}
+
+// parseCommandFlags is parseFlags for subcommands, whose flags may also
+// follow their arguments (mdview audit docs/ --json); it returns the
+// arguments. Everything after -- is an argument.
+func parseCommandFlags(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		parseFlags(fs, args)
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...)
+		}
+		positional, args = append(positional, rest[0]), rest[1:]
+	}
+}
+
 func run() error {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "aggregate":
+			return runAggregate(os.Args[2:])
+		case "audit":
+			return runAudit(os.Args[2:])
+		case "outline-diff":
+			return runOutlineDiff(os.Args[2:])
+		case "fix-links":
+			return runFixLinks(os.Args[2:])
+		case "a11y":
+			return runA11y(os.Args[2:])
+		case "version":
+			return runVersion(os.Args[2:])
+		case "self-update":
+			return runSelfUpdate(os.Args[2:])
+		case "doctor":
+			return runDoctor(os.Args[2:])
+		}
+	}
+
 	// Parse args
-	args := os.Args[1:]
-	for _, a := range args {
-		if a == "-h" || a == "--help" {
-			fmt.Fprintf(os.Stderr, "Usage: mdview <file.md> [file2.md ...]\n")
-			fmt.Fprintf(os.Stderr, "       cat file.md | mdview\n\n")
-			fmt.Fprintf(os.Stderr, "Renders Markdown in a browser with live reload.\n")
-			fmt.Fprintf(os.Stderr, "Close the browser tab or press Ctrl+C to exit.\n")
-			os.Exit(0)
+	fs := flag.NewFlagSet("mdview", flag.ContinueOnError)
+	addRenderFlags(fs)
+	fs.StringVar(&identityFile, "identity", "", "age identity `file` for decrypting .age inputs (default: prompt for a passphrase)")
+	fs.StringVar(&exportPath, "export", "", "write the document as a self-contained HTML `file` (- for stdout) and exit")
+	fs.StringVar(&exportPath, "o", "", "shorthand for --export")
+	fs.StringVar(&pdfPath, "pdf", "", "write the document as a PDF `file` through headless Chrome and exit")
+	fs.StringVar(&chromePath, "chrome", "", "Chrome, Chromium or Edge `binary` for PDF output (default: search PATH)")
+	fs.BoolVar(&combineFiles, "combine", false, "join several input files into one document instead of a page each")
+	fs.BoolVar(&browseDir, "browse", false, "show the directory index even when the directory has a README")
+	fs.BoolVar(&editable, "editable", false, "allow the browser to modify the source files (find/replace)")
+	fs.BoolVar(&editTasks, "edit-tasks", false, "let task list checkboxes in the browser tick [ ] / [x] in the source files")
+	fs.BoolVar(&chatEnabled, "chat", false, "add a chat sidebar for everyone viewing the document (messages are kept in memory only)")
+	fs.StringVar(&chatLogPath, "chat-log", "", "with --chat, also append messages to this `file` as JSON lines")
+	fs.BoolVar(&shareLAN, "share", false, "serve on the local network; visitors need the viewer (read-only) or editor link printed at startup")
+	fs.StringVar(&tlsCert, "tls-cert", "", "serve HTTPS (and HTTP/2) with this certificate `file`")
+	fs.StringVar(&tlsKey, "tls-key", "", "private key `file` for --tls-cert")
+	fs.IntVar(&maxClients, "max-clients", 0, "maximum concurrent live-reload connections (0 = unlimited)")
+	fs.Var(byteSizeFlag{}, "memory-limit", "soft memory `limit` for the process, e.g. 256MiB (0 = none)")
+	fs.IntVar(&listenPort, "port", 0, "listen on `port` (default: the last port used for this directory), falling back to a nearby free one")
+	fs.BoolVar(&strictPort, "strict-port", false, "exit instead of falling back when --port is in use")
+	fs.IntVar(&discoveryPort, "discovery-port", 0, "well-known `port` through which tabs find this document after a restart (e.g. 6418)")
+	fs.DurationVar(&watchDebounce, "debounce", 150*time.Millisecond, "wait this long after the last file change before reloading")
+	fs.StringVar(&onChangeCommand, "on-change", "", "run this shell `command` when the watched files change, before re-reading them (e.g. \"make docs\")")
+	fs.Var(watchFlag{}, "watch", "also watch these files (a `glob`, repeatable), e.g. the sources of a generated document")
+	fs.StringVar(&editorCommand, "editor", "", "`command` the ✎ button opens the source with; {file} and {line} are replaced (default: $VISUAL or $EDITOR)")
+	fs.BoolVar(&watchPoll, "poll", false, "watch files by polling instead of filesystem notifications (for shared folders that miss changes)")
+	fs.DurationVar(&pollInterval, "watch-interval", pollInterval, "how often polled files are checked for changes")
+	fs.StringVar(&browserCommand, "browser", "", "browser `command`, e.g. firefox or \"chromium --app={url}\"; {url} is replaced, or the URL appended (default: $BROWSER, else the system's default)")
+	fs.BoolVar(&noBrowser, "no-browser", envBool("MDVIEW_NO_BROWSER"), "only print the URL instead of opening a browser, e.g. over SSH (default: $MDVIEW_NO_BROWSER)")
+	fs.BoolVar(&wikiLinks, "wikilinks", false, "resolve Obsidian-style [[Page Name]] links and ![[image.png]] embeds against the files' directory")
+	fs.Var(vaultFlag{}, "vault", "with --wikilinks, look names up under this `directory` (implies --wikilinks)")
+	fs.StringVar(&plantumlMode, "plantuml", plantumlMode, "how ```plantuml blocks are drawn: `local` (the plantuml command), a .jar path, a server URL, or url:SERVER for images the browser loads")
+	fs.StringVar(&execCommand, "exec", "", "render the output of this shell `command` instead of a file, run again every --every and when a --watch file changes")
+	fs.DurationVar(&execEvery, "every", 0, "with --exec, run the command again at this `interval` (e.g. 5s; 0 = only on --watch changes)")
+	fs.BoolVar(&followStdin, "follow", false, "render stdin incrementally as it arrives instead of waiting for EOF")
+	fs.BoolVar(&exitOnEOF, "exit-on-eof", false, "with --follow, exit when stdin closes instead of keeping the final output up")
+	fs.BoolVar(&notifyDone, "notify", false, "with --pdf or --export, show a desktop notification when the file is written")
+	fs.StringVar(&snapshotDir, "snapshot-dir", "", "keep document snapshots in this `directory` (default: under the user cache directory)")
+	fs.DurationVar(&lockAfter, "lock-after", 10*time.Minute, "lock encrypted documents after this much inactivity (0 disables)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdview [options] <file.md> [file2.md ...]\n")
+		fmt.Fprintf(os.Stderr, "       mdview [options] <directory>        (its README, or --browse for an index)\n")
+		fmt.Fprintf(os.Stderr, "       cat file.md | mdview [options]\n")
+		fmt.Fprintf(os.Stderr, "       mdview aggregate [options] <glob>\n")
+		fmt.Fprintf(os.Stderr, "       mdview audit [options] [dir]\n")
+		fmt.Fprintf(os.Stderr, "       mdview version | self-update [--check] | doctor\n\n")
+		fmt.Fprintf(os.Stderr, "Renders Markdown in a browser with live reload.\n")
+		fmt.Fprintf(os.Stderr, "Close the browser tab or press Ctrl+C to exit.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, os.Args[1:])
+	if err := applyUserConfig(fs, userConfigPath()); err != nil {
+		return err
+	}
+	args := fs.Args()
+	if err := checkTheme(themeFlag); err != nil {
+		return err
+	}
+	if userCSSPath != "" {
+		abs, err := filepath.Abs(userCSSPath)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %w", userCSSPath, err)
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return fmt.Errorf("--css: %w", err)
+		}
+		userCSSPath = abs
+	}
+	if len(args) == 1 && isDirectory(args[0]) && !browseDir {
+		if readme := findReadme(args[0]); readme != "" {
+			args = []string{readme}
 		}
 	}
-	if len(args) == 0 {
+	// Raw HTML from stdin or the network is sanitized unless --unsafe.
+	trustInput(execCommand == "" && untrustedInput(args))
+	if execCommand != "" {
+		if len(args) > 0 || followStdin {
+			return fmt.Errorf("--exec renders a command's output and takes no files or --follow")
+		}
+		out, err := runExec()
+		if err != nil {
+			return err
+		}
+		baseDir, _ = os.Getwd()
+		docs.Set(mainDocument, Document{Content: out, Modified: time.Now()})
+	} else if len(args) == 0 {
 		// Check for stdin pipe
 		stat, _ := os.Stdin.Stat()
-		if (stat.Mode() & os.ModeCharDevice) == 0 {
+		if (stat.Mode()&os.ModeCharDevice) == 0 && followStdin {
+			// followInput fills in content once the server is up.
+			mu.Lock()
+			stream = streamStatus{State: "following"}
+			mu.Unlock()
+			docs.Set(mainDocument, Document{Modified: time.Now()})
+		} else if (stat.Mode() & os.ModeCharDevice) == 0 {
 			data, err := io.ReadAll(os.Stdin)
 			if err != nil {
 				return fmt.Errorf("reading stdin: %w", err)
 			}
-			mu.Lock()
-			content = data
-			filePath = ""
-			lastModified = time.Now()
-			mu.Unlock()
+			docs.Set(mainDocument, Document{Content: data, Modified: time.Now()})
 		} else {
-			fmt.Fprintf(os.Stderr, "Usage: mdview <file.md> [file2.md ...]\n")
-			fmt.Fprintf(os.Stderr, "       cat file.md | mdview\n")
+			fs.Usage()
 			os.Exit(1)
 		}
+	} else if followStdin {
+		return fmt.Errorf("--follow reads stdin and takes no file arguments")
+	} else if len(args) == 1 && isDirectory(args[0]) {
+		root, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("resolving %s: %w", args[0], err)
+		}
+		dirRoot = root
+		baseDir = root
+		docStore = os.DirFS(root)
+		args = nil
+	} else if len(args) == 1 && isRepoSpec(args[0]) {
+		cloneURL, label, ref, _ := parseRepoSpec(args[0])
+		r, err := cloneRepo(cloneURL, label, ref)
+		if err != nil {
+			return fmt.Errorf("cloning %s: %w", args[0], err)
+		}
+		defer os.RemoveAll(r.dir)
+		repo = r
+		dirRoot = r.dir
+		baseDir = r.dir
+		docStore = os.DirFS(r.dir)
+		args = nil
+	} else if len(args) == 1 && isStoreSpec(args[0]) {
+		store, err := openStore(args[0])
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", args[0], err)
+		}
+		dirRoot = args[0]
+		if _, err := os.Stat(args[0]); err == nil {
+			// An archive; buckets and embed: keep their spec.
+			dirRoot, _ = filepath.Abs(args[0])
+		}
+		docStore = store
+		args = nil
 	} else {
-		// Read files and track latest mod time
-		var combined []byte
-		var latestMod time.Time
 		for _, arg := range args {
-			data, err := os.ReadFile(arg)
-			if err != nil {
-				return fmt.Errorf("reading %s: %w", arg, err)
-			}
-			if info, err := os.Stat(arg); err == nil {
-				if info.ModTime().After(latestMod) {
-					latestMod = info.ModTime()
-				}
+			if isEncrypted(arg) {
+				encrypted = true
 			}
-			if len(combined) > 0 {
-				combined = append(combined, '\n', '\n')
+		}
+		if encrypted && needsKeyring(args) {
+			if err := loadKeyring(""); err != nil {
+				return err
 			}
-			combined = append(combined, data...)
 		}
+		inputPaths = args
 		absFirst, err := filepath.Abs(args[0])
 		if err != nil {
 			return fmt.Errorf("resolving %s: %w", args[0], err)
 		}
-		mu.Lock()
-		filePath = args[0]
 		baseDir = filepath.Dir(absFirst)
-		content = combined
-		lastModified = latestMod
-		mu.Unlock()
+		// Exports are one document, and locking works on the combined one.
+		if len(args) > 1 && !combineFiles && !encrypted && exportPath == "" && pdfPath == "" {
+			inputPages = newInputPages(args)
+			for _, p := range inputPages {
+				data, modTime, err := readInputs([]string{p.Path})
+				if err != nil {
+					return err
+				}
+				docs.Set(p.Key, Document{Path: p.Path, Content: data, Modified: modTime})
+			}
+		} else {
+			combined, latestMod, err := readInputs(args)
+			if err != nil {
+				return err
+			}
+			setFileSections(args)
+			docs.Set(mainDocument, Document{Path: args[0], Content: combined, Modified: latestMod})
+		}
 	}
 
-	// Start server on random port
-	listener, err := net.Listen("tcp", "localhost:0")
+	if exportPath != "" || pdfPath != "" {
+		if dirRoot != "" || followStdin {
+			return fmt.Errorf("--export and --pdf write a single document; give them files or stdin")
+		}
+		if pdfPath != "" {
+			return exportPDF(pdfPath)
+		}
+		return exportStandalone(exportPath)
+	}
+
+	return serve(args, func(ctx context.Context) {
+		// File watcher (notifications, or polling; see watch.go)
+		if docs.Get(mainDocument).Path != "" {
+			go watchFiles(ctx, args)
+		} else if execCommand != "" {
+			go execLoop(ctx)
+			if len(extraWatches) > 0 {
+				go watchFiles(ctx, nil)
+			}
+		} else if followStdin {
+			go followInput(os.Stdin)
+		}
+	})
+}
+
+// serve starts the preview server for the loaded document, opens the
+// browser and blocks until Ctrl+C. watch starts the mode's change watcher.
+func serve(args []string, watch func(ctx context.Context)) error {
+	if err := applyConfig(); err != nil {
+		return err
+	}
+	if shareLAN {
+		if err := newShareTokens(); err != nil {
+			return fmt.Errorf("creating share links: %w", err)
+		}
+	}
+	listener, err := listen()
 	if err != nil {
 		return fmt.Errorf("starting server: %w", err)
 	}
 	port := listener.Addr().(*net.TCPAddr).Port
-	url := fmt.Sprintf("http://localhost:%d", port)
+	scheme := "http"
+	if tlsCert != "" {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://localhost:%d", scheme, port)
+
+	// Every change to the document reloads open pages.
+	defer docs.Subscribe(func(string, Document) { notifyClients() })()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handlePage)
 	mux.HandleFunc("/events", handleSSE)
 	mux.HandleFunc("/raw", handleRaw)
-
-	server := &http.Server{Handler: mux}
+	mux.HandleFunc("/preview", handlePreview)
+	mux.HandleFunc("/unlock", handleUnlock(args))
+	mux.HandleFunc("/highlight.css", handleHighlightCSS)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/api/search", handleSearch)
+	mux.HandleFunc("/api/replace", handleReplace)
+	mux.HandleFunc("/api/board", handleBoard)
+	mux.HandleFunc("/api/board/move", handleBoardMove)
+	mux.HandleFunc("/api/tasks/toggle", handleTaskToggle)
+	mux.HandleFunc("/api/definitions", handleDefinitions)
+	mux.HandleFunc("/api/snapshot", handleSnapshot)
+	mux.HandleFunc("/api/history", handleHistory)
+	mux.HandleFunc("/api/a11y", handleA11y)
+	mux.HandleFunc("/api/chat", handleChat)
+	mux.HandleFunc("/api/repo/ref", handleRepoRef)
+	mux.HandleFunc("/api/renderer", handleRenderer)
+	mux.HandleFunc("/api/profile", handleProfile)
+	mux.HandleFunc("/api/edit", handleEdit)
+	mux.HandleFunc("/api/section", handleSection)
+	mux.HandleFunc("/pdf", handlePDF)
+	mux.HandleFunc("/print", handlePrint)
+	mux.HandleFunc("/katex/", handleKaTeX)
+	mux.HandleFunc("/user-css/", handleUserCSS)
+
+	server := &http.Server{
+		Handler:           recoverHandler(trackActivity(shareAuth(plainRequests(mux)))),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		// No WriteTimeout: SSE streams are long-lived and set per-write
+		// deadlines themselves.
+	}
 
 	// Graceful shutdown context
 	ctx, cancel := context.WithCancel(context.Background())
@@ -157,22 +432,51 @@ func run() error {
 
 	// Start server
 	go func() {
-		if err := server.Serve(listener); err != http.ErrServerClosed {
+		var err error
+		if tlsCert != "" {
+			// net/http negotiates HTTP/2 automatically over TLS.
+			err = server.ServeTLS(listener, tlsCert, tlsKey)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != http.ErrServerClosed {
 			fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 			cancel()
 		}
 	}()
 
 	fmt.Fprintf(os.Stderr, "Serving at %s\n", url)
+	if shareLAN {
+		printShareLinks(scheme, port)
+	}
+
+	if discoveryPort > 0 {
+		doc := documentKey(docs.Get(mainDocument).Path)
+		if unregister, err := registerInstance(url, doc); err != nil {
+			fmt.Fprintf(os.Stderr, "discovery: %v\n", err)
+		} else {
+			defer unregister()
+		}
+		go serveDiscovery(ctx)
+	}
 
 	// Open browser
-	if err := openBrowser(url); err != nil {
+	if noBrowser {
+		fmt.Fprintf(os.Stderr, "Open %s in a browser (--no-browser).\n", url)
+	} else if err := openBrowser(url); err != nil {
 		fmt.Fprintf(os.Stderr, "Could not open browser: %v\nOpen %s manually.\n", err, url)
 	}
 
-	// File watcher (poll-based, no external dependency)
-	if filePath != "" {
-		go watchFiles(ctx, args)
+	watch(ctx)
+	if userCSSPath != "" {
+		go watchUserCSS(ctx)
+	}
+	if configPath != "" {
+		go reloadOnHangup(ctx)
+	}
+	if encrypted && lockAfter > 0 {
+		lastActivity.Store(time.Now().UnixNano())
+		go watchInactivity(ctx)
 	}
 
 	// Wait for shutdown signal. The server runs until the user stops it
@@ -181,6 +485,7 @@ func run() error {
 	select {
 	case <-sigCh:
 		fmt.Fprintf(os.Stderr, "\nShutting down...\n")
+	case <-quit:
 	case <-ctx.Done():
 	}
 
@@ -189,305 +494,471 @@ func run() error {
 	return server.Shutdown(shutdownCtx)
 }
 
+// readInputs reads and concatenates the input files, returning the latest
+// modification time among them.
+func readInputs(paths []string) ([]byte, time.Time, error) {
+	var data [][]byte
+	var latestMod time.Time
+	for _, p := range paths {
+		d, err := readSource(p)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("reading %s: %w", p, err)
+		}
+		if info, err := os.Stat(p); err == nil {
+			if info.ModTime().After(latestMod) {
+				latestMod = info.ModTime()
+			}
+		}
+		data = append(data, d)
+	}
+	return combineInputs(paths, data), latestMod, nil
+}
+
+// renderMarkdown renders the current main document.
 func renderMarkdown() ([]byte, error) {
-	mu.RLock()
-	src := content
-	mu.RUnlock()
+	return renderDocument(docs.Get(mainDocument))
+}
 
-	var buf bytes.Buffer
-	if err := md.Convert(src, &buf); err != nil {
+// renderDocument renders d. Every client shares one rendering per version
+// instead of converting on each request.
+func renderDocument(d Document) ([]byte, error) {
+	if html, ok := renderCache.get(d.Version); ok {
+		metrics.cacheHits.Add(1)
+		return html, nil
+	}
+	start := time.Now()
+	rendered, err := convertLimited(d.Content, parseOptions(fileSections)...)
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	metrics.renders.Add(1)
+	metrics.renderMicros.Add(time.Since(start).Microseconds())
+	renderCache.put(d.Version, rendered)
+	return rendered, nil
 }
 
 func handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" && dirRoot != "" {
+		handleDirectory(w, r)
+		return
+	}
 	if r.URL.Path == "/" {
-		rendered, err := renderMarkdown()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if isLocked() {
+			writeLockPage(w)
 			return
 		}
-		mu.RLock()
-		modTime := lastModified
-		name := filePath
-		mu.RUnlock()
-		writePage(w, name, rendered, modTime, true)
-		return
-	}
-
-	// Serve files from baseDir (set when launched with file args).
-	if baseDir == "" {
-		http.NotFound(w, r)
-		return
-	}
-
-	// Resolve and validate the requested path stays within baseDir.
-	rel := strings.TrimPrefix(r.URL.Path, "/")
-	cleaned := filepath.Clean(rel)
-	if cleaned == "." || strings.HasPrefix(cleaned, "..") || filepath.IsAbs(cleaned) {
-		http.NotFound(w, r)
-		return
-	}
-	absPath := filepath.Join(baseDir, cleaned)
-	// Defense in depth: re-check containment after Join.
-	if !strings.HasPrefix(absPath, baseDir+string(filepath.Separator)) && absPath != baseDir {
-		http.NotFound(w, r)
+		if checkNotModified(w, r) {
+			return
+		}
+		servePage(w, r, docs.Get(mainDocument))
 		return
 	}
-
-	info, err := os.Stat(absPath)
-	if err != nil || info.IsDir() {
-		http.NotFound(w, r)
+	if i := pageIndex(r.URL.Path); i == 0 {
+		http.Redirect(w, r, "/", http.StatusFound)
 		return
-	}
-
-	ext := strings.ToLower(filepath.Ext(absPath))
-	if ext == ".md" || ext == ".markdown" {
-		data, err := os.ReadFile(absPath)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		var buf bytes.Buffer
-		if err := md.Convert(data, &buf); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else if i > 0 {
+		if checkNotModified(w, r) {
 			return
 		}
-		writePage(w, absPath, buf.Bytes(), info.ModTime(), false)
+		servePage(w, r, docs.Get(inputPages[i].Key))
+		return
+	}
+	if handleLinked(w, r) {
 		return
 	}
 
-	// Serve other files as static assets (images, etc.).
-	http.ServeFile(w, r, absPath)
+	serveSiteFile(w, r)
 }
 
 // writePage writes the full HTML page. liveReload controls whether the SSE
 // reload script is included — only the initially-loaded file is watched.
-func writePage(w http.ResponseWriter, name string, rendered []byte, modTime time.Time, liveReload bool) {
+// src is the Markdown the page was rendered from, for its front matter.
+func writePage(w http.ResponseWriter, r *http.Request, name string, src, rendered []byte, modTime time.Time, liveReload bool) {
+	data := pageFor(r, name, src, modTime, liveReload)
+	if isPlain(r) {
+		rendered = plainHTML(rendered)
+	}
+	data.Content = template.HTML(rendered)
+	executeTemplate(w, "page.html", data)
+}
+
+// pageFor returns everything page.html shows but the content.
+func pageFor(r *http.Request, name string, src []byte, modTime time.Time, liveReload bool) pageData {
 	css, _ := styleFS.ReadFile("style.css")
 
 	title := "mdview"
 	if name != "" {
-		title = filepath.Base(name) + " — mdview"
+		title = displayName(filepath.Base(name)) + " — mdview"
+	}
+	if rel, err := filepath.Rel(dirRoot, name); dirRoot != "" && err == nil && filepath.Dir(rel) != "." && !strings.HasPrefix(rel, "..") {
+		// Every folder has a README.md; the path tells them apart.
+		title = displayName(filepath.ToSlash(rel)) + " — mdview"
+	}
+	fm, _ := documentFrontMatter(src)
+	if fm.Title != "" {
+		title = displayName(fm.Title) + " — mdview"
 	}
 
-	modTimeStr := modTime.Format(time.RFC3339)
-	modTimeDisplay := modTime.Format("Jan 2, 2006 at 3:04:05 PM")
+	profile, profileSettings, profileNames := activeProfile()
+	plain := isPlain(r)
 
-	reloadScript := ""
+	// With the inputs as separate pages, which one this is; a file linked
+	// from them is a page too.
+	page := -1
+	for i, p := range inputPages {
+		if liveReload && p.Path == name {
+			page = i
+			break
+		}
+	}
+	route := pageRoute(page)
+	linked := ""
 	if liveReload {
-		reloadScript = `
-  // SSE live reload
-  const evtSource = new EventSource('/events');
-  evtSource.addEventListener('reload', function() {
-    fetch('/raw').then(r => r.json()).then(data => {
-      document.querySelector('.container').innerHTML = data.html;
-      const timeEl = document.querySelector('#lastModified time');
-      timeEl.setAttribute('datetime', data.lastModified);
-      timeEl.textContent = formatDate(data.lastModified);
-    });
-  });
-  evtSource.onerror = function() {
-    evtSource.close();
-  };`
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, `<!DOCTYPE html>
-<html lang="en">
-<head>
-<meta charset="utf-8">
-<meta name="viewport" content="width=device-width, initial-scale=1">
-<title>%s</title>
-<style>%s</style>
-</head>
-<body>
-<button class="theme-toggle" id="themeToggle" title="Toggle dark/light mode">🌓</button>
-<div class="container">
-<div class="last-modified" id="lastModified">
-  Last modified: <time datetime="%s">%s</time>
-</div>
-%s
-</div>
-<script>
-(function() {
-  // Theme toggle
-  const toggle = document.getElementById('themeToggle');
-  const root = document.documentElement;
-  const stored = localStorage.getItem('mdview-theme');
-  if (stored) root.setAttribute('data-theme', stored);
-
-  toggle.addEventListener('click', function() {
-    const current = root.getAttribute('data-theme');
-    let next;
-    if (current === 'dark') next = 'light';
-    else if (current === 'light') next = '';
-    else next = 'dark';
-
-    if (next) {
-      root.setAttribute('data-theme', next);
-      localStorage.setItem('mdview-theme', next);
-    } else {
-      root.removeAttribute('data-theme');
-      localStorage.removeItem('mdview-theme');
-    }
-  });
-
-  function formatDate(iso) {
-    const d = new Date(iso);
-    return d.toLocaleDateString(undefined, {year:'numeric',month:'short',day:'numeric'})
-      + ' at ' + d.toLocaleTimeString();
-  }
-%s
-})();
-</script>
-</body>
-</html>`, title, string(css), modTimeStr, modTimeDisplay, string(rendered), reloadScript)
+		linked = linkedRoute(name)
+	}
+	if linked != "" {
+		route = linked
+	}
+	// The watched document may be several inputs; other pages are one file.
+	docPaths := []string{name}
+	if liveReload && len(inputPaths) > 0 && inputPages == nil && linked == "" {
+		docPaths = inputPaths
+	}
+	ownPage := page > 0 || linked != ""
+	var nav *pageNav
+	// file is the input file on screen, for mapping its lines to blocks.
+	file := ""
+	if page >= 0 {
+		nav = navFor(page)
+		file = inputPages[page].Path
+	} else if linked != "" || liveReload && dirRoot == "" && len(inputPaths) == 1 {
+		file = docPaths[0]
+	}
+
+	config := map[string]interface{}{
+		"confirmExternal": externalLinks.Confirm,
+		"discoveryPort":   discoveryPort,
+		"stream":          currentStream(),
+		"editable":        canEdit(r),
+		"editTasks":       liveReload && dirRoot == "" && canEditTasks(r),
+		"searchable":      len(inputPaths) > 0,
+		"definitions":     dirRoot != "",
+		"chat":            chatEnabled && liveReload,
+		"snapshots":       liveReload && dirRoot == "" && !encrypted && !isViewer(r) && !ownPage,
+		"history":         liveReload && dirRoot == "" && !encrypted && file != "" && !isEmail(file) && gitAvailable(),
+		"a11y":            dirRoot == "",
+		"vim":             vimKeys,
+		"themes":          themes,
+		"theme":           defaultTheme(),
+		"document":        documentKey(name),
+		"highlightStyles": styles.Names(),
+		"highlight":       currentRenderer().Highlight,
+		"pdf":             liveReload && dirRoot == "" && !encrypted && !ownPage && chromeAvailable(),
+		"printView":       liveReload && dirRoot == "" && !encrypted && !ownPage,
+		"math":            katexAvailable() && !plain,
+		"plain":           plain,
+		"openEditor":      liveReload && dirRoot == "" && canOpenEditor(r),
+		"sectionCopy":     liveReload && dirRoot == "",
+		"sectionTitles":   dirRoot != "" || page >= 0 || linked != "",
+		"page":            route,
+		"file":            file,
+		"profile":         profile,
+		"profiles":        profileNames,
+		"profileSettings": profileSettings,
+		"renderer":        !isViewer(r),
+		"exportSections":  exportDefaults(docPaths),
+	}
+
+	return pageData{
+		Title:      title,
+		Author:     fm.Author.String(),
+		Theme:      pageTheme(profileTheme(profileSettings)),
+		CSS:        template.CSS(css),
+		UserCSS:    userCSSURL(),
+		Config:     config,
+		Stale:      staleNotes(docPaths),
+		Large:      len(src) >= largeDocument,
+		Modified:   modTime,
+		Nav:        nav,
+		LiveReload: liveReload,
+	}
 }
 
 func handleRaw(w http.ResponseWriter, r *http.Request) {
-	rendered, err := renderMarkdown()
+	if isLocked() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"locked": true})
+		return
+	}
+	d := docs.Get(requestedDocument(r))
+	etag := contentETag()
+	if inputPages != nil {
+		// A page refetches only when its own file changed.
+		etag = versionETag(d.Version)
+	}
+	if checkETag(w, r, etag) {
+		return
+	}
+	rendered, err := renderDocument(d)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	mu.RLock()
-	modTime := lastModified
-	mu.RUnlock()
+	if isPlain(r) {
+		rendered = plainHTML(rendered)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	json.NewEncoder(w).Encode(map[string]interface{}{
 		"html":         string(rendered),
-		"lastModified": modTime.Format(time.RFC3339),
+		"lastModified": d.Modified.Format(time.RFC3339),
+		"stream":       currentStream(),
 	})
 }
 
 func handleSSE(w http.ResponseWriter, r *http.Request) {
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	if _, ok := w.(http.Flusher); !ok {
 		http.Error(w, "streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
+	if maxClients > 0 && metrics.sseClients.Load() >= int64(maxClients) {
+		metrics.sseRejected.Add(1)
+		http.Error(w, "too many live-reload clients", http.StatusServiceUnavailable)
+		return
+	}
+	metrics.sseClients.Add(1)
+	defer metrics.sseClients.Add(-1)
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	if r.ProtoMajor == 1 {
+		// Connection-specific headers are not allowed over HTTP/2.
+		w.Header().Set("Connection", "keep-alive")
+	}
 
-	ch := make(chan struct{}, 1)
-	clientsMu.Lock()
-	clients[ch] = struct{}{}
-	clientsMu.Unlock()
+	types := []EventType{EventReload, EventToast, EventStyle}
+	if chatEnabled {
+		types = append(types, EventChat)
+	}
+	sub, unsubscribe := bus.subscribe(types...)
+	defer unsubscribe()
+
+	// Every write gets a deadline so a stalled client is dropped instead of
+	// pinning its goroutine and buffers forever.
+	rc := http.NewResponseController(w)
+	send := func(msg string) bool {
+		rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if _, err := io.WriteString(w, msg); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
 
-	defer func() {
-		clientsMu.Lock()
-		delete(clients, ch)
-		clientsMu.Unlock()
-	}()
+	// Send initial ping with the reconnect delay hint
+	if !send(fmt.Sprintf("retry: %d\n: connected\n\n", sseRetry.Milliseconds())) {
+		return
+	}
 
-	// Send initial ping
-	fmt.Fprintf(w, ": connected\n\n")
-	flusher.Flush()
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
 
 	for {
 		select {
-		case <-ch:
-			fmt.Fprintf(w, "event: reload\ndata: reload\n\n")
-			flusher.Flush()
+		case <-sub.Ready():
+			for _, e := range sub.Take() {
+				if !send(e.sse()) {
+					return
+				}
+			}
+		case <-heartbeat.C:
+			if !send(": ping\n\n") {
+				return
+			}
 		case <-r.Context().Done():
 			return
 		}
 	}
 }
 
+const (
+	sseRetry        = 2 * time.Second  // client reconnect delay hint
+	sseHeartbeat    = 30 * time.Second // keeps proxies from closing idle streams
+	sseWriteTimeout = 10 * time.Second // per-write deadline for SSE clients
+
+	// notifyCoalesce is how long notifyClients waits so a burst of
+	// changes results in a single reload event per client.
+	notifyCoalesce = 50 * time.Millisecond
+)
+
+var (
+	notifyMu    sync.Mutex
+	notifyTimer *time.Timer
+)
+
+// notifyClients schedules a reload event for every SSE client. Calls within
+// notifyCoalesce of each other are merged into one event.
 func notifyClients() {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-	for ch := range clients {
-		select {
-		case ch <- struct{}{}:
-		default:
-		}
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	if notifyTimer != nil {
+		return
 	}
+	notifyTimer = time.AfterFunc(notifyCoalesce, func() {
+		notifyMu.Lock()
+		notifyTimer = nil
+		notifyMu.Unlock()
+		bus.Publish(Event{Type: EventReload})
+	})
 }
 
 func watchFiles(ctx context.Context, paths []string) {
-	modTimes := make(map[string]time.Time)
+	defer recoverCrash()
+	files := make(map[string]*fileState)
 	for _, p := range paths {
 		abs, err := filepath.Abs(p)
 		if err != nil {
 			continue
 		}
-		info, err := os.Stat(abs)
+		s, err := snapshotFile(abs)
 		if err != nil {
 			continue
 		}
-		modTimes[abs] = info.ModTime()
+		files[abs] = &s
+	}
+	for _, abs := range extraWatches {
+		if s, err := snapshotFile(abs); err == nil {
+			files[abs] = &s
+		}
+	}
+
+	lastHash := sha256.Sum256(docs.Get(mainDocument).Content)
+	pageHashes := make(map[string][32]byte, len(inputPages))
+	for _, p := range inputPages {
+		pageHashes[p.Key] = sha256.Sum256(docs.Get(p.Key).Content)
+	}
+
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	var poll <-chan time.Time
+	w, names := watchNative(files)
+	if w != nil {
+		defer w.Close()
+		events, watchErrors = w.Events, w.Errors
+	} else {
+		interval := pollInterval
+		if watchDebounce > 0 && watchDebounce < interval {
+			interval = watchDebounce
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
 	}
 
-	ticker := time.NewTicker(300 * time.Millisecond)
-	defer ticker.Stop()
+	// Editors often save in several writes; wait until the files have been
+	// quiet for watchDebounce before re-reading them.
+	var quiet <-chan time.Time
+	changed := func(c bool, err error) {
+		if err == nil && c {
+			quiet = time.After(watchDebounce)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			changed := false
-			var latestMod time.Time
-			for absPath, lastMod := range modTimes {
-				info, err := os.Stat(absPath)
+		case <-poll:
+			for absPath, s := range files {
+				changed(s.update(absPath))
+			}
+		case ev := <-events:
+			absPath, ok := names[filepath.Clean(ev.Name)]
+			if !ok || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			changed(files[absPath].reread(absPath))
+		case p := <-linkedFiles:
+			// A linked file someone opened joins the watch set.
+			s, err := snapshotFile(p.Path)
+			if err != nil {
+				continue
+			}
+			files[p.Path] = &s
+			pageHashes[p.Key] = sha256.Sum256(docs.Get(p.Key).Content)
+			if w != nil {
+				watchAlso(w, names, p.Path)
+			}
+		case <-watchErrors:
+			// Events may have been lost; look at every file.
+			for absPath, s := range files {
+				changed(s.reread(absPath))
+			}
+		case <-quiet:
+			quiet = nil
+			if isLocked() {
+				continue // unlocking reads the files again
+			}
+			if onChangeCommand != "" {
+				runOnChange()
+				// What the command wrote is the new baseline, not a change.
+				for absPath, s := range files {
+					s.reread(absPath)
+				}
+			}
+			if execCommand != "" {
+				refreshExec()
+				continue
+			}
+			// Only the pages whose file changed reload.
+			for _, p := range append(append([]inputPage(nil), inputPages...), linkedPageList()...) {
+				d, mod, err := readInputs([]string{p.Path})
 				if err != nil {
 					continue
 				}
-				if info.ModTime().After(lastMod) {
-					modTimes[absPath] = info.ModTime()
-					changed = true
+				hash := sha256.Sum256(d)
+				if hash == pageHashes[p.Key] {
+					continue
 				}
-				if info.ModTime().After(latestMod) {
-					latestMod = info.ModTime()
+				pageHashes[p.Key] = hash
+				docs.Update(p.Key, func(cur Document) (Document, bool) {
+					cur.Content, cur.Modified = d, mod
+					return cur, true
+				})
+			}
+			if inputPages != nil {
+				continue
+			}
+			var latestMod time.Time
+			for abs, s := range files {
+				if isInput(abs) && s.modTime.After(latestMod) {
+					latestMod = s.modTime
 				}
 			}
-			if changed {
-				// Re-read all files
-				var combined []byte
-				for _, p := range paths {
-					data, err := os.ReadFile(p)
-					if err != nil {
-						continue
-					}
-					if len(combined) > 0 {
-						combined = append(combined, '\n', '\n')
-					}
-					combined = append(combined, data...)
+			// Re-read all files
+			var read []string
+			var data [][]byte
+			for _, p := range paths {
+				d, err := readSource(p)
+				if err != nil {
+					continue
 				}
-				mu.Lock()
-				content = combined
-				lastModified = latestMod
-				mu.Unlock()
-				notifyClients()
+				read = append(read, p)
+				data = append(data, d)
+			}
+			if len(data) == 0 {
+				continue
 			}
+			combined := combineInputs(read, data)
+			hash := sha256.Sum256(combined)
+			if hash == lastHash {
+				continue
+			}
+			lastHash = hash
+			docs.Update(mainDocument, func(cur Document) (Document, bool) {
+				cur.Content, cur.Modified = combined, latestMod
+				return cur, true
+			})
 		}
 	}
 }
-
-func openBrowser(url string) error {
-	var cmd string
-	var args []string
-
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = "open"
-		args = []string{url}
-	case "linux":
-		cmd = "xdg-open"
-		args = []string{url}
-	case "windows":
-		cmd = "cmd"
-		args = []string{"/c", "start", strings.ReplaceAll(url, "&", "^&")}
-	default:
-		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
-	}
-
-	return exec.Command(cmd, args...).Start()
-}
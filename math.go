@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Math is written as $inline$, $$display$$ within a paragraph, or a block
+// fenced by $$ lines. Each .math element holds the formula as MathML (see
+// mathml.go) with the TeX as its annotation; builds that embed KaTeX load
+// it on first use and typeset that TeX in the page instead.
+//
+// As in Pandoc, an opening $ must be followed and a closing $ preceded by a
+// non-space, and a closing $ can't be followed by a digit, so prices like
+// "$5 and $10" stay text. \$ is a literal dollar sign.
+
+//go:generate sh katex/fetch.sh 0.16.11
+
+// katexFiles holds the KaTeX release fetched by katex/fetch.sh.
+//
+//go:embed all:katex
+var katexFiles embed.FS
+
+// KindMath and KindMathBlock are the node kinds of inline and block math.
+var (
+	KindMath      = ast.NewNodeKind("Math")
+	KindMathBlock = ast.NewNodeKind("MathBlock")
+)
+
+// A Math is $tex$, or $$tex$$ when Display is set.
+type Math struct {
+	ast.BaseInline
+	TeX     []byte
+	Display bool
+}
+
+// Kind implements ast.Node.
+func (n *Math) Kind() ast.NodeKind { return KindMath }
+
+// Dump implements ast.Node.
+func (n *Math) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": string(n.TeX)}, nil)
+}
+
+// A MathBlock is display math between $$ lines; its lines are the TeX.
+type MathBlock struct {
+	ast.BaseBlock
+
+	closed bool // by $$ on its opening line
+}
+
+// Kind implements ast.Node.
+func (n *MathBlock) Kind() ast.NodeKind { return KindMathBlock }
+
+// IsRaw implements ast.Node.
+func (n *MathBlock) IsRaw() bool { return true }
+
+// Dump implements ast.Node.
+func (n *MathBlock) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+type mathParser struct{}
+
+func (p *mathParser) Trigger() []byte { return []byte{'$'} }
+
+func (p *mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+	if delim == 1 && (len(line) < 2 || util.IsSpace(line[1])) {
+		return nil
+	}
+	for i := delim; i < len(line); i++ {
+		switch {
+		case line[i] == '\\':
+			i++ // \$ and \\ inside math
+		case line[i] != '$':
+		case delim == 2:
+			if i+1 < len(line) && line[i+1] == '$' && i > delim {
+				block.Advance(i + 2)
+				return &Math{TeX: append([]byte(nil), line[2:i]...), Display: true}
+			}
+		case util.IsSpace(line[i-1]) || i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9':
+			// Not a closing $.
+		default:
+			block.Advance(i + 1)
+			return &Math{TeX: append([]byte(nil), line[1:i]...)}
+		}
+	}
+	return nil
+}
+
+type mathBlockParser struct{}
+
+func (p *mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (p *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	node := &MathBlock{}
+	rest := segment.WithStart(segment.Start + pos + 2)
+	rest = rest.WithStop(rest.Start + lineLength(rest.Value(reader.Source())))
+	reader.Advance(segment.Len() - 1)
+	if tex := bytes.TrimSpace(rest.Value(reader.Source())); len(tex) > 2 && bytes.HasSuffix(tex, []byte("$$")) {
+		// $$ tex $$ on one line.
+		node.Lines().Append(rest.WithStop(rest.Start + bytes.LastIndex(rest.Value(reader.Source()), []byte("$$"))))
+		node.closed = true
+		return node, parser.NoChildren
+	}
+	if !util.IsBlank(rest.Value(reader.Source())) {
+		node.Lines().Append(rest)
+	}
+	return node, parser.NoChildren
+}
+
+func (p *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*MathBlock).closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	trimmed := bytes.TrimRight(line[:lineLength(line)], " \t")
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		if tex := trimmed[:len(trimmed)-2]; !util.IsBlank(tex) {
+			node.Lines().Append(segment.WithStop(segment.Start + len(tex)))
+		}
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (p *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *mathBlockParser) CanInterruptParagraph() bool { return true }
+
+func (p *mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMath, r.renderMath)
+	reg.Register(KindMathBlock, r.renderBlock)
+}
+
+func (r *mathRenderer) renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*Math)
+	class := "math math-inline"
+	if n.Display {
+		class = "math math-display"
+	}
+	w.WriteString(`<span class="` + class + `">`)
+	w.WriteString(texToMathML(string(n.TeX), n.Display))
+	w.WriteString("</span>")
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var tex bytes.Buffer
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		tex.Write(seg.Value(source))
+	}
+	w.WriteString(`<div class="math math-display">`)
+	w.WriteString(texToMathML(tex.String(), true))
+	w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+type mathExtension struct{}
+
+// MathExtension is a goldmark.Extender adding $ and $$ math.
+var MathExtension goldmark.Extender = &mathExtension{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 90)),
+		parser.WithInlineParsers(util.Prioritized(&mathParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&mathRenderer{}, 500),
+	))
+}
+
+// katexAvailable reports whether this build carries KaTeX; the page only
+// tries to typeset math when it does.
+func katexAvailable() bool {
+	_, err := fs.Stat(katexFiles, "katex/katex.min.js")
+	return err == nil
+}
+
+// handleKaTeX serves /katex/: the script, its stylesheet and fonts.
+func handleKaTeX(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/katex/")
+	if name != "katex.min.js" && name != "katex.min.css" && !(path.Dir(name) == "fonts" && path.Ext(name) == ".woff2") {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := katexFiles.ReadFile("katex/" + name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// The files change only with the binary.
+	w.Header().Set("Cache-Control", "max-age=86400")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
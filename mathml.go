@@ -0,0 +1,872 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Math is also converted to MathML on the server, which browsers typeset
+// natively, so it reads as math in exports, with JavaScript off and in
+// builds without KaTeX. The converter covers the LaTeX math people write
+// in notes: scripts and primes, fractions, roots, \left…\right, fonts,
+// accents, big operators, matrices, cases and aligned equations. Anything
+// it doesn't know is shown in place as an error rather than dropped.
+
+// texMaxDepth bounds nesting, so a page of braces can't exhaust the stack.
+const texMaxDepth = 60
+
+// texToMathML converts TeX to a <math> element carrying the source as its
+// annotation, which is what KaTeX typesets when the page has it.
+func texToMathML(tex string, display bool) string {
+	p := &texParser{src: []rune(tex)}
+	var b strings.Builder
+	b.WriteString("<math")
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString("><semantics>")
+	b.WriteString(p.top())
+	b.WriteString(`<annotation encoding="application/x-tex">`)
+	b.WriteString(html.EscapeString(tex))
+	b.WriteString("</annotation></semantics></math>")
+	return b.String()
+}
+
+type texParser struct {
+	src     []rune
+	pos     int
+	depth   int
+	variant string // \mathbf and friends, applied to letters and digits
+}
+
+// texAtom is one parsed item: its MathML, whether scripts go above and
+// below it (\sum, \lim, \overbrace) and what follows its scripts, like the
+// function application after \sin.
+type texAtom struct {
+	ml     string
+	limits bool
+	after  string
+}
+
+func (p *texParser) eof() bool {
+	p.skipSpace()
+	return p.pos >= len(p.src)
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns the next token without consuming it: a command such as
+// `\frac`, `\\` or `\{`, a single character, or "" at the end.
+func (p *texParser) peek() string {
+	tok, _ := p.scan()
+	return tok
+}
+
+func (p *texParser) next() string {
+	tok, end := p.scan()
+	p.pos = end
+	return tok
+}
+
+func (p *texParser) scan() (string, int) {
+	p.skipSpace()
+	i := p.pos
+	if i >= len(p.src) {
+		return "", i
+	}
+	if p.src[i] != '\\' {
+		return string(p.src[i]), i + 1
+	}
+	j := i + 1
+	for j < len(p.src) && isASCIILetter(p.src[j]) {
+		j++
+	}
+	if j == i+1 && j < len(p.src) {
+		j++
+	}
+	return string(p.src[i:j]), j
+}
+
+func isASCIILetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// top parses the whole input. Rows split by \\ outside an environment
+// are stacked, as KaTeX does in display mode.
+func (p *texParser) top() string {
+	rows := p.rows("")
+	for !p.eof() {
+		// A stray closing brace or \end: skip it and carry on.
+		p.next()
+		rows = append(rows, p.rows("")...)
+	}
+	if len(rows) == 1 && len(rows[0]) == 1 {
+		return rows[0][0]
+	}
+	return texTable(rows, "", nil)
+}
+
+// rows parses the body of an environment up to \end{env}, or the input's
+// end when env is empty: cells split by & and rows by \\.
+func (p *texParser) rows(env string) [][]string {
+	var rows [][]string
+	row := []string{}
+	for {
+		row = append(row, mrow(p.expr(nil)))
+		tok := p.peek()
+		switch tok {
+		case "&":
+			p.next()
+			continue
+		case `\\`, `\cr`, `\newline`:
+			p.next()
+			p.optional()
+			rows = append(rows, row)
+			row = []string{}
+			continue
+		}
+		if tok == `\end` && env != "" {
+			p.next()
+			p.rawArg()
+		}
+		// An empty last row, left by a trailing \\, isn't shown.
+		if len(rows) == 0 || len(row) > 1 || row[0] != "<mrow></mrow>" {
+			rows = append(rows, row)
+		}
+		return rows
+	}
+}
+
+// expr parses items up to a closing brace, a cell or row break, \end,
+// \right, the input's end, or a token for which stop reports true.
+func (p *texParser) expr(stop func(string) bool) []string {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > texMaxDepth {
+		p.pos = len(p.src)
+		return []string{texError("nested too deep")}
+	}
+	var out []string
+	for {
+		tok := p.peek()
+		switch tok {
+		case "", "}", "&", `\\`, `\cr`, `\newline`, `\end`, `\right`:
+			return out
+		}
+		if stop != nil && stop(tok) {
+			return out
+		}
+		switch tok {
+		case `\displaystyle`, `\textstyle`, `\scriptstyle`, `\scriptscriptstyle`:
+			// These apply to the rest of the group.
+			p.next()
+			rest := mrow(p.expr(stop))
+			out = append(out, `<mstyle `+texStyles[tok]+`>`+rest+`</mstyle>`)
+			return out
+		case `\color`:
+			p.next()
+			color := p.rawArg()
+			rest := mrow(p.expr(stop))
+			out = append(out, colored(color, rest))
+			return out
+		}
+		a := p.scripted()
+		if a.ml != "" {
+			out = append(out, a.ml)
+		}
+		if a.after != "" {
+			out = append(out, a.after)
+		}
+	}
+}
+
+// scripted parses an item with any ^, _ and primes after it.
+func (p *texParser) scripted() texAtom {
+	a := p.atom()
+	switch p.peek() {
+	case `\limits`:
+		p.next()
+		a.limits = true
+	case `\nolimits`:
+		p.next()
+		a.limits = false
+	}
+	var sub, sup, primes string
+scripts:
+	for {
+		switch p.peek() {
+		case "^":
+			p.next()
+			sup = p.arg()
+		case "_":
+			p.next()
+			sub = p.arg()
+		case "'":
+			p.next()
+			primes += "<mo>′</mo>"
+		default:
+			break scripts
+		}
+	}
+	if primes != "" {
+		if sup != "" {
+			sup = "<mrow>" + primes + sup + "</mrow>"
+		} else {
+			sup = primes
+			if strings.Count(primes, "<mo>") > 1 {
+				sup = "<mrow>" + primes + "</mrow>"
+			}
+		}
+	}
+	base := a.ml
+	switch {
+	case sub == "" && sup == "":
+	case a.limits && sub != "" && sup != "":
+		a.ml = "<munderover>" + base + sub + sup + "</munderover>"
+	case a.limits && sub != "":
+		a.ml = "<munder>" + base + sub + "</munder>"
+	case a.limits:
+		a.ml = "<mover>" + base + sup + "</mover>"
+	case sub != "" && sup != "":
+		a.ml = "<msubsup>" + base + sub + sup + "</msubsup>"
+	case sub != "":
+		a.ml = "<msub>" + base + sub + "</msub>"
+	default:
+		a.ml = "<msup>" + base + sup + "</msup>"
+	}
+	return a
+}
+
+// arg parses a command's argument or a script: a braced group, or else
+// a single item.
+func (p *texParser) arg() string {
+	switch p.peek() {
+	case "{":
+		p.next()
+		return p.group()
+	case "", "}", "&", `\\`, `\end`, `\right`:
+		return "<mrow></mrow>"
+	}
+	a := p.atom()
+	return a.ml + a.after
+}
+
+// group parses up to the closing brace, consuming it.
+func (p *texParser) group() string {
+	body := mrow(p.expr(nil))
+	if p.peek() == "}" {
+		p.next()
+	}
+	return body
+}
+
+// rawArg returns a braced argument's source, such as an environment name,
+// a color or \text's words.
+func (p *texParser) rawArg() string {
+	if p.peek() != "{" {
+		return ""
+	}
+	p.next()
+	start, depth := p.pos, 0
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				s := string(p.src[start:p.pos])
+				p.pos++
+				return s
+			}
+			depth--
+		}
+		p.pos++
+	}
+	if p.pos > len(p.src) {
+		p.pos = len(p.src)
+	}
+	return string(p.src[start:p.pos])
+}
+
+// optional parses a bracketed argument, like \sqrt's index, returning ""
+// when there is none.
+func (p *texParser) optional() string {
+	if p.peek() != "[" {
+		return ""
+	}
+	p.next()
+	body := mrow(p.expr(func(tok string) bool { return tok == "]" }))
+	if p.peek() == "]" {
+		p.next()
+	}
+	return body
+}
+
+func (p *texParser) atom() texAtom {
+	tok := p.next()
+	if tok == "" {
+		return texAtom{ml: "<mrow></mrow>"}
+	}
+	r := []rune(tok)[0]
+	switch {
+	case tok == "{":
+		return texAtom{ml: p.group()}
+	case r >= '0' && r <= '9' || r == '.' && p.pos < len(p.src) && unicode.IsDigit(p.src[p.pos]):
+		num := tok
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if unicode.IsDigit(c) || c == '.' && p.pos+1 < len(p.src) && unicode.IsDigit(p.src[p.pos+1]) {
+				num += string(c)
+				p.pos++
+				continue
+			}
+			break
+		}
+		return texAtom{ml: "<mn>" + p.styled(num) + "</mn>"}
+	case r == '\\':
+		return p.command(tok)
+	case unicode.IsLetter(r):
+		return texAtom{ml: p.ident(tok)}
+	case tok == "~":
+		return texAtom{ml: `<mspace width="0.333em"></mspace>`}
+	case tok == "^" || tok == "_":
+		// A script with nothing before it.
+		p.pos -= 1
+		return texAtom{ml: "<mrow></mrow>"}
+	}
+	if op, ok := texCharOps[tok]; ok {
+		return texAtom{ml: "<mo>" + op + "</mo>"}
+	}
+	return texAtom{ml: "<mo>" + html.EscapeString(tok) + "</mo>"}
+}
+
+// ident writes a letter in the current font.
+func (p *texParser) ident(s string) string {
+	if p.variant == "normal" {
+		return `<mi mathvariant="normal">` + html.EscapeString(s) + "</mi>"
+	}
+	return "<mi>" + p.styled(s) + "</mi>"
+}
+
+// styled maps letters and digits to the Unicode mathematical alphabet of
+// the current font, which is how MathML Core spells \mathbf and friends.
+func (p *texParser) styled(s string) string {
+	if p.variant == "" || p.variant == "normal" {
+		return html.EscapeString(s)
+	}
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteRune(mathAlphabet(r, p.variant))
+	}
+	return html.EscapeString(b.String())
+}
+
+func (p *texParser) command(tok string) texAtom {
+	name := tok[1:]
+	if s, ok := texIdents[name]; ok {
+		if r := []rune(s)[0]; unicode.IsUpper(r) && unicode.Is(unicode.Greek, r) {
+			// Capital Greek is upright, as in TeX.
+			return texAtom{ml: `<mi mathvariant="normal">` + s + "</mi>"}
+		}
+		return texAtom{ml: "<mi>" + s + "</mi>"}
+	}
+	if s, ok := texOps[name]; ok {
+		return texAtom{ml: "<mo>" + html.EscapeString(s) + "</mo>"}
+	}
+	if s, ok := texBigOps[name]; ok {
+		limits := name != "int" && name != "iint" && name != "iiint" && name != "oint"
+		if limits {
+			return texAtom{ml: `<mo movablelimits="true">` + s + "</mo>", limits: true}
+		}
+		return texAtom{ml: "<mo>" + s + "</mo>"}
+	}
+	if w, ok := texSpaces[name]; ok {
+		return texAtom{ml: `<mspace width="` + w + `"></mspace>`}
+	}
+	if texFunctions[name] {
+		return texAtom{ml: "<mi>" + name + "</mi>", after: "<mo>⁡</mo>"}
+	}
+	if s, ok := texLimitFunctions[name]; ok {
+		return texAtom{ml: `<mo movablelimits="true" lspace="0em" rspace="0.1667em">` + s + "</mo>", limits: true}
+	}
+	if v, ok := texFonts[name]; ok {
+		saved := p.variant
+		p.variant = v
+		body := p.arg()
+		p.variant = saved
+		return texAtom{ml: body}
+	}
+	if a, ok := texAccents[name]; ok {
+		base := p.arg()
+		if a.under {
+			return texAtom{ml: `<munder accentunder="true">` + base + `<mo stretchy="` + a.stretchy + `">` + a.mark + "</mo></munder>"}
+		}
+		return texAtom{ml: `<mover accent="true">` + base + `<mo stretchy="` + a.stretchy + `">` + a.mark + "</mo></mover>"}
+	}
+	if size, ok := texBigDelims[name]; ok {
+		d := p.delimiter()
+		return texAtom{ml: `<mo fence="false" stretchy="true" minsize="` + size + `" maxsize="` + size + `">` + d + "</mo>"}
+	}
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num := p.arg()
+		den := p.arg()
+		f := "<mfrac>" + num + den + "</mfrac>"
+		switch name {
+		case "dfrac", "cfrac":
+			f = `<mstyle displaystyle="true" scriptlevel="0">` + f + "</mstyle>"
+		case "tfrac":
+			f = `<mstyle displaystyle="false" scriptlevel="0">` + f + "</mstyle>"
+		}
+		return texAtom{ml: f}
+	case "binom", "dbinom", "tbinom":
+		n := p.arg()
+		k := p.arg()
+		return texAtom{ml: `<mrow><mo fence="true">(</mo><mfrac linethickness="0">` + n + k + `</mfrac><mo fence="true">)</mo></mrow>`}
+	case "sqrt":
+		index := p.optional()
+		body := p.arg()
+		if index != "" {
+			return texAtom{ml: "<mroot>" + body + index + "</mroot>"}
+		}
+		return texAtom{ml: "<msqrt>" + body + "</msqrt>"}
+	case "left":
+		open := p.delimiter()
+		body := p.expr(nil)
+		var close string
+		if p.peek() == `\right` {
+			p.next()
+			close = p.delimiter()
+		}
+		var b strings.Builder
+		b.WriteString("<mrow>")
+		if open != "" {
+			b.WriteString(`<mo fence="true" stretchy="true">` + open + "</mo>")
+		}
+		b.WriteString(mrow(body))
+		if close != "" {
+			b.WriteString(`<mo fence="true" stretchy="true">` + close + "</mo>")
+		}
+		b.WriteString("</mrow>")
+		return texAtom{ml: b.String()}
+	case "middle":
+		return texAtom{ml: `<mo stretchy="true">` + p.delimiter() + "</mo>"}
+	case "text", "textrm", "textnormal", "mbox", "hbox", "textup", "textit", "textbf", "texttt":
+		return texAtom{ml: "<mtext>" + texText(p.rawArg()) + "</mtext>"}
+	case "operatorname":
+		if p.pos < len(p.src) && p.src[p.pos] == '*' {
+			p.pos++
+			return texAtom{ml: `<mo movablelimits="true" lspace="0em" rspace="0.1667em">` + texText(p.rawArg()) + "</mo>", limits: true}
+		}
+		return texAtom{ml: "<mi>" + texText(p.rawArg()) + "</mi>", after: "<mo>⁡</mo>"}
+	case "mathop":
+		return texAtom{ml: p.arg(), limits: true}
+	case "overset", "stackrel":
+		over := p.arg()
+		base := p.arg()
+		return texAtom{ml: "<mover>" + base + over + "</mover>"}
+	case "underset":
+		under := p.arg()
+		base := p.arg()
+		return texAtom{ml: "<munder>" + base + under + "</munder>"}
+	case "overbrace":
+		return texAtom{ml: `<mover>` + p.arg() + `<mo stretchy="true">⏞</mo></mover>`, limits: true}
+	case "underbrace":
+		return texAtom{ml: `<munder>` + p.arg() + `<mo stretchy="true">⏟</mo></munder>`, limits: true}
+	case "xrightarrow", "xleftarrow":
+		under := p.optional()
+		over := p.arg()
+		arrow := `<mo stretchy="true" minsize="1.5em">→</mo>`
+		if name == "xleftarrow" {
+			arrow = `<mo stretchy="true" minsize="1.5em">←</mo>`
+		}
+		if under != "" {
+			return texAtom{ml: "<munderover>" + arrow + under + over + "</munderover>"}
+		}
+		return texAtom{ml: "<mover>" + arrow + over + "</mover>"}
+	case "not":
+		return texAtom{ml: strings.Replace(p.arg(), "</mo>", "̸</mo>", 1)}
+	case "textcolor":
+		color := p.rawArg()
+		return texAtom{ml: colored(color, p.arg())}
+	case "boxed", "fbox":
+		return texAtom{ml: `<mrow class="tex-boxed">` + p.arg() + "</mrow>"}
+	case "phantom":
+		return texAtom{ml: "<mphantom>" + p.arg() + "</mphantom>"}
+	case "pmod":
+		return texAtom{ml: `<mrow><mspace width="0.4444em"></mspace><mo>(</mo><mi>mod</mi><mspace width="0.3333em"></mspace>` + p.arg() + "<mo>)</mo></mrow>"}
+	case "bmod", "mod":
+		return texAtom{ml: `<mo lspace="0.2222em" rspace="0.2222em">mod</mo>`}
+	case "substack":
+		var rows [][]string
+		if p.peek() == "{" {
+			p.next()
+			rows = p.rows("")
+			if p.peek() == "}" {
+				p.next()
+			}
+		}
+		return texAtom{ml: texTable(rows, "", nil)}
+	case "tag":
+		return texAtom{ml: `<mspace width="2em"></mspace><mtext>(` + texText(p.rawArg()) + ")</mtext>"}
+	case "label":
+		p.rawArg()
+		return texAtom{}
+	case "nonumber", "notag", "hline", "strut", "mathstrut", "limits", "nolimits", "relax":
+		return texAtom{}
+	case "begin":
+		return texAtom{ml: p.environment(p.rawArg())}
+	}
+	return texAtom{ml: texError(tok)}
+}
+
+// delimiter reads the delimiter after \left, \right or \big; "." is none.
+func (p *texParser) delimiter() string {
+	tok := p.next()
+	if tok == "." || tok == "" {
+		return ""
+	}
+	if tok[0] == '\\' {
+		if d, ok := texOps[tok[1:]]; ok {
+			return html.EscapeString(d)
+		}
+		return texError(tok)
+	}
+	return html.EscapeString(tok)
+}
+
+// texEnvironments maps the environments to their fences and the side
+// each column is aligned to.
+var texEnvironments = map[string]struct {
+	open, close string
+	align       string // repeated over the columns
+}{
+	"matrix":      {"", "", "c"},
+	"smallmatrix": {"", "", "c"},
+	"pmatrix":     {"(", ")", "c"},
+	"bmatrix":     {"[", "]", "c"},
+	"Bmatrix":     {"{", "}", "c"},
+	"vmatrix":     {"|", "|", "c"},
+	"Vmatrix":     {"‖", "‖", "c"},
+	"cases":       {"{", "", "l"},
+	"aligned":     {"", "", "rl"},
+	"align":       {"", "", "rl"},
+	"align*":      {"", "", "rl"},
+	"alignat":     {"", "", "rl"},
+	"alignat*":    {"", "", "rl"},
+	"split":       {"", "", "rl"},
+	"eqnarray":    {"", "", "rcl"},
+	"eqnarray*":   {"", "", "rcl"},
+	"gathered":    {"", "", "c"},
+	"gather":      {"", "", "c"},
+	"gather*":     {"", "", "c"},
+	"equation":    {"", "", "c"},
+	"equation*":   {"", "", "c"},
+	"array":       {"", "", "c"},
+}
+
+func (p *texParser) environment(name string) string {
+	env, ok := texEnvironments[name]
+	if !ok {
+		// Parse it anyway so the rest of the formula still shows.
+		p.rows(name)
+		return texError(`\begin{` + name + `}`)
+	}
+	align := []byte(env.align)
+	switch name {
+	case "array":
+		align = align[:0]
+		for _, c := range p.rawArg() {
+			if c == 'l' || c == 'c' || c == 'r' {
+				align = append(align, byte(c))
+			}
+		}
+	case "alignat", "alignat*":
+		p.rawArg()
+	}
+	rows := p.rows(name)
+	if len(align) > 1 && align[0] == 'r' {
+		// In aligned columns a relation after & keeps its spacing.
+		for _, row := range rows {
+			for i := 1; i < len(row); i += 2 {
+				row[i] = "<mrow><mi></mi>" + row[i] + "</mrow>"
+			}
+		}
+	}
+	class := ""
+	if name == "smallmatrix" {
+		class = "tex-small"
+	}
+	table := texTable(rows, class, align)
+	if env.open == "" && env.close == "" {
+		return table
+	}
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if env.open != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + html.EscapeString(env.open) + "</mo>")
+	}
+	b.WriteString(table)
+	if env.close != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + html.EscapeString(env.close) + "</mo>")
+	}
+	b.WriteString("</mrow>")
+	return b.String()
+}
+
+// texTable writes rows as an mtable; align gives each column's side
+// (l, c or r), repeating when there are more columns. Left and right are
+// classes styled in style.css, since MathML Core has no columnalign.
+func texTable(rows [][]string, class string, align []byte) string {
+	var b strings.Builder
+	b.WriteString("<mtable")
+	if class != "" {
+		b.WriteString(` class="` + class + `"`)
+	}
+	b.WriteString(">")
+	for _, row := range rows {
+		b.WriteString("<mtr>")
+		for i, cell := range row {
+			b.WriteString("<mtd")
+			if len(align) > 0 {
+				switch align[i%len(align)] {
+				case 'l':
+					b.WriteString(` class="tex-l"`)
+				case 'r':
+					b.WriteString(` class="tex-r"`)
+				}
+			}
+			b.WriteString(">" + cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+	return b.String()
+}
+
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+func texError(src string) string {
+	return "<merror><mtext>" + html.EscapeString(src) + "</mtext></merror>"
+}
+
+// colored wraps ml in a color, which is kept only when it is a plain
+// name or hex value.
+func colored(color, ml string) string {
+	for _, c := range color {
+		if !isASCIILetter(c) && !(c >= '0' && c <= '9') && c != '#' {
+			return ml
+		}
+	}
+	if color == "" {
+		return ml
+	}
+	return `<mstyle mathcolor="` + color + `">` + ml + "</mstyle>"
+}
+
+// texText unescapes the text of \text and \operatorname.
+func texText(s string) string {
+	var b strings.Builder
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		switch r := rs[i]; {
+		case r == '\\' && i+1 < len(rs) && strings.ContainsRune(`$%&#_{} \`, rs[i+1]):
+			i++
+			b.WriteRune(rs[i])
+		case r == '~':
+			b.WriteRune(' ')
+		case r == '{' || r == '}':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return html.EscapeString(b.String())
+}
+
+var texStyles = map[string]string{
+	`\displaystyle`:      `displaystyle="true" scriptlevel="0"`,
+	`\textstyle`:         `displaystyle="false" scriptlevel="0"`,
+	`\scriptstyle`:       `displaystyle="false" scriptlevel="1"`,
+	`\scriptscriptstyle`: `displaystyle="false" scriptlevel="2"`,
+}
+
+// texCharOps respells the characters that TeX typesets differently.
+var texCharOps = map[string]string{
+	"-": "−",
+	"*": "∗",
+	"<": "&lt;",
+	">": "&gt;",
+}
+
+// texIdents are the commands for letters and symbols that typeset as
+// identifiers.
+var texIdents = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "varkappa": "ϰ", "lambda": "λ", "mu": "μ",
+	"nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+	"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ",
+	"Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ",
+	"emptyset": "∅", "varnothing": "∅", "aleph": "ℵ", "beth": "ℶ", "Re": "ℜ",
+	"Im": "ℑ", "wp": "℘", "imath": "ı", "jmath": "ȷ", "top": "⊤", "bot": "⊥",
+	"angle": "∠", "triangle": "△", "Box": "□", "square": "□", "prime": "′",
+	"infinity": "∞", "complement": "∁", "eth": "ð", "mho": "℧",
+}
+
+// texOps are the commands for operators, relations, arrows, punctuation
+// and delimiters.
+var texOps = map[string]string{
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗",
+	"star": "⋆", "circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖",
+	"otimes": "⊗", "oslash": "⊘", "odot": "⊙", "cap": "∩", "cup": "∪",
+	"setminus": "∖", "smallsetminus": "∖", "wedge": "∧", "land": "∧", "vee": "∨",
+	"lor": "∨", "sqcup": "⊔", "sqcap": "⊓", "uplus": "⊎", "dagger": "†",
+	"ddagger": "‡", "amalg": "⨿", "wr": "≀", "diamond": "⋄", "bigtriangleup": "△",
+	"bigtriangledown": "▽", "forall": "∀", "exists": "∃", "nexists": "∄",
+	"neg": "¬", "lnot": "¬", "ldots": "…", "dots": "…", "cdots": "⋯",
+	"dotsb": "⋯", "dotsc": "…", "vdots": "⋮", "ddots": "⋱", "colon": ":",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠",
+	"equiv": "≡", "approx": "≈", "sim": "∼", "simeq": "≃", "cong": "≅",
+	"propto": "∝", "ll": "≪", "gg": "≫", "subset": "⊂", "supset": "⊃",
+	"subseteq": "⊆", "supseteq": "⊇", "subsetneq": "⊊", "supsetneq": "⊋",
+	"in": "∈", "notin": "∉", "ni": "∋", "mid": "∣", "nmid": "∤",
+	"parallel": "∥", "nparallel": "∦", "perp": "⊥", "vdash": "⊢", "dashv": "⊣",
+	"models": "⊨", "prec": "≺", "succ": "≻", "preceq": "⪯", "succeq": "⪰",
+	"doteq": "≐", "asymp": "≍", "leqslant": "⩽", "geqslant": "⩾",
+	"coloneqq": "≔", "triangleq": "≜", "nleq": "≰", "ngeq": "≱",
+	"nsubseteq": "⊈", "sqsubseteq": "⊑", "sqsupseteq": "⊒", "lesssim": "≲",
+	"gtrsim": "≳", "approxeq": "≊", "bowtie": "⋈", "therefore": "∴",
+	"because": "∵", "to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "implies": "⟹", "impliedby": "⟸", "iff": "⟺",
+	"mapsto": "↦", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"longleftrightarrow": "⟷", "Longrightarrow": "⟹", "Longleftarrow": "⟸",
+	"Longleftrightarrow": "⟺", "longmapsto": "⟼", "uparrow": "↑",
+	"downarrow": "↓", "updownarrow": "↕", "Uparrow": "⇑", "Downarrow": "⇓",
+	"Updownarrow": "⇕", "hookrightarrow": "↪", "hookleftarrow": "↩",
+	"rightharpoonup": "⇀", "leftharpoonup": "↼", "rightleftharpoons": "⇌",
+	"nearrow": "↗", "searrow": "↘", "swarrow": "↙", "nwarrow": "↖",
+	"leadsto": "⇝", "circlearrowleft": "↺", "circlearrowright": "↻",
+	"{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "lbrack": "[", "rbrack": "]",
+	"langle": "⟨", "rangle": "⟩", "lvert": "|", "rvert": "|", "vert": "|",
+	"|": "‖", "Vert": "‖", "lVert": "‖", "rVert": "‖", "lfloor": "⌊",
+	"rfloor": "⌋", "lceil": "⌈", "rceil": "⌉", "backslash": "∖",
+	"%": "%", "$": "$", "&": "&", "#": "#", "_": "_",
+}
+
+// texBigOps are the large operators; all but the integrals take limits.
+var texBigOps = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂",
+	"bigoplus": "⨁", "bigotimes": "⨂", "bigodot": "⨀", "bigvee": "⋁",
+	"bigwedge": "⋀", "bigsqcup": "⨆", "biguplus": "⨄",
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+}
+
+// texFunctions are the upright function names; texLimitFunctions also take
+// limits below them in display math.
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true,
+	"tanh": true, "coth": true, "log": true, "ln": true, "lg": true, "exp": true,
+	"deg": true, "dim": true, "hom": true, "ker": true, "arg": true,
+}
+
+var texLimitFunctions = map[string]string{
+	"lim": "lim", "liminf": "lim inf", "limsup": "lim sup", "max": "max",
+	"min": "min", "sup": "sup", "inf": "inf", "det": "det", "gcd": "gcd",
+	"Pr": "Pr", "argmax": "arg max", "argmin": "arg min",
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", "thinspace": "0.1667em", ":": "0.2222em", ">": "0.2222em",
+	"medspace": "0.2222em", ";": "0.2778em", "thickspace": "0.2778em",
+	"!": "-0.1667em", "negthinspace": "-0.1667em", " ": "0.333em",
+	"quad": "1em", "qquad": "2em", "enspace": "0.5em",
+}
+
+// texFonts maps the font commands to the alphabets of mathAlphabet.
+var texFonts = map[string]string{
+	"mathrm": "normal", "textrm": "normal", "mathup": "normal", "mathit": "italic",
+	"mathbf": "bold", "boldsymbol": "bold-italic", "bm": "bold-italic",
+	"mathbb": "double-struck", "mathcal": "script", "mathscr": "script",
+	"mathfrak": "fraktur", "mathsf": "sans-serif", "mathtt": "monospace",
+}
+
+var texAccents = map[string]struct {
+	mark, stretchy string
+	under          bool
+}{
+	"hat": {"^", "false", false}, "widehat": {"^", "true", false},
+	"check": {"ˇ", "false", false}, "tilde": {"~", "false", false},
+	"widetilde": {"~", "true", false}, "acute": {"´", "false", false},
+	"grave": {"`", "false", false}, "dot": {"˙", "false", false},
+	"ddot": {"¨", "false", false}, "breve": {"˘", "false", false},
+	"bar": {"¯", "false", false}, "vec": {"→", "false", false},
+	"overline": {"‾", "true", false}, "overrightarrow": {"→", "true", false},
+	"overleftarrow": {"←", "true", false}, "underline": {"_", "true", true},
+}
+
+var texBigDelims = map[string]string{
+	"big": "1.2em", "bigl": "1.2em", "bigr": "1.2em", "bigm": "1.2em",
+	"Big": "1.8em", "Bigl": "1.8em", "Bigr": "1.8em", "Bigm": "1.8em",
+	"bigg": "2.4em", "biggl": "2.4em", "biggr": "2.4em", "biggm": "2.4em",
+	"Bigg": "3em", "Biggl": "3em", "Biggr": "3em", "Biggm": "3em",
+}
+
+// mathAlphabet maps an ASCII letter or digit to its character in one of the
+// Unicode mathematical alphabets. The holes in those blocks are letters that
+// were encoded earlier, like ℝ and ℋ.
+func mathAlphabet(r rune, variant string) rune {
+	if s, ok := mathAlphabetHoles[variant][r]; ok {
+		return s
+	}
+	a, ok := mathAlphabets[variant]
+	if !ok {
+		return r
+	}
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return a.upper + r - 'A'
+	case r >= 'a' && r <= 'z':
+		return a.upper + 26 + r - 'a'
+	case r >= '0' && r <= '9' && a.digits != 0:
+		return a.digits + r - '0'
+	}
+	return r
+}
+
+var mathAlphabets = map[string]struct{ upper, digits rune }{
+	"bold":          {0x1D400, 0x1D7CE},
+	"italic":        {0x1D434, 0},
+	"bold-italic":   {0x1D468, 0x1D7CE},
+	"script":        {0x1D49C, 0},
+	"fraktur":       {0x1D504, 0},
+	"double-struck": {0x1D538, 0x1D7D8},
+	"sans-serif":    {0x1D5A0, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D7F6},
+}
+
+var mathAlphabetHoles = map[string]map[rune]rune{
+	"italic": {'h': 'ℎ'},
+	"script": {
+		'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ',
+		'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ',
+	},
+	"fraktur":       {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// --on-change 'make docs' runs a command each time the watched files
+// change, once they have been quiet for --debounce and before they are
+// read again, so a document generated from other sources is rebuilt and
+// previewed in one loop. --watch adds those sources (files or globs,
+// repeatable) to what is watched. What the command writes is taken as the
+// new state of the files rather than another change, so it doesn't run
+// itself in circles.
+
+var (
+	onChangeCommand string   // --on-change
+	extraWatches    []string // --watch, absolute
+)
+
+// watchFlag is a repeatable --watch, a file or glob adding to extraWatches.
+type watchFlag struct{}
+
+func (watchFlag) String() string { return "" }
+
+func (watchFlag) Set(v string) error {
+	matches, err := filepath.Glob(v)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no files match %q", v)
+	}
+	for _, m := range matches {
+		if isDirectory(m) {
+			continue
+		}
+		abs, err := filepath.Abs(m)
+		if err != nil {
+			return err
+		}
+		extraWatches = append(extraWatches, abs)
+	}
+	return nil
+}
+
+// shellCommand returns a command running line through the system shell.
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+	return exec.Command("sh", "-c", line)
+}
+
+// runOnChange runs --on-change, reporting a failure in the terminal and on
+// open pages; the files are read again either way.
+func runOnChange() {
+	fmt.Fprintf(os.Stderr, "mdview: running %s\n", onChangeCommand)
+	cmd := shellCommand(onChangeCommand)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "mdview: %s: %v\n", onChangeCommand, err)
+		bus.Publish(Event{Type: EventToast, Data: onChangeCommand + " failed (" + err.Error() + ")"})
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v3"
+)
+
+// ```openapi fences hold an OpenAPI 3 or Swagger 2 spec (YAML or JSON),
+// inline or from a file with ```openapi src=api.yaml, and render as an API
+// reference: collapsible operations with their parameters, request body
+// and responses, followed by the schemas. It is all server-side HTML, so it
+// works offline and prints.
+
+type oaSpec struct {
+	OpenAPI string `yaml:"openapi"`
+	Swagger string `yaml:"swagger"`
+	Info    struct {
+		Title       string `yaml:"title"`
+		Version     string `yaml:"version"`
+		Description string `yaml:"description"`
+	} `yaml:"info"`
+	Paths      yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas yaml.Node `yaml:"schemas"`
+	} `yaml:"components"`
+	Definitions yaml.Node `yaml:"definitions"` // Swagger 2
+}
+
+type oaOperation struct {
+	Summary     string        `yaml:"summary"`
+	Description string        `yaml:"description"`
+	OperationID string        `yaml:"operationId"`
+	Deprecated  bool          `yaml:"deprecated"`
+	Parameters  []oaParameter `yaml:"parameters"`
+	RequestBody *struct {
+		Description string    `yaml:"description"`
+		Required    bool      `yaml:"required"`
+		Content     yaml.Node `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses yaml.Node `yaml:"responses"`
+}
+
+type oaParameter struct {
+	Ref         string    `yaml:"$ref"`
+	Name        string    `yaml:"name"`
+	In          string    `yaml:"in"`
+	Description string    `yaml:"description"`
+	Required    bool      `yaml:"required"`
+	Schema      *oaSchema `yaml:"schema"`
+	Type        string    `yaml:"type"` // Swagger 2 non-body parameters
+}
+
+type oaResponse struct {
+	Ref         string    `yaml:"$ref"`
+	Description string    `yaml:"description"`
+	Content     yaml.Node `yaml:"content"`
+	Schema      *oaSchema `yaml:"schema"` // Swagger 2
+}
+
+type oaSchema struct {
+	Ref         string        `yaml:"$ref"`
+	Type        interface{}   `yaml:"type"` // a string, or a list in 3.1
+	Format      string        `yaml:"format"`
+	Description string        `yaml:"description"`
+	Items       *oaSchema     `yaml:"items"`
+	Properties  yaml.Node     `yaml:"properties"`
+	Required    []string      `yaml:"required"`
+	Enum        []interface{} `yaml:"enum"`
+	AllOf       []*oaSchema   `yaml:"allOf"`
+	OneOf       []*oaSchema   `yaml:"oneOf"`
+	AnyOf       []*oaSchema   `yaml:"anyOf"`
+}
+
+var oaMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// mapPairs returns the key/value nodes of a YAML mapping, in source order.
+func mapPairs(n *yaml.Node) [][2]*yaml.Node {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	var pairs [][2]*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{n.Content[i], n.Content[i+1]})
+	}
+	return pairs
+}
+
+// openAPIState is a spec read at parse time, or why it couldn't be.
+type openAPIState struct {
+	spec   []byte
+	err    error
+	inline bool
+}
+
+// openAPIBlock renders ```openapi fences.
+type openAPIBlock struct{}
+
+func init() {
+	registerBlock("openapi", []string{"openapi"}, true, openAPIBlock{})
+}
+
+func (openAPIBlock) Prepare(fcb *ast.FencedCodeBlock, lang string, code []byte) interface{} {
+	v, ok := fcb.AttributeString("src")
+	if !ok {
+		return &openAPIState{spec: code, inline: true}
+	}
+	// Like linked documents, only files under the served directory.
+	st := &openAPIState{}
+	if fsys, rel, _, ok := siteFile("/" + string(attrValue(v))); ok {
+		st.spec, st.err = fs.ReadFile(fsys, rel)
+	} else {
+		st.err = fmt.Errorf("%s is outside the served directory", attrValue(v))
+	}
+	return st
+}
+
+func (openAPIBlock) Render(w util.BufWriter, state interface{}, entering bool) ast.WalkStatus {
+	if !entering {
+		return ast.WalkContinue
+	}
+	st := state.(*openAPIState)
+	var spec oaSpec
+	err := st.err
+	if err == nil {
+		err = yaml.Unmarshal(st.spec, &spec)
+	}
+	if err == nil && spec.OpenAPI == "" && spec.Swagger == "" {
+		err = fmt.Errorf("no openapi or swagger version field")
+	}
+	if err != nil {
+		fmt.Fprintf(w, "<div class=\"openapi-error\">Invalid OpenAPI spec: %s</div>\n", html.EscapeString(err.Error()))
+		// An inline spec is shown as code below; a file's is not.
+		if st.inline {
+			return ast.WalkContinue
+		}
+		return ast.WalkSkipChildren
+	}
+
+	w.WriteString("<section class=\"openapi\">\n<div class=\"openapi-info\">")
+	fmt.Fprintf(w, "<strong>%s</strong>", html.EscapeString(spec.Info.Title))
+	if spec.Info.Version != "" {
+		fmt.Fprintf(w, " <span class=\"openapi-version\">%s</span>", html.EscapeString(spec.Info.Version))
+	}
+	if spec.Info.Description != "" {
+		fmt.Fprintf(w, "<p>%s</p>", html.EscapeString(spec.Info.Description))
+	}
+	w.WriteString("</div>\n")
+
+	for _, p := range mapPairs(&spec.Paths) {
+		path, item := p[0].Value, p[1]
+		var shared []oaParameter
+		for _, f := range mapPairs(item) {
+			if f[0].Value == "parameters" {
+				f[1].Decode(&shared)
+			}
+		}
+		for _, f := range mapPairs(item) {
+			method := strings.ToLower(f[0].Value)
+			if !isHTTPMethod(method) {
+				continue
+			}
+			var op oaOperation
+			if err := f[1].Decode(&op); err != nil {
+				continue
+			}
+			op.Parameters = append(shared, op.Parameters...)
+			writeOperation(w, method, path, &op)
+		}
+	}
+
+	schemas := &spec.Components.Schemas
+	if spec.Swagger != "" {
+		schemas = &spec.Definitions
+	}
+	if pairs := mapPairs(schemas); len(pairs) > 0 {
+		w.WriteString("<div class=\"openapi-schemas\"><strong>Schemas</strong>\n")
+		for _, p := range pairs {
+			var s oaSchema
+			if p[1].Decode(&s) != nil {
+				continue
+			}
+			fmt.Fprintf(w, "<details class=\"openapi-schema\" id=\"schema-%s\"><summary><code>%s</code> <span class=\"openapi-type\">%s</span></summary>\n",
+				html.EscapeString(p[0].Value), html.EscapeString(p[0].Value), schemaLabel(&s))
+			writeSchemaBody(w, &s)
+			w.WriteString("</details>\n")
+		}
+		w.WriteString("</div>\n")
+	}
+	w.WriteString("</section>\n")
+	return ast.WalkSkipChildren
+}
+
+func isHTTPMethod(m string) bool {
+	for _, x := range oaMethods {
+		if m == x {
+			return true
+		}
+	}
+	return false
+}
+
+func writeOperation(w util.BufWriter, method, path string, op *oaOperation) {
+	class := "openapi-op"
+	if op.Deprecated {
+		class += " deprecated"
+	}
+	fmt.Fprintf(w, "<details class=\"%s\"><summary><span class=\"openapi-method %s\">%s</span> <code>%s</code> %s</summary>\n",
+		class, method, strings.ToUpper(method), html.EscapeString(path), html.EscapeString(op.Summary))
+	if op.Description != "" {
+		fmt.Fprintf(w, "<p>%s</p>\n", html.EscapeString(op.Description))
+	}
+
+	var params []oaParameter
+	var body *oaSchema
+	for _, p := range op.Parameters {
+		if p.In == "body" { // Swagger 2
+			body = p.Schema
+			continue
+		}
+		params = append(params, p)
+	}
+	if len(params) > 0 {
+		w.WriteString("<table><thead><tr><th>Parameter</th><th>In</th><th>Type</th><th>Description</th></tr></thead><tbody>\n")
+		for _, p := range params {
+			if p.Ref != "" {
+				fmt.Fprintf(w, "<tr><td colspan=\"4\"><code>%s</code></td></tr>\n", html.EscapeString(p.Ref))
+				continue
+			}
+			typ := html.EscapeString(p.Type)
+			if p.Schema != nil {
+				typ = schemaLabel(p.Schema)
+			}
+			name := "<code>" + html.EscapeString(p.Name) + "</code>"
+			if p.Required {
+				name += ` <span class="openapi-required" title="required">*</span>`
+			}
+			fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+				name, html.EscapeString(p.In), typ, html.EscapeString(p.Description))
+		}
+		w.WriteString("</tbody></table>\n")
+	}
+
+	if op.RequestBody != nil {
+		w.WriteString("<p><strong>Request body</strong>")
+		if op.RequestBody.Required {
+			w.WriteString(` <span class="openapi-required" title="required">*</span>`)
+		}
+		if op.RequestBody.Description != "" {
+			w.WriteString(" — " + html.EscapeString(op.RequestBody.Description))
+		}
+		w.WriteString("</p>\n")
+		writeContent(w, &op.RequestBody.Content)
+	} else if body != nil {
+		fmt.Fprintf(w, "<p><strong>Request body</strong> %s</p>\n", schemaLabel(body))
+	}
+
+	if pairs := mapPairs(&op.Responses); len(pairs) > 0 {
+		w.WriteString("<table><thead><tr><th>Response</th><th>Description</th><th>Body</th></tr></thead><tbody>\n")
+		for _, p := range pairs {
+			var resp oaResponse
+			p[1].Decode(&resp)
+			desc := html.EscapeString(resp.Description)
+			if resp.Ref != "" {
+				desc = "<code>" + html.EscapeString(resp.Ref) + "</code>"
+			}
+			var bodies []string
+			if resp.Schema != nil {
+				bodies = append(bodies, schemaLabel(resp.Schema))
+			}
+			for _, c := range mapPairs(&resp.Content) {
+				var media struct {
+					Schema *oaSchema `yaml:"schema"`
+				}
+				c[1].Decode(&media)
+				label := "<code>" + html.EscapeString(c[0].Value) + "</code>"
+				if media.Schema != nil {
+					label += " " + schemaLabel(media.Schema)
+				}
+				bodies = append(bodies, label)
+			}
+			fmt.Fprintf(w, "<tr><td><code class=\"openapi-status s%c\">%s</code></td><td>%s</td><td>%s</td></tr>\n",
+				firstByte(p[0].Value), html.EscapeString(p[0].Value), desc, strings.Join(bodies, "<br>"))
+		}
+		w.WriteString("</tbody></table>\n")
+	}
+	w.WriteString("</details>\n")
+}
+
+func firstByte(s string) byte {
+	if s == "" {
+		return 'x'
+	}
+	return s[0]
+}
+
+// writeContent lists the media types of a request body with their schemas.
+func writeContent(w util.BufWriter, content *yaml.Node) {
+	for _, c := range mapPairs(content) {
+		var media struct {
+			Schema *oaSchema `yaml:"schema"`
+		}
+		c[1].Decode(&media)
+		fmt.Fprintf(w, "<p><code>%s</code>", html.EscapeString(c[0].Value))
+		if media.Schema != nil {
+			w.WriteString(" " + schemaLabel(media.Schema))
+		}
+		w.WriteString("</p>\n")
+		if media.Schema != nil && media.Schema.Ref == "" {
+			writeSchemaBody(w, media.Schema)
+		}
+	}
+}
+
+// schemaLabel returns a short HTML description of s: a link for a $ref,
+// "array of X", or the type and format.
+func schemaLabel(s *oaSchema) string {
+	switch {
+	case s.Ref != "":
+		name := s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+		if strings.HasPrefix(s.Ref, "#/") {
+			return fmt.Sprintf(`<a href="#schema-%s">%s</a>`, html.EscapeString(name), html.EscapeString(name))
+		}
+		return "<code>" + html.EscapeString(s.Ref) + "</code>"
+	case s.Items != nil:
+		return "array of " + schemaLabel(s.Items)
+	}
+	for _, alt := range []struct {
+		word    string
+		schemas []*oaSchema
+	}{{" & ", s.AllOf}, {" | ", s.OneOf}, {" | ", s.AnyOf}} {
+		if len(alt.schemas) > 0 {
+			labels := make([]string, len(alt.schemas))
+			for i, x := range alt.schemas {
+				labels[i] = schemaLabel(x)
+			}
+			return strings.Join(labels, alt.word)
+		}
+	}
+	typ := fmt.Sprint(s.Type)
+	if s.Type == nil {
+		typ = "object"
+	} else if types, ok := s.Type.([]interface{}); ok {
+		parts := make([]string, len(types))
+		for i, t := range types {
+			parts[i] = fmt.Sprint(t)
+		}
+		typ = strings.Join(parts, " | ")
+	}
+	if s.Format != "" {
+		typ += " (" + s.Format + ")"
+	}
+	if len(s.Enum) > 0 {
+		vals := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			vals[i] = fmt.Sprint(v)
+		}
+		typ += ": " + strings.Join(vals, ", ")
+	}
+	return html.EscapeString(typ)
+}
+
+// writeSchemaBody writes the description and property table of s.
+func writeSchemaBody(w util.BufWriter, s *oaSchema) {
+	if s.Description != "" {
+		fmt.Fprintf(w, "<p>%s</p>\n", html.EscapeString(s.Description))
+	}
+	pairs := mapPairs(&s.Properties)
+	if len(pairs) == 0 {
+		return
+	}
+	required := make(map[string]bool)
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	w.WriteString("<table><thead><tr><th>Property</th><th>Type</th><th>Description</th></tr></thead><tbody>\n")
+	for _, p := range pairs {
+		var prop oaSchema
+		p[1].Decode(&prop)
+		name := "<code>" + html.EscapeString(p[0].Value) + "</code>"
+		if required[p[0].Value] {
+			name += ` <span class="openapi-required" title="required">*</span>`
+		}
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n", name, schemaLabel(&prop), html.EscapeString(prop.Description))
+	}
+	w.WriteString("</tbody></table>\n")
+}
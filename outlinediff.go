@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// `mdview outline-diff old.md new.md` compares the headings of two
+// versions of a document, for review bots that flag a changed API or
+// policy outline. Headings are matched by level and text, wherever they
+// moved; of the rest, an old and a new heading at the same level are a
+// rename when their sections mostly say the same thing. A removed or
+// renamed heading breaks links to its anchor, so either makes the command
+// exit with status 1.
+
+// An outlineHeading is one heading of a document.
+type outlineHeading struct {
+	Level int             `json:"level"`
+	Text  string          `json:"text"`
+	ID    string          `json:"id"`
+	Line  int             `json:"line"`
+	words map[string]bool // of its section, up to the next heading
+}
+
+// An outlineChange is a renamed heading.
+type outlineChange struct {
+	Old outlineHeading `json:"old"`
+	New outlineHeading `json:"new"`
+}
+
+// An outlineDiff is the result of comparing two outlines.
+type outlineDiff struct {
+	Added   []outlineHeading `json:"added"`
+	Removed []outlineHeading `json:"removed"`
+	Renamed []outlineChange  `json:"renamed"`
+}
+
+// renameSimilarity is how alike two sections' words must be for their
+// headings to count as one renamed.
+const renameSimilarity = 0.5
+
+// runOutlineDiff implements `mdview outline-diff`.
+func runOutlineDiff(argv []string) error {
+	fs := flag.NewFlagSet("mdview outline-diff", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the changes as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdview outline-diff [options] old.md new.md\n\n")
+		fmt.Fprintf(os.Stderr, "Lists the headings added, removed and renamed between two versions of\n")
+		fmt.Fprintf(os.Stderr, "a document. Exits with status 1 when any were removed or renamed.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	files := parseCommandFlags(fs, argv)
+	if len(files) != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var outlines [2][]outlineHeading
+	for i, path := range files {
+		src, err := readSource(path)
+		if err != nil {
+			return err
+		}
+		outlines[i] = documentOutline(src)
+	}
+	d := diffOutlines(outlines[0], outlines[1])
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	} else {
+		heading := func(h outlineHeading) string { return strings.Repeat("#", h.Level) + " " + h.Text }
+		for _, h := range d.Removed {
+			fmt.Printf("- removed  %s  (line %d, #%s)\n", heading(h), h.Line, h.ID)
+		}
+		for _, c := range d.Renamed {
+			fmt.Printf("~ renamed  %s → %s  (line %d → %d, #%s → #%s)\n", heading(c.Old), c.New.Text, c.Old.Line, c.New.Line, c.Old.ID, c.New.ID)
+		}
+		for _, h := range d.Added {
+			fmt.Printf("+ added    %s  (line %d, #%s)\n", heading(h), h.Line, h.ID)
+		}
+	}
+	if n := len(d.Removed) + len(d.Renamed); n > 0 {
+		return fmt.Errorf("%d heading(s) removed or renamed", n)
+	}
+	return nil
+}
+
+// documentOutline returns the headings of src in order, with the anchors
+// the page gives them.
+func documentOutline(src []byte) []outlineHeading {
+	doc := markdown().Parser().Parse(text.NewReader(src), parseOptions(nil)...)
+	var list []outlineHeading
+	var starts []int // where each heading's line starts
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok || h.Lines().Len() == 0 {
+			return ast.WalkContinue, nil
+		}
+		start := h.Lines().At(0).Start
+		start = bytes.LastIndexByte(src[:start], '\n') + 1
+		id, _ := h.AttributeString("id")
+		list = append(list, outlineHeading{
+			Level: h.Level,
+			Text:  string(h.Text(src)),
+			ID:    string(attrValue(id)),
+			Line:  bytes.Count(src[:start], []byte("\n")) + 1,
+		})
+		starts = append(starts, start)
+		return ast.WalkSkipChildren, nil
+	})
+	for i := range list {
+		end := len(src)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		body := src[starts[i]:end]
+		if nl := bytes.IndexByte(body, '\n'); nl >= 0 {
+			body = body[nl+1:]
+		}
+		list[i].words = make(map[string]bool)
+		for _, w := range strings.FieldsFunc(strings.ToLower(string(body)), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			list[i].words[w] = true
+		}
+	}
+	return list
+}
+
+// diffOutlines compares the old and new outlines.
+func diffOutlines(old, updated []outlineHeading) outlineDiff {
+	d := outlineDiff{Added: []outlineHeading{}, Removed: []outlineHeading{}, Renamed: []outlineChange{}}
+	key := func(h outlineHeading) string { return fmt.Sprintf("%d\x00%s", h.Level, h.Text) }
+
+	// The same heading text at the same level is the same heading; repeats
+	// pair up in order.
+	unmatched := make(map[string][]int)
+	for j, h := range updated {
+		unmatched[key(h)] = append(unmatched[key(h)], j)
+	}
+	oldMatched, newMatched := make([]bool, len(old)), make([]bool, len(updated))
+	for i, h := range old {
+		if js := unmatched[key(h)]; len(js) > 0 {
+			oldMatched[i], newMatched[js[0]] = true, true
+			unmatched[key(h)] = js[1:]
+		}
+	}
+
+	// Of the rest, the most alike pairs at the same level are renames.
+	for {
+		best, bi, bj := renameSimilarity, -1, -1
+		for i, o := range old {
+			if oldMatched[i] {
+				continue
+			}
+			for j, n := range updated {
+				if newMatched[j] || n.Level != o.Level {
+					continue
+				}
+				if s := wordSimilarity(o.words, n.words); s >= best && (bi < 0 || s > best) {
+					best, bi, bj = s, i, j
+				}
+			}
+		}
+		if bi < 0 {
+			break
+		}
+		oldMatched[bi], newMatched[bj] = true, true
+		d.Renamed = append(d.Renamed, outlineChange{old[bi], updated[bj]})
+	}
+
+	for i, h := range old {
+		if !oldMatched[i] {
+			d.Removed = append(d.Removed, h)
+		}
+	}
+	for j, h := range updated {
+		if !newMatched[j] {
+			d.Added = append(d.Added, h)
+		}
+	}
+	sort.SliceStable(d.Renamed, func(i, j int) bool { return d.Renamed[i].New.Line < d.Renamed[j].New.Line })
+	return d
+}
+
+// wordSimilarity is the Jaccard index of two word sets; two empty
+// sections are not alike, as nothing says they are the same.
+func wordSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
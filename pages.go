@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Several input files are served as pages of their own unless --combine
+// joins them into one document (see combined.go). The first file is at /,
+// the others at their path relative to its directory, so links between
+// them work as they do on disk. Each file is a document of its own in the
+// store and reloads on its own; a bar above the content links to the
+// previous and next file and lists them all.
+
+// combineFiles is --combine.
+var combineFiles bool
+
+// An inputPage is one input file served as its own page.
+type inputPage struct {
+	Path  string // as given on the command line
+	Route string // URL path
+	Key   string // document key
+}
+
+// inputPages are the pages in command-line order; nil when the inputs are
+// one document.
+var inputPages []inputPage
+
+// newInputPages assigns routes and document keys to paths. Files outside
+// the first one's directory get a numbered route.
+func newInputPages(paths []string) []inputPage {
+	first, _ := filepath.Abs(paths[0])
+	dir := filepath.Dir(first)
+	pages := make([]inputPage, len(paths))
+	taken := make(map[string]bool)
+	for i, p := range paths {
+		route := "/"
+		if i > 0 {
+			abs, _ := filepath.Abs(p)
+			rel, err := filepath.Rel(dir, abs)
+			route = "/" + filepath.ToSlash(rel)
+			if err != nil || !withinDir(dir, abs) || taken[route] {
+				route = fmt.Sprintf("/~%d/%s", i, filepath.Base(p))
+			}
+		}
+		taken[route] = true
+		pages[i] = inputPage{Path: p, Route: route, Key: mainDocument}
+		if i > 0 {
+			pages[i].Key = "page:" + route
+		}
+	}
+	return pages
+}
+
+// pageIndex returns the index of the page served at route, or -1. The
+// first file's own path, where links from the others lead, counts too.
+func pageIndex(route string) int {
+	for i, p := range inputPages {
+		if p.Route == route || i == 0 && route == "/"+filepath.Base(p.Path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// requestedDocument returns the key of the document r is about: the page
+// or linked file named by ?page=, else the main document.
+func requestedDocument(r *http.Request) string {
+	route := r.URL.Query().Get("page")
+	if i := pageIndex(route); i >= 0 {
+		return inputPages[i].Key
+	}
+	if p, ok := linkedPage(route); ok {
+		return p.Key
+	}
+	return mainDocument
+}
+
+// pageRoute returns the route of page i, or "" for none.
+func pageRoute(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return inputPages[i].Route
+}
+
+// A pageLink is an entry of the page bar.
+type pageLink struct {
+	Name    string
+	Route   string
+	Current bool
+}
+
+// pageNav is the bar above a page's content.
+type pageNav struct {
+	Prev, Next *pageLink
+	Pages      []pageLink
+}
+
+// navFor returns the bar for the page at index i.
+func navFor(i int) *pageNav {
+	nav := &pageNav{}
+	for j, p := range inputPages {
+		nav.Pages = append(nav.Pages, pageLink{
+			Name:    displayName(strings.TrimPrefix(filepath.ToSlash(filepath.Clean(p.Path)), "./")),
+			Route:   p.Route,
+			Current: j == i,
+		})
+	}
+	if i > 0 {
+		nav.Prev = &nav.Pages[i-1]
+	}
+	if i < len(nav.Pages)-1 {
+		nav.Next = &nav.Pages[i+1]
+	}
+	return nav
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// PDFs are printed by a local headless Chrome, Chromium or Edge from the
+// same self-contained page --export writes, so they keep the theme and
+// highlight style. --pdf out.pdf writes one and exits; while serving, /pdf
+// returns the watched document as PDF.
+
+var (
+	pdfPath    string // --pdf
+	chromePath string // --chrome; found on PATH or in the usual places otherwise
+)
+
+// pdfTimeout bounds one print, including the browser's startup.
+const pdfTimeout = time.Minute
+
+// chromeNames are looked up on PATH, then chromeLocations are tried.
+var chromeNames = []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "chrome", "microsoft-edge", "msedge"}
+
+var chromeLocations = map[string][]string{
+	"darwin": {
+		"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+		"/Applications/Chromium.app/Contents/MacOS/Chromium",
+		"/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge",
+	},
+	"windows": {
+		`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+		`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+		`C:\Program Files (x86)\Microsoft\Edge\Application\msedge.exe`,
+	},
+}
+
+var errNoChrome = errors.New("PDF output needs Chrome, Chromium or Edge; install one or point --chrome at it")
+
+func findChrome() (string, error) {
+	if chromePath != "" {
+		return chromePath, nil
+	}
+	for _, name := range chromeNames {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	for _, path := range chromeLocations[runtime.GOOS] {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errNoChrome
+}
+
+// chromeAvailable reports whether PDFs can be made, for the page's button.
+func chromeAvailable() bool {
+	_, err := findChrome()
+	return err == nil
+}
+
+// printPDF renders page to PDF with headless Chrome, until ctx is done.
+func printPDF(ctx context.Context, page []byte) ([]byte, error) {
+	chrome, err := findChrome()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "mdview-pdf-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "page.html"), filepath.Join(dir, "page.pdf")
+	if err := os.WriteFile(in, page, 0o600); err != nil {
+		return nil, err
+	}
+
+	args := []string{"--headless", "--disable-gpu", "--no-pdf-header-footer", "--user-data-dir=" + filepath.Join(dir, "profile"), "--print-to-pdf=" + out}
+	if runtime.GOOS == "linux" && os.Geteuid() == 0 {
+		args = append(args, "--no-sandbox") // Chrome refuses to run as root otherwise
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(in)}
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path // Windows drive paths
+	}
+	printCtx, cancel := context.WithTimeout(ctx, pdfTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(printCtx, chrome, append(args, u.String())...)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, errCancelled
+	}
+	if printCtx.Err() != nil {
+		return nil, fmt.Errorf("printing timed out after %s", pdfTimeout)
+	}
+	pdf, readErr := os.ReadFile(out)
+	if readErr != nil {
+		if err == nil {
+			err = readErr
+		}
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return nil, fmt.Errorf("%s: %v\n%s", filepath.Base(chrome), err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", filepath.Base(chrome), err)
+	}
+	return pdf, nil
+}
+
+// exportPDF writes the loaded document to path as PDF.
+func exportPDF(path string) (err error) {
+	defer func() { notifyExport(path, err) }()
+	if err := applyConfig(); err != nil {
+		return err
+	}
+	ctx, stop := exportContext()
+	defer stop()
+	_, p, _ := activeProfile()
+	dir, _ := filepath.Abs(filepath.Dir(path))
+	done := showProgress("Rendering " + filepath.Base(path))
+	page, err := standaloneHTML(profileTheme(p), currentRenderer().Highlight, modePDF, dir)
+	done()
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return errCancelled
+	}
+	done = showProgress("Printing " + filepath.Base(path) + " with Chrome")
+	pdf, err := printPDF(ctx, page)
+	done()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, pdf); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s (%s)\n", path, formatSize(int64(len(pdf))))
+	return nil
+}
+
+// handlePDF serves /pdf: the watched document as PDF, with ?theme= and
+// ?highlight= as the page currently shows them.
+func handlePDF(w http.ResponseWriter, r *http.Request) {
+	if isLocked() || dirRoot != "" {
+		http.NotFound(w, r)
+		return
+	}
+	if encrypted {
+		// Chrome reads the page from a temporary file.
+		http.Error(w, "PDF output is disabled for encrypted documents", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	theme := q.Get("theme")
+	if _, ok := lookupTheme(theme); !ok {
+		theme = ""
+	}
+	highlight := q.Get("highlight")
+	if highlight == "" {
+		highlight = currentRenderer().Highlight
+	}
+	if err := checkHighlight(highlight); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := standaloneHTML(theme, highlight, modePDF, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pdf, err := printPDF(r.Context(), page)
+	if errors.Is(err, errNoChrome) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path := docs.Get(mainDocument).Path
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if name == "" || name == "." {
+		name = "document"
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", contentDisposition("inline", name+".pdf"))
+	w.Write(pdf)
+}
+
+// contentDisposition returns a Content-Disposition header value for a
+// download named filename. Quotes, backslashes and controls can't break out
+// of the quoted name, and a non-ASCII name is sent RFC 2231-encoded, which
+// browsers prefer, next to an ASCII stand-in.
+func contentDisposition(kind, filename string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	v := kind + `; filename="` + ascii + `"`
+	if ascii != filename {
+		v += "; filename*=UTF-8''"
+		for _, c := range []byte(displayName(filename)) {
+			if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+				v += string(c)
+			} else {
+				v += fmt.Sprintf("%%%02X", c)
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"compress/gzip"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Plain pages are for slow SSH tunnels and metered connections: images
+// become their alt text, embedded media a link, and the page loads no
+// KaTeX, highlight stylesheet or link checks; pages and /raw go out
+// gzip-compressed. --plain makes every page plain; ?plain=1 and ?plain=0
+// switch one browser, which a cookie remembers.
+
+// plainMode is --plain.
+var plainMode bool
+
+const plainCookie = "mdview-plain"
+
+// isPlain reports whether r asked for plain pages.
+func isPlain(r *http.Request) bool {
+	if v := r.URL.Query().Get("plain"); v != "" {
+		return v == "1"
+	}
+	if c, err := r.Cookie(plainCookie); err == nil {
+		return c.Value == "1"
+	}
+	return plainMode
+}
+
+var (
+	plainImgPattern   = regexp.MustCompile(`<img\b[^>]*>`)
+	plainMediaPattern = regexp.MustCompile(`(?s)<(iframe|video|audio)\b[^>]*>.*?</(?:iframe|video|audio)>`)
+	plainAttrPattern  = regexp.MustCompile(`\b(alt|src)="([^"]*)"`)
+)
+
+// plainHTML strips what a plain page doesn't load from rendered HTML.
+func plainHTML(rendered []byte) []byte {
+	rendered = plainImgPattern.ReplaceAllFunc(rendered, func(m []byte) []byte {
+		alt := "image"
+		if a := tagAttr(m, "alt"); a != "" {
+			alt = a
+		}
+		return []byte(`<span class="img-alt">[` + alt + `]</span>`)
+	})
+	return plainMediaPattern.ReplaceAllFunc(rendered, func(m []byte) []byte {
+		kind := string(plainMediaPattern.FindSubmatch(m)[1])
+		src := tagAttr(m, "src")
+		if src == "" {
+			return []byte(`<span class="img-alt">[` + kind + `]</span>`)
+		}
+		return []byte(`<a class="img-alt" href="` + src + `">[` + kind + `: ` + html.EscapeString(html.UnescapeString(src)) + `]</a>`)
+	})
+}
+
+// tagAttr returns the still-escaped value of attribute name in tag.
+func tagAttr(tag []byte, name string) string {
+	for _, m := range plainAttrPattern.FindAllSubmatch(tag, -1) {
+		if string(m[1]) == name {
+			return string(m[2])
+		}
+	}
+	return ""
+}
+
+// plainRequests remembers ?plain= in a cookie and compresses the text
+// responses to plain requests, except the event stream.
+func plainRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("plain"); v != "" && r.Method == http.MethodGet {
+			http.SetCookie(w, &http.Cookie{Name: plainCookie, Value: v, Path: "/", SameSite: http.SameSiteStrictMode})
+		}
+		if !isPlain(r) || r.URL.Path == "/events" || r.Header.Get("Range") != "" ||
+			!strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// A gzipWriter compresses a successful text response; images, a 304 or an
+// error go out as they are.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		// Sniffed here, as net/http would only see compressed bytes.
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been compressed so far, for pages that stream in.
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// compressible reports whether a body of type typ is worth compressing.
+func compressible(typ string) bool {
+	return strings.HasPrefix(typ, "text/") || strings.Contains(typ, "json") ||
+		strings.Contains(typ, "javascript") || strings.Contains(typ, "svg")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// Fenced `plantuml`/`puml` blocks render as diagrams. --plantuml says how:
+//
+//	local                 the plantuml command on PATH (the default)
+//	path/to/plantuml.jar  that jar, run with java
+//	https://host/plantuml a PlantUML server, which mdview asks for the SVG
+//	url:https://host/...  an <img> the browser loads from the server itself
+//
+// A missing @startuml/@enduml pair is added. As with Graphviz, a failure is
+// shown above the block's source. SVG from a server is shown as an image,
+// so nothing it contains runs in the page.
+
+// plantumlMode is --plantuml.
+var plantumlMode = "local"
+
+// plantumlTimeout bounds one diagram. PlantUML starts a JVM each time, so
+// the first can take a few seconds.
+const plantumlTimeout = 20 * time.Second
+
+// plantumlCache keeps rendered diagrams by mode and source, so live reloads
+// only redraw the diagrams that changed.
+var plantumlCache = struct {
+	sync.Mutex
+	html map[[32]byte][]byte
+}{html: make(map[[32]byte][]byte)}
+
+var plantumlClient = &http.Client{Timeout: plantumlTimeout}
+
+var errNoPlantUML = errors.New("plantuml not found in PATH; install PlantUML or set --plantuml to a jar or server")
+
+// plantumlEncoding is PlantUML's base64 alphabet for diagram URLs.
+var plantumlEncoding = base64.NewEncoding("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_").WithPadding(base64.NoPadding)
+
+// plantumlSource wraps src in @startuml/@enduml unless it starts a diagram
+// itself (@startuml, @startmindmap...).
+func plantumlSource(src []byte) []byte {
+	if bytes.HasPrefix(bytes.TrimSpace(src), []byte("@start")) {
+		return src
+	}
+	out := append([]byte("@startuml\n"), src...)
+	if !bytes.HasSuffix(out, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	return append(out, "@enduml\n"...)
+}
+
+// plantumlEncode returns the form of src used in server URLs: deflated,
+// then base64 in PlantUML's alphabet.
+func plantumlEncode(src []byte) string {
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	zw.Write(src)
+	zw.Close()
+	// PlantUML encodes whole 3-byte groups, padding with zeros.
+	for buf.Len()%3 != 0 {
+		buf.WriteByte(0)
+	}
+	return plantumlEncoding.EncodeToString(buf.Bytes())
+}
+
+// renderPlantUML returns the HTML of the diagram in src.
+func renderPlantUML(src []byte) ([]byte, error) {
+	src = plantumlSource(src)
+	key := sha256.Sum256(append([]byte(plantumlMode+"\x00"), src...))
+	plantumlCache.Lock()
+	out, ok := plantumlCache.html[key]
+	plantumlCache.Unlock()
+	if ok {
+		return out, nil
+	}
+
+	var err error
+	switch {
+	case strings.HasPrefix(plantumlMode, "url:"):
+		server := strings.TrimSuffix(strings.TrimPrefix(plantumlMode, "url:"), "/")
+		out = []byte(`<img src="` + html.EscapeString(server+"/svg/"+plantumlEncode(src)) + `" alt="PlantUML diagram">`)
+	case strings.HasPrefix(plantumlMode, "http://") || strings.HasPrefix(plantumlMode, "https://"):
+		out, err = fetchPlantUML(src)
+	default:
+		out, err = runPlantUML(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plantumlCache.Lock()
+	if len(plantumlCache.html) >= 256 {
+		plantumlCache.html = make(map[[32]byte][]byte)
+	}
+	plantumlCache.html[key] = out
+	plantumlCache.Unlock()
+	return out, nil
+}
+
+// runPlantUML draws src with the local plantuml command or jar and
+// returns the SVG element.
+func runPlantUML(src []byte) ([]byte, error) {
+	args := []string{"-tsvg", "-pipe", "-charset", "UTF-8"}
+	var name string
+	if strings.HasSuffix(strings.ToLower(plantumlMode), ".jar") {
+		name, args = "java", append([]string{"-Djava.awt.headless=true", "-jar", plantumlMode}, args...)
+	} else {
+		name = "plantuml"
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		if name == "java" {
+			return nil, errors.New("java not found in PATH; it is needed to run " + plantumlMode)
+		}
+		return nil, errNoPlantUML
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), plantumlTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = bytes.NewReader(src)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("drawing timed out after %s", plantumlTimeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	// Drop the XML declaration; the page is HTML.
+	if i := bytes.Index(out, []byte("<svg")); i >= 0 {
+		out = out[i:]
+	}
+	return out, nil
+}
+
+// fetchPlantUML asks the --plantuml server for src's SVG and returns it
+// as an image.
+func fetchPlantUML(src []byte) ([]byte, error) {
+	u := strings.TrimSuffix(plantumlMode, "/") + "/svg/" + plantumlEncode(src)
+	resp, err := plantumlClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	svg, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// The server draws syntax errors too; say which line.
+		if msg := resp.Header.Get("X-PlantUML-Diagram-Error"); msg != "" {
+			return nil, fmt.Errorf("%s (line %s)", msg, resp.Header.Get("X-PlantUML-Diagram-Error-Line"))
+		}
+		return nil, fmt.Errorf("%s: %s", plantumlMode, resp.Status)
+	}
+	return []byte(`<img src="data:image/svg+xml;base64,` + base64.StdEncoding.EncodeToString(svg) + `" alt="PlantUML diagram">`), nil
+}
+
+// plantumlBlock renders plantuml/puml fences. The fenced code is only
+// shown when drawing fails.
+type plantumlBlock struct{}
+
+func init() {
+	registerBlock("plantuml", []string{"plantuml", "puml"}, true, plantumlBlock{})
+}
+
+func (plantumlBlock) Prepare(fcb *ast.FencedCodeBlock, lang string, code []byte) interface{} {
+	return code
+}
+
+func (plantumlBlock) Render(w util.BufWriter, state interface{}, entering bool) ast.WalkStatus {
+	if !entering {
+		return ast.WalkContinue
+	}
+	out, err := renderPlantUML(state.([]byte))
+	if err != nil {
+		fmt.Fprintf(w, "<div class=\"plantuml-error\">PlantUML: %s</div>\n", html.EscapeString(err.Error()))
+		return ast.WalkContinue
+	}
+	w.WriteString(`<div class="plantuml">`)
+	w.Write(out)
+	w.WriteString("</div>\n")
+	return ast.WalkSkipChildren
+}
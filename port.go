@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+var (
+	listenPort int  // --port; 0 reuses the project's last port or picks one
+	strictPort bool // fail instead of falling back when --port is taken
+)
+
+// portFallbacks is how many ports after the preferred one are tried.
+const portFallbacks = 10
+
+// listen opens the server socket. The preferred port is --port or, without
+// it, the port last used for the project directory, so bookmarks survive
+// restarts. A busy port falls back to the next free one nearby.
+func listen() (net.Listener, error) {
+	project := baseDir
+	if project == "" {
+		project, _ = os.Getwd()
+	}
+	preferred := listenPort
+	if preferred == 0 {
+		preferred = rememberedPorts()[project]
+	}
+
+	// Only --share exposes the server beyond this machine.
+	host := "localhost"
+	if shareLAN {
+		host = ""
+	}
+	var listener net.Listener
+	if preferred != 0 {
+		l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, preferred))
+		if err != nil && listenPort != 0 && strictPort {
+			return nil, fmt.Errorf("port %d: %w", preferred, err)
+		}
+		for i := 1; err != nil && i <= portFallbacks; i++ {
+			l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", host, preferred+i))
+		}
+		listener = l
+	}
+	if listener == nil {
+		l, err := net.Listen("tcp", host+":0")
+		if err != nil {
+			return nil, err
+		}
+		listener = l
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	if listenPort != 0 && port != listenPort {
+		fmt.Fprintf(os.Stderr, "Port %d is in use, using %d instead.\n", listenPort, port)
+	}
+	if err := rememberPort(project, port); err != nil {
+		fmt.Fprintf(os.Stderr, "could not remember port: %v\n", err)
+	}
+	return listener, nil
+}
+
+func portsFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mdview", "ports.json"), nil
+}
+
+// rememberedPorts maps project directories to the port they last used.
+func rememberedPorts() map[string]int {
+	ports := make(map[string]int)
+	path, err := portsFile()
+	if err != nil {
+		return ports
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &ports)
+	}
+	return ports
+}
+
+func rememberPort(project string, port int) error {
+	if project == "" {
+		return errors.New("no project directory")
+	}
+	path, err := portsFile()
+	if err != nil {
+		return err
+	}
+	ports := rememberedPorts()
+	if ports[project] == port {
+		return nil
+	}
+	ports[project] = port
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(ports, "", "  ")
+	return writeFileAtomic(path, data)
+}
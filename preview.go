@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// handlePreview serves the title and first paragraph of a document, or of a
+// section when id names a heading, for hover popovers on internal links.
+// path is a URL path as seen by the browser; "/" is the watched document.
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	id := r.URL.Query().Get("id")
+
+	var src []byte
+	var name string
+	if path == "" || path == "/" {
+		if isLocked() {
+			http.NotFound(w, r)
+			return
+		}
+		d := docs.Get(mainDocument)
+		src, name = d.Content, d.Path
+	} else {
+		fsys, rel, fileName, ok := siteFile(path)
+		if !ok || !isMarkdown(rel) {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := fs.ReadFile(fsys, rel)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		src = data
+		name = fileName
+	}
+
+	opts := parseOptions(nil)
+	if path == "" || path == "/" {
+		opts = parseOptions(fileSections)
+	}
+	title, para := previewNodes(src, id, opts...)
+	if id != "" && title == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if title == "" {
+		title = filepath.Base(name)
+	}
+	var buf bytes.Buffer
+	if para != nil {
+		if err := markdown().Renderer().Render(&buf, src, para); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	out := buf.Bytes()
+	if isPlain(r) {
+		out = plainHTML(out)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"title": title,
+		"html":  string(out),
+	})
+}
+
+// previewNodes returns the title and first paragraph of src. With an id, the
+// title is that heading's text and the paragraph the first one after it;
+// otherwise the first heading and first paragraph of the document are used.
+// opts must match those the page was rendered with so IDs agree.
+func previewNodes(src []byte, id string, opts ...parser.ParseOption) (string, ast.Node) {
+	doc := markdown().Parser().Parse(text.NewReader(src), opts...)
+	var title string
+	var para ast.Node
+	var found bool
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		switch n := n.(type) {
+		case *ast.Heading:
+			if id != "" {
+				if found {
+					return title, para
+				}
+				if v, ok := n.AttributeString("id"); ok && string(attrValue(v)) == id {
+					title = string(n.Text(src))
+					found = true
+				}
+			} else if title == "" {
+				title = string(n.Text(src))
+			}
+		case *ast.Paragraph:
+			if para == nil && (id == "" || found) {
+				para = n
+				if id != "" || title != "" {
+					return title, para
+				}
+			}
+		}
+	}
+	return title, para
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// /print is the document laid out for paper: always the light theme, none
+// of the page's controls, margins set with @page, a page break before each
+// h1 and h2, and code blocks, tables and figures kept whole. A printed link
+// can't be followed, so each link out of the document gets a numbered
+// note and the URLs are listed at the end. The ☰ panel's "Print view"
+// opens it in a new tab with a Print button that doesn't print.
+
+// printLinkPattern matches a link and its text.
+var printLinkPattern = regexp.MustCompile(`(?s)<a\b[^>]*?\bhref="([^"]*)"[^>]*>(.*?)</a>`)
+
+// printLinkNotes adds a note after each link in rendered that leaves the
+// document, numbering a URL once however often it is linked, and appends
+// the list of them.
+func printLinkNotes(rendered []byte) []byte {
+	var urls []string
+	numbers := make(map[string]int)
+	out := printLinkPattern.ReplaceAllFunc(rendered, func(m []byte) []byte {
+		parts := printLinkPattern.FindSubmatch(m)
+		href := html.UnescapeString(string(parts[1]))
+		u, err := url.Parse(href)
+		if err != nil {
+			return m
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "mailto":
+		default:
+			return m
+		}
+		// A bare URL already says where it goes.
+		text := html.UnescapeString(tagPattern.ReplaceAllString(string(parts[2]), ""))
+		if text == href || text == strings.TrimPrefix(href, "mailto:") {
+			return m
+		}
+		n, ok := numbers[href]
+		if !ok {
+			urls = append(urls, href)
+			n = len(urls)
+			numbers[href] = n
+		}
+		return []byte(fmt.Sprintf(`%s<sup class="print-link-ref"><a href="#print-link-%d">[%d]</a></sup>`, m, n, n))
+	})
+	if len(urls) == 0 {
+		return rendered
+	}
+	var b bytes.Buffer
+	b.Write(out)
+	b.WriteString("<section class=\"print-links\">\n<h2 id=\"print-links\">Links</h2>\n<ol>\n")
+	for i, u := range urls {
+		fmt.Fprintf(&b, "<li id=\"print-link-%d\">%s</li>\n", i+1, html.EscapeString(u))
+	}
+	b.WriteString("</ol>\n</section>\n")
+	return b.Bytes()
+}
+
+// handlePrint serves /print: the watched document laid out for printing,
+// with ?highlight= as the page currently shows it.
+func handlePrint(w http.ResponseWriter, r *http.Request) {
+	if isLocked() || dirRoot != "" {
+		http.NotFound(w, r)
+		return
+	}
+	if encrypted {
+		http.Error(w, "the print view is disabled for encrypted documents", http.StatusForbidden)
+		return
+	}
+	highlight := r.URL.Query().Get("highlight")
+	if highlight == "" {
+		highlight = currentRenderer().Highlight
+	}
+	if err := checkHighlight(highlight); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := standaloneHTML("light", highlight, modePrint, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
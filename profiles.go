@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Profiles bundle reading settings and renderer options for a kind of
+// work, picked with --profile or from the settings panel. Three are built
+// in; the config file (or the user config, see userconfig.go) can redefine
+// them or add more:
+//
+//	profiles:
+//	  review:
+//	    theme: light
+//	    width: 1200
+//	    extensions: [table, tasklist, footnote]
+//
+// The reading settings are the page's defaults; a reader's own choices for
+// a document still win.
+
+// A profile is one named bundle. Unset fields leave things as they are.
+type profile struct {
+	Theme      string  `yaml:"theme" toml:"theme" json:"theme,omitempty"`
+	Width      int     `yaml:"width" toml:"width" json:"width,omitempty"`
+	FontSize   int     `yaml:"font-size" toml:"font-size" json:"fontSize,omitempty"`
+	LineHeight float64 `yaml:"line-height" toml:"line-height" json:"lineHeight,omitempty"`
+
+	Highlight  string   `yaml:"highlight" toml:"highlight" json:"-"`
+	Unsafe     *bool    `yaml:"unsafe" toml:"unsafe" json:"-"`
+	Extensions []string `yaml:"extensions" toml:"extensions" json:"-"`
+}
+
+var builtinProfiles = map[string]profile{
+	// Narrow, airy text with smart punctuation and footnotes.
+	"writing": {Width: 760, FontSize: 18, LineHeight: 1.8,
+		Extensions: []string{"table", "strikethrough", "linkify", "tasklist", "footnote", "typographer", "math", "emoji"}},
+	// Wide, light and literal, for reading someone else's document.
+	"review": {Theme: "light", Width: 1200,
+		Extensions: []string{"table", "strikethrough", "linkify", "tasklist", "footnote", "definition-list", "math", "emoji"}},
+	// Large type for a projector.
+	"slides": {Theme: "dark", Width: 1400, FontSize: 26, LineHeight: 1.5},
+}
+
+var (
+	profileName string // --profile, then the profile picked in the page; under mu
+
+	profiles     map[string]profile // built-in and configured, under mu
+	baseRenderer = defaultRenderer  // options before any profile, under mu
+)
+
+// renderer returns base with p's renderer options applied.
+func (p profile) renderer(base rendererOptions) rendererOptions {
+	o := base
+	if p.Highlight != "" {
+		o.Highlight = p.Highlight
+	}
+	if p.Unsafe != nil {
+		o.Unsafe = *p.Unsafe
+	}
+	if p.Extensions != nil {
+		o.Extensions = p.Extensions
+	}
+	return o
+}
+
+// setProfiles installs c's options and profiles and applies the active
+// profile on top.
+func setProfiles(c *configFile) error {
+	all := make(map[string]profile, len(builtinProfiles)+len(c.Profiles))
+	for name, p := range builtinProfiles {
+		all[name] = p
+	}
+	for name, p := range userProfiles {
+		all[name] = p
+	}
+	for name, p := range c.Profiles {
+		all[name] = p
+	}
+	mu.Lock()
+	name := profileName
+	mu.Unlock()
+	p, ok := all[name]
+	if name != "" && !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	mu.Lock()
+	profiles, baseRenderer, configTheme = all, c.rendererOptions, c.Theme
+	mu.Unlock()
+	return setRenderer(p.renderer(c.rendererOptions))
+}
+
+// switchProfile makes name (or no profile, for "") the active one.
+func switchProfile(name string) error {
+	mu.RLock()
+	p, ok := profiles[name]
+	base := baseRenderer
+	mu.RUnlock()
+	if name != "" && !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if err := setRenderer(p.renderer(base)); err != nil {
+		return err
+	}
+	mu.Lock()
+	profileName = name
+	mu.Unlock()
+	return nil
+}
+
+// activeProfile returns the active profile's name and reading settings,
+// and the names to choose from.
+func activeProfile() (string, profile, []string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return profileName, profiles[profileName], names
+}
+
+// handleProfile serves /api/profile: POST {"name": "..."} switches profile
+// for everyone viewing; an empty name goes back to no profile.
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" || isViewer(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := switchProfile(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name, settings, _ := activeProfile()
+	if name != "" {
+		bus.Publish(Event{Type: EventToast, Data: "Switched to the " + name + " profile"})
+	} else {
+		bus.Publish(Event{Type: EventToast, Data: "Profile cleared"})
+	}
+	writeJSON(w, map[string]interface{}{"name": name, "settings": settings})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// --pdf and --export of a long document can take a while, mostly in
+// Chrome. The terminal shows what is being done and for how long, and
+// with --notify a desktop notification says when it is over, so the
+// terminal needn't be watched. Ctrl+C stops the export: Chrome is killed,
+// its scratch files removed, and the output, which is only written once
+// complete, is left as it was. A second Ctrl+C quits at once.
+
+// notifyDone is --notify.
+var notifyDone bool
+
+// errCancelled is returned by an export stopped with Ctrl+C.
+var errCancelled = errors.New("cancelled; nothing was written")
+
+// exportContext returns a context cancelled by Ctrl+C or SIGTERM, after
+// which the signals kill the process again.
+func exportContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// showProgress shows label and the time it has been running on stderr,
+// when that is a terminal, until the returned func is called.
+func showProgress(label string) func() {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return func() {}
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			fmt.Fprintf(os.Stderr, "\r\033[K%s... %s", label, time.Since(start).Round(time.Second))
+			select {
+			case <-done:
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// notifyExport reports the outcome of an export as a desktop notification,
+// with --notify.
+func notifyExport(path string, err error) {
+	if !notifyDone || errors.Is(err, errCancelled) {
+		return
+	}
+	msg := "Wrote " + path
+	if err != nil {
+		msg = "Export failed: " + err.Error()
+	}
+	if err := desktopNotify("mdview", msg); err != nil {
+		fmt.Fprintf(os.Stderr, "mdview: notification: %v\n", err)
+	}
+}
+
+// desktopNotify shows a native notification: notify-send on Linux and the
+// BSDs, Notification Center on macOS, a tray balloon on Windows.
+func desktopNotify(title, msg string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", "display notification "+appleScriptString(msg)+" with title "+appleScriptString(title))
+	case "windows":
+		quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+		cmd = exec.Command("powershell", "-NoProfile", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms; $n = New-Object System.Windows.Forms.NotifyIcon; "+
+				"$n.Icon = [System.Drawing.SystemIcons]::Information; $n.Visible = $true; "+
+				"$n.ShowBalloonTip(5000, "+quote(title)+", "+quote(msg)+", 'Info'); Start-Sleep -Seconds 5; $n.Dispose()")
+	default:
+		cmd = exec.Command("notify-send", "--app-name=mdview", title, msg)
+	}
+	return cmd.Run()
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
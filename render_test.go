@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark/parser"
+)
+
+// The golden tests render every testdata/golden/*.md fixture and compare
+// the result with the .html file next to it. After an intended change to
+// the output, regenerate them and review the diff:
+//
+//	go test -run TestGolden -update
+
+var update = flag.Bool("update", false, "rewrite the golden files from the current output")
+
+// setDocument makes src the watched document, as run does after reading
+// the inputs, and restores the previous one when the test ends.
+func setDocument(t *testing.T, path, src string) {
+	t.Helper()
+	old, oldDir := docs.Get(mainDocument), baseDir
+	baseDir = filepath.Dir(path)
+	docs.Set(mainDocument, Document{Path: path, Content: []byte(src)})
+	t.Cleanup(func() {
+		baseDir = oldDir
+		docs.Set(mainDocument, old)
+	})
+}
+
+func TestGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "golden", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in testdata/golden")
+	}
+	for _, fixture := range fixtures {
+		fixture := fixture
+		name := strings.TrimSuffix(filepath.Base(fixture), ".md")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setDocument(t, fixture, string(src))
+			got, err := renderMarkdown()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := strings.TrimSuffix(fixture, ".md") + ".html"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v; run go test -run TestGolden -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s\n%s", golden, firstDifference(want, got))
+			}
+		})
+	}
+}
+
+// TestRenderCache checks that a render is shared until the content changes.
+func TestRenderCache(t *testing.T) {
+	setDocument(t, "doc.md", "# One\n")
+	first, err := renderMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits := metrics.cacheHits.Load()
+	if _, err := renderMarkdown(); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.cacheHits.Load() != hits+1 {
+		t.Error("second render of the same version missed the cache")
+	}
+
+	setDocument(t, "doc.md", "# Two\n")
+	second, err := renderMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("changed content rendered from the stale cache entry")
+	}
+}
+
+func TestSectionMarkup(t *testing.T) {
+	src := []byte("# Doc\n\n## Plan\n\nShip **soon**, see [notes](https://x.test).\n\n- [x] draft\n  - `review`\n\n### Detail\n\n| A | B |\n|---|---|\n| 1 | 2 |\n\n## Later\n\nNot copied.\n")
+	for format, want := range map[string]string{
+		"slack": "*Plan*\n\nShip *soon*, see notes (https://x.test).\n\n• ☑ draft\n    • `review`\n\n*Detail*\n\n```\nA | B\n1 | 2\n```",
+		"jira":  "h2. Plan\n\nShip *soon*, see [notes|https://x.test].\n\n* (/) draft\n** {{review}}\n\nh3. Detail\n\n||A||B||\n|1|2|",
+	} {
+		got, err := sectionMarkup(src, "plan", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s:\n got %q\nwant %q", format, got, want)
+		}
+	}
+	if _, err := sectionMarkup(src, "missing", "slack"); err == nil {
+		t.Error("no error for a heading that isn't there")
+	}
+}
+
+func TestExportLinks(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.md")
+	if err := os.WriteFile(filepath.Join(dir, "design.md"), []byte("# Design\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setDocument(t, main, "")
+	rendered := []byte(`<h2 id="intro">Intro</h2>` + "\n" +
+		`<p><a href="design.md#goals">a</a> <a href="#intro">b</a> <a href="#gone">c</a> <a href="missing.md" class="x">d</a></p>`)
+	got := string(exportLinks(rendered, ".pdf", filepath.Join(dir, "out")))
+	for _, want := range []string{
+		`<a href="../design.pdf#goals">a</a>`,
+		`<a href="#intro">b</a>`,
+		`<a class="broken-link" href="#gone">c</a>`,
+		`<a href="missing.md" class="broken-link x">d</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("export is missing %s in\n%s", want, got)
+		}
+	}
+}
+
+func TestPrintLinkNotes(t *testing.T) {
+	rendered := []byte(`<p><a href="https://go.dev/doc">Go docs</a>, <a href="#intro">intro</a>, ` +
+		`<a href="https://go.dev/">https://go.dev/</a> and <a href="https://go.dev/doc">again</a></p>`)
+	got := string(printLinkNotes(rendered))
+	for _, want := range []string{
+		`<a href="https://go.dev/doc">Go docs</a><sup class="print-link-ref"><a href="#print-link-1">[1]</a></sup>`,
+		`<a href="#intro">intro</a>,`,
+		`<a href="https://go.dev/">https://go.dev/</a> and`,
+		`<a href="https://go.dev/doc">again</a><sup class="print-link-ref"><a href="#print-link-1">[1]</a></sup>`,
+		`<li id="print-link-1">https://go.dev/doc</li>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("print view is missing %s in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "print-link-2") {
+		t.Errorf("a URL is listed twice in\n%s", got)
+	}
+}
+
+func TestBrokenLinks(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{"assets/img/arch.png", "docs/guide/setup.md", "a/dup.png", "b/dup.png", "docs/here.md"} {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, err := fileIndex(os.DirFS(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte("![a](img/arch.png) [s](setup.md#install) [h](here.md) `[c](img/arch.png)`\n\n" +
+		"[ref]: dup.png\n[^1]: A note\n")
+	var got []string
+	for _, x := range brokenLinks(src, "docs/index.md", root, index) {
+		got = append(got, fmt.Sprintf("%d %s -> %s %v", x.line, x.old, x.new, x.candidates))
+	}
+	want := []string{
+		"1 img/arch.png -> ../assets/img/arch.png [assets/img/arch.png]",
+		"1 setup.md#install -> guide/setup.md#install [docs/guide/setup.md]",
+		"3 dup.png ->  [a/dup.png b/dup.png]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("broken links:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWikiLinks(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{"Home.md", "notes/Page Name.md", "projects/Plan.md", "archive/projects/Plan.md", "img/diagram.png"} {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wikiLinks = true
+	t.Cleanup(func() { wikiLinks = false })
+	setDocument(t, filepath.Join(root, "Home.md"),
+		"[[page name]] [[Page Name#Set Up|setup]] [[plan]] [[archive/projects/Plan]] [[#Top]] [[Missing]]\n\n"+
+			"![[diagram.png|300]] `[[code]]` [link](x.md)\n")
+	out, err := renderMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(out)
+	for _, want := range []string{
+		`<a href="/notes/Page%20Name.md" class="wikilink">page name</a>`,
+		`<a href="/notes/Page%20Name.md#set-up" class="wikilink">setup</a>`,
+		`<a href="/projects/Plan.md" class="wikilink">plan</a>`,
+		`<a href="/archive/projects/Plan.md" class="wikilink">archive/projects/Plan</a>`,
+		`<a href="#top" class="wikilink">Top</a>`,
+		`<a href="/Missing.md" title="no note named Missing" class="wikilink broken-link">Missing</a>`,
+		`<img src="/img/diagram.png" alt="diagram.png" width="300">`,
+		`<code>[[code]]</code>`,
+		`<a href="x.md">link</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in\n%s", want, got)
+		}
+	}
+}
+
+func TestAccessibilityIssues(t *testing.T) {
+	src := "# Doc\n\n![](chart.png) ![Chart](ok.png)\n\n" +
+		"[https://go.dev](https://go.dev) [docs](https://go.dev/doc)\n\n" +
+		"#### Deep\n\n" +
+		`<span style="color: #aaa">faint</span> <span style="color:#fff; background-color: black">fine</span>` + "\n" +
+		`<img src="a.png"> <img src="b.png" alt="">` + "\n"
+	var got []string
+	for _, issue := range accessibilityIssues([]byte(src)) {
+		got = append(got, fmt.Sprintf("%d %s", issue.Line, issue.Rule))
+	}
+	want := []string{"3 image-alt", "5 link-text", "7 heading-order", "9 contrast", "10 image-alt"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("issues = %s, want %s", strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+}
+
+func TestEmailInput(t *testing.T) {
+	msg := "Subject: =?utf-8?q?Caf=C3=A9_notes?=\r\nFrom: Ada <ada@x.test>\r\n" +
+		"Content-Type: multipart/related; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/markdown; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+		"# Plan\r\n\r\n![chart](cid:c1) caf=C3=A9\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Id: <c1>\r\nContent-Transfer-Encoding: base64\r\n\r\naGk=\r\n--b--\r\n"
+	path := filepath.Join(t.TempDir(), "notes.eml")
+	if err := os.WriteFile(path, []byte(msg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "---\nauthor: Ada\ntitle: Café notes\n---\n\n# Plan\n\n![chart](data:image/png;base64,aGk=) café"
+	if string(got) != want {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestHighlightPair(t *testing.T) {
+	css, err := highlightCSS("github,monokai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`:root[data-hl="github,monokai"] .chroma`,
+		`:root[data-hl="github,monokai"][data-theme="dark"] .chroma`,
+		"@media (prefers-color-scheme: dark) {",
+	} {
+		if !strings.Contains(string(css), want) {
+			t.Errorf("stylesheet is missing %s", want)
+		}
+	}
+	for _, bad := range []string{"nope", "github,", "github,nope"} {
+		if checkHighlight(bad) == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestPlantUML(t *testing.T) {
+	decode := func(enc string) string {
+		data, err := plantumlEncoding.DecodeString(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		return string(out)
+	}
+	// PlantUML's own example.
+	if got := decode("SyfFKj2rKt3CoKnELR1Io4ZDoSa70000"); got != "Bob -> Alice : hello" {
+		t.Errorf("decoded %q", got)
+	}
+	src := plantumlSource([]byte("Bob -> Alice : hi"))
+	if got := decode(plantumlEncode(src)); got != string(src) {
+		t.Errorf("round trip gave %q", got)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/svg/") {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		if strings.Contains(decode(strings.TrimPrefix(r.URL.Path, "/svg/")), "oops") {
+			w.Header().Set("X-PlantUML-Diagram-Error", "Syntax Error?")
+			w.Header().Set("X-PlantUML-Diagram-Error-Line", "2")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("<svg></svg>"))
+	}))
+	defer srv.Close()
+	old := plantumlMode
+	t.Cleanup(func() { plantumlMode = old })
+
+	plantumlMode = srv.URL
+	if out, err := renderPlantUML([]byte("A -> B")); err != nil || !strings.HasPrefix(string(out), `<img src="data:image/svg+xml;base64,`) {
+		t.Errorf("server: %s, %v", out, err)
+	}
+	if _, err := renderPlantUML([]byte("oops")); err == nil || err.Error() != "Syntax Error? (line 2)" {
+		t.Errorf("server error: %v", err)
+	}
+	plantumlMode = "url:" + srv.URL
+	if out, _ := renderPlantUML([]byte("A -> B")); !strings.HasPrefix(string(out), `<img src="`+srv.URL+"/svg/") {
+		t.Errorf("url: %s", out)
+	}
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	for raw, want := range map[string]string{
+		`<details open><summary onclick="x()">Hi</summary>`:    `<details open=""><summary>Hi</summary>`,
+		"<script>\nalert(1)\n</script>after":                   "after",
+		`<a href="JaVa&#x53;cript:alert(1)" title='t'>x</a>`:   `<a title="t">x</a>`,
+		`<img src="a.png" srcset="b.png 2x, javascript:x 3x">`: `<img src="a.png">`,
+		`<img src="a.png" srcset="b.png 2x, c.png 300w">`:      `<img src="a.png" srcset="b.png 2x, c.png 300w">`,
+		`<a href="https://x.test/?a=1&amp;b=2">x</a>`:          `<a href="https://x.test/?a=1&amp;b=2">x</a>`,
+		`<iframe src="//evil"></iframe><kbd>K</kbd>`:           `<kbd>K</kbd>`,
+		`<img src=x onerror=alert(1)`:                          ``,
+		`<!-- note --><style>p{}</style><p style="x">p</p>`:    `<p>p</p>`,
+
+		// Entity-encoded and split schemes.
+		`<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">x</a>`: `x`,
+		`<a href="javascript&colon;alert(1)">x</a>`:                                              `x`,
+		`<a href="java&#9;script:alert(1)">x</a>`:                                                `x`,
+		`<a href=" javascript:alert(1)">x</a>`:                                                   `x`,
+		`<picture><source srcset="java&#x09;script:x"><img src="a.png"></picture>`:               `<picture><img src="a.png"></picture>`,
+
+		// Quoting tricks stay inside the attribute value.
+		`<a title='x" onclick="alert(1)'>x</a>`:             `<a title="x&#34; onclick=&#34;alert(1)">x</a>`,
+		"<a title=`x`onclick=alert(1)>x</a>":                "<a title=\"`x`onclick=alert(1)\">x</a>",
+		`<img src=a.png/onerror=alert(1)>`:                  `<img src="a.png/onerror=alert(1)">`,
+		`<a href="https://ok.test" title="a>b">x</a>`:       `<a href="https://ok.test" title="a&gt;b">x</a>`,
+		`<details ontoggle=alert(1) open>`:                  `<details open="">`,
+		`<img """><script>alert(1)</script>">`:              `&#34;&gt;`,
+		`<math><mi xlink:href="javascript:alert(1)">x</mi>`: `x`,
+
+		// Malformed and nested tags.
+		`<scr<script>ipt>alert(1)</script>`:          `ipt&gt;alert(1)`,
+		`<<script>script>alert(1)<</script>/script>`: `&lt;/script&gt;`,
+		`<svg><script>alert(1)</script></svg>`:       ``,
+		`<!--><script>alert(1)</script>-->`:          `--&gt;`,
+		`<div><p><b>x</div></b>`:                     `<div><p><b>x</div></b>`,
+	} {
+		if got := string(sanitizeHTML([]byte(raw))); got != want {
+			t.Errorf("sanitizeHTML(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+// TestSafeAttributes checks that attribute lists can't add handlers or
+// script URLs with raw HTML off.
+func TestSafeAttributes(t *testing.T) {
+	o := defaultRenderer
+	o.Unsafe = false
+	var buf bytes.Buffer
+	src := "```go {onmouseover=\"alert(1)\" #ok data-x=1}\nx\n```\n\n" +
+		"```go onclick=alert(1) data-y=2\nx\n```\n\n" +
+		"# H {onclick=\"alert(1)\" title=t}\n\n" +
+		"![a](a.png){onerror=\"alert(1)\" width=10}\n\n" +
+		"[l](https://x.test){style=\"color:red\" href=\"javascript:alert(1)\"}\n"
+	if err := newMarkdown(o).Convert([]byte(src), &buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, bad := range []string{"onmouseover", "onclick", "onerror", "style=", "javascript:"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("safe output has %s:\n%s", bad, got)
+		}
+	}
+	for _, want := range []string{`id="ok"`, `data-x="1"`, `data-y="2"`, `title="t"`, `width="10"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("safe output lost %s:\n%s", want, got)
+		}
+	}
+}
+
+func TestBibliographyLinks(t *testing.T) {
+	old := bibliography
+	defer func() { bibliography = old }()
+	entries, err := parseCSLJSON([]byte(`[
+		{"id": "ok", "title": "Fine", "URL": "https://example.com/paper", "issued": {"date-parts": [[2020]]}},
+		{"id": "bad", "title": "Evil", "URL": "javascript:alert(1)", "issued": {"date-parts": [[2021]]}},
+		{"id": "doi", "title": "Cited", "DOI": "10.1000/182", "issued": {"date-parts": [[2022]]}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bibliography = map[string]*bibEntry{}
+	for _, e := range entries {
+		bibliography[e.Key] = e
+	}
+	var buf bytes.Buffer
+	if err := newMarkdown(defaultRenderer).Convert([]byte("See [@ok; @bad; @doi].\n"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{`<a href="https://example.com/paper">`, `<a href="https://doi.org/10.1000/182">`, "javascript:alert(1)</li>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("references lack %s:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `href="javascript:`) {
+		t.Errorf("javascript: URL linked:\n%s", got)
+	}
+}
+
+func TestSlowRenderShownAsSource(t *testing.T) {
+	src := []byte("# Slow\n")
+	key := sha256.Sum256(src)
+	slowRenders.Lock()
+	slowRenders.running[key] = true
+	slowRenders.Unlock()
+	defer func() {
+		slowRenders.Lock()
+		delete(slowRenders.running, key)
+		slowRenders.Unlock()
+	}()
+	out, err := convertLimited(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `<pre class="render-limit-source"># Slow`) || strings.Contains(string(out), "<h1") {
+		t.Errorf("a source still rendering was rendered again:\n%s", out)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	for _, tc := range []struct {
+		style, in, want string
+	}{
+		{"ascii", "Café au lait", "caf-au-lait"},
+		{"translit", "Café au lait", "cafe-au-lait"},
+		{"unicode", "Café au lait", "café-au-lait"},
+		{"translit", "Straße & Œuvre", "strasse--oeuvre"},
+		{"translit", "Łódź, Ørsted, Þór", "lodz-orsted-thor"},
+		{"translit", "İstanbul Ñandú", "istanbul-nandu"},
+		{"ascii", "東京 タワー", "-"},
+		{"translit", "東京 タワー", "-"},
+		{"unicode", "東京 タワー", "東京-タワー"},
+		{"unicode", "Ωμέγα 2", "ωμέγα-2"},
+		{"unicode", "x² ½", "x²-½"},
+		{"unicode", "emoji 🎉 here", "emoji--here"},
+		{"ascii", "  snake_case-and-dash  ", "snake-case-and-dash"},
+	} {
+		if got := slugify(tc.in, tc.style); got != tc.want {
+			t.Errorf("slugify(%q, %s) = %q, want %q", tc.in, tc.style, got, tc.want)
+		}
+	}
+}
+
+func TestDuplicateAnchors(t *testing.T) {
+	old := anchorStyle
+	defer func() { anchorStyle = old }()
+	src := []byte("# Café\n\n# Café\n\n# Café-1\n\n# 東京\n\n# 東京\n\n# !!!\n")
+	idRe := regexp.MustCompile(`<h1 id="([^"]*)"`)
+	ids := func(opts ...parser.ParseOption) string {
+		var buf bytes.Buffer
+		if err := newMarkdown(defaultRenderer).Convert(src, &buf, opts...); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range idRe.FindAllStringSubmatch(buf.String(), -1) {
+			got = append(got, m[1])
+		}
+		return strings.Join(got, " ")
+	}
+	for style, want := range map[string]string{
+		"ascii":    "caf caf-1 caf-1-1 heading heading-1 heading-2",
+		"translit": "cafe cafe-1 cafe-1-1 heading heading-1 heading-2",
+		"unicode":  "café café-1 café-1-1 東京 東京-1 heading",
+	} {
+		anchorStyle = style
+		gen := &headingIDs{values: make(map[string]bool)}
+		if got := ids(parser.WithContext(parser.NewContext(parser.WithIDs(gen)))); got != want {
+			t.Errorf("%s: ids %q, want %q", style, got, want)
+		}
+	}
+	// The ascii style keeps goldmark's IDs, which links may already use.
+	anchorStyle = "ascii"
+	if got, want := ids(parseOptions(map[string]string{})...), ids(); got != want {
+		t.Errorf("ascii ids %q, goldmark's %q", got, want)
+	}
+}
+
+func TestDiffOutlines(t *testing.T) {
+	old := documentOutline([]byte("# API\n\n## Install\n\nRun go install to get the binary.\n\n## Usage\n\nCall it.\n\n## Legacy\n\nGone soon.\n"))
+	updated := documentOutline([]byte("# API\n\n## Usage\n\nCall it.\n\n## Installation\n\nRun go install to get the binary now.\n\n## Configuration\n"))
+	d := diffOutlines(old, updated)
+	if len(d.Renamed) != 1 || d.Renamed[0].Old.ID != "install" || d.Renamed[0].New.ID != "installation" {
+		t.Errorf("renamed: %+v", d.Renamed)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Text != "Legacy" || d.Removed[0].Line != 11 {
+		t.Errorf("removed: %+v", d.Removed)
+	}
+	if len(d.Added) != 1 || d.Added[0].Text != "Configuration" {
+		t.Errorf("added: %+v", d.Added)
+	}
+}
+
+func TestTeXToMathML(t *testing.T) {
+	tests := []struct{ tex, want string }{
+		{`x^2_i`, `<msubsup><mi>x</mi><mi>i</mi><mn>2</mn></msubsup>`},
+		{`\frac{a}{b+1}`, `<mfrac><mi>a</mi><mrow><mi>b</mi><mo>+</mo><mn>1</mn></mrow></mfrac>`},
+		{`\sum_{k}^{n}`, `<munderover><mo movablelimits="true">∑</mo><mi>k</mi><mi>n</mi></munderover>`},
+		{`\mathbb{N} \mathcal{L} \mathbf{v}`, `<mi>ℕ</mi><mi>ℒ</mi><mi>𝐯</mi>`},
+		{`\Gamma`, `<mi mathvariant="normal">Γ</mi>`},
+		{`\begin{bmatrix}1&2\\3&4\end{bmatrix}`, `<mtr><mtd><mn>3</mn></mtd><mtd><mn>4</mn></mtd></mtr></mtable><mo fence="true" stretchy="true">]</mo>`},
+		{`\text{a<b}`, `<mtext>a&lt;b</mtext>`},
+		{`\frob x`, `<merror><mtext>\frob</mtext></merror>`},
+	}
+	for _, tt := range tests {
+		if got := texToMathML(tt.tex, false); !strings.Contains(got, tt.want) {
+			t.Errorf("texToMathML(%q) = %s, want it to contain %s", tt.tex, got, tt.want)
+		}
+	}
+	// Malformed input still gives one closed <math> element.
+	for _, tex := range []string{`{{{`, `}}\end{x}`, `\left(`, `x^`, `\sqrt[`, `\begin{matrix}`, `\`, strings.Repeat("{", 10000)} {
+		got := texToMathML(tex, true)
+		if !strings.HasPrefix(got, "<math") || !strings.HasSuffix(got, "</math>") {
+			t.Errorf("texToMathML(%q) = %s", tex, got)
+		}
+	}
+}
+
+func TestCombinedLinks(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"c1.md":  "## Setup\n\n[here](#setup) [there](c2.md#usage) [own](c2.md#own) [c2](c2.md#setup) [web](https://x.test/c2.md#setup)\n",
+		"c2.md":  "## Setup {#own}\n\n## Usage\n\n[back](c1.md#setup) [own](#own) [usage](#usage)\n",
+		"a-b.md": "x\n",
+		"a.b.md": "y\n",
+	}
+	var paths []string
+	var data [][]byte
+	for _, name := range []string{"c1.md", "c2.md", "a-b.md", "a.b.md"} {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, []byte(files[name]), 0o644)
+		paths = append(paths, p)
+		data = append(data, []byte(files[name]))
+	}
+	oldPaths := inputPaths
+	inputPaths = paths
+	setFileSections(paths)
+	defer func() { inputPaths = oldPaths; setFileSections(nil) }()
+
+	var buf bytes.Buffer
+	if err := markdown().Convert(combineInputs(paths, data), &buf, parseOptions(fileSections)...); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	c1, c2 := fileHeaderID(paths[0]), fileHeaderID(paths[1])
+	p1, p2 := fileSections[c1], fileSections[c2]
+	for _, want := range []string{
+		`<a href="#` + p1 + `setup">here</a>`,
+		`<a href="#` + p2 + `usage">there</a>`,
+		`<a href="#own">own</a> <a href="#` + c2 + `">c2</a>`,
+		`href="https://x.test/c2.md#setup"`,
+		`<a href="#` + p1 + `setup">back</a>`,
+		`<a href="#own">own</a>`,
+		`<a href="#` + p2 + `usage">usage</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("combined output lacks %s:\n%s", want, got)
+		}
+	}
+	if a, b := fileHeaderID(paths[2]), fileHeaderID(paths[3]); a == b || fileSections[a] == fileSections[b] {
+		t.Errorf("a-b.md and a.b.md share header ID %s", a)
+	}
+}
+
+// firstDifference describes the first line where got and want differ.
+func firstDifference(want, got []byte) string {
+	wl, gl := strings.Split(string(want), "\n"), strings.Split(string(got), "\n")
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d:\n  want: %s\n  got:  %s", i+1, w, g)
+		}
+	}
+	return "(trailing newline differs)"
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	emoji "github.com/yuin/goldmark-emoji"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"gopkg.in/yaml.v3"
+)
+
+// Renderer options can change while the server runs: from the settings
+// panel, or from the --config file, which is re-read on SIGHUP. A change
+// builds a new goldmark instance and swaps it in under mu, then gives the
+// documents new versions so cached renders are dropped and open pages
+// reload.
+
+// rendererOptions are the settings that need a new goldmark instance, plus
+// the page's default highlight style.
+type rendererOptions struct {
+	Unsafe     bool     `json:"unsafe" yaml:"unsafe"`         // pass raw HTML through, else sanitize it
+	Extensions []string `json:"extensions" yaml:"extensions"` // from optionalExtensions
+	Highlight  string   `json:"highlight" yaml:"highlight"`   // Chroma style, or "light,dark"; "" keeps the built-in one
+}
+
+// optionalExtensions are the goldmark extensions that can be switched;
+// mdview's own syntax (containers, collapsibles, blocks...) is always on.
+var optionalExtensions = []struct {
+	name string
+	ext  goldmark.Extender
+}{
+	{"table", extension.Table},
+	{"strikethrough", extension.Strikethrough},
+	{"linkify", extension.Linkify},
+	{"tasklist", extension.TaskList},
+	{"footnote", extension.Footnote},
+	{"definition-list", extension.DefinitionList},
+	{"typographer", extension.Typographer},
+	{"math", MathExtension},
+	{"emoji", emoji.Emoji},
+}
+
+// defaultRenderer is GitHub-flavored Markdown, footnotes, math and :emoji:
+// shortcodes included, with raw HTML allowed.
+var defaultRenderer = rendererOptions{
+	Unsafe:     true,
+	Extensions: []string{"table", "strikethrough", "linkify", "tasklist", "footnote", "math", "emoji"},
+}
+
+var (
+	configPath    string          // --config
+	highlightFlag string          // --highlight-style
+	renderOptions rendererOptions // current options, under mu
+)
+
+func (o rendererOptions) validate() error {
+	for _, name := range o.Extensions {
+		found := false
+		for _, e := range optionalExtensions {
+			found = found || e.name == name
+		}
+		if !found {
+			return fmt.Errorf("unknown extension %q", name)
+		}
+	}
+	return checkHighlight(o.Highlight)
+}
+
+// newMarkdown builds the Markdown converter for o.
+func newMarkdown(o rendererOptions) goldmark.Markdown {
+	exts := []goldmark.Extender{
+		FrontMatters,
+		Containers,
+		Alerts,
+		Collapsibles,
+		Glossary,
+		Citations,
+		WikiLinks,
+		Blocks,
+		Figures,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle("github"),
+			highlighting.WithFormatOptions(
+				chromahtml.WithClasses(true),
+			),
+			highlighting.WithWrapperRenderer(codeBlockWrapper(o.Unsafe)),
+		),
+	}
+	for _, e := range optionalExtensions {
+		if contains(o.Extensions, e.name) {
+			exts = append(exts, e.ext)
+		}
+	}
+	var rendererOpts []goldmark.Option
+	if o.Unsafe {
+		rendererOpts = append(rendererOpts, goldmark.WithRendererOptions(html.WithUnsafe()))
+	} else {
+		exts = append(exts, SafeHTML)
+	}
+	return goldmark.New(append([]goldmark.Option{
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithAttribute(),
+			parser.WithASTTransformers(depthLimit, spanAttributes, codeInfoAttributes, externalLinkAttributes, timelineAttributes, definitionAttributes, sourceLines, combinedLinks),
+		),
+	}, rendererOpts...)...)
+}
+
+// markdown returns the current converter.
+func markdown() goldmark.Markdown {
+	mu.RLock()
+	defer mu.RUnlock()
+	return md
+}
+
+func currentRenderer() rendererOptions {
+	mu.RLock()
+	defer mu.RUnlock()
+	return renderOptions
+}
+
+// setRenderer switches to o and reloads open pages.
+func setRenderer(o rendererOptions) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if htmlMode == "safe" {
+		o.Unsafe = false
+	}
+	if o.Extensions == nil {
+		o.Extensions = []string{}
+	}
+	m := newMarkdown(o)
+	mu.Lock()
+	md, renderOptions = m, o
+	mu.Unlock()
+	docs.Invalidate()
+	return nil
+}
+
+// A configFile is what --config holds: the renderer options, plus named
+// profiles (see profiles.go).
+type configFile struct {
+	rendererOptions `yaml:",inline"`
+	Profiles        map[string]profile `yaml:"profiles"`
+	Theme           string             `yaml:"theme"`
+}
+
+// loadConfig reads a YAML (or JSON) config file; fields it leaves out keep
+// their defaults.
+func loadConfig(path string) (*configFile, error) {
+	c := &configFile{rendererOptions: defaultRenderer}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := checkTheme(c.Theme); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range c.Profiles {
+		if err := p.renderer(c.rendererOptions).validate(); err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+		if err := checkTheme(p.Theme); err != nil {
+			return nil, fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+	}
+	return c, nil
+}
+
+// applyConfig reads --config, if given, and applies it with the active
+// profile. It runs at startup and on SIGHUP.
+func applyConfig() error {
+	c := &configFile{rendererOptions: defaultRenderer}
+	if configPath != "" {
+		var err error
+		if c, err = loadConfig(configPath); err != nil {
+			return err
+		}
+	}
+	if highlightFlag != "" {
+		c.Highlight = highlightFlag
+	}
+	return setProfiles(c)
+}
+
+// reloadOnHangup re-reads --config whenever the process gets SIGHUP. A bad
+// file is reported and the running options kept.
+func reloadOnHangup(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			if err := applyConfig(); err != nil {
+				fmt.Fprintf(os.Stderr, "mdview: reloading config: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Reloaded %s\n", configPath)
+			bus.Publish(Event{Type: EventToast, Data: "Reloaded " + filepath.Base(configPath)})
+		}
+	}
+}
+
+// handleRenderer serves /api/renderer: GET returns the current options and
+// the extensions to choose from, POST replaces the options. Changes last
+// until the server stops or --config is reloaded.
+func handleRenderer(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		names := make([]string, len(optionalExtensions))
+		for i, e := range optionalExtensions {
+			names[i] = e.name
+		}
+		writeJSON(w, map[string]interface{}{"options": currentRenderer(), "extensions": names})
+	case http.MethodPost:
+		if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" || isViewer(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var o rendererOptions
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&o); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := setRenderer(o); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"options": currentRenderer()})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
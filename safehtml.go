@@ -0,0 +1,259 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Raw HTML in a document is passed through by default, which is fine for
+// one's own notes but lets a downloaded README run scripts in the browser.
+// With raw HTML off (--safe, unsafe: false in a config, or the ⚙ menu) it
+// is sanitized instead of dropped: elements and attributes outside an
+// allowlist are removed (script and style with their content), URLs are
+// limited to http, https, mailto and relative ones, and stray markup is
+// escaped, so <details>, <kbd>, tables and images keep working. Other files
+// of the tree, like .html and .svg, are then served sandboxed. Stdin,
+// archives and remote inputs (git repositories, buckets) are safe as if
+// --safe were given; --unsafe opts back in. Safe mode can't be undone from
+// the ⚙ menu, a profile or --config.
+
+// htmlMode is "safe" for --safe, "unsafe" for --unsafe, or "" to go by the
+// input.
+var htmlMode string
+
+// setHTMLMode handles --safe and --unsafe; the last one given wins.
+func setHTMLMode(v, mode string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	if on {
+		htmlMode = mode
+	} else if htmlMode == mode {
+		htmlMode = ""
+	}
+	return nil
+}
+
+// untrustedInput reports whether args name input from stdin, the network
+// or an archive rather than the user's own files.
+func untrustedInput(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	if len(args) > 1 {
+		return false
+	}
+	if isRepoSpec(args[0]) {
+		return true
+	}
+	info, err := os.Stat(args[0])
+	return isStoreSpec(args[0]) && (err != nil || !info.IsDir())
+}
+
+// trustInput sets whether raw HTML passes through by default, from
+// --safe/--unsafe or, without them, whether the input is untrusted, which
+// then binds like --safe.
+func trustInput(untrusted bool) {
+	if htmlMode == "" && untrusted {
+		htmlMode = "safe"
+	}
+	switch htmlMode {
+	case "safe":
+		defaultRenderer.Unsafe = false
+	case "unsafe":
+		defaultRenderer.Unsafe = true
+	}
+}
+
+// safeElements are the elements kept.
+var safeElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "blockquote": true, "br": true,
+	"caption": true, "center": true, "cite": true, "code": true, "col": true, "colgroup": true,
+	"dd": true, "del": true, "details": true, "dfn": true, "div": true, "dl": true, "dt": true,
+	"em": true, "figcaption": true, "figure": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true, "li": true,
+	"mark": true, "ol": true, "p": true, "picture": true, "pre": true, "q": true, "rp": true,
+	"rt": true, "ruby": true, "s": true, "samp": true, "small": true, "source": true, "span": true,
+	"strike": true, "strong": true, "sub": true, "summary": true, "sup": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "time": true, "tr": true,
+	"tt": true, "u": true, "ul": true, "var": true, "wbr": true,
+}
+
+// safeAttributes are allowed on any kept element; href, src, cite and
+// srcset only where htmlPolicy checks their URLs.
+var safeAttributes = map[string]bool{
+	"align": true, "alt": true, "colspan": true, "datetime": true, "dir": true,
+	"height": true, "id": true, "lang": true, "media": true,
+	"open": true, "reversed": true, "rowspan": true, "sizes": true, "span": true,
+	"start": true, "title": true, "type": true, "valign": true, "width": true,
+}
+
+// droppedWithContent are elements whose content goes too.
+var droppedWithContent = map[string]bool{
+	"script": true, "style": true, "template": true, "textarea": true, "title": true,
+	"noscript": true, "noembed": true, "noframes": true, "xmp": true, "iframe": true, "object": true,
+}
+
+// safeURL reports whether u may be linked to or loaded.
+func safeURL(u string) bool {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, html.UnescapeString(u))
+	p, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(p.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// htmlPolicy is the allowlist raw HTML is sanitized against. bluemonday
+// checks href on links, src on images and cite against the URL schemes;
+// srcset, which it doesn't parse, has to match srcsetPattern.
+var htmlPolicy = newHTMLPolicy()
+
+func newHTMLPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	for name := range safeElements {
+		p.AllowElements(name)
+	}
+	for name := range safeAttributes {
+		p.AllowAttrs(name).Globally()
+	}
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("src").OnElements("img")
+	p.AllowAttrs("cite").OnElements("blockquote", "q", "del", "ins")
+	p.AllowAttrs("srcset").Matching(srcsetPattern).OnElements("img", "source")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(true)
+	p.RequireParseableURLs(true)
+	for name := range droppedWithContent {
+		p.SkipElementsContent(name)
+	}
+	return p
+}
+
+// srcsetPattern matches a srcset whose URLs are all http, https or
+// relative, each with an optional width or density.
+var srcsetPattern = regexp.MustCompile(`^\s*(?:(?i:https?:)[^\s,]*|[^\s,:/?#]*(?:[/?#][^\s,]*)?)(?:\s+[\d.]+[wx])?\s*(?:,\s*(?:(?i:https?:)[^\s,]*|[^\s,:/?#]*(?:[/?#][^\s,]*)?)(?:\s+[\d.]+[wx])?\s*)*$`)
+
+// sanitizeHTML returns raw HTML with only the allowlisted markup left.
+func sanitizeHTML(raw []byte) []byte {
+	return htmlPolicy.SanitizeBytes(raw)
+}
+
+type safeHTMLRenderer struct{}
+
+func (r *safeHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindHTMLBlock, r.renderHTMLBlock)
+	reg.Register(ast.KindRawHTML, r.renderRawHTML)
+}
+
+func (r *safeHTMLRenderer) renderHTMLBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.HTMLBlock)
+	var raw []byte
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		raw = append(raw, line.Value(source)...)
+	}
+	if n.HasClosure() {
+		raw = append(raw, n.ClosureLine.Value(source)...)
+	}
+	w.Write(sanitizeHTML(raw))
+	return ast.WalkContinue, nil
+}
+
+func (r *safeHTMLRenderer) renderRawHTML(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	n := node.(*ast.RawHTML)
+	var raw []byte
+	for i := 0; i < n.Segments.Len(); i++ {
+		segment := n.Segments.At(i)
+		raw = append(raw, segment.Value(source)...)
+	}
+	w.Write(sanitizeHTML(raw))
+	return ast.WalkSkipChildren, nil
+}
+
+// unsafeAttribute reports whether a node attribute, which `{...}` lists
+// and info strings let a document set, could run script or load
+// something: event handlers, styles, and URLs outside safeURL's.
+func unsafeAttribute(name string, value []byte) bool {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(name, "on"), name == "style", name == "srcdoc", name == "formaction":
+		return true
+	case name == "href", name == "cite", name == "action", name == "poster", name == "xlink:href", strings.HasPrefix(name, "src"):
+		for _, c := range strings.Split(string(value), ",") {
+			if f := strings.Fields(c); len(f) > 0 && !safeURL(f[0]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// safeAttributeTransformer drops the unsafe attributes of every node, last,
+// once the other transformers have moved them where they render.
+type safeAttributeTransformer struct{}
+
+func (t *safeAttributeTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		attrs := n.Attributes()
+		for _, a := range attrs {
+			if unsafeAttribute(string(a.Name), attrValue(a.Value)) {
+				n.RemoveAttributes()
+				for _, a := range attrs {
+					if !unsafeAttribute(string(a.Name), attrValue(a.Value)) {
+						n.SetAttribute(a.Name, a.Value)
+					}
+				}
+				break
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+type safeHTMLExtension struct{}
+
+// SafeHTML is a goldmark.Extender rendering raw HTML sanitized, and
+// node attributes without handlers; newMarkdown adds it when raw HTML is
+// off.
+var SafeHTML goldmark.Extender = &safeHTMLExtension{}
+
+func (e *safeHTMLExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&safeAttributeTransformer{}, 2000),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&safeHTMLRenderer{}, 500),
+	))
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// The "schema" block renderer draws an entity diagram above fenced `sql`
+// blocks holding CREATE TABLE statements and `proto` blocks holding
+// messages (--blocks schema). Each diagram can be hidden from its block.
+
+// A schemaEntity is a table, message or enum box in the diagram.
+type schemaEntity struct {
+	Name   string
+	Kind   string // "table", "message" or "enum"
+	Fields []schemaField
+}
+
+type schemaField struct {
+	Name string
+	Type string
+	Key  bool   // primary key
+	Ref  string // entity this field references
+}
+
+func (e *schemaEntity) field(name string) *schemaField {
+	for i := range e.Fields {
+		if strings.EqualFold(e.Fields[i].Name, name) {
+			return &e.Fields[i]
+		}
+	}
+	return nil
+}
+
+var (
+	sqlComment    = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	sqlCreate     = regexp.MustCompile(`(?i)\bcreate\s+(?:(?:global\s+|local\s+)?(?:temporary|temp)\s+)?table\s+(?:if\s+not\s+exists\s+)?([^\s(]+)\s*\(`)
+	sqlReferences = regexp.MustCompile(`(?i)\breferences\s+([^\s(]+)`)
+	sqlPrimaryKey = regexp.MustCompile(`(?i)^primary\s+key\s*\(([^)]*)\)`)
+	sqlForeignKey = regexp.MustCompile(`(?i)^foreign\s+key\s*\(([^)]*)\)\s*references\s+([^\s(]+)`)
+	sqlTableLevel = regexp.MustCompile(`(?i)^(constraint|primary|foreign|unique|check|key|index|exclude)\b`)
+)
+
+// unquoteIdent strips double quote, backtick or bracket quoting and any
+// schema qualifier.
+func unquoteIdent(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '.'); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.Trim(s, "\"`[]")
+}
+
+// splitTopLevel splits s on sep outside parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseSQLSchema reads the CREATE TABLE statements of a DDL script.
+func parseSQLSchema(src string) []*schemaEntity {
+	src = sqlComment.ReplaceAllString(src, "")
+	var tables []*schemaEntity
+	for _, m := range sqlCreate.FindAllStringSubmatchIndex(src, -1) {
+		t := &schemaEntity{Name: unquoteIdent(src[m[2]:m[3]]), Kind: "table"}
+		depth, end := 1, -1
+		for i := m[1]; i < len(src) && end < 0; i++ {
+			switch src[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		var constraints []string
+		for _, def := range splitTopLevel(src[m[1]:end], ',') {
+			def = strings.Join(strings.Fields(def), " ")
+			if def == "" {
+				continue
+			}
+			// CONSTRAINT name PRIMARY KEY (...) reads like PRIMARY KEY (...).
+			if strings.HasPrefix(strings.ToLower(def), "constraint ") {
+				if f := strings.SplitN(def, " ", 3); len(f) == 3 {
+					def = f[2]
+				}
+			}
+			if sqlTableLevel.MatchString(def) {
+				constraints = append(constraints, def)
+				continue
+			}
+			name, rest, _ := strings.Cut(def, " ")
+			f := schemaField{Name: unquoteIdent(name)}
+			lower := strings.ToLower(rest)
+			f.Type, _, _ = strings.Cut(lower, " ")
+			if strings.HasPrefix(lower, "double precision") {
+				f.Type = "double precision"
+			}
+			f.Key = strings.Contains(lower, "primary key")
+			if r := sqlReferences.FindStringSubmatch(rest); r != nil {
+				f.Ref = unquoteIdent(r[1])
+			}
+			t.Fields = append(t.Fields, f)
+		}
+		for _, c := range constraints {
+			if pk := sqlPrimaryKey.FindStringSubmatch(c); pk != nil {
+				for _, col := range strings.Split(pk[1], ",") {
+					if f := t.field(unquoteIdent(col)); f != nil {
+						f.Key = true
+					}
+				}
+			}
+			if fk := sqlForeignKey.FindStringSubmatch(c); fk != nil {
+				for _, col := range strings.Split(fk[1], ",") {
+					if f := t.field(unquoteIdent(col)); f != nil {
+						f.Ref = unquoteIdent(fk[2])
+					}
+				}
+			}
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+var (
+	protoComment = regexp.MustCompile(`(?s)//[^\n]*|/\*.*?\*/`)
+	protoBlock   = regexp.MustCompile(`^\s*(message|enum|oneof|service|extend)\s+([\w.]+)\s*\{`)
+	protoField   = regexp.MustCompile(`^\s*(?:(repeated|optional|required)\s+)?(map\s*<[^>]+>|[\w.]+)\s+(\w+)\s*=\s*\d+`)
+	protoValue   = regexp.MustCompile(`^\s*(\w+)\s*=\s*-?\w+`)
+	protoTypeRe  = regexp.MustCompile(`[\w.]+`)
+)
+
+// parseProtoSchema reads the messages and enums of a .proto file, nested
+// ones named Outer.Inner. Oneof fields belong to the enclosing message.
+func parseProtoSchema(src string) []*schemaEntity {
+	src = protoComment.ReplaceAllString(src, "")
+	var entities []*schemaEntity
+	// One frame per open brace. Oneofs have no entity of their own; the
+	// insides of options, services and the like are skipped.
+	type frame struct {
+		entity *schemaEntity
+		prefix string
+		skip   bool
+	}
+	stack := []frame{{}}
+	top := func() frame { return stack[len(stack)-1] }
+	owner := func() *schemaEntity {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].skip {
+				return nil
+			}
+			if stack[i].entity != nil {
+				return stack[i].entity
+			}
+		}
+		return nil
+	}
+	// Split into statements at ; { and }, keeping the braces.
+	var stmt strings.Builder
+	for _, r := range src {
+		switch r {
+		case '{':
+			s := stmt.String() + "{"
+			stmt.Reset()
+			m := protoBlock.FindStringSubmatch(s)
+			f := frame{prefix: top().prefix}
+			switch {
+			case m != nil && (m[1] == "message" || m[1] == "enum"):
+				f.entity = &schemaEntity{Name: top().prefix + m[2], Kind: m[1]}
+				f.prefix = f.entity.Name + "."
+				entities = append(entities, f.entity)
+			case m == nil || m[1] != "oneof":
+				f.skip = true
+			}
+			stack = append(stack, f)
+		case '}':
+			stmt.Reset()
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case ';':
+			s := stmt.String()
+			stmt.Reset()
+			e := owner()
+			if e == nil {
+				continue
+			}
+			if e.Kind == "enum" {
+				if m := protoValue.FindStringSubmatch(s); m != nil && m[1] != "option" && m[1] != "reserved" {
+					e.Fields = append(e.Fields, schemaField{Name: m[1]})
+				}
+			} else if m := protoField.FindStringSubmatch(s); m != nil && m[2] != "option" && m[2] != "reserved" {
+				typ := strings.Join(strings.Fields(m[2]), "")
+				if m[1] == "repeated" {
+					typ = "repeated " + typ
+				}
+				e.Fields = append(e.Fields, schemaField{Name: m[3], Type: typ})
+			}
+		default:
+			stmt.WriteRune(r)
+		}
+	}
+
+	// Resolve field types to the boxes they name, innermost scope first
+	// like protoc; map<K, V> references V.
+	byName := make(map[string]*schemaEntity, len(entities))
+	for _, e := range entities {
+		byName[e.Name] = e
+	}
+	for _, e := range entities {
+		if e.Kind != "message" {
+			continue
+		}
+		for i := range e.Fields {
+			f := &e.Fields[i]
+			names := protoTypeRe.FindAllString(strings.TrimPrefix(f.Type, "repeated "), -1)
+			if len(names) == 0 {
+				continue
+			}
+			f.Ref = resolveProtoType(byName, e.Name, strings.TrimPrefix(names[len(names)-1], "."))
+		}
+	}
+	return entities
+}
+
+// resolveProtoType finds the entity a type name used in scope refers to:
+// nested scopes first, then top level, then with package qualifiers
+// dropped (only one file is known, so any package matches).
+func resolveProtoType(byName map[string]*schemaEntity, scope, name string) string {
+	for {
+		if byName[scope+"."+name] != nil {
+			return scope + "." + name
+		}
+		i := strings.LastIndexByte(scope, '.')
+		if i < 0 {
+			break
+		}
+		scope = scope[:i]
+	}
+	for {
+		if byName[name] != nil {
+			return name
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return ""
+		}
+		name = name[i+1:]
+	}
+}
+
+// Diagram geometry, in SVG user units. Text is monospace, so widths can
+// be estimated from character counts.
+const (
+	schemaCharWidth = 7.2
+	schemaRowHeight = 18
+	schemaHeader    = 24
+	schemaGap       = 48
+	schemaBend      = 40 // how far reference curves swing out
+	schemaColumns   = 3
+)
+
+type schemaBox struct {
+	x, y, w, h float64
+}
+
+// schemaSVG lays the entities out on a grid and draws references as
+// curves from the referencing row to the referenced box.
+func schemaSVG(entities []*schemaEntity) string {
+	boxes := make(map[string]*schemaBox, len(entities))
+	var width, y, rowHeight float64
+	x := 0.0
+	for i, e := range entities {
+		if i > 0 && i%schemaColumns == 0 {
+			y += rowHeight + schemaGap
+			x, rowHeight = 0, 0
+		}
+		chars := len(e.Name) + 2
+		for _, f := range e.Fields {
+			if n := len(f.Name) + len(f.Type) + 4; n > chars {
+				chars = n
+			}
+		}
+		if chars > 48 {
+			chars = 48
+		}
+		b := &schemaBox{x: x, y: y, w: float64(chars)*schemaCharWidth + 16, h: schemaHeader + float64(len(e.Fields))*schemaRowHeight + 6}
+		boxes[e.Name] = b
+		x += b.w + schemaGap
+		if b.x+b.w > width {
+			width = b.x + b.w
+		}
+		if b.h > rowHeight {
+			rowHeight = b.h
+		}
+	}
+	// Room for curves looping out of the rightmost column.
+	width += schemaBend
+	height := y + rowHeight
+
+	var s strings.Builder
+	fmt.Fprintf(&s, `<svg xmlns="http://www.w3.org/2000/svg" class="schema-svg" viewBox="-2 -2 %.0f %.0f" width="%.0f" role="img" aria-label="Schema diagram">`,
+		width+4, height+4, width+4)
+	for _, e := range entities {
+		from := boxes[e.Name]
+		for i, f := range e.Fields {
+			to := boxes[f.Ref]
+			if to == nil {
+				continue
+			}
+			y1 := from.y + schemaHeader + float64(i)*schemaRowHeight + schemaRowHeight/2
+			y2 := to.y + schemaHeader/2
+			var x1, c1, c2, x2 float64
+			switch {
+			case to.x >= from.x+from.w: // to the right
+				x1, x2 = from.x+from.w, to.x
+				c1, c2 = x1+schemaBend, x2-schemaBend
+			case to.x+to.w <= from.x: // to the left
+				x1, x2 = from.x, to.x+to.w
+				c1, c2 = x1-schemaBend, x2+schemaBend
+			default: // same column, or itself: loop around the right
+				x1, x2 = from.x+from.w, to.x+to.w
+				c1, c2 = x1+schemaBend, x2+schemaBend
+			}
+			fmt.Fprintf(&s, `<path class="schema-edge" d="M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f"/>`,
+				x1, y1, c1, y1, c2, y2, x2, y2)
+			// The curve ends horizontally, so the arrowhead is too. (No
+			// <marker>: those break when another diagram is hidden.)
+			d := 8.0
+			if c2 > x2 {
+				d = -8
+			}
+			fmt.Fprintf(&s, `<path class="schema-arrow" d="M%.1f,%.1f L%.1f,%.1f L%.1f,%.1f z"/>`,
+				x2, y2, x2-d, y2-4, x2-d, y2+4)
+		}
+	}
+	for _, e := range entities {
+		b := boxes[e.Name]
+		fmt.Fprintf(&s, `<g class="schema-entity %s"><rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" rx="4"/>`, e.Kind, b.x, b.y, b.w, b.h)
+		fmt.Fprintf(&s, `<path class="schema-head" d="M%.1f,%.1f h%.1f"/>`, b.x, b.y+schemaHeader, b.w)
+		fmt.Fprintf(&s, `<text class="schema-name" x="%.1f" y="%.1f">%s</text>`, b.x+8, b.y+16, html.EscapeString(e.Name))
+		for i, f := range e.Fields {
+			ty := b.y + schemaHeader + float64(i+1)*schemaRowHeight - 4
+			name := f.Name
+			if f.Key {
+				name = "🔑" + name
+			}
+			fmt.Fprintf(&s, `<text x="%.1f" y="%.1f"><tspan class="schema-field">%s</tspan>`, b.x+8, ty, html.EscapeString(name))
+			if f.Type != "" {
+				fmt.Fprintf(&s, `<tspan class="schema-type" x="%.1f" text-anchor="end">%s</tspan>`, b.x+b.w-8, html.EscapeString(f.Type))
+			}
+			s.WriteString("</text>")
+		}
+		s.WriteString("</g>")
+	}
+	s.WriteString("</svg>")
+	return s.String()
+}
+
+// schemaBlock renders sql and proto fences with a diagram above them.
+type schemaBlock struct{}
+
+func init() {
+	registerBlock("schema", []string{"sql", "proto", "protobuf"}, false, schemaBlock{})
+}
+
+// Prepare returns the diagram's SVG, or nil for queries and the like,
+// which have nothing to draw.
+func (schemaBlock) Prepare(fcb *ast.FencedCodeBlock, lang string, code []byte) interface{} {
+	var entities []*schemaEntity
+	if lang == "sql" {
+		entities = parseSQLSchema(string(code))
+	} else {
+		entities = parseProtoSchema(string(code))
+	}
+	if len(entities) == 0 {
+		return nil
+	}
+	return schemaSVG(entities)
+}
+
+func (schemaBlock) Render(w util.BufWriter, state interface{}, entering bool) ast.WalkStatus {
+	if !entering {
+		w.WriteString("</div>\n")
+		return ast.WalkContinue
+	}
+	w.WriteString(`<div class="schema-block">` + "\n")
+	w.WriteString(`<div class="schema-bar"><button type="button" class="schema-toggle" aria-expanded="true">Hide diagram</button></div>` + "\n")
+	w.WriteString(`<div class="schema-diagram">`)
+	w.WriteString(state.(string))
+	w.WriteString("</div>\n")
+	return ast.WalkContinue
+}
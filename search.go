@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+var (
+	// inputPaths are the files given on the command line, in order.
+	inputPaths []string
+
+	// editable allows the browser to modify the source files (--editable).
+	editable bool
+)
+
+// A searchMatch is one line of an input file matching a search.
+type searchMatch struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Text     string `json:"text"`
+	Replaced string `json:"replaced,omitempty"`
+}
+
+// compileSearch turns a query into a regexp; literal queries are quoted.
+func compileSearch(query string, isRegex bool) (*regexp.Regexp, error) {
+	if !isRegex {
+		query = regexp.QuoteMeta(query)
+	}
+	return regexp.Compile("(?m)" + query)
+}
+
+// searchFiles returns the lines of paths matching re. When replacement is
+// non-nil each match also carries the line as it would read after replacing.
+func searchFiles(paths []string, re *regexp.Regexp, replacement *string, literal bool) ([]searchMatch, error) {
+	var matches []searchMatch
+	for _, p := range paths {
+		data, err := readSource(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", p, err)
+		}
+		for i, line := range bytes.Split(data, []byte("\n")) {
+			line = bytes.TrimSuffix(line, []byte("\r"))
+			if !re.Match(line) {
+				continue
+			}
+			m := searchMatch{File: p, Line: i + 1, Text: string(line)}
+			if replacement != nil {
+				m.Replaced = string(replaceAll(re, line, *replacement, literal))
+			}
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// replaceAll replaces every match of re in src line by line, as
+// searchFiles previews them. Lines are matched without their CR, so `$`
+// behaves the same on Windows-edited files, and each keeps its own ending:
+// a file mixing LF and CRLF only changes where something was replaced.
+func replaceAll(re *regexp.Regexp, src []byte, replacement string, literal bool) []byte {
+	lines := bytes.Split(src, []byte("\n"))
+	for i, line := range lines {
+		cr := bytes.HasSuffix(line, []byte("\r"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if !re.Match(line) {
+			continue
+		}
+		if literal {
+			line = re.ReplaceAllLiteral(line, []byte(replacement))
+		} else {
+			line = re.ReplaceAll(line, []byte(replacement))
+		}
+		if cr {
+			line = append(line, '\r')
+		}
+		lines[i] = line
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// sameOrigin rejects cross-site requests to the state-changing endpoints:
+// the page and its scripts are always served from the same origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// handleSearch serves GET /api/search?q=...&regex=1 across the input files.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	re, err := compileSearch(q.Get("q"), q.Get("regex") == "1")
+	if err != nil || q.Get("q") == "" {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+		return
+	}
+	matches, err := searchFiles(inputPaths, re, nil, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"matches": matches})
+}
+
+// handleReplace serves POST /api/replace. Without "apply" it only previews
+// the changed lines; with it (and --editable) the files are rewritten and
+// the watcher pushes the reload.
+func handleReplace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Query   string `json:"query"`
+		Replace string `json:"replace"`
+		Regex   bool   `json:"regex"`
+		Apply   bool   `json:"apply"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Query == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	re, err := compileSearch(req.Query, req.Regex)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	matches, err := searchFiles(inputPaths, re, &req.Replace, !req.Regex)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !req.Apply {
+		writeJSON(w, map[string]interface{}{"matches": matches})
+		return
+	}
+	if !canEdit(r) {
+		http.Error(w, "start mdview with --editable to modify files", http.StatusForbidden)
+		return
+	}
+
+	editMu.Lock()
+	defer editMu.Unlock()
+	changed := 0
+	for _, p := range inputPaths {
+		if isEncrypted(p) || isEmail(p) {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out := replaceAll(re, data, req.Replace, !req.Regex)
+		if bytes.Equal(out, data) {
+			continue
+		}
+		if err := writeFileAtomic(p, out); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		changed++
+	}
+	writeJSON(w, map[string]interface{}{"matches": matches, "filesChanged": changed})
+}
+
+// editMu serializes the page's edits to files (task ticks, board moves,
+// replace all), so two can't both read a file before either writes it and
+// lose one of the changes.
+var editMu sync.Mutex
+
+// writeFileAtomic replaces path with data via a temp file and rename,
+// keeping the original permissions.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
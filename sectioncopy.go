@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Hovering a heading offers to copy its section for Slack or Jira, which
+// both mangle pasted Markdown. The page asks /api/section for the section
+// under the heading's id, and the server converts the blocks from the
+// heading to the next one of the same or a higher level.
+
+// sectionNodes returns the heading with id and the blocks of its section,
+// subsections included.
+func sectionNodes(src []byte, id string) ([]ast.Node, bool) {
+	doc := markdown().Parser().Parse(text.NewReader(src), parseOptions(fileSections)...)
+	var nodes []ast.Node
+	level := 0
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		h, isHeading := n.(*ast.Heading)
+		if level > 0 && isHeading && h.Level <= level {
+			break
+		}
+		if level == 0 && isHeading {
+			if v, ok := h.AttributeString("id"); ok && string(v.([]byte)) == id {
+				level = h.Level
+			}
+		}
+		if level > 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, level > 0
+}
+
+// childNodes returns the children of n.
+func childNodes(n ast.Node) []ast.Node {
+	var nodes []ast.Node
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		nodes = append(nodes, c)
+	}
+	return nodes
+}
+
+// codeText returns the lines of a code block.
+func codeText(src []byte, n ast.Node) string {
+	var b strings.Builder
+	for i := 0; i < n.Lines().Len(); i++ {
+		seg := n.Lines().At(i)
+		b.Write(seg.Value(src))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// prefixLines puts prefix before every line of s.
+func prefixLines(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
+
+// tableRows returns the text of each cell of a table, header row first.
+func tableRows(t ast.Node, cell func(ast.Node) string) [][]string {
+	var rows [][]string
+	for r := t.FirstChild(); r != nil; r = r.NextSibling() {
+		var row []string
+		for c := r.FirstChild(); c != nil; c = c.NextSibling() {
+			row = append(row, cell(c))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// slackConverter writes Slack mrkdwn as the message box takes it when
+// pasted: the markers, but links as "text (url)", since <url|text> and the
+// &lt; escapes only work through the API. Slack has no headings, tables
+// or nested lists, so headings become bold lines, tables a preformatted
+// block and nested items are indented.
+type slackConverter struct{ src []byte }
+
+func (c slackConverter) blocks(nodes []ast.Node) string {
+	var parts []string
+	for _, n := range nodes {
+		if s := c.block(n); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (c slackConverter) block(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Heading:
+		return "*" + c.inlines(n) + "*"
+	case *ast.Paragraph, *ast.TextBlock:
+		return c.inlines(n)
+	case *ast.FencedCodeBlock, *ast.CodeBlock, *MathBlock:
+		return "```\n" + codeText(c.src, n) + "\n```"
+	case *ast.Blockquote:
+		return prefixLines(c.blocks(childNodes(n)), "> ")
+	case *ast.List:
+		return c.list(n, "")
+	case *ast.ThematicBreak:
+		return "———"
+	case *ast.HTMLBlock, *FrontMatter:
+		return ""
+	case *east.Table:
+		return "```\n" + alignColumns(tableRows(n, func(cell ast.Node) string { return plainInlines(c.src, cell) })) + "\n```"
+	case *Container:
+		return prefixLines(strings.TrimSpace("*"+string(n.Title)+"*\n"+c.blocks(childNodes(n))), "> ")
+	case *Collapsible:
+		return prefixLines("*"+string(n.Title)+"*\n"+c.blocks(childNodes(n)), "> ")
+	}
+	return c.blocks(childNodes(n))
+}
+
+func (c slackConverter) list(l *ast.List, indent string) string {
+	var items []string
+	i := l.Start
+	for it := l.FirstChild(); it != nil; it = it.NextSibling() {
+		marker := "• "
+		if l.IsOrdered() {
+			marker = strconv.Itoa(i) + ". "
+			i++
+		}
+		var parts []string
+		for b := it.FirstChild(); b != nil; b = b.NextSibling() {
+			if sub, ok := b.(*ast.List); ok {
+				parts = append(parts, c.list(sub, indent+"    "))
+				continue
+			}
+			parts = append(parts, indent+strings.ReplaceAll(c.block(b), "\n", "\n"+indent+"   "))
+		}
+		if len(parts) == 0 {
+			parts = []string{indent}
+		}
+		parts[0] = indent + marker + strings.TrimPrefix(parts[0], indent)
+		items = append(items, strings.Join(parts, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c slackConverter) inlines(n ast.Node) string {
+	var b strings.Builder
+	for x := n.FirstChild(); x != nil; x = x.NextSibling() {
+		b.WriteString(c.inline(x))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (c slackConverter) inline(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Text:
+		s := string(n.Segment.Value(c.src))
+		switch {
+		case n.HardLineBreak():
+			s += "\n"
+		case n.SoftLineBreak():
+			s += " "
+		}
+		return s
+	case *ast.String:
+		return string(n.Value)
+	case *ast.Emphasis:
+		mark := "_"
+		if n.Level == 2 {
+			mark = "*"
+		}
+		return mark + c.inlines(n) + mark
+	case *east.Strikethrough:
+		return "~" + c.inlines(n) + "~"
+	case *ast.CodeSpan:
+		return "`" + plainInlines(c.src, n) + "`"
+	case *Math:
+		return "`" + string(n.TeX) + "`"
+	case *ast.Link:
+		if text := c.inlines(n); text != string(n.Destination) {
+			return text + " (" + string(n.Destination) + ")"
+		}
+		return string(n.Destination)
+	case *ast.AutoLink:
+		return string(n.URL(c.src))
+	case *ast.Image:
+		return string(n.Destination)
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			return "☑ "
+		}
+		return "☐ "
+	case *ast.RawHTML:
+		return ""
+	}
+	return c.inlines(n)
+}
+
+// jiraConverter writes Jira wiki markup.
+type jiraConverter struct{ src []byte }
+
+// jiraEscaper backslash-escapes the characters that start Jira markup.
+var jiraEscaper = strings.NewReplacer("*", `\*`, "_", `\_`, "{", `\{`, "}", `\}`, "[", `\[`, "]", `\]`, "|", `\|`, "!", `\!`)
+
+// jiraPanels maps container names to the Jira macros styled like them.
+var jiraPanels = map[string]string{"note": "info", "info": "info", "tip": "tip", "warning": "note", "danger": "warning", "caution": "warning"}
+
+func (c jiraConverter) blocks(nodes []ast.Node) string {
+	var parts []string
+	for _, n := range nodes {
+		if s := c.block(n); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (c jiraConverter) block(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Heading:
+		return fmt.Sprintf("h%d. %s", n.Level, c.inlines(n))
+	case *ast.Paragraph, *ast.TextBlock:
+		return c.inlines(n)
+	case *ast.FencedCodeBlock:
+		macro := "{code}"
+		if lang := n.Language(c.src); len(lang) > 0 {
+			macro = "{code:" + string(lang) + "}"
+		}
+		return macro + "\n" + codeText(c.src, n) + "\n{code}"
+	case *ast.CodeBlock, *MathBlock:
+		return "{noformat}\n" + codeText(c.src, n) + "\n{noformat}"
+	case *ast.Blockquote:
+		return "{quote}\n" + c.blocks(childNodes(n)) + "\n{quote}"
+	case *ast.List:
+		return c.list(n, "")
+	case *ast.ThematicBreak:
+		return "----"
+	case *ast.HTMLBlock, *FrontMatter:
+		return ""
+	case *east.Table:
+		var lines []string
+		for i, row := range tableRows(n, func(cell ast.Node) string { return c.inlines(cell) }) {
+			sep := "|"
+			if i == 0 {
+				sep = "||"
+			}
+			lines = append(lines, sep+strings.Join(row, sep)+sep)
+		}
+		return strings.Join(lines, "\n")
+	case *Container:
+		return c.panel(n.Name, n.Title, n)
+	case *Collapsible:
+		return c.panel(n.Name, n.Title, n)
+	}
+	return c.blocks(childNodes(n))
+}
+
+// panel writes a container as the Jira macro for its kind, or a titled
+// {panel}.
+func (c jiraConverter) panel(name string, title []byte, n ast.Node) string {
+	macro, ok := jiraPanels[name]
+	if !ok {
+		macro = "panel"
+	}
+	open := "{" + macro
+	if len(title) > 0 {
+		open += ":title=" + strings.NewReplacer("|", "", "}", "").Replace(string(title))
+	}
+	return open + "}\n" + c.blocks(childNodes(n)) + "\n{" + macro + "}"
+}
+
+// list writes l with markers that repeat per level, as Jira nests them:
+// "*", "**", "#*".
+func (c jiraConverter) list(l *ast.List, markers string) string {
+	marker := "*"
+	if l.IsOrdered() {
+		marker = "#"
+	}
+	markers += marker
+	var items []string
+	for it := l.FirstChild(); it != nil; it = it.NextSibling() {
+		var parts []string
+		for b := it.FirstChild(); b != nil; b = b.NextSibling() {
+			if sub, ok := b.(*ast.List); ok {
+				parts = append(parts, c.list(sub, markers))
+				continue
+			}
+			parts = append(parts, c.block(b))
+		}
+		if len(parts) == 0 {
+			parts = []string{""}
+		}
+		parts[0] = markers + " " + parts[0]
+		items = append(items, strings.Join(parts, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c jiraConverter) inlines(n ast.Node) string {
+	var b strings.Builder
+	for x := n.FirstChild(); x != nil; x = x.NextSibling() {
+		b.WriteString(c.inline(x))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (c jiraConverter) inline(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Text:
+		s := jiraEscaper.Replace(string(n.Segment.Value(c.src)))
+		switch {
+		case n.HardLineBreak():
+			s += "\n"
+		case n.SoftLineBreak():
+			s += " "
+		}
+		return s
+	case *ast.String:
+		return jiraEscaper.Replace(string(n.Value))
+	case *ast.Emphasis:
+		mark := "_"
+		if n.Level == 2 {
+			mark = "*"
+		}
+		return mark + c.inlines(n) + mark
+	case *east.Strikethrough:
+		return "-" + c.inlines(n) + "-"
+	case *ast.CodeSpan:
+		return "{{" + plainInlines(c.src, n) + "}}"
+	case *Math:
+		return "{{" + string(n.TeX) + "}}"
+	case *ast.Link:
+		return "[" + c.inlines(n) + "|" + string(n.Destination) + "]"
+	case *ast.AutoLink:
+		return "[" + string(n.URL(c.src)) + "]"
+	case *ast.Image:
+		return "!" + string(n.Destination) + "!"
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			return "(/) "
+		}
+		return "(x) "
+	case *ast.RawHTML:
+		return ""
+	}
+	return c.inlines(n)
+}
+
+// plainInlines returns the text of n's inline children without markup.
+func plainInlines(src []byte, n ast.Node) string {
+	var b bytes.Buffer
+	ast.Walk(n, func(x ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch x := x.(type) {
+		case *ast.Text:
+			b.Write(x.Segment.Value(src))
+			if x.SoftLineBreak() || x.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(x.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// alignColumns lays rows out as text with padded columns.
+func alignColumns(rows [][]string) string {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := len([]rune(cell)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	var lines []string
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = cell + strings.Repeat(" ", widths[i]-len([]rune(cell)))
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, " | "), " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sectionMarkup converts the section under the heading with id to format,
+// "slack" or "jira".
+func sectionMarkup(src []byte, id, format string) (string, error) {
+	nodes, ok := sectionNodes(src, id)
+	if !ok {
+		return "", fmt.Errorf("no heading with id %q", id)
+	}
+	switch format {
+	case "slack":
+		return slackConverter{src}.blocks(nodes), nil
+	case "jira":
+		return jiraConverter{src}.blocks(nodes), nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+// handleSection serves GET /api/section?id=heading&format=slack|jira
+// [&page=route], the section as text to paste.
+func handleSection(w http.ResponseWriter, r *http.Request) {
+	if isLocked() {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	out, err := sectionMarkup(docs.Get(requestedDocument(r)).Content, q.Get("id"), q.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(out + "\n"))
+}
@@ -0,0 +1,1128 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sseEvents connects to an SSE endpoint and delivers each event block
+// (the lines up to a blank line, joined with "\n").
+func sseEvents(t *testing.T, url string) <-chan string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		cancel()
+		resp.Body.Close()
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+	})
+
+	events := make(chan string, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var block []string
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				block = append(block, line)
+				continue
+			}
+			events <- strings.Join(block, "\n")
+			block = nil
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan string, timeout time.Duration) (string, bool) {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		return e, ok
+	case <-time.After(timeout):
+		return "", false
+	}
+}
+
+func TestSSEReload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleSSE))
+	t.Cleanup(srv.Close) // after the streams below are closed
+	events := sseEvents(t, srv.URL)
+
+	e, ok := nextEvent(t, events, time.Second)
+	if !ok || !strings.Contains(e, ": connected") || !strings.Contains(e, "retry: 2000") {
+		t.Fatalf("first event = %q, want the connect comment and retry hint", e)
+	}
+
+	// A burst of changes is coalesced into one reload.
+	notifyClients()
+	notifyClients()
+	notifyClients()
+	e, ok = nextEvent(t, events, time.Second)
+	if !ok || e != "event: reload\ndata: reload" {
+		t.Fatalf("event = %q, want a reload", e)
+	}
+	if e, ok := nextEvent(t, events, 4*notifyCoalesce); ok {
+		t.Errorf("got %q after the coalesced reload", e)
+	}
+}
+
+func TestSSEMaxClients(t *testing.T) {
+	old := maxClients
+	maxClients = 1
+	defer func() { maxClients = old }()
+
+	srv := httptest.NewServer(http.HandlerFunc(handleSSE))
+	t.Cleanup(srv.Close) // after the streams below are closed
+	events := sseEvents(t, srv.URL)
+	nextEvent(t, events, time.Second)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second client got %s, want 503", resp.Status)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	setDocument(t, "doc.md", "# Hello\n")
+
+	rec := httptest.NewRecorder()
+	handleRaw(rec, httptest.NewRequest(http.MethodGet, "/raw", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var body struct {
+		HTML string `json:"html"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body.HTML, `<h1 id="hello" data-line="1">Hello</h1>`) {
+		t.Errorf("html = %q", body.HTML)
+	}
+
+	// The ETag holds until the content changes.
+	etag := rec.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/raw", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handleRaw(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged content: status %d, want 304", rec.Code)
+	}
+	setDocument(t, "doc.md", "# Changed\n")
+	rec = httptest.NewRecorder()
+	handleRaw(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("changed content: status %d, want 200", rec.Code)
+	}
+}
+
+func TestPlainRaw(t *testing.T) {
+	setDocument(t, "doc.md", "![A <b>diagram</b>](d.png) and ![](x.png)\n")
+	rec := httptest.NewRecorder()
+	handleRaw(rec, httptest.NewRequest(http.MethodGet, "/raw?plain=1", nil))
+	var body struct {
+		HTML string `json:"html"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := `<span class="img-alt">[A diagram]</span> and <span class="img-alt">[image]</span>`
+	if !strings.Contains(body.HTML, want) || strings.Contains(body.HTML, "<img") {
+		t.Errorf("html = %q, want %s", body.HTML, want)
+	}
+	if etag := rec.Header().Get("ETag"); !strings.HasSuffix(etag, `-plain"`) {
+		t.Errorf("ETag %s is the one of the full page", etag)
+	}
+}
+
+// TestHostileFilenames checks that file names end up in the page as text.
+func TestHostileFilenames(t *testing.T) {
+	for _, tc := range []struct{ name, title string }{
+		{`<img src=x onerror=alert(1)>.md`, `&lt;img src=x onerror=alert(1)&gt;.md`},
+		{`a"b'c&d.md`, `a&#34;b&#39;c&amp;d.md`},
+		{"evil\u202egpj.md", "evil\ufffdgpj.md"},
+		{"tab\tand\nnewline.md", "tab\ufffdand\ufffdnewline.md"},
+		{"bad\xffutf8.md", "bad\ufffdutf8.md"},
+	} {
+		setDocument(t, filepath.Join(t.TempDir(), tc.name), "# Doc\n")
+		rec := httptest.NewRecorder()
+		handlePage(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		body := rec.Body.String()
+		if want := "<title>" + tc.title + " — mdview</title>"; !strings.Contains(body, want) {
+			start := strings.Index(body, "<title>")
+			end := strings.Index(body, "</title>")
+			t.Errorf("%q: got %s, want %s", tc.name, body[start:end+len("</title>")], want)
+		}
+		if strings.Contains(body, "<img src=x") {
+			t.Errorf("%q: the name is in the page unescaped", tc.name)
+		}
+	}
+}
+
+func TestFrontMatterTitle(t *testing.T) {
+	setDocument(t, "doc.md", "---\ntitle: Notes <draft>\nauthor: [Ada, Grace]\n---\n\n# Heading\n")
+	rec := httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"<title>Notes &lt;draft&gt; — mdview</title>",
+		`<meta name="author" content="Ada, Grace">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s", want)
+		}
+	}
+}
+
+func TestThemeFlag(t *testing.T) {
+	setDocument(t, "doc.md", "# Doc\n")
+	themeFlag = "solarized"
+	defer func() { themeFlag = "" }()
+	rec := httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := `<html lang="en" data-theme="light" data-palette="solarized">`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("page is missing %s", want)
+	}
+	for name, ok := range map[string]bool{"": true, "dark": true, "dracula": true, "neon": false} {
+		if err := checkTheme(name); (err == nil) != ok {
+			t.Errorf("checkTheme(%q) = %v", name, err)
+		}
+	}
+}
+
+func TestUserConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	src := "# defaults\ntheme = \"dracula\"\nport = 6419 # the usual\nwatch_interval = '500ms'\nextensions = [\"table\", \"footnote\"]\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := defaultRenderer
+	defer func() { defaultRenderer = old }()
+	fs := flag.NewFlagSet("mdview", flag.ContinueOnError)
+	theme := fs.String("theme", "", "")
+	port := fs.Int("port", 0, "")
+	interval := fs.Duration("watch-interval", 0, "")
+	if err := fs.Parse([]string{"--theme", "sepia"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyUserConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if *theme != "sepia" || *port != 6419 || *interval != 500*time.Millisecond {
+		t.Errorf("theme %q, port %d, interval %v", *theme, *port, *interval)
+	}
+	if got := strings.Join(defaultRenderer.Extensions, ","); got != "table,footnote" {
+		t.Errorf("extensions = %s", got)
+	}
+
+	src = "extensions = [\n  \"table\",\n  \"tasklist\", # lists\n]\n\n[profiles.print]\ntheme = \"light\"\nfont-size = 14\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { userProfiles = nil }()
+	if err := applyUserConfig(flag.NewFlagSet("mdview", flag.ContinueOnError), path); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(defaultRenderer.Extensions, ","); got != "table,tasklist" {
+		t.Errorf("multi-line extensions = %s", got)
+	}
+	if p := userProfiles["print"]; p.Theme != "light" || p.FontSize != 14 {
+		t.Errorf("profile print = %+v", p)
+	}
+
+	// A HOME that is a file leaves no config, rather than an error.
+	if err := applyUserConfig(flag.NewFlagSet("mdview", flag.ContinueOnError), filepath.Join(path, "mdview", "config.toml")); err != nil {
+		t.Errorf("config under a file: %v", err)
+	}
+
+	for _, bad := range []string{"colour = \"red\"\n", "theme = dracula\n", "[server]\n", "extensions = [\"tables\"]\n", "[profiles.print]\ncolour = \"red\"\n", "[profiles.print]\ntheme = \"plaid\"\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyUserConfig(flag.NewFlagSet("mdview", flag.ContinueOnError), path); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestParseCommandFlags(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+		sort string
+	}{
+		{[]string{"--sort", "date", "changes/*.md"}, "changes/*.md", "date"},
+		{[]string{"changes/*.md", "--sort", "date"}, "changes/*.md", "date"},
+		{[]string{"a", "--sort=date", "b"}, "a b", "date"},
+		{[]string{"a", "--", "--sort", "date"}, "a --sort date", "name"},
+		{[]string{"-", "b"}, "- b", "name"},
+	} {
+		fs := flag.NewFlagSet("mdview aggregate", flag.ContinueOnError)
+		sortBy := fs.String("sort", "name", "")
+		got := strings.Join(parseCommandFlags(fs, tc.args), " ")
+		if got != tc.want || *sortBy != tc.sort {
+			t.Errorf("%q: args %q, --sort %s", tc.args, got, *sortBy)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"1.4.0", "1.4.0", 0, true},
+		{"v1.10.0", "1.9.3", 1, true},
+		{"1.4.0", "1.4.1", -1, true},
+		{"2.0.0", "10.0.0", -1, true},
+		{"1.4.0-rc.1", "1.4.0", -1, true},
+		{"1.4.0-rc.2", "1.4.0-rc.10", -1, true},
+		{"1.4.0-beta", "1.4.0-alpha", 1, true},
+		{"1.4.0-rc", "1.4.0-rc.1", -1, true},
+		{"1.4.0+build.5", "1.4.0", 0, true},
+		{"1.4.0", "dev", 0, false},
+		{"1.4", "1.4.0", 0, false},
+	} {
+		got, ok := compareVersions(tc.a, tc.b)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("compareVersions(%q, %q) = %d, %v; want %d, %v", tc.a, tc.b, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestSiteFileContainment(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "docs")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("# A\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "secret.md"), []byte("# Secret\n"), 0o644)
+	if err := os.Symlink(filepath.Join(root, "secret.md"), filepath.Join(dir, "link.md")); err != nil {
+		t.Skip(err)
+	}
+	oldDir := baseDir
+	baseDir = dir
+	defer func() { baseDir = oldDir }()
+
+	cases := map[string]string{ // URL path: served path, "" if refused
+		"/a.md":                "a.md",
+		"/sub/../a.md":         "a.md",
+		"/../secret.md":        "secret.md",
+		"/sub/../../secret.md": "secret.md",
+		"//a.md":               "a.md",
+		"/":                    "",
+		"/.env":                "",
+		"/sub/.git/config":     "",
+		"/link.md":             "",
+	}
+	if runtime.GOOS == "windows" {
+		cases[`/..\secret.md`] = ""
+		cases[`/sub\..\..\secret.md`] = ""
+		cases["/C:secret.md"] = ""
+		cases[`/\\host\share\a.md`] = ""
+	}
+	if files, _ := listMarkdown(os.DirFS(dir)); len(files) != 1 || files[0].rel != "a.md" {
+		for _, f := range files {
+			t.Errorf("listMarkdown lists %s", f.rel)
+		}
+	}
+	for urlPath, want := range cases {
+		_, rel, name, ok := siteFile(urlPath)
+		if want == "" {
+			if ok {
+				t.Errorf("siteFile(%q) = %q, want refused", urlPath, name)
+			}
+			continue
+		}
+		if !ok || rel != want || name != filepath.Join(dir, filepath.FromSlash(want)) {
+			t.Errorf("siteFile(%q) = %q, %q, %v; want %q", urlPath, rel, name, ok, want)
+		}
+	}
+}
+
+func TestUntrustedInputStaysSafe(t *testing.T) {
+	oldMode, oldDefault, oldDir := htmlMode, defaultRenderer, baseDir
+	defer func() {
+		htmlMode, defaultRenderer, baseDir = oldMode, oldDefault, oldDir
+		switchProfile("")
+		setRenderer(defaultRenderer)
+	}()
+	htmlMode = ""
+	trustInput(true)
+	setRenderer(defaultRenderer)
+
+	post := func(h http.HandlerFunc, body string) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h(httptest.NewRecorder(), req)
+	}
+	post(handleRenderer, `{"unsafe":true}`)
+	if currentRenderer().Unsafe {
+		t.Error("/api/renderer turned raw HTML on for an untrusted input")
+	}
+	on := true
+	if err := setProfiles(&configFile{rendererOptions: defaultRenderer, Profiles: map[string]profile{"raw": {Unsafe: &on}}}); err != nil {
+		t.Fatal(err)
+	}
+	post(handleProfile, `{"name":"raw"}`)
+	if currentRenderer().Unsafe {
+		t.Error("a profile turned raw HTML on for an untrusted input")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "page.html"), []byte("<script>alert(1)</script>"), 0o644)
+	baseDir = dir
+	w := httptest.NewRecorder()
+	serveSiteFile(w, httptest.NewRequest(http.MethodGet, "/page.html", nil))
+	if got := w.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Errorf("page.html served with Content-Security-Policy %q, want sandbox", got)
+	}
+}
+
+func TestTarLimits(t *testing.T) {
+	old := maxInputSize
+	defer func() { maxInputSize = old }()
+	maxInputSize = 1024
+	writeTar := func(sizes ...int) string {
+		name := filepath.Join(t.TempDir(), "docs.tar")
+		f, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw := tar.NewWriter(f)
+		for i, size := range sizes {
+			tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("f%d.md", i), Mode: 0o644, Size: int64(size), Typeflag: tar.TypeReg})
+			tw.Write([]byte(strings.Repeat("x", size)))
+		}
+		tw.Close()
+		f.Close()
+		return name
+	}
+	if _, err := openTar(writeTar(100, 1000)); err != nil {
+		t.Errorf("archive within the limits: %v", err)
+	}
+	if _, err := openTar(writeTar(100, 2000)); err == nil || !strings.Contains(err.Error(), "f1.md") {
+		t.Errorf("oversized entry: %v", err)
+	}
+	sizes := make([]int, 17)
+	for i := range sizes {
+		sizes[i] = 1000
+	}
+	if _, err := openTar(writeTar(sizes...)); err == nil {
+		t.Error("archive past maxArchiveSize opened")
+	}
+	if name := writeTar(1); !untrustedInput([]string{name}) {
+		t.Error("archives should be untrusted")
+	}
+	if untrustedInput([]string{t.TempDir()}) {
+		t.Error("a directory should be trusted")
+	}
+}
+
+func TestBucketLimit(t *testing.T) {
+	old := maxInputSize
+	defer func() { maxInputSize = old }()
+	maxInputSize = 1024
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("list-type") != "" {
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>small.md</Key><Size>5</Size></Contents><Contents><Key>big.md</Key><Size>2000</Size></Contents></ListBucketResult>`)
+			return
+		}
+		if r.URL.Path == "/big.md" {
+			fmt.Fprint(w, strings.Repeat("x", 2000))
+			return
+		}
+		fmt.Fprint(w, "# Hi\n")
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	b, err := openBucket(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := b.fetch("small.md"); err != nil || string(data) != "# Hi\n" {
+		t.Errorf("small.md: %q, %v", data, err)
+	}
+	if _, err := b.fetch("big.md"); err == nil || !strings.Contains(err.Error(), "--max-input-size") {
+		t.Errorf("big.md: %v", err)
+	}
+}
+
+func TestReplaceLineEndings(t *testing.T) {
+	for _, tc := range []struct {
+		query, replace, src, want string
+	}{
+		{"cat", "dog", "a cat\nb\n", "a dog\nb\n"},
+		{"cat", "dog", "a cat\r\nb\r\n", "a dog\r\nb\r\n"},
+		// Mixed endings: only the replaced line is touched.
+		{"cat", "dog", "cat\r\nb\nc\n", "dog\r\nb\nc\n"},
+		{"cat", "dog", "x\r\nb\ncat\n", "x\r\nb\ndog\n"},
+		{"cat", "dog", "no match\r\n", "no match\r\n"},
+		// $ matches before a CR as before an LF.
+		{"b$", "cat", "b\r\nab\nc", "cat\r\nacat\nc"},
+	} {
+		re, err := compileSearch(tc.query, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(replaceAll(re, []byte(tc.src), tc.replace, true)); got != tc.want {
+			t.Errorf("replaceAll(%q, %q) = %q, want %q", tc.query, tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestDirectorySlugs(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"User Guide/Getting Started.md": "# Start\n\n![](pic.png)\n",
+		"User Guide/getting_started.md": "# Again\n",
+		"User Guide/pic.png":            "png",
+	} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldRoot, oldStore := dirRoot, docStore
+	dirRoot, docStore = dir, os.DirFS(dir)
+	defer func() { dirRoot, docStore = oldRoot, oldStore }()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handlePage(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	index := get("/").Body.String()
+	for _, want := range []string{`<a href="/user-guide/getting-started">`, `<a href="/user-guide/getting-started-2">`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index is missing %s", want)
+		}
+	}
+	if loc := get("/User%20Guide/Getting%20Started.md").Header().Get("Location"); loc != "/user-guide/getting-started" {
+		t.Errorf("file path redirects to %q", loc)
+	}
+	if body := get("/user-guide/getting-started-2").Body.String(); !strings.Contains(body, ">Again</h1>") {
+		t.Error("second slug does not serve the second file")
+	}
+	if body := get("/user-guide/pic.png").Body.String(); body != "png" {
+		t.Errorf("image under a slugged directory: %q", body)
+	}
+}
+
+func TestStreamedPage(t *testing.T) {
+	src := "# Big\n\n" + strings.Repeat("A paragraph of filler text.\n\n", largeDocument/28+1)
+	setDocument(t, "big.md", src)
+	streamed := metrics.streamedPages.Load()
+	rec := httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	if !rec.Flushed || metrics.streamedPages.Load() != streamed+1 {
+		t.Error("the page was not sent shell first")
+	}
+	for _, want := range []string{`<div id="content" class="large">`, `<h1 id="big" data-line="1">Big</h1>`, "</html>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s", want)
+		}
+	}
+	if strings.Contains(body, contentMarker) {
+		t.Error("page still has the content marker")
+	}
+}
+
+func TestUserCSS(t *testing.T) {
+	dir := t.TempDir()
+	userCSSPath = filepath.Join(dir, "site.css")
+	defer func() { userCSSPath = "" }()
+	for name, data := range map[string]string{"site.css": "body { color: red }", "notes.md": "# Private\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setDocument(t, "doc.md", "# Doc\n")
+	rec := httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := `<link rel="stylesheet" id="userStyle" href="/user-css/site.css?v=`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("page is missing %s", want)
+	}
+	for path, code := range map[string]int{"/user-css/site.css": http.StatusOK, "/user-css/notes.md": http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		handleUserCSS(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, code)
+		}
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	if !gitAvailable() {
+		t.Skip("no git")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=T", "-c", "user.email=t@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	body := "\nA paragraph long enough for git to see the rename.\n"
+	write("old.md", "# First\n"+body)
+	run("add", ".")
+	run("commit", "-qm", "first")
+	run("mv", "old.md", "new.md")
+	write("new.md", "# Second\n"+body)
+	run("commit", "-qam", "second")
+
+	list, err := fileHistory(filepath.Join(dir, "new.md"))
+	if err != nil || len(list) != 2 {
+		t.Fatalf("history: %v, %v", list, err)
+	}
+	for i, want := range []string{"# Second\n" + body, "# First\n" + body} {
+		src, err := sourceAt(filepath.Join(dir, "new.md"), list[i])
+		if err != nil || string(src) != want {
+			t.Errorf("commit %s: %q, %v; want %q", list[i].Subject, src, err, want)
+		}
+	}
+}
+
+func TestBrowserArgs(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	const url = "http://localhost:6419/"
+	for browser, want := range map[string]string{
+		"sh -P work":     sh + " -P work " + url,
+		"sh --app={url}": sh + " --app=" + url,
+		"sh %s --new":    sh + " " + url + " --new",
+	} {
+		if got := strings.Join(browserArgs(browser, url), " "); got != want {
+			t.Errorf("browserArgs(%q) = %q, want %q", browser, got, want)
+		}
+	}
+	t.Setenv("BROWSER", "no-such-browser"+string(os.PathListSeparator)+"sh %s")
+	if got := browser(); got != "sh %s" {
+		t.Errorf("$BROWSER picks %q, want the installed one", got)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	for name, want := range map[string]string{
+		"doc.pdf":                  `inline; filename="doc.pdf"`,
+		`a"b\c.pdf`:                `inline; filename="a_b_c.pdf"; filename*=UTF-8''a%22b%5Cc.pdf`,
+		"résumé.pdf":               `inline; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`,
+		"x\r\nSet-Cookie: a=b.pdf": `inline; filename="x__Set-Cookie: a=b.pdf"; filename*=UTF-8''x%EF%BF%BD%EF%BF%BDSet-Cookie%3A%20a%3Db.pdf`,
+	} {
+		if got := contentDisposition("inline", name); got != want {
+			t.Errorf("contentDisposition(%q)\n got %s\nwant %s", name, got, want)
+		}
+	}
+}
+
+func TestInputPages(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "one.md"), filepath.Join(dir, "sub", "two.md")
+	setDocument(t, first, "# One\n")
+	inputPages = newInputPages([]string{first, second})
+	defer func() { inputPages = nil }()
+	docs.Set(inputPages[1].Key, Document{Path: second, Content: []byte("# Two\n")})
+
+	if got := inputPages[1].Route; got != "/sub/two.md" {
+		t.Fatalf("second file's route = %q", got)
+	}
+	rec := httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/sub/two.md", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<h1 id="two" data-line="1">Two</h1>`,
+		`<a class="page-prev" href="/" rel="prev">`,
+		`<a href="/sub/two.md" aria-current="page">`,
+		`"page":"/sub/two.md"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handleRaw(rec, httptest.NewRequest(http.MethodGet, "/raw?page=/sub/two.md", nil))
+	if !strings.Contains(rec.Body.String(), "Two") {
+		t.Errorf("/raw?page= served %s", rec.Body.String())
+	}
+	// A change to the other file leaves this page's ETag alone.
+	etag := rec.Header().Get("ETag")
+	setDocument(t, first, "# One again\n")
+	req := httptest.NewRequest(http.MethodGet, "/raw?page=/sub/two.md", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handleRaw(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("after another file changed: status %d, want 304", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/one.md", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Errorf("/one.md: %d to %q, want a redirect to /", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestLinkedPage(t *testing.T) {
+	dir := t.TempDir()
+	main, design := filepath.Join(dir, "index.md"), filepath.Join(dir, "docs", "design.md")
+	if err := os.MkdirAll(filepath.Dir(design), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(design, []byte("# Design\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setDocument(t, main, "See [design](./docs/design.md).\n")
+	inputPaths = []string{main}
+	defer func() {
+		inputPaths = nil
+		linkedPages.byRoute = make(map[string]inputPage)
+	}()
+
+	rec := httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/docs/design.md", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`<h1 id="design" data-line="1">Design</h1>`, `"page":"/docs/design.md"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("linked page is missing %s", want)
+		}
+	}
+	if p := <-linkedFiles; p.Path != design {
+		t.Errorf("watcher was handed %q, want %q", p.Path, design)
+	}
+
+	rec = httptest.NewRecorder()
+	handleRaw(rec, httptest.NewRequest(http.MethodGet, "/raw?page=/docs/design.md", nil))
+	if !strings.Contains(rec.Body.String(), "Design") {
+		t.Errorf("/raw?page= served %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handlePage(rec, httptest.NewRequest(http.MethodGet, "/index.md", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Errorf("/index.md: %d to %q, want a redirect to /", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestTaskToggle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.md")
+	src := "# Todo\r\n\r\n- [ ] write #doing\r\n  - [X] nested\r\n- plain\r\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setDocument(t, path, src)
+	toggle := func(line int, checked bool) int {
+		body := fmt.Sprintf(`{"line": %d, "checked": %t}`, line, checked)
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/toggle", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handleTaskToggle(rec, req)
+		return rec.Code
+	}
+
+	if code := toggle(3, true); code != http.StatusForbidden {
+		t.Errorf("without --edit-tasks: %d", code)
+	}
+	editTasks = true
+	defer func() { editTasks = false }()
+	for _, c := range []struct {
+		line    int
+		checked bool
+		code    int
+	}{{3, true, http.StatusNoContent}, {4, false, http.StatusNoContent}, {5, true, http.StatusConflict}, {99, true, http.StatusConflict}} {
+		if code := toggle(c.line, c.checked); code != c.code {
+			t.Errorf("line %d: %d, want %d", c.line, code, c.code)
+		}
+	}
+	got, _ := os.ReadFile(path)
+	if want := "# Todo\r\n\r\n- [x] write #doing\r\n  - [ ] nested\r\n- plain\r\n"; string(got) != want {
+		t.Errorf("file is %q, want %q", got, want)
+	}
+
+	// Tasks quoted in a blockquote or an alert, toggled all at once.
+	src = "> - [ ] quoted\n>\n> > 1. [ ] nested quote\n\n> [!NOTE]\n> - [x] in an alert\n\n- [ ] a\n- [ ] b\n- [ ] c\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setDocument(t, path, src)
+	var wg sync.WaitGroup
+	for _, c := range []struct {
+		line    int
+		checked bool
+	}{{1, true}, {3, true}, {6, false}, {8, true}, {9, true}, {10, true}} {
+		wg.Add(1)
+		go func(line int, checked bool) {
+			defer wg.Done()
+			if code := toggle(line, checked); code != http.StatusNoContent {
+				t.Errorf("line %d: %d", line, code)
+			}
+		}(c.line, c.checked)
+	}
+	wg.Wait()
+	got, _ = os.ReadFile(path)
+	if want := "> - [x] quoted\n>\n> > 1. [x] nested quote\n\n> [!NOTE]\n> - [ ] in an alert\n\n- [x] a\n- [x] b\n- [x] c\n"; string(got) != want {
+		t.Errorf("file is %q, want %q", got, want)
+	}
+
+	// A tick and a board move of another task, at the same time: both wait
+	// for the file edit lock, then neither loses the other's change.
+	oldPaths := inputPaths
+	inputPaths, editable = []string{path}, true
+	defer func() { inputPaths, editable = oldPaths, false }()
+	src = "- [ ] a\n- [ ] b\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setDocument(t, path, src)
+	editMu.Lock()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if code := toggle(1, true); code != http.StatusNoContent {
+			t.Errorf("toggle: %d", code)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		body := fmt.Sprintf(`{"file": %q, "line": 2, "source": "- [ ] b", "status": "doing"}`, path)
+		req := httptest.NewRequest(http.MethodPost, "/api/board/move", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handleBoardMove(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("board move: %d %s", rec.Code, rec.Body)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	got, _ = os.ReadFile(path)
+	editMu.Unlock()
+	wg.Wait()
+	if string(got) != src {
+		t.Errorf("file edited without the lock: %q", got)
+	}
+	got, _ = os.ReadFile(path)
+	if want := "- [x] a\n- [ ] b #doing\n"; string(got) != want {
+		t.Errorf("file is %q, want %q", got, want)
+	}
+}
+
+func watchReloads(t *testing.T, path string) <-chan struct{} {
+	t.Helper()
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setDocument(t, path, string(src))
+
+	ch := make(chan struct{}, 16)
+	unsubscribe := docs.Subscribe(func(string, Document) {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchFiles(ctx, []string{path})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		unsubscribe()
+	})
+	return ch
+}
+
+func currentContent() string {
+	return string(docs.Get(mainDocument).Content)
+}
+
+func TestUnlockOrigin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.md")
+	if err := os.WriteFile(path, []byte("# Notes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setDocument(t, path, "")
+	defer func() { locked = false }()
+	unlock := func(contentType, origin, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/unlock", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		handleUnlock([]string{path})(rec, req)
+		return rec.Code
+	}
+
+	locked = true
+	if code := unlock("application/x-www-form-urlencoded", "", "passphrase=x"); code != http.StatusForbidden {
+		t.Errorf("form post: %d", code)
+	}
+	if code := unlock("application/json", "http://evil.example", `{"passphrase": ""}`); code != http.StatusForbidden {
+		t.Errorf("cross-origin post: %d", code)
+	}
+	if !locked {
+		t.Fatal("unlocked by a rejected request")
+	}
+	if code := unlock("application/json", "", `{"passphrase": ""}`); code != http.StatusNoContent || locked {
+		t.Errorf("same-origin post: %d, locked %t", code, locked)
+	}
+	if got := string(docs.Get(mainDocument).Content); got != "# Notes\n" {
+		t.Errorf("content after unlock = %q", got)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	old := watchDebounce
+	watchDebounce = 0
+	defer func() { watchDebounce = old }()
+
+	for _, mode := range []string{"notify", "poll"} {
+		t.Run(mode, func(t *testing.T) {
+			watchPoll = mode == "poll"
+			defer func() { watchPoll = false }()
+
+			path := filepath.Join(t.TempDir(), "doc.md")
+			if err := os.WriteFile(path, []byte("# Before\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			reloads := watchReloads(t, path)
+			time.Sleep(150 * time.Millisecond) // let the watcher take its first look
+
+			// A write truncates the file first, so a reload may see it empty
+			// before the one with the new content.
+			waitFor := func(want, what string) {
+				t.Helper()
+				deadline := time.After(2 * time.Second)
+				for {
+					select {
+					case <-reloads:
+						if currentContent() == want {
+							return
+						}
+					case <-deadline:
+						t.Fatalf("no reload after %s; content = %q", what, currentContent())
+					}
+				}
+			}
+			if err := os.WriteFile(path, []byte("# After\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			waitFor("# After\n", "the file changed")
+
+			// Saved the way many editors do: a new file renamed over the old.
+			tmp := path + ".tmp"
+			if err := os.WriteFile(tmp, []byte("# Renamed\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				t.Fatal(err)
+			}
+			waitFor("# Renamed\n", "the file was replaced")
+		})
+	}
+}
+
+func TestWatchDebounce(t *testing.T) {
+	old := watchDebounce
+	watchDebounce = 300 * time.Millisecond
+	defer func() { watchDebounce = old }()
+
+	path := filepath.Join(t.TempDir(), "doc.md")
+	if err := os.WriteFile(path, []byte("# Start\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reloads := watchReloads(t, path)
+	time.Sleep(150 * time.Millisecond)
+
+	// Several writes in quick succession, as some editors save.
+	for _, s := range []string{"# Pa", "# Part", "# Partial\n", "# Final\n"} {
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload after the writes settled")
+	}
+	if got := currentContent(); got != "# Final\n" {
+		t.Errorf("content = %q, want the last write", got)
+	}
+	select {
+	case <-reloads:
+		t.Error("more than one reload for one burst of writes")
+	case <-time.After(watchDebounce + 200*time.Millisecond):
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	oldDebounce, oldCommand, oldWatches := watchDebounce, onChangeCommand, extraWatches
+	defer func() { watchDebounce, onChangeCommand, extraWatches = oldDebounce, oldCommand, oldWatches }()
+	watchDebounce = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	source, path := filepath.Join(dir, "api.txt"), filepath.Join(dir, "api.md")
+	if err := os.WriteFile(source, []byte("v1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("# v1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Regenerating with a timestamp rewrites the document every time.
+	onChangeCommand = fmt.Sprintf("printf '# %%s%%s\\n' $(cat %q) $(date +%%N) > %q", source, path)
+	extraWatches = []string{source}
+	reloads := watchReloads(t, path)
+	time.Sleep(150 * time.Millisecond)
+
+	if err := os.WriteFile(source, []byte("v2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload after the source changed")
+	}
+	if got := currentContent(); !strings.HasPrefix(got, "# v2") {
+		t.Errorf("content = %q, want the regenerated document", got)
+	}
+	select {
+	case <-reloads:
+		t.Error("the command's own write triggered it again")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestExecRefresh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	old := execCommand
+	defer func() { execCommand = old }()
+	state := filepath.Join(t.TempDir(), "state")
+	execCommand = fmt.Sprintf("test -s %q && printf '# %%s\\n' $(cat %q)", state, state)
+	setDocument(t, "", "")
+
+	for _, step := range []struct{ state, want string }{
+		{"up", "# up\n"},
+		{"", "# up\n"}, // a failing run keeps the last output
+		{"down", "# down\n"},
+	} {
+		if err := os.WriteFile(state, []byte(step.state), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		refreshExec()
+		if got := currentContent(); got != step.want {
+			t.Errorf("after %q: content = %q, want %q", step.state, got, step.want)
+		}
+	}
+}
+
+// TestServeShutdown runs the whole server and stops it the way --follow
+// --exit-on-eof does.
+func TestServeShutdown(t *testing.T) {
+	// Keep the remembered ports and the browser out of it.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PATH", "")
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	oldPort, oldStrict := listenPort, strictPort
+	listenPort, strictPort = port, true
+	defer func() { listenPort, strictPort = oldPort, oldStrict }()
+
+	setDocument(t, filepath.Join(t.TempDir(), "doc.md"), "# Served\n")
+	watching := make(chan context.Context, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(nil, func(ctx context.Context) { watching <- ctx })
+	}()
+
+	var ctx context.Context
+	select {
+	case ctx = <-watching:
+	case err := <-errc:
+		t.Fatalf("serve: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not start")
+	}
+
+	resp, err := http.Get("http://" + net.JoinHostPort("localhost", strconv.Itoa(port)) + "/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /raw: %s", resp.Status)
+	}
+
+	quit <- struct{}{}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	if ctx.Err() == nil {
+		t.Error("watchers were not stopped")
+	}
+	if _, err := http.Get("http://" + net.JoinHostPort("localhost", strconv.Itoa(port)) + "/raw"); err == nil {
+		t.Error("server still answering after shutdown")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// With --share the server listens on every interface so others on the LAN
+// can follow along. Visitors need one of two links, each carrying its own
+// token: the viewer link gives read-only access, the editor link also
+// allows modifying the files (with --editable). Requests from this machine
+// act as the author and need no token.
+
+var (
+	shareLAN    bool   // --share
+	viewerToken string // set at startup with --share
+	editorToken string
+)
+
+// A shareRole is what a request may do.
+type shareRole int
+
+const (
+	roleNone   shareRole = iota // no valid token
+	roleViewer                  // read-only
+	roleEditor                  // may modify files when --editable is on
+)
+
+const shareCookie = "mdview-token"
+
+type roleKey struct{}
+
+// newShareTokens creates the session's viewer and editor tokens.
+func newShareTokens() error {
+	for _, t := range []*string{&viewerToken, &editorToken} {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		*t = hex.EncodeToString(b)
+	}
+	return nil
+}
+
+func tokenRole(token string) shareRole {
+	switch {
+	case token == "":
+		return roleNone
+	case subtle.ConstantTimeCompare([]byte(token), []byte(editorToken)) == 1:
+		return roleEditor
+	case subtle.ConstantTimeCompare([]byte(token), []byte(viewerToken)) == 1:
+		return roleViewer
+	}
+	return roleNone
+}
+
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// shareAuth checks share tokens. A token in the query string is moved into
+// a cookie and the URL reloaded without it, so it doesn't linger in the
+// address bar or in links copied from the page.
+func shareAuth(next http.Handler) http.Handler {
+	if !shareLAN {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := roleEditor
+		if !isLoopback(r) {
+			role = roleNone
+			if c, err := r.Cookie(shareCookie); err == nil {
+				role = tokenRole(c.Value)
+			}
+		}
+		if t := r.URL.Query().Get("token"); t != "" && r.Method == http.MethodGet {
+			if tokenRole(t) != roleNone {
+				http.SetCookie(w, &http.Cookie{Name: shareCookie, Value: t, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+				u := *r.URL
+				q := u.Query()
+				q.Del("token")
+				u.RawQuery = q.Encode()
+				http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+				return
+			}
+		}
+		if role == roleNone {
+			http.Error(w, "This mdview session is shared by link; ask for a viewer or editor link.", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, role)))
+	})
+}
+
+// canEdit reports whether r may modify the source files: --editable is on
+// and, when sharing, the request comes from the author or an editor link.
+func canEdit(r *http.Request) bool {
+	if !editable {
+		return false
+	}
+	role, ok := r.Context().Value(roleKey{}).(shareRole)
+	return !ok || role == roleEditor
+}
+
+// isViewer reports whether r came in through the read-only link.
+func isViewer(r *http.Request) bool {
+	role, _ := r.Context().Value(roleKey{}).(shareRole)
+	return role == roleViewer
+}
+
+// lanAddress returns this machine's first non-loopback IPv4 address, for
+// the links printed at startup.
+func lanAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+	}
+	return ""
+}
+
+// printShareLinks tells the author which links to hand out.
+func printShareLinks(scheme string, port int) {
+	host := lanAddress()
+	if host == "" {
+		host = "<this-machine>"
+	}
+	base := fmt.Sprintf("%s://%s/?token=", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+	fmt.Fprintf(os.Stderr, "Viewer link (read-only): %s%s\n", base, viewerToken)
+	if editable {
+		fmt.Fprintf(os.Stderr, "Editor link:             %s%s\n", base, editorToken)
+	}
+}
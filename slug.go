@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"golang.org/x/text/unicode/norm"
+)
+
+// anchorStyle selects how heading text becomes an anchor (--anchors):
+//
+//	ascii    drop non-ASCII characters (goldmark's default; "Café" → "caf")
+//	translit fold accents and common ligatures first ("Café" → "cafe")
+//	unicode  keep letters and digits of any script ("Café" → "café")
+var anchorStyle = "ascii"
+
+// anchorFlag validates --anchors.
+type anchorFlag struct{}
+
+func (anchorFlag) String() string { return anchorStyle }
+
+func (anchorFlag) Set(v string) error {
+	switch v {
+	case "ascii", "translit", "unicode":
+		anchorStyle = v
+		return nil
+	}
+	return fmt.Errorf("expected ascii, translit or unicode, got %q", v)
+}
+
+// translitTable covers letters that don't decompose into base + accent.
+var translitTable = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "ae", 'œ': "oe", 'Œ': "oe", 'ø': "o", 'Ø': "o",
+	'ł': "l", 'Ł': "l", 'đ': "d", 'Đ': "d", 'ð': "d", 'Ð': "d", 'þ': "th", 'Þ': "th",
+	'ı': "i",
+}
+
+// transliterate approximates s in ASCII: accents are stripped after
+// canonical decomposition. Scripts without a Latin reading are left as is
+// (and then dropped by the ASCII slug).
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if t, ok := translitTable[r]; ok {
+			b.WriteString(t)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// slugify turns heading text into an anchor in the given style. The ascii
+// style matches goldmark's own generator so existing links keep working.
+func slugify(value, style string) string {
+	if style == "translit" {
+		value = transliterate(value)
+	}
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(value) {
+		switch {
+		case r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(unicode.ToLower(r))
+		case r < 128 && unicode.IsSpace(r) || r == '-' || r == '_':
+			b.WriteByte('-')
+		case style == "unicode" && (unicode.IsLetter(r) || unicode.IsNumber(r)):
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// headingIDs generates heading IDs in anchorStyle. In a combined document
+// the IDs are also namespaced per file section: the heading parser Puts the
+// explicit ID of each generated file header, in document order, which is
+// where a new section begins.
+type headingIDs struct {
+	values   map[string]bool
+	sections map[string]string
+	prefix   string
+}
+
+func (s *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	id := slugify(string(value), anchorStyle)
+	if id == "" {
+		if kind == ast.KindHeading {
+			id = "heading"
+		} else {
+			id = "id"
+		}
+	}
+	id = s.prefix + id
+	if !s.values[id] {
+		s.values[id] = true
+		return []byte(id)
+	}
+	for i := 1; ; i++ {
+		next := fmt.Sprintf("%s-%d", id, i)
+		if !s.values[next] {
+			s.values[next] = true
+			return []byte(next)
+		}
+	}
+}
+
+func (s *headingIDs) Put(value []byte) {
+	if prefix, ok := s.sections[string(value)]; ok {
+		s.prefix = prefix
+	}
+	s.values[string(value)] = true
+}
+
+// parseOptions returns the parse options giving headings their IDs; sections
+// is fileSections for the watched content and nil for other documents.
+func parseOptions(sections map[string]string) []parser.ParseOption {
+	if sections == nil && anchorStyle == "ascii" {
+		return nil
+	}
+	ids := &headingIDs{values: make(map[string]bool), sections: sections}
+	return []parser.ParseOption{parser.WithContext(parser.NewContext(parser.WithIDs(ids)))}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshots are timestamped copies of the watched document's render (and
+// Markdown source), for readers who don't keep their notes in git. They
+// live in one directory per document under the user cache directory, or
+// under --snapshot-dir; the page lists them and diffs any two.
+
+var snapshotDir string // --snapshot-dir; empty uses the user cache directory
+
+// A snapshot is one stored version. HTML and Source are left out of
+// listings.
+type snapshot struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Label  string    `json:"label,omitempty"`
+	HTML   string    `json:"html,omitempty"`
+	Source string    `json:"source,omitempty"`
+}
+
+// Snapshot IDs are their UTC time, which sorts and names files safely.
+const snapshotIDLayout = "20060102T150405.000000000Z"
+
+var snapshotIDPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z$`)
+
+// snapshotsDir returns the watched document's snapshot directory, named
+// after the file plus a hash of its absolute path so same-named documents
+// elsewhere don't share history.
+func snapshotsDir() (string, error) {
+	base := snapshotDir
+	if base == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(dir, "mdview", "snapshots")
+	}
+	doc := documentKey(docs.Get(mainDocument).Path)
+	sum := sha256.Sum256([]byte(doc))
+	return filepath.Join(base, filepath.Base(doc)+"-"+hex.EncodeToString(sum[:4])), nil
+}
+
+func readSnapshot(dir, id string) (*snapshot, error) {
+	if !snapshotIDPattern.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// listSnapshots returns the snapshots in dir, newest first, without their
+// content.
+func listSnapshots(dir string) ([]snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []snapshot{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		s, err := readSnapshot(dir, id)
+		if err != nil {
+			continue
+		}
+		s.HTML, s.Source = "", ""
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+// takeSnapshot stores the current render. When nothing changed since the
+// newest snapshot, that one is returned instead and unchanged is true.
+func takeSnapshot(label string) (s *snapshot, unchanged bool, err error) {
+	d := docs.Get(mainDocument)
+	rendered, err := renderDocument(d)
+	if err != nil {
+		return nil, false, err
+	}
+	src := string(d.Content)
+	dir, err := snapshotsDir()
+	if err != nil {
+		return nil, false, err
+	}
+	if list, err := listSnapshots(dir); err == nil && len(list) > 0 {
+		if last, err := readSnapshot(dir, list[0].ID); err == nil && last.HTML == string(rendered) && label == "" {
+			last.HTML, last.Source = "", ""
+			return last, true, nil
+		}
+	}
+
+	now := time.Now()
+	s = &snapshot{ID: now.UTC().Format(snapshotIDLayout), Time: now, Label: label, HTML: string(rendered), Source: src}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, false, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, s.ID+".json"), data); err != nil {
+		return nil, false, err
+	}
+	s.HTML, s.Source = "", ""
+	return s, false, nil
+}
+
+// handleSnapshot serves /api/snapshot: GET lists the snapshots, GET ?id=
+// returns one with its HTML, and POST (optionally {"label": "..."}) takes
+// a new one.
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if isLocked() || dirRoot != "" {
+		http.NotFound(w, r)
+		return
+	}
+	if encrypted {
+		// A snapshot would write the plaintext to disk.
+		http.Error(w, "snapshots are disabled for encrypted documents", http.StatusForbidden)
+		return
+	}
+	dir, err := snapshotsDir()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if id := r.URL.Query().Get("id"); id != "" {
+			s, err := readSnapshot(dir, id)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, s)
+			return
+		}
+		list, err := listSnapshots(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"snapshots": list})
+	case http.MethodPost:
+		if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" || isViewer(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		s, unchanged, err := takeSnapshot(strings.TrimSpace(req.Label))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"snapshot": s, "unchanged": unchanged})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
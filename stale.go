@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staleAfter flags documents not reviewed or changed for this long with a
+// "possibly outdated" banner (--stale-after; 0 disables).
+var staleAfter time.Duration
+
+// ageFlag is a duration flag that also accepts days and weeks ("180d").
+type ageFlag struct{ d *time.Duration }
+
+func (f ageFlag) String() string {
+	if f.d == nil || *f.d == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%dd", *f.d/(24*time.Hour))
+}
+
+func (f ageFlag) Set(v string) error {
+	d, err := parseAge(v)
+	if err != nil {
+		return err
+	}
+	*f.d = d
+	return nil
+}
+
+func parseAge(v string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(v, suffix); ok {
+			if i, err := strconv.Atoi(n); err == nil && i >= 0 {
+				return time.Duration(i) * unit, nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("expected an age such as 180d, 26w or 720h, got %q", v)
+	}
+	return d, nil
+}
+
+// frontMatterField returns the value of key in a leading YAML front matter
+// block ("---" fenced). Only flat `key: value` lines are understood.
+func frontMatterField(src []byte, key string) (string, bool) {
+	src = bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "---" {
+		return "", false
+	}
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "---" || line == "..." {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(k) == key {
+			return strings.Trim(strings.TrimSpace(v), `"'`), true
+		}
+	}
+	return "", false
+}
+
+// A docAge is when a document was last known to be current.
+type docAge struct {
+	When   time.Time
+	Source string // "reviewed", "last commit" or "modified"
+}
+
+// reviewedAt parses the front matter `reviewed:` date of src.
+func reviewedAt(src []byte) (time.Time, bool) {
+	v, ok := frontMatterField(src, "reviewed")
+	if !ok {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// gitLastCommits returns the latest commit time of each file under dir
+// touched by git history, keyed by absolute path. It is empty outside a
+// repository or without git.
+func gitLastCommits(dir string, pathspecs ...string) map[string]time.Time {
+	dates := make(map[string]time.Time)
+	args := append([]string{"-C", dir, "-c", "core.quotePath=false", "log", "--format=@%cI", "--name-only", "--relative", "--"}, pathspecs...)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return dates
+	}
+	var when time.Time
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "@") {
+			when, _ = time.Parse(time.RFC3339, line[1:])
+			continue
+		}
+		if line == "" || when.IsZero() {
+			continue
+		}
+		// git log is newest first, so the first mention wins.
+		abs := filepath.Join(dir, filepath.FromSlash(line))
+		if _, seen := dates[abs]; !seen {
+			dates[abs] = when
+		}
+	}
+	return dates
+}
+
+// ageOf decides how old path is: its front matter review date, else its
+// last commit (from commits, see gitLastCommits), else its mtime.
+func ageOf(path string, commits map[string]time.Time) (docAge, error) {
+	src, err := readSource(path)
+	if err != nil {
+		return docAge{}, err
+	}
+	if t, ok := reviewedAt(src); ok {
+		return docAge{t, "reviewed"}, nil
+	}
+	abs, _ := filepath.Abs(path)
+	if t, ok := commits[abs]; ok {
+		return docAge{t, "last commit"}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return docAge{}, err
+	}
+	return docAge{info.ModTime(), "modified"}, nil
+}
+
+// staleNotes returns a note for each stale file among paths for the
+// "possibly outdated" banner, or nil when none are (or --stale-after is
+// off).
+func staleNotes(paths []string) []string {
+	if staleAfter <= 0 {
+		return nil
+	}
+	var items []string
+	for _, p := range paths {
+		if p == "" || isDirectory(p) {
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		age, err := ageOf(p, gitLastCommits(filepath.Dir(abs), filepath.Base(abs)))
+		if err != nil || time.Since(age.When) < staleAfter {
+			continue
+		}
+		item := fmt.Sprintf("%s %s", age.Source, age.When.Format("Jan 2, 2006"))
+		if len(paths) > 1 {
+			item = displayName(filepath.Base(p)) + ": " + item
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directory mode reads its documents through an fs.FS, so besides a
+// directory it can serve a .zip or .tar(.gz, .bz2) archive, a public
+// S3-compatible bucket, or a tree compiled into the binary, all read-only:
+//
+//	mdview docs/
+//	mdview docs.zip
+//	mdview s3://bucket/docs
+//	mdview 'https://minio.local:9000/bucket?prefix=docs/'
+//	mdview embed:   (built with -tags embeddocs)
+
+// docStore is the tree served in directory mode.
+var docStore fs.FS
+
+// embeddedDocs is set by embeddocs.go in builds with -tags embeddocs.
+var embeddedDocs fs.FS
+
+var archiveExts = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2"}
+
+// isStoreSpec reports whether arg names a non-directory document store.
+func isStoreSpec(arg string) bool {
+	lower := strings.ToLower(arg)
+	for _, p := range []string{"s3://", "http://", "https://", "embed:"} {
+		if strings.HasPrefix(lower, p) {
+			return true
+		}
+	}
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// openStore opens the store named by spec (see isStoreSpec).
+func openStore(spec string) (fs.FS, error) {
+	lower := strings.ToLower(spec)
+	switch {
+	case strings.HasPrefix(lower, "embed:"):
+		if embeddedDocs == nil {
+			return nil, errors.New("this mdview was built without embedded documents (-tags embeddocs)")
+		}
+		return embeddedDocs, nil
+	case strings.HasPrefix(lower, "s3://"):
+		bucket, prefix, _ := strings.Cut(spec[len("s3://"):], "/")
+		u := &url.URL{Scheme: "https", Host: bucket + ".s3.amazonaws.com", Path: "/"}
+		if prefix != "" {
+			u.RawQuery = url.Values{"prefix": {strings.TrimSuffix(prefix, "/") + "/"}}.Encode()
+		}
+		return openBucket(u)
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		return openBucket(u)
+	case strings.HasSuffix(lower, ".zip"):
+		z, err := zip.OpenReader(spec)
+		if err != nil {
+			return nil, err
+		}
+		return unwrapArchive(z)
+	}
+	t, err := openTar(spec)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapArchive(t)
+}
+
+// unwrapArchive serves the contents of an archive's only top-level
+// directory, if that is all it holds ("project-1.2/docs/..."), so the
+// index and links start where the author's tree did.
+func unwrapArchive(fsys fs.FS) (fs.FS, error) {
+	for {
+		entries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 || !entries[0].IsDir() {
+			return fsys, nil
+		}
+		if fsys, err = fs.Sub(fsys, entries[0].Name()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// siteFile maps a URL path to a file of the served tree: the document
+// store in directory mode, else the directory of the input files. name is
+// the file's path for display and per-document settings. It reports false
+// when nothing is served or the path would leave the tree.
+func siteFile(urlPath string) (fsys fs.FS, rel, name string, ok bool) {
+	switch {
+	case docStore != nil:
+		fsys = docStore
+	case baseDir != "":
+		fsys = os.DirFS(baseDir)
+	default:
+		return nil, "", "", false
+	}
+	// Cleaning a rooted path drops any "..". Hidden files and directories
+	// (.git, .env) are left out, as in the directory index. On Windows a
+	// backslash or colon would let filepath.Join climb out (..\) or switch
+	// drives (C:), so those are refused too.
+	rel = strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(urlPath, "/")), "/")
+	if rel == "" || !fs.ValidPath(rel) {
+		return nil, "", "", false
+	}
+	for _, seg := range strings.Split(rel, "/") {
+		if strings.HasPrefix(seg, ".") || filepath.Separator == '\\' && strings.ContainsAny(seg, `\:`) {
+			return nil, "", "", false
+		}
+	}
+	if docStore != nil {
+		rel = resolveSlug(fsys, rel)
+	}
+	if baseDir != "" {
+		name = filepath.Join(baseDir, filepath.FromSlash(rel))
+		if !withinDir(baseDir, name) {
+			return nil, "", "", false
+		}
+	} else {
+		name = strings.TrimSuffix(dirRoot, "/") + "/" + rel
+	}
+	return fsys, rel, name, true
+}
+
+// withinDir reports whether path, once symlinks are resolved, is still
+// under dir. A path that doesn't exist passes; opening it will fail.
+func withinDir(dir, path string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return true
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// serveSiteFile serves a file of the served tree: Markdown rendered as a
+// page, anything else (images, etc.) as is.
+func serveSiteFile(w http.ResponseWriter, r *http.Request) {
+	fsys, rel, name, ok := siteFile(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := fs.Stat(fsys, rel)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := fs.ReadFile(fsys, rel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if docStore != nil && isMarkdown(rel) {
+		if slug := siteSlug(fsys, rel); r.URL.Path != slug {
+			u := *r.URL
+			u.Path, u.RawPath = slug, ""
+			http.Redirect(w, r, u.String(), http.StatusFound)
+			return
+		}
+	}
+	if isMarkdown(rel) {
+		rendered, err := convertLimited(data, parseOptions(nil)...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writePage(w, r, name, data, rendered, info.ModTime(), false)
+		return
+	}
+	if !currentRenderer().Unsafe {
+		// With raw HTML off, an .html or .svg in the tree can't run scripts
+		// as this origin either: it opens sandboxed. Images still load.
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	http.ServeContent(w, r, rel, info.ModTime(), bytes.NewReader(data))
+}
+
+// openTar reads a (compressed) tar archive into memory, up to
+// --max-input-size per file and maxArchiveSize in all.
+func openTar(name string) (fs.FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch lower := strings.ToLower(name); {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(lower, ".bz2"), strings.HasSuffix(lower, ".tbz2"):
+		r = bzip2.NewReader(f)
+	}
+	tr := tar.NewReader(r)
+	var files []*indexFile
+	var total int64
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		p := strings.TrimPrefix(path.Clean(strings.TrimPrefix(h.Name, "./")), "/")
+		if h.Typeflag != tar.TypeReg || !fs.ValidPath(p) {
+			continue
+		}
+		data, err := readAtMost(tr, maxInputSize)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", name, p, err)
+		}
+		if total += int64(len(data)); maxInputSize > 0 && total > maxArchiveSize() {
+			return nil, fmt.Errorf("%s: more than %s of files", name, sizeFlag{&total})
+		}
+		files = append(files, &indexFile{name: p, size: int64(len(data)), modTime: h.ModTime, data: data})
+	}
+	return newIndexFS(files, nil), nil
+}
+
+// bucketRefresh is how long a bucket listing is reused before the index
+// page lists the bucket again.
+const bucketRefresh = 30 * time.Second
+
+var bucketClient = &http.Client{Timeout: 30 * time.Second}
+
+// A bucketFS lists an S3-compatible bucket (ListObjectsV2, anonymous read)
+// and fetches objects as they are opened.
+type bucketFS struct {
+	*indexFS
+	endpoint *url.URL // bucket root
+	prefix   string
+
+	mu     sync.Mutex // serializes listings
+	listed time.Time
+}
+
+func openBucket(u *url.URL) (*bucketFS, error) {
+	b := &bucketFS{endpoint: u, prefix: u.Query().Get("prefix")}
+	b.endpoint.RawQuery = ""
+	if err := b.Refresh(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Refresh lists the bucket again once the last listing is older than
+// bucketRefresh.
+func (b *bucketFS) Refresh() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.indexFS != nil && time.Since(b.listed) < bucketRefresh {
+		return nil
+	}
+	var files []*indexFile
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {b.prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u := *b.endpoint
+		u.RawQuery = q.Encode()
+		var page struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := b.get(u.String(), func(r io.Reader) error { return xml.NewDecoder(r).Decode(&page) }); err != nil {
+			return fmt.Errorf("listing %s: %w", b.endpoint.Redacted(), err)
+		}
+		for _, c := range page.Contents {
+			name := strings.TrimPrefix(c.Key, b.prefix)
+			if fs.ValidPath(name) && name != "." && !strings.HasSuffix(c.Key, "/") {
+				files = append(files, &indexFile{name: name, size: c.Size, modTime: c.LastModified})
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	fsys := newIndexFS(files, b.fetch)
+	if b.indexFS == nil {
+		b.indexFS = fsys
+	} else {
+		b.indexFS.replace(fsys)
+	}
+	b.listed = time.Now()
+	return nil
+}
+
+func (b *bucketFS) fetch(name string) ([]byte, error) {
+	u := *b.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.prefix + name
+	var data []byte
+	err := b.get(u.String(), func(r io.Reader) (err error) {
+		data, err = readAtMost(r, maxInputSize)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return data, nil
+}
+
+func (b *bucketFS) get(u string, read func(io.Reader) error) error {
+	resp, err := bucketClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if maxInputSize > 0 {
+		// Nothing read from a bucket is larger; fetch reports hitting it.
+		return read(io.LimitReader(resp.Body, maxInputSize+1))
+	}
+	return read(resp.Body)
+}
+
+// An indexFS is a read-only fs.FS over a fixed list of files, for archives
+// and buckets; directories are implied by the file paths. File contents
+// are either in memory already or fetched, and kept, on first open.
+type indexFS struct {
+	mu    sync.Mutex
+	files map[string]*indexFile
+	dirs  map[string][]fs.DirEntry
+	fetch func(name string) ([]byte, error)
+}
+
+type indexFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+	data    []byte
+}
+
+func newIndexFS(files []*indexFile, fetch func(string) ([]byte, error)) *indexFS {
+	fsys := &indexFS{files: make(map[string]*indexFile), dirs: map[string][]fs.DirEntry{".": nil}, fetch: fetch}
+	seen := make(map[string]bool)
+	add := func(dir string, info fs.FileInfo) {
+		if key := dir + "\x00" + info.Name(); !seen[key] {
+			seen[key] = true
+			fsys.dirs[dir] = append(fsys.dirs[dir], fs.FileInfoToDirEntry(info))
+		}
+	}
+	for _, f := range files {
+		fsys.files[f.name] = f
+		child, info := f.name, fs.FileInfo(indexInfo{f})
+		for {
+			dir := path.Dir(child)
+			add(dir, info)
+			if dir == "." {
+				break
+			}
+			child, info = dir, dirInfo(path.Base(dir))
+		}
+	}
+	for _, entries := range fsys.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return fsys
+}
+
+// replace swaps in the files and directories of a fresh listing.
+func (fsys *indexFS) replace(from *indexFS) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.files, fsys.dirs = from.files, from.dirs
+}
+
+func (fsys *indexFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	fsys.mu.Lock()
+	f, isFile := fsys.files[name]
+	entries, isDir := fsys.dirs[name]
+	fsys.mu.Unlock()
+	switch {
+	case isFile:
+		data, err := fsys.contents(f)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &indexOpenFile{info: indexInfo{f}, Reader: bytes.NewReader(data)}, nil
+	case isDir:
+		return &indexOpenDir{info: dirInfo(path.Base(name)), entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (fsys *indexFS) contents(f *indexFile) ([]byte, error) {
+	fsys.mu.Lock()
+	data := f.data
+	fsys.mu.Unlock()
+	if data != nil || fsys.fetch == nil {
+		return data, nil
+	}
+	data, err := fsys.fetch(f.name)
+	if err != nil {
+		return nil, err
+	}
+	fsys.mu.Lock()
+	f.data = data
+	fsys.mu.Unlock()
+	return data, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *indexFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys.mu.Lock()
+	entries, ok := fsys.dirs[name]
+	fsys.mu.Unlock()
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry(nil), entries...), nil
+}
+
+type indexInfo struct{ f *indexFile }
+
+func (i indexInfo) Name() string       { return path.Base(i.f.name) }
+func (i indexInfo) Size() int64        { return i.f.size }
+func (i indexInfo) Mode() fs.FileMode  { return 0o444 }
+func (i indexInfo) ModTime() time.Time { return i.f.modTime }
+func (i indexInfo) IsDir() bool        { return false }
+func (i indexInfo) Sys() interface{}   { return nil }
+
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
+
+type indexOpenFile struct {
+	info fs.FileInfo
+	*bytes.Reader
+}
+
+func (f *indexOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *indexOpenFile) Close() error               { return nil }
+
+type indexOpenDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *indexOpenDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *indexOpenDir) Close() error               { return nil }
+
+func (d *indexOpenDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *indexOpenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
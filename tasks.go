@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// With --edit-tasks the page's task list boxes can be ticked: the page
+// posts the item's data-line, mdview sets [ ] or [x] on that line of the
+// input file, and the watcher reloads every open page as for any edit.
+// Nothing else on the line is touched, so an edit made in the meantime
+// survives; if the line is no longer a task the page is told to reload.
+
+// editTasks is --edit-tasks.
+var editTasks bool
+
+// taskBoxRe is taskLineRe for any task the page shows a box for, which
+// includes tasks quoted in a blockquote or an alert ("> - [ ] item").
+var taskBoxRe = regexp.MustCompile(`^(\s*(?:>\s*)*(?:[-*+]|\d+[.)])\s+\[)([ xX])(\].*)$`)
+
+// canEditTasks reports whether r may tick task boxes.
+func canEditTasks(r *http.Request) bool {
+	if !editTasks || encrypted {
+		return false
+	}
+	role, ok := r.Context().Value(roleKey{}).(shareRole)
+	return !ok || role == roleEditor
+}
+
+// setTaskLine returns line with its task box set to checked, or false if
+// it isn't a task list item.
+func setTaskLine(line string, checked bool) (string, bool) {
+	cr := ""
+	if strings.HasSuffix(line, "\r") {
+		line, cr = strings.TrimSuffix(line, "\r"), "\r"
+	}
+	m := taskBoxRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	box := " "
+	if checked {
+		box = "x"
+		if m[2] == "X" {
+			box = "X"
+		}
+	}
+	return m[1] + box + m[3] + cr, true
+}
+
+// handleTaskToggle serves POST /api/tasks/toggle[?page=route] with
+// {"line": 12, "checked": true}, line being the item's line in the
+// document.
+func handleTaskToggle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if !canEditTasks(r) {
+		http.Error(w, "start mdview with --edit-tasks to tick tasks", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Line    int  `json:"line"`
+		Checked bool `json:"checked"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Line < 1 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	key := requestedDocument(r)
+	d := docs.Get(key)
+	if d.Path == "" {
+		http.Error(w, "this document has no file to write to", http.StatusForbidden)
+		return
+	}
+	paths := []string{d.Path}
+	if key == mainDocument && len(inputPaths) > 0 && inputPages == nil {
+		paths = inputPaths
+	}
+	path, line := sourceLocation(d.Content, paths, req.Line)
+	if isEncrypted(path) || isEmail(path) {
+		http.Error(w, "read-only file", http.StatusForbidden)
+		return
+	}
+	editMu.Lock()
+	defer editMu.Unlock()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	lines := strings.Split(string(data), "\n")
+	updated, ok := "", false
+	if line >= 1 && line <= len(lines) {
+		updated, ok = setTaskLine(lines[line-1], req.Checked)
+	}
+	if !ok {
+		http.Error(w, "the file changed; reload the page", http.StatusConflict)
+		return
+	}
+	if updated != lines[line-1] {
+		lines[line-1] = updated
+		if err := writeFileAtomic(path, []byte(strings.Join(lines, "\n"))); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The HTML pages are html/template files under templates/: page.html (with
+// page.js and reload.js for its script), lock.html and export.html. Values
+// are escaped for where they land, so file names and titles can't inject
+// markup; rendered Markdown, the stylesheet and the config are passed as
+// the trusted types they are. page.html is split into blocks (toolbar,
+// sidebar, content, footer) that can be redefined.
+//
+// Release builds embed the templates and parse them once. Built with
+// -tags dev, mdview reads them from the source tree on every page, so edits
+// show on reload.
+
+var templateFuncs = template.FuncMap{"join": strings.Join}
+
+// parseTemplates parses the templates directory of fsys.
+func parseTemplates(fsys fs.FS) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs).ParseFS(fsys, "templates/*.html", "templates/*.js")
+}
+
+// pageData is what page.html is executed with.
+type pageData struct {
+	Title      string
+	Author     string // from the front matter
+	Theme      *theme // the default theme; nil follows the system
+	CSS        template.CSS
+	UserCSS    string                 // the --css stylesheet's URL
+	Config     map[string]interface{} // the page script's config object
+	Stale      []string               // "possibly outdated" notes; see staleNotes
+	Modified   time.Time
+	Content    template.HTML
+	Large      bool     // the content lays out lazily; see firstpaint.go
+	Nav        *pageNav // links between the input files served as pages
+	LiveReload bool     // include reload.js
+}
+
+// executeTemplate writes the named template to w. The page is built in
+// memory first so a failure is a 500, not half a page.
+func executeTemplate(w http.ResponseWriter, name string, data interface{}) {
+	page, err := renderTemplate(name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// renderTemplate returns the named template executed with data.
+func renderTemplate(name string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	t, err := loadTemplates()
+	if err == nil {
+		err = t.ExecuteTemplate(&buf, name, data)
+	}
+	return buf.Bytes(), err
+}
+
+// displayName makes a file name safe to show: control characters and the
+// bidirectional formatting ones, which can make "evil\u202egpj.exe" read as
+// "evilexe.jpg", become U+FFFD, as do invalid UTF-8 bytes. Escaping for the
+// context is still the template's job.
+func displayName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) {
+			return unicode.ReplacementChar
+		}
+		return r
+	}, s)
+}
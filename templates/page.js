@@ -0,0 +1,1872 @@
+(function() {
+  const config = {{.Config}};
+  // pageURL names the page in a request about the document, when the
+  // input files are served as pages of their own.
+  function pageURL(url) {
+    if (!config.page) return url;
+    return url + (url.indexOf('?') < 0 ? '?' : '&') + 'page=' + encodeURIComponent(config.page);
+  }
+
+  // Themes: data-theme is the light or dark base style, data-palette the
+  // theme's colors over it; neither follows the system. The toggle cycles
+  // through Auto and each theme.
+  const toggle = document.getElementById('themeToggle');
+  const root = document.documentElement;
+  function themeNamed(name) {
+    name = {light: 'github', dark: 'github-dark'}[name] || name;
+    return config.themes.find(function(t) { return t.name === name; });
+  }
+  function setTheme(name) {
+    const t = themeNamed(name);
+    if (t) {
+      root.setAttribute('data-theme', t.scheme);
+      root.setAttribute('data-palette', t.name);
+    } else {
+      root.removeAttribute('data-theme');
+      root.removeAttribute('data-palette');
+    }
+  }
+  // baseTheme is the theme without the reader's per-document choice.
+  function baseTheme() {
+    return config.profileSettings.theme || localStorage.getItem('mdview-theme') || config.theme;
+  }
+  setTheme(baseTheme());
+
+  toggle.addEventListener('click', function() {
+    const names = [''].concat(config.themes.map(function(t) { return t.name; }));
+    const next = names[(names.indexOf(root.getAttribute('data-palette') || '') + 1) % names.length];
+    setTheme(next);
+    if (next) localStorage.setItem('mdview-theme', next);
+    else localStorage.removeItem('mdview-theme');
+    toggle.title = 'Theme: ' + (next ? themeNamed(next).label : 'Auto');
+    if ('theme' in settings) {
+      delete settings.theme;
+      saveSettings();
+    }
+  });
+
+  // Per-document reading settings, stored under the document's path.
+  const settingsKey = 'mdview-settings:' + config.document;
+  const settingsPanel = document.getElementById('settingsPanel');
+  let settings = {};
+  try { settings = JSON.parse(localStorage.getItem(settingsKey) || '{}'); } catch (e) {}
+  let reloadPending = false;
+  function saveSettings() {
+    localStorage.setItem(settingsKey, JSON.stringify(settings));
+  }
+  function applySettings() {
+    const s = root.style;
+    if ('theme' in settings) setTheme(settings.theme);
+    // The active profile supplies defaults for what the reader hasn't set.
+    const p = config.profileSettings;
+    const width = settings.width || p.width;
+    const fontSize = settings.fontSize || p.fontSize;
+    const lineHeight = settings.lineHeight || p.lineHeight;
+    width ? s.setProperty('--content-width', width + 'px') : s.removeProperty('--content-width');
+    fontSize ? s.setProperty('--font-size', fontSize + 'px') : s.removeProperty('--font-size');
+    lineHeight ? s.setProperty('--line-height', lineHeight) : s.removeProperty('--line-height');
+    let link = document.getElementById('highlightStyle');
+    // Plain pages keep the built-in style rather than fetch another.
+    const highlight = !config.plain && (settings.highlight || config.highlight);
+    if (highlight) {
+      if (!link) {
+        link = document.createElement('link');
+        link.id = 'highlightStyle';
+        link.rel = 'stylesheet';
+        document.head.appendChild(link);
+      }
+      link.href = '/highlight.css?style=' + encodeURIComponent(highlight);
+      root.setAttribute('data-hl', highlight);
+    } else {
+      if (link) link.remove();
+      root.removeAttribute('data-hl');
+    }
+    document.getElementById('reloadPaused').hidden = !settings.pauseReload;
+    if (!settings.pauseReload && reloadPending) {
+      reloadPending = false;
+      if (typeof reloadContent === 'function') reloadContent();
+    }
+  }
+  function syncSettingsForm() {
+    const f = settingsPanel;
+    const t = themeNamed(settings.theme !== undefined ? settings.theme : root.getAttribute('data-palette'));
+    f.theme.value = t ? t.name : '';
+    const p = config.profileSettings;
+    f.width.value = settings.width || p.width || 980;
+    f.fontSize.value = settings.fontSize || p.fontSize || 16;
+    f.lineHeight.value = settings.lineHeight || p.lineHeight || 1.6;
+    f.profile.value = config.profile;
+    f.highlight.value = settings.highlight || '';
+    f.pauseReload.checked = !!settings.pauseReload;
+    f.outline.checked = !!settings.outline;
+    f.vim.checked = vimEnabled();
+    f.copyHeader.checked = localStorage.getItem('mdview-copy-header') === '1';
+  }
+  config.themes.forEach(function(t) {
+    const opt = document.createElement('option');
+    opt.value = t.name;
+    opt.textContent = t.label;
+    settingsPanel.theme.appendChild(opt);
+  });
+  config.highlightStyles.forEach(function(name) {
+    const opt = document.createElement('option');
+    opt.value = opt.textContent = name;
+    settingsPanel.highlight.appendChild(opt);
+  });
+  document.getElementById('settingsToggle').addEventListener('click', function() {
+    settingsPanel.hidden = !settingsPanel.hidden;
+    if (!settingsPanel.hidden) syncSettingsForm();
+  });
+  const rendererSettings = document.getElementById('rendererSettings');
+  settingsPanel.addEventListener('input', function(e) {
+    const el = e.target;
+    if (rendererSettings.contains(el)) return;
+    if (el.name === 'profile') {
+      // Profiles apply to everyone viewing; reload to pick up the new
+      // defaults.
+      fetch('/api/profile', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({name: el.value})
+      }).then(function(res) {
+        if (res.ok) location.reload();
+        else el.value = config.profile;
+      });
+      return;
+    }
+    if (el.name === 'vim') {
+      localStorage.setItem('mdview-vim', el.checked ? '1' : '0');
+      return;
+    }
+    if (el.name === 'copyHeader') {
+      localStorage.setItem('mdview-copy-header', el.checked ? '1' : '0');
+      return;
+    }
+    settings[el.name] = el.type === 'checkbox' ? el.checked : el.value;
+    saveSettings();
+    if (el.name === 'outline') refreshOutline();
+    else applySettings();
+  });
+  settingsPanel.reset.addEventListener('click', function() {
+    settings = {};
+    localStorage.removeItem(settingsKey);
+    localStorage.removeItem('mdview-vim');
+    localStorage.removeItem('mdview-copy-header');
+    setTheme(baseTheme());
+    applySettings();
+    refreshOutline();
+    syncSettingsForm();
+  });
+
+  if (config.renderer) {
+    config.profiles.forEach(function(name) {
+      const opt = document.createElement('option');
+      opt.value = opt.textContent = name;
+      settingsPanel.profile.appendChild(opt);
+    });
+    settingsPanel.profile.hidden = settingsPanel.querySelector('[for="set-profile"]').hidden = false;
+  }
+
+  // Renderer options change how the server renders, for every viewer, so
+  // they are sent to it instead of kept with the reading settings.
+  if (config.renderer) {
+    const rendererStatus = rendererSettings.querySelector('.renderer-status');
+    config.highlightStyles.forEach(function(name) {
+      const opt = document.createElement('option');
+      opt.value = opt.textContent = name;
+      rendererSettings.querySelector('select').appendChild(opt);
+    });
+    fetch('/api/renderer').then(function(res) { return res.json(); }).then(function(data) {
+      data.extensions.forEach(function(name) {
+        const label = document.createElement('label');
+        label.className = 'renderer-option';
+        const box = document.createElement('input');
+        box.type = 'checkbox';
+        box.name = 'ext';
+        box.value = name;
+        box.checked = data.options.extensions.indexOf(name) >= 0;
+        label.append(box, ' ' + name);
+        rendererSettings.insertBefore(label, rendererStatus);
+      });
+      rendererSettings.querySelector('[name="unsafe"]').checked = data.options.unsafe;
+      const hl = rendererSettings.querySelector('select');
+      // A light,dark pair from --highlight-style or the config isn't listed.
+      if (data.options.highlight && !Array.from(hl.options).some(function(o) { return o.value === data.options.highlight; })) {
+        const opt = document.createElement('option');
+        opt.value = opt.textContent = data.options.highlight;
+        hl.insertBefore(opt, hl.options[1] || null);
+      }
+      hl.value = data.options.highlight || '';
+      rendererSettings.hidden = false;
+    });
+    rendererSettings.addEventListener('change', function() {
+      const options = {
+        unsafe: rendererSettings.querySelector('[name="unsafe"]').checked,
+        extensions: Array.from(rendererSettings.querySelectorAll('[name="ext"]:checked')).map(function(b) { return b.value; }),
+        highlight: rendererSettings.querySelector('select').value
+      };
+      rendererStatus.textContent = 'Applying...';
+      fetch('/api/renderer', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(options)
+      }).then(function(res) {
+        if (!res.ok) return res.text().then(function(t) { throw new Error(t); });
+        rendererStatus.textContent = '';
+        config.highlight = options.highlight;
+        applySettings();
+      }).catch(function(err) { rendererStatus.textContent = err.message; });
+    });
+  }
+  applySettings();
+
+  function downloadBlob(blob, filename) {
+    const a = document.createElement('a');
+    a.href = URL.createObjectURL(blob);
+    a.download = filename;
+    document.body.appendChild(a);
+    a.click();
+    a.remove();
+    setTimeout(function() { URL.revokeObjectURL(a.href); }, 0);
+  }
+
+  // Code block copy and download buttons (delegated so they survive live
+  // reload)
+  document.addEventListener('click', function(e) {
+    const btn = e.target.closest('.code-copy, .code-download');
+    if (!btn) return;
+    const code = btn.closest('.code-block').querySelector('code');
+    if (btn.classList.contains('code-download')) {
+      downloadBlob(new Blob([code.textContent], {type: 'text/plain;charset=utf-8'}), btn.dataset.filename || 'snippet.txt');
+      return;
+    }
+    // With "Copy with header" on, the code starts with a comment naming
+    // its file or language.
+    const header = btn.closest('.code-block').querySelector('.code-header[data-copy-header]');
+    const text = header && localStorage.getItem('mdview-copy-header') === '1'
+      ? header.dataset.copyHeader + '\n' + code.textContent : code.textContent;
+    copyText(text).then(function() {
+      btn.textContent = '✓';
+    }, function() {
+      btn.textContent = '✗';
+    }).then(function() {
+      setTimeout(function() { btn.textContent = '⧉'; }, 1500);
+    });
+  });
+  // copyText puts text on the clipboard, also where the async API is
+  // missing (plain HTTP on the LAN with --share).
+  function copyText(text) {
+    if (navigator.clipboard && window.isSecureContext) return navigator.clipboard.writeText(text);
+    return new Promise(function(resolve, reject) {
+      const area = document.createElement('textarea');
+      area.value = text;
+      area.style.position = 'fixed';
+      area.style.opacity = '0';
+      document.body.appendChild(area);
+      area.select();
+      const ok = document.execCommand('copy');
+      area.remove();
+      ok ? resolve() : reject(new Error('copy failed'));
+    });
+  }
+
+  // Remember <details> open/closed state across reloads, keyed by summary
+  // text and its occurrence so inserted blocks don't shift the others.
+  const detailsKey = 'mdview-details:' + location.pathname;
+  function eachDetails(fn) {
+    const seen = {};
+    // Folded json/yaml trees have too many to track.
+    document.querySelectorAll('.container details:not(.data-tree details)').forEach(function(el) {
+      const s = el.querySelector('summary');
+      const text = s ? s.textContent.trim() : '';
+      seen[text] = (seen[text] || 0) + 1;
+      fn(el, text + '#' + seen[text]);
+    });
+  }
+  function saveDetails() {
+    const state = {};
+    eachDetails(function(el, id) { state[id] = el.open; });
+    sessionStorage.setItem(detailsKey, JSON.stringify(state));
+  }
+  function restoreDetails() {
+    let state = {};
+    try { state = JSON.parse(sessionStorage.getItem(detailsKey) || '{}'); } catch (e) {}
+    eachDetails(function(el, id) {
+      if (id in state) el.open = state[id];
+    });
+  }
+  document.addEventListener('toggle', function(e) {
+    if (!e.target.closest('.data-tree')) saveDetails();
+  }, true);
+  restoreDetails();
+
+  // Math comes as MathML. Builds with KaTeX fetch it the first time a page
+  // has some, then typeset each .math element's TeX annotation once; live
+  // reloads bring fresh, unrendered ones.
+  let katexReady = null;
+  function renderMath(root) {
+    const els = root.querySelectorAll('.math:not([data-typeset])');
+    if (!config.math || els.length === 0) return;
+    if (!katexReady) {
+      katexReady = new Promise(function(resolve, reject) {
+        const css = document.createElement('link');
+        css.rel = 'stylesheet';
+        css.href = '/katex/katex.min.css';
+        document.head.appendChild(css);
+        const script = document.createElement('script');
+        script.src = '/katex/katex.min.js';
+        script.onload = resolve;
+        script.onerror = reject;
+        document.head.appendChild(script);
+      });
+    }
+    katexReady.then(function() {
+      els.forEach(function(el) {
+        const tex = mathTeX(el);
+        try {
+          katex.render(tex, el, {displayMode: el.classList.contains('math-display'), throwOnError: false});
+          el.dataset.typeset = '';
+          el.title = tex;
+        } catch (e) {}
+      });
+    }, function() { katexReady = null; });
+  }
+  renderMath(document.getElementById('content'));
+
+  function mathTeX(el) {
+    const annotation = el.querySelector('annotation');
+    return annotation ? annotation.textContent : el.textContent;
+  }
+
+  // Following a link to a collapsed section (an OpenAPI schema) opens it.
+  function openTarget() {
+    const el = location.hash && document.getElementById(decodeURIComponent(location.hash.slice(1)));
+    if (el && el.tagName === 'DETAILS') el.open = true;
+  }
+  window.addEventListener('hashchange', openTarget);
+  openTarget();
+
+  // Folded json/yaml blocks: search opens the branches holding matches,
+  // Enter steps through them. Delegated, so swapped-in content works too.
+  document.addEventListener('input', function(e) {
+    const tree = e.target.closest('.data-tree-bar') && e.target.closest('.data-tree');
+    if (!tree) return;
+    const q = e.target.value.trim().toLowerCase();
+    tree.querySelectorAll('.match').forEach(function(el) { el.classList.remove('match', 'current'); });
+    let n = 0;
+    if (q) {
+      tree.querySelectorAll('.data-tree-view .k, .data-tree-view .v').forEach(function(el) {
+        if (el.textContent.toLowerCase().indexOf(q) < 0) return;
+        el.classList.add('match');
+        n++;
+        for (let d = el.closest('details'); d; d = d.parentElement.closest('details')) {
+          // A match in a summary doesn't need its own branch open.
+          if (!d.firstElementChild.contains(el)) d.open = true;
+        }
+      });
+    }
+    tree.querySelector('.data-tree-count').textContent = q ? n + ' match' + (n === 1 ? '' : 'es') : '';
+  });
+  document.addEventListener('keydown', function(e) {
+    const tree = e.key === 'Enter' && e.target.closest('.data-tree-bar') && e.target.closest('.data-tree');
+    if (!tree) return;
+    e.preventDefault();
+    const matches = Array.from(tree.querySelectorAll('.match'));
+    if (!matches.length) return;
+    const i = matches.findIndex(function(el) { return el.classList.contains('current'); });
+    if (i >= 0) matches[i].classList.remove('current');
+    const next = matches[(i + (e.shiftKey ? -1 : 1) + matches.length) % matches.length];
+    next.classList.add('current');
+    next.scrollIntoView({block: 'center'});
+  });
+  // Schema diagrams can be hidden per block.
+  document.addEventListener('click', function(e) {
+    const btn = e.target.closest('.schema-toggle');
+    if (!btn) return;
+    const diagram = btn.closest('.schema-block').querySelector('.schema-diagram');
+    diagram.hidden = !diagram.hidden;
+    btn.textContent = diagram.hidden ? 'Show diagram' : 'Hide diagram';
+    btn.setAttribute('aria-expanded', String(!diagram.hidden));
+  });
+  document.addEventListener('click', function(e) {
+    const btn = e.target.closest('.data-tree-bar button');
+    if (!btn) return;
+    const tree = btn.closest('.data-tree');
+    const view = tree.querySelector('.data-tree-view');
+    const source = tree.querySelector('.data-tree-source');
+    if (btn.dataset.tree === 'raw') {
+      source.hidden = !source.hidden;
+      view.hidden = !source.hidden;
+      btn.textContent = source.hidden ? 'Source' : 'Tree';
+      return;
+    }
+    const open = btn.dataset.tree === 'expand';
+    view.querySelectorAll('details').forEach(function(d) { d.open = open; });
+  });
+
+  // Confirm before leaving the live preview through an external link
+  document.addEventListener('click', function(e) {
+    if (!config.confirmExternal) return;
+    const a = e.target.closest('a.external');
+    if (!a || a.target === '_blank' || e.ctrlKey || e.metaKey || e.shiftKey) return;
+    if (!confirm('Leave the live preview for ' + a.href + '?')) e.preventDefault();
+  });
+
+  // Jump list: the places that search, find, a snapshot comparison and the
+  // link check turn up, in one list stepped through with n and p wherever
+  // focus isn't in a field. Each source replaces only its own entries; the
+  // badge counts them and says what the current one is.
+  const jumpBadge = document.getElementById('jumpBadge');
+  const jumpSources = {};
+  const jumpOrder = ['search', 'find', 'diff', 'links'];
+  let jumpItems = [];
+  let jumpIndex = -1;
+  // setJumps replaces the entries of source with items ({el, label}); with
+  // go, it moves to the first of them.
+  function setJumps(source, items, go) {
+    if (jumpItems[jumpIndex]) jumpItems[jumpIndex].el.classList.remove('jump-current');
+    jumpSources[source] = items;
+    jumpItems = [];
+    jumpOrder.forEach(function(s) { jumpItems = jumpItems.concat(jumpSources[s] || []); });
+    jumpIndex = -1;
+    if (go && items.length) jumpTo(jumpItems.indexOf(items[0]));
+    else showJumps();
+  }
+  function jumpTo(i) {
+    if (jumpItems[jumpIndex]) jumpItems[jumpIndex].el.classList.remove('jump-current');
+    jumpIndex = i;
+    const it = jumpItems[i];
+    it.el.classList.add('jump-current');
+    it.el.scrollIntoView({block: 'center'});
+    showJumps();
+  }
+  function jump(dir) {
+    if (!jumpItems.length) return;
+    jumpTo(jumpIndex < 0 && dir < 0 ? jumpItems.length - 1 : (jumpIndex + dir + jumpItems.length) % jumpItems.length);
+  }
+  function showJumps() {
+    jumpBadge.hidden = jumpItems.length === 0;
+    const it = jumpItems[jumpIndex];
+    jumpBadge.querySelector('.jump-count').textContent = it
+      ? (jumpIndex + 1) + '/' + jumpItems.length + ' ' + it.label
+      : jumpItems.length + (jumpItems.length === 1 ? ' place' : ' places') + ' to visit';
+  }
+  jumpBadge.addEventListener('click', function(e) {
+    const btn = e.target.closest('button');
+    if (!btn) return;
+    if (btn.name === 'clear') jumpOrder.forEach(function(s) { setJumps(s, []); });
+    else jump(btn.name === 'next' ? 1 : -1);
+  });
+  document.addEventListener('keydown', function(e) {
+    if (!jumpItems.length || e.ctrlKey || e.altKey || e.metaKey) return;
+    const t = e.target;
+    if (t.isContentEditable || /^(INPUT|TEXTAREA|SELECT)$/.test(t.tagName)) return;
+    if (e.key === 'n') jump(1);
+    else if (e.key === 'p' || e.key === 'N') jump(-1);
+    else return;
+    e.preventDefault();
+  });
+
+  // blockAtLine returns the innermost rendered block starting at or before
+  // source line n.
+  function blockAtLine(n) {
+    let found = null;
+    document.querySelectorAll('#content [data-line]').forEach(function(el) {
+      if (+el.dataset.line <= n) found = el;
+    });
+    return found;
+  }
+
+  // The link check: links to a missing anchor on the page, or to a page or
+  // file of this server that isn't there.
+  function checkLinks() {
+    const linkStatus = {};
+    const content = document.getElementById('content');
+    const links = Array.from(content.querySelectorAll('a[href]'));
+    const checks = links.map(function(a) {
+      const url = new URL(a.href, location.href);
+      if (url.origin !== location.origin || /^\/(api|raw|events|katex)\b/.test(url.pathname)) return Promise.resolve(true);
+      if (url.pathname === location.pathname) {
+        const id = decodeURIComponent(url.hash.slice(1).split(':~:')[0]);
+        return Promise.resolve(!id || document.getElementById(id) !== null);
+      }
+      // Plain pages don't spend requests on it.
+      if (config.plain) return Promise.resolve(true);
+      if (!(url.pathname in linkStatus)) {
+        linkStatus[url.pathname] = fetch(url.pathname, {method: 'HEAD'}).then(function(r) { return r.status !== 404; }, function() { return true; });
+      }
+      return linkStatus[url.pathname];
+    });
+    Promise.all(checks).then(function(ok) {
+      const broken = links.filter(function(a, i) {
+        a.classList.toggle('broken-link', !ok[i]);
+        return !ok[i];
+      });
+      setJumps('links', broken.map(function(a) { return {el: a, label: 'Broken link: ' + a.getAttribute('href')}; }));
+    });
+  }
+  checkLinks();
+
+  // Find and replace across the input files. Preview always works; applying
+  // needs --editable and goes through the server so files stay the source.
+  const findToggle = document.getElementById('findToggle');
+  const findPanel = document.getElementById('findPanel');
+  if (config.searchable) {
+    findToggle.hidden = false;
+    const openFind = function() {
+      findPanel.hidden = !findPanel.hidden;
+      if (!findPanel.hidden) findPanel.query.focus();
+    };
+    findToggle.addEventListener('click', openFind);
+    document.addEventListener('keydown', function(e) {
+      if (e.key === 'F' && e.shiftKey && (e.ctrlKey || e.metaKey)) { e.preventDefault(); openFind(); }
+    });
+    const results = findPanel.querySelector('.find-results');
+    const request = function(apply) {
+      return fetch('/api/replace', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({
+          query: findPanel.query.value,
+          replace: findPanel.replace.value,
+          regex: findPanel.regex.checked,
+          apply: apply
+        })
+      }).then(function(r) {
+        if (!r.ok) return r.text().then(function(t) { throw new Error(t); });
+        return r.json();
+      });
+    };
+    const show = function(data) {
+      results.innerHTML = '';
+      // Matches in the file on screen can be visited by their block.
+      const visits = [];
+      (data.matches || []).forEach(function(m) {
+        const block = config.file && m.file === config.file ? blockAtLine(m.line) : null;
+        if (block && (!visits.length || visits[visits.length - 1].el !== block)) {
+          visits.push({el: block, label: 'Find “' + findPanel.query.value + '”'});
+        }
+        const li = document.createElement('li');
+        const loc = document.createElement('span');
+        loc.className = 'find-loc';
+        loc.textContent = m.file + ':' + m.line;
+        const del = document.createElement('del');
+        del.textContent = m.text;
+        const ins = document.createElement('ins');
+        ins.textContent = m.replaced;
+        li.append(loc, del, ins);
+        if (block) {
+          li.classList.add('find-visit');
+          li.addEventListener('click', function() {
+            jumpTo(jumpItems.findIndex(function(it) { return it.el === block; }));
+          });
+        }
+        results.appendChild(li);
+      });
+      setJumps('find', visits);
+      findPanel.apply.disabled = !config.editable || !(data.matches || []).length;
+    };
+    findPanel.addEventListener('submit', function(e) {
+      e.preventDefault();
+      request(false).then(show).catch(function(err) { results.textContent = err.message; });
+    });
+    findPanel.apply.addEventListener('click', function() {
+      if (!confirm('Replace in all files?')) return;
+      request(true).then(function(data) {
+        results.textContent = 'Updated ' + data.filesChanged + ' file(s).';
+        findPanel.apply.disabled = true;
+      }).catch(function(err) { results.textContent = err.message; });
+    });
+  }
+
+  // Directory mode: a palette jumping to where a term or heading is
+  // defined, in whichever file that is. Opens with the selection filled in.
+  const defPalette = document.getElementById('defPalette');
+  if (config.definitions) {
+    const defToggle = document.getElementById('defToggle');
+    const defInput = defPalette.querySelector('input');
+    const defResults = defPalette.querySelector('.def-results');
+    let defs = null;
+    let defMatches = [];
+    let defActive = 0;
+    defToggle.hidden = false;
+    const defHref = function(d) {
+      return '/' + d.file.split('/').map(encodeURIComponent).join('/') + '#' + encodeURIComponent(d.anchor);
+    };
+    const defRender = function() {
+      const q = defInput.value.trim().toLowerCase();
+      defMatches = (defs || []).filter(function(d) {
+        return d.term.toLowerCase().indexOf(q) >= 0;
+      });
+      // Exact, then prefix matches first; defined terms before headings.
+      const rank = function(d) {
+        const t = d.term.toLowerCase();
+        return (t === q ? 0 : t.indexOf(q) === 0 ? 2 : 4) + (d.kind === 'term' ? 0 : 1);
+      };
+      defMatches.sort(function(a, b) { return rank(a) - rank(b); });
+      defMatches = defMatches.slice(0, 50);
+      defActive = Math.min(defActive, Math.max(defMatches.length - 1, 0));
+      defResults.innerHTML = '';
+      defMatches.forEach(function(d, i) {
+        const li = document.createElement('li');
+        li.setAttribute('role', 'option');
+        li.classList.toggle('active', i === defActive);
+        const a = document.createElement('a');
+        a.href = defHref(d);
+        a.textContent = d.term;
+        const loc = document.createElement('span');
+        loc.className = 'def-loc';
+        loc.textContent = d.file + (d.kind === 'term' ? ' · definition' : '');
+        a.appendChild(loc);
+        li.appendChild(a);
+        defResults.appendChild(li);
+      });
+      if (defs && !defMatches.length) defResults.textContent = 'No matches';
+    };
+    const openDefs = function() {
+      const sel = String(window.getSelection()).trim();
+      defPalette.hidden = false;
+      if (sel && sel.length < 100) defInput.value = sel;
+      defInput.select();
+      defActive = 0;
+      // Refetched on every open; the server only rescans changed files.
+      fetch('/api/definitions').then(r => r.json()).then(function(data) {
+        defs = data.definitions;
+        defRender();
+      });
+      defRender();
+    };
+    const closeDefs = function() { defPalette.hidden = true; };
+    defToggle.addEventListener('click', function() {
+      if (defPalette.hidden) openDefs(); else closeDefs();
+    });
+    document.addEventListener('keydown', function(e) {
+      if (e.key === 'k' && (e.ctrlKey || e.metaKey) && !e.shiftKey) { e.preventDefault(); openDefs(); }
+    });
+    defInput.addEventListener('input', function() { defActive = 0; defRender(); });
+    defInput.addEventListener('keydown', function(e) {
+      if (e.key === 'ArrowDown' || e.key === 'ArrowUp') {
+        e.preventDefault();
+        defActive = (defActive + (e.key === 'ArrowDown' ? 1 : -1) + defMatches.length) % Math.max(defMatches.length, 1);
+        defRender();
+      } else if (e.key === 'Enter' && defMatches[defActive]) {
+        closeDefs();
+        location.href = defHref(defMatches[defActive]);
+      } else if (e.key === 'Escape') {
+        closeDefs();
+      }
+    });
+    defResults.addEventListener('click', closeDefs);
+  }
+
+  // Directory mode: clicking a heatmap day filters the file list.
+  const heatmap = document.querySelector('.heatmap');
+  if (heatmap) {
+    const filterNote = document.querySelector('.heatmap-filter');
+    const filterDay = function(day) {
+      document.querySelectorAll('.dir-index li').forEach(function(li) {
+        li.hidden = day !== '' && li.dataset.days.split(' ').indexOf(day) < 0;
+      });
+      heatmap.querySelectorAll('button').forEach(function(b) { b.classList.toggle('selected', b.dataset.day === day); });
+      filterNote.hidden = day === '';
+      filterNote.querySelector('span').textContent = day;
+    };
+    heatmap.addEventListener('click', function(e) {
+      const b = e.target.closest('button');
+      if (b) filterDay(b.classList.contains('selected') ? '' : b.dataset.day);
+    });
+    filterNote.querySelector('button').addEventListener('click', function() { filterDay(''); });
+  }
+
+  // Directory mode on a cloned repository: switch branch or tag.
+  const repoRef = document.querySelector('.repo-ref select');
+  if (repoRef) {
+    repoRef.addEventListener('change', function() {
+      const status = document.querySelector('.repo-status');
+      repoRef.disabled = true;
+      status.textContent = 'Fetching ' + repoRef.value + '...';
+      fetch('/api/repo/ref', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({ref: repoRef.value})
+      }).then(function(res) {
+        if (!res.ok) return res.text().then(function(t) { throw new Error(t); });
+        location.reload();
+      }).catch(function(err) {
+        repoRef.disabled = false;
+        status.textContent = err.message;
+      });
+    });
+  }
+
+  // Contents with a checkbox per section, to export, print or copy only
+  // the checked ones. A section runs from its heading to the next heading
+  // of any level; (un)checking one does the same to its subsections. The
+  // choice is remembered per document; front matter "export:" sets the
+  // initial one.
+  const tocToggle = document.getElementById('tocToggle');
+  const tocPanel = document.getElementById('tocPanel');
+  const tocList = tocPanel.querySelector('.toc');
+  const tocStatus = tocPanel.querySelector('.toc-status');
+  tocPanel.querySelector('[name="pdf"]').hidden = !config.pdf;
+  tocPanel.querySelector('[name="printView"]').hidden = !config.printView;
+  const exportKey = 'mdview-export:' + config.document;
+  // Checked heading ids; null means everything.
+  let exportSelection;
+  try {
+    const stored = localStorage.getItem(exportKey);
+    if (stored !== null) exportSelection = JSON.parse(stored);
+  } catch (e) {}
+  function sectionHeadings() {
+    return Array.from(document.getElementById('content').children).filter(function(el) {
+      return /^H[1-4]$/.test(el.tagName) && el.id;
+    });
+  }
+  function headingLevel(h) { return +h.tagName[1]; }
+  function frontMatterSelection(headings) {
+    const names = (config.exportSections || []).map(function(s) { return s.toLowerCase(); });
+    if (names.length === 0) return null;
+    const ids = [];
+    let within = 0;
+    headings.forEach(function(h) {
+      const level = headingLevel(h);
+      if (within && level > within) { ids.push(h.id); return; }
+      within = 0;
+      if (names.includes(h.id.toLowerCase()) || names.includes(h.textContent.trim().toLowerCase())) {
+        ids.push(h.id);
+        within = level;
+      }
+    });
+    return ids;
+  }
+  function refreshToc() {
+    const headings = sectionHeadings();
+    tocToggle.hidden = headings.length === 0;
+    if (headings.length === 0) tocPanel.hidden = true;
+    if (exportSelection === undefined) exportSelection = frontMatterSelection(headings);
+    const top = Math.min.apply(null, headings.map(headingLevel));
+    tocList.innerHTML = '';
+    headings.forEach(function(h) {
+      const li = document.createElement('li');
+      li.style.paddingLeft = (headingLevel(h) - top) * 14 + 'px';
+      li.dataset.level = headingLevel(h);
+      const box = document.createElement('input');
+      box.type = 'checkbox';
+      box.value = h.id;
+      box.checked = !exportSelection || exportSelection.includes(h.id);
+      box.setAttribute('aria-label', 'Include ' + h.textContent);
+      const a = document.createElement('a');
+      a.href = '#' + h.id;
+      a.textContent = h.textContent;
+      li.append(box, a);
+      tocList.appendChild(li);
+    });
+    tocStatus.textContent = '';
+  }
+  function saveExportSelection() {
+    const boxes = Array.from(tocList.querySelectorAll('input'));
+    if (boxes.every(function(b) { return b.checked; })) {
+      exportSelection = null;
+      localStorage.removeItem(exportKey);
+      return;
+    }
+    exportSelection = boxes.filter(function(b) { return b.checked; }).map(function(b) { return b.value; });
+    localStorage.setItem(exportKey, JSON.stringify(exportSelection));
+  }
+  tocList.addEventListener('change', function(e) {
+    const li = e.target.closest('li');
+    for (let next = li.nextElementSibling; next && +next.dataset.level > +li.dataset.level; next = next.nextElementSibling) {
+      next.querySelector('input').checked = e.target.checked;
+    }
+    saveExportSelection();
+  });
+  // exportedNodes returns the top-level content of the checked sections.
+  // Content before the first heading goes along only with everything.
+  function exportedNodes() {
+    const checked = new Set(Array.from(tocList.querySelectorAll('input:checked')).map(function(b) { return b.value; }));
+    const all = checked.size === tocList.querySelectorAll('input').length;
+    const nodes = [];
+    let include = all;
+    Array.from(document.getElementById('content').children).forEach(function(el) {
+      if (/^H[1-4]$/.test(el.tagName) && el.id) include = checked.has(el.id);
+      if (include) nodes.push(el);
+    });
+    return nodes;
+  }
+  function exportStatus(nodes, verb) {
+    const n = nodes.filter(function(el) { return /^H[1-4]$/.test(el.tagName); }).length;
+    tocStatus.textContent = nodes.length === 0 ? 'Nothing checked' : verb + ' ' + n + (n === 1 ? ' section' : ' sections');
+  }
+  tocToggle.addEventListener('click', function() {
+    tocPanel.hidden = !tocPanel.hidden;
+  });
+  tocPanel.addEventListener('click', function(e) {
+    const btn = e.target.closest('button');
+    if (!btn) return;
+    if (btn.name === 'all' || btn.name === 'none') {
+      tocList.querySelectorAll('input').forEach(function(b) { b.checked = btn.name === 'all'; });
+      saveExportSelection();
+      tocStatus.textContent = '';
+      return;
+    }
+    const nodes = exportedNodes();
+    if (nodes.length === 0) { exportStatus(nodes); return; }
+    if (btn.name === 'html') {
+      const name = document.title.split(' — ')[0].replace(/\.[^.]*$/, '') || 'document';
+      const title = document.createElement('title');
+      title.textContent = nodes[0].textContent;
+      const page = '<!DOCTYPE html>\n<html lang="en">\n<head>\n<meta charset="utf-8">\n' + title.outerHTML + '\n' +
+        document.querySelector('style').outerHTML + '\n</head>\n<body>\n<div class="container">\n' +
+        nodes.map(function(el) { return el.outerHTML; }).join('\n') + '\n</div>\n</body>\n</html>\n';
+      downloadBlob(new Blob([page], {type: 'text/html;charset=utf-8'}), name + '-sections.html');
+      exportStatus(nodes, 'Exported');
+    } else if (btn.name === 'pdf') {
+      // The whole document, printed by the server as the page looks now.
+      const q = new URLSearchParams();
+      const theme = root.getAttribute('data-palette') || (matchMedia('(prefers-color-scheme: dark)').matches ? 'dark' : 'light');
+      q.set('theme', theme);
+      if (root.getAttribute('data-hl')) q.set('highlight', root.getAttribute('data-hl'));
+      window.open('/pdf?' + q.toString());
+    } else if (btn.name === 'printView') {
+      // The whole document, laid out by the server for paper.
+      const q = new URLSearchParams();
+      if (root.getAttribute('data-hl')) q.set('highlight', root.getAttribute('data-hl'));
+      window.open('/print' + (q.toString() ? '?' + q.toString() : ''));
+    } else if (btn.name === 'print') {
+      const keep = new Set(nodes);
+      const skipped = Array.from(document.getElementById('content').children).filter(function(el) { return !keep.has(el); });
+      skipped.forEach(function(el) { el.setAttribute('data-export-skip', ''); });
+      window.addEventListener('afterprint', function() {
+        skipped.forEach(function(el) { el.removeAttribute('data-export-skip'); });
+      }, {once: true});
+      window.print();
+    } else if (btn.name === 'copy') {
+      const html = nodes.map(function(el) { return el.outerHTML; }).join('\n');
+      const text = nodes.map(function(el) { return el.innerText; }).join('\n\n');
+      const copied = window.ClipboardItem
+        ? navigator.clipboard.write([new ClipboardItem({
+            'text/html': new Blob([html], {type: 'text/html'}),
+            'text/plain': new Blob([text], {type: 'text/plain'}),
+          })])
+        : navigator.clipboard.writeText(text);
+      copied.then(function() { exportStatus(nodes, 'Copied'); }, function() { tocStatus.textContent = 'Copy failed'; });
+    }
+  });
+  refreshToc();
+
+  // Outline: the heading tree in a sidebar, marking the section being read.
+  // Whether it is open is a reading setting (≣ and the ⚙ menu both set
+  // it); which branches are folded is remembered per document beside it,
+  // so it comes back the same after a reload.
+  const outlineToggle = document.getElementById('outlineToggle');
+  const outline = document.getElementById('outline');
+  const outlineKey = 'mdview-outline:' + config.document;
+  const outlineState = {folded: []};
+  try { Object.assign(outlineState, JSON.parse(localStorage.getItem(outlineKey) || '{}')); } catch (e) {}
+  // Earlier versions kept the open state here.
+  if ('open' in outlineState) {
+    if (!('outline' in settings)) settings.outline = outlineState.open;
+    delete outlineState.open;
+    saveSettings();
+    saveOutline();
+  }
+  let outlineHeadings = [];
+  function saveOutline() {
+    localStorage.setItem(outlineKey, JSON.stringify(outlineState));
+  }
+  function showOutline(open) {
+    outline.hidden = !open;
+    document.body.classList.toggle('outline-open', open);
+    outlineToggle.setAttribute('aria-pressed', String(open));
+  }
+  function refreshOutline() {
+    outlineHeadings = Array.from(document.getElementById('content').children).filter(function(el) {
+      return /^H[1-6]$/.test(el.tagName) && el.id;
+    });
+    outlineToggle.hidden = outlineHeadings.length === 0;
+    showOutline(!!settings.outline && outlineHeadings.length > 0);
+    const scrollTop = outline.scrollTop;
+    const list = document.createElement('ul');
+    // Each heading nests under the closest one before it of a higher level.
+    const stack = [{level: 0, list: list}];
+    outlineHeadings.forEach(function(h) {
+      const level = headingLevel(h);
+      while (stack.length > 1 && stack[stack.length - 1].level >= level) stack.pop();
+      const parent = stack[stack.length - 1];
+      if (!parent.list) {
+        parent.list = document.createElement('ul');
+        const fold = document.createElement('button');
+        fold.type = 'button';
+        fold.className = 'outline-fold';
+        fold.setAttribute('aria-label', 'Fold ' + parent.li.textContent);
+        parent.li.prepend(fold);
+        parent.li.appendChild(parent.list);
+        parent.li.classList.toggle('folded', outlineState.folded.includes(parent.li.dataset.id));
+      }
+      const li = document.createElement('li');
+      li.dataset.id = h.id;
+      const a = document.createElement('a');
+      a.href = '#' + h.id;
+      a.textContent = h.textContent;
+      li.appendChild(a);
+      parent.list.appendChild(li);
+      stack.push({level: level, li: li, list: null});
+    });
+    outline.replaceChildren(list);
+    outline.scrollTop = scrollTop;
+    markOutline();
+  }
+  // markOutline highlights the last heading above the top of the window.
+  function markOutline() {
+    if (outline.hidden) return;
+    let current = outlineHeadings[0];
+    outlineHeadings.forEach(function(h) {
+      if (h.getBoundingClientRect().top <= 80) current = h;
+    });
+    outline.querySelectorAll('a.active').forEach(function(a) { a.classList.remove('active'); });
+    const li = current && outline.querySelector('li[data-id="' + CSS.escape(current.id) + '"]');
+    if (!li) return;
+    const a = li.querySelector('a');
+    a.classList.add('active');
+    if (a.offsetTop < outline.scrollTop || a.offsetTop + a.offsetHeight > outline.scrollTop + outline.clientHeight) {
+      outline.scrollTop = a.offsetTop - outline.clientHeight / 3;
+    }
+  }
+  let outlineFrame = 0;
+  window.addEventListener('scroll', function() {
+    if (outlineFrame) return;
+    outlineFrame = requestAnimationFrame(function() { outlineFrame = 0; markOutline(); });
+  }, {passive: true});
+  outlineToggle.addEventListener('click', function() {
+    settings.outline = outline.hidden;
+    saveSettings();
+    showOutline(settings.outline);
+    markOutline();
+    if (!settingsPanel.hidden) syncSettingsForm();
+  });
+  outline.addEventListener('click', function(e) {
+    const fold = e.target.closest('.outline-fold');
+    if (!fold) return;
+    const li = fold.parentElement;
+    li.classList.toggle('folded');
+    outlineState.folded = Array.from(outline.querySelectorAll('li.folded')).map(function(el) { return el.dataset.id; });
+    saveOutline();
+  });
+  refreshOutline();
+
+  // Snapshots: stored copies of the render, newest first, each viewable on
+  // its own. Comparing two of them (or one and the current version) shows
+  // the blocks that were removed and added between them.
+  const snapshotToggle = document.getElementById('snapshotToggle');
+  const snapshotPanel = document.getElementById('snapshotPanel');
+  const snapshotRows = snapshotPanel.querySelector('tbody');
+  const snapshotStatus = snapshotPanel.querySelector('.snapshot-status');
+  const snapshotView = document.getElementById('snapshotView');
+  const snapshotBody = snapshotView.querySelector('.snapshot-view-body');
+  let snapshotList = [];
+  function snapshotName(s) {
+    return s.id === 'current' ? 'Current version' : formatDate(s.time) + (s.label ? ' — ' + s.label : '');
+  }
+  function renderSnapshots() {
+    const current = snapshotRows.querySelector('input[name="to"]:checked');
+    const versions = [{id: 'current'}].concat(snapshotList);
+    snapshotRows.innerHTML = '';
+    versions.forEach(function(s, i) {
+      const tr = document.createElement('tr');
+      ['from', 'to'].forEach(function(side) {
+        const td = document.createElement('td');
+        const radio = document.createElement('input');
+        radio.type = 'radio';
+        radio.name = side;
+        radio.value = s.id;
+        // Newest snapshot against the current version by default.
+        radio.checked = side === 'from' ? i === 1 : i === 0;
+        radio.setAttribute('aria-label', 'Compare ' + side + ' ' + snapshotName(s));
+        td.appendChild(radio);
+        tr.appendChild(td);
+      });
+      const td = document.createElement('td');
+      const view = document.createElement('button');
+      view.type = 'button';
+      view.className = 'snapshot-open';
+      view.dataset.id = s.id;
+      view.textContent = snapshotName(s);
+      td.appendChild(view);
+      tr.appendChild(td);
+      snapshotRows.appendChild(tr);
+    });
+    if (current) {
+      const keep = snapshotRows.querySelector('input[name="to"][value="' + current.value + '"]');
+      if (keep) keep.checked = true;
+    }
+    snapshotPanel.querySelector('button[name="compare"]').disabled = snapshotList.length === 0;
+  }
+  function loadSnapshots() {
+    return fetch('/api/snapshot').then(r => r.json()).then(function(data) {
+      snapshotList = data.snapshots || [];
+      renderSnapshots();
+    });
+  }
+  const snapshotHTMLCache = {};
+  function snapshotHTML(id) {
+    if (id === 'current') return fetch('/raw').then(r => r.json()).then(function(data) { return data.html; });
+    if (snapshotHTMLCache[id]) return Promise.resolve(snapshotHTMLCache[id]);
+    return fetch('/api/snapshot?id=' + encodeURIComponent(id)).then(r => r.json()).then(function(data) {
+      return (snapshotHTMLCache[id] = data.html);
+    });
+  }
+  function topLevelBlocks(html) {
+    const t = document.createElement('template');
+    t.innerHTML = html;
+    return Array.from(t.content.children);
+  }
+  // diffBlocks aligns two block lists by their longest common subsequence
+  // and returns [op, block] pairs, op being '=', '-' or '+'.
+  function diffBlocks(a, b) {
+    // Blocks that only moved to another source line are the same.
+    function key(el) { return el.outerHTML.replace(/ data-line="\d+"/g, ''); }
+    const ka = a.map(key);
+    const kb = b.map(key);
+    let start = 0;
+    while (start < ka.length && start < kb.length && ka[start] === kb[start]) start++;
+    let endA = ka.length, endB = kb.length;
+    while (endA > start && endB > start && ka[endA - 1] === kb[endB - 1]) { endA--; endB--; }
+    const n = endA - start, m = endB - start;
+    const lcs = new Int32Array((n + 1) * (m + 1));
+    for (let i = n - 1; i >= 0; i--) {
+      for (let j = m - 1; j >= 0; j--) {
+        lcs[i * (m + 1) + j] = ka[start + i] === kb[start + j]
+          ? lcs[(i + 1) * (m + 1) + j + 1] + 1
+          : Math.max(lcs[(i + 1) * (m + 1) + j], lcs[i * (m + 1) + j + 1]);
+      }
+    }
+    const ops = [];
+    for (let i = 0; i < start; i++) ops.push(['=', b[i]]);
+    let i = 0, j = 0;
+    while (i < n || j < m) {
+      if (i < n && j < m && ka[start + i] === kb[start + j]) { ops.push(['=', b[start + j]]); i++; j++; }
+      else if (i < n && (j === m || lcs[(i + 1) * (m + 1) + j] >= lcs[i * (m + 1) + j + 1])) { ops.push(['-', a[start + i]]); i++; }
+      else { ops.push(['+', b[start + j]]); j++; }
+    }
+    for (let k = endB; k < kb.length; k++) ops.push(['=', b[k]]);
+    return ops;
+  }
+  function showSnapshotView(title) {
+    snapshotView.querySelector('.snapshot-view-title').textContent = title;
+    snapshotView.hidden = false;
+    snapshotPanel.hidden = true;
+    snapshotBody.scrollTop = 0;
+  }
+  function versionName(id) {
+    return snapshotName(id === 'current' ? {id: id} : snapshotList.find(function(s) { return s.id === id; }));
+  }
+  snapshotToggle.hidden = !config.snapshots;
+  snapshotToggle.addEventListener('click', function() {
+    snapshotPanel.hidden = !snapshotPanel.hidden;
+    if (!snapshotPanel.hidden) loadSnapshots();
+  });
+  snapshotPanel.querySelector('form').addEventListener('submit', function(e) {
+    e.preventDefault();
+    const label = this.elements.label;
+    fetch('/api/snapshot', {
+      method: 'POST',
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({label: label.value}),
+    }).then(function(r) {
+      if (!r.ok) return r.text().then(function(t) { throw new Error(t.trim()); });
+      return r.json();
+    }).then(function(data) {
+      label.value = '';
+      snapshotStatus.textContent = data.unchanged ? 'No changes since the last snapshot' : 'Saved ' + snapshotName(data.snapshot);
+      return loadSnapshots();
+    }).catch(function(err) { snapshotStatus.textContent = err.message; });
+  });
+  snapshotRows.addEventListener('click', function(e) {
+    const btn = e.target.closest('.snapshot-open');
+    if (!btn) return;
+    snapshotHTML(btn.dataset.id).then(function(html) {
+      snapshotBody.innerHTML = html;
+      renderMath(snapshotBody);
+      showSnapshotView(versionName(btn.dataset.id));
+    });
+  });
+  snapshotPanel.querySelector('button[name="compare"]').addEventListener('click', function() {
+    const from = snapshotRows.querySelector('input[name="from"]:checked');
+    const to = snapshotRows.querySelector('input[name="to"]:checked');
+    if (!from || !to) return;
+    Promise.all([snapshotHTML(from.value), snapshotHTML(to.value)]).then(function(pair) {
+      const ops = diffBlocks(topLevelBlocks(pair[0]), topLevelBlocks(pair[1]));
+      snapshotBody.innerHTML = '';
+      let changes = 0;
+      const hunks = [];
+      ops.forEach(function(op) {
+        if (op[0] === '=') { snapshotBody.appendChild(op[1]); return; }
+        changes++;
+        const wrap = document.createElement('div');
+        wrap.className = op[0] === '+' ? 'snapshot-ins' : 'snapshot-del';
+        wrap.appendChild(op[1]);
+        snapshotBody.appendChild(wrap);
+        // A run of changed blocks is one stop.
+        const prev = wrap.previousElementSibling;
+        if (!prev || !/^snapshot-(ins|del)$/.test(prev.className)) hunks.push({el: wrap, label: op[0] === '+' ? 'Added' : 'Removed'});
+      });
+      if (changes === 0) snapshotBody.insertAdjacentHTML('afterbegin', '<p class="snapshot-same">No differences.</p>');
+      showSnapshotView('Changes from ' + versionName(from.value) + ' to ' + versionName(to.value));
+      setJumps('diff', hunks, false);
+    });
+  });
+  snapshotView.querySelector('button[name="close"]').addEventListener('click', function() {
+    snapshotView.hidden = true;
+    snapshotBody.innerHTML = '';
+    setJumps('diff', []);
+  });
+
+  // Git history: a slider over the file's commits, oldest on the left,
+  // showing the file as of each. Versions already fetched are kept, and the
+  // neighbours of the one on screen are fetched ahead of the slider.
+  const historyToggle = document.getElementById('historyToggle');
+  const historyView = document.getElementById('historyView');
+  const historyRange = historyView.querySelector('input[name="commit"]');
+  const historyBody = historyView.querySelector('.snapshot-view-body');
+  const historyTitle = historyView.querySelector('.snapshot-view-title');
+  const historyCache = {};
+  let historyCommits = [];
+  let historyTimer = null;
+  function historyHTML(hash) {
+    if (!historyCache[hash]) {
+      historyCache[hash] = fetch(pageURL('/api/history?commit=' + hash)).then(function(r) {
+        if (!r.ok) throw new Error('HTTP ' + r.status);
+        return r.json();
+      }).then(function(data) { return data.html; });
+      historyCache[hash].catch(function() { delete historyCache[hash]; });
+    }
+    return historyCache[hash];
+  }
+  function commitName(c) {
+    return c.hash.slice(0, 7) + ' · ' + new Date(c.time).toLocaleString() + ' · ' + c.author + ' · ' + c.subject;
+  }
+  function showCommit(i) {
+    const c = historyCommits[i];
+    if (!c) return;
+    historyTitle.textContent = commitName(c) + ' (' + (i + 1) + '/' + historyCommits.length + ')';
+    historyHTML(c.hash).then(function(html) {
+      if (Number(historyRange.value) !== i) return; // the slider moved on
+      const top = historyBody.scrollTop;
+      historyBody.innerHTML = html;
+      renderMath(historyBody);
+      historyBody.scrollTop = top;
+    }).catch(function(err) { historyTitle.textContent = commitName(c) + ' — ' + err.message; });
+    [i - 1, i + 1].forEach(function(j) {
+      if (historyCommits[j]) historyHTML(historyCommits[j].hash).catch(function() {});
+    });
+  }
+  historyToggle.hidden = !config.history;
+  historyToggle.addEventListener('click', function() {
+    if (!historyView.hidden) { historyView.hidden = true; return; }
+    fetch(pageURL('/api/history')).then(r => r.json()).then(function(data) {
+      historyCommits = (data.commits || []).slice().reverse();
+      historyView.hidden = false;
+      historyBody.innerHTML = '';
+      historyBody.scrollTop = 0;
+      historyRange.hidden = historyCommits.length < 2;
+      if (historyCommits.length === 0) {
+        historyTitle.textContent = 'This file has no commits';
+        return;
+      }
+      historyRange.max = historyCommits.length - 1;
+      historyRange.value = historyCommits.length - 1;
+      showCommit(historyCommits.length - 1);
+    });
+  });
+  historyRange.addEventListener('input', function() {
+    const i = Number(historyRange.value);
+    historyTitle.textContent = commitName(historyCommits[i]) + ' (' + (i + 1) + '/' + historyCommits.length + ')';
+    clearTimeout(historyTimer);
+    historyTimer = setTimeout(function() { showCommit(i); }, historyCache[historyCommits[i].hash] ? 0 : 120);
+  });
+  historyView.querySelector('button[name="close"]').addEventListener('click', function() {
+    historyView.hidden = true;
+    historyBody.innerHTML = '';
+  });
+
+  // Chat sidebar (--chat): short messages relayed to every open tab over
+  // the live-reload stream, optionally pointing at the section on screen.
+  const chatToggle = document.getElementById('chatToggle');
+  const chatPanel = document.getElementById('chatPanel');
+  const chatList = chatPanel.querySelector('.chat-messages');
+  const chatForm = chatPanel.querySelector('form');
+  const chatName = chatPanel.querySelector('input[name="name"]');
+  const chatPinLabel = chatPanel.querySelector('.chat-anchor span');
+  const chatSeen = new Set();
+  let chatUnread = 0;
+  chatName.value = localStorage.getItem('mdview-chat-name') || '';
+  chatName.addEventListener('change', function() {
+    localStorage.setItem('mdview-chat-name', chatName.value.trim());
+  });
+  // currentSection returns the last heading scrolled past the top.
+  function currentSection() {
+    const headings = document.querySelectorAll('#content h1[id], #content h2[id], #content h3[id], #content h4[id]');
+    let current = headings[0] || null;
+    for (const h of headings) {
+      if (h.getBoundingClientRect().top > 80) break;
+      current = h;
+    }
+    return current;
+  }
+  function updateChatPin() {
+    const h = currentSection();
+    chatPinLabel.textContent = h ? h.textContent : 'this section';
+  }
+  function addChatMessage(m, quiet) {
+    if (chatSeen.has(m.id)) return;
+    chatSeen.add(m.id);
+    const li = document.createElement('li');
+    const head = document.createElement('div');
+    head.className = 'chat-meta';
+    const name = document.createElement('strong');
+    name.textContent = m.name;
+    const time = document.createElement('time');
+    time.dateTime = m.time;
+    time.textContent = new Date(m.time).toLocaleTimeString([], {hour: '2-digit', minute: '2-digit'});
+    head.append(name, ' ', time);
+    li.appendChild(head);
+    if (m.anchor) {
+      const a = document.createElement('a');
+      a.className = 'chat-link';
+      a.href = '#' + encodeURIComponent(m.anchor);
+      a.textContent = '§ ' + (m.title || m.anchor);
+      li.appendChild(a);
+    }
+    const text = document.createElement('p');
+    text.textContent = m.text;
+    li.appendChild(text);
+    chatList.appendChild(li);
+    chatList.scrollTop = chatList.scrollHeight;
+    if (chatPanel.hidden && !quiet) {
+      chatUnread++;
+      chatToggle.dataset.unread = chatUnread;
+    }
+  }
+  function loadChat(quiet) {
+    fetch('/api/chat').then(r => r.json()).then(function(data) {
+      (data.messages || []).forEach(function(m) { addChatMessage(m, quiet); });
+    });
+  }
+  function onChat(e) { addChatMessage(JSON.parse(e.data)); }
+  if (config.chat) {
+    chatToggle.hidden = false;
+    loadChat(true);
+  }
+  chatToggle.addEventListener('click', function() {
+    chatPanel.hidden = !chatPanel.hidden;
+    if (chatPanel.hidden) return;
+    chatUnread = 0;
+    delete chatToggle.dataset.unread;
+    updateChatPin();
+    chatForm.elements.text.focus();
+  });
+  chatPanel.querySelector('button[name="close"]').addEventListener('click', function() { chatPanel.hidden = true; });
+  let chatPinFrame = 0;
+  window.addEventListener('scroll', function() {
+    if (chatPanel.hidden || chatPinFrame) return;
+    chatPinFrame = requestAnimationFrame(function() { chatPinFrame = 0; updateChatPin(); });
+  }, {passive: true});
+  chatForm.elements.text.addEventListener('keydown', function(e) {
+    if (e.key === 'Enter' && !e.shiftKey && !e.isComposing) {
+      e.preventDefault();
+      chatForm.requestSubmit();
+    }
+  });
+  chatForm.addEventListener('submit', function(e) {
+    e.preventDefault();
+    const text = chatForm.elements.text;
+    if (!text.value.trim()) return;
+    const msg = {name: chatName.value, text: text.value};
+    const h = chatForm.elements.pin.checked ? currentSection() : null;
+    if (h) { msg.anchor = h.id; msg.title = h.textContent; }
+    fetch('/api/chat', {
+      method: 'POST',
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify(msg),
+    }).then(function(r) {
+      if (!r.ok) throw new Error('send failed');
+      return r.json();
+    }).then(function(m) {
+      text.value = '';
+      addChatMessage(m, true);
+    }).catch(function() { text.setCustomValidity('Could not send'); text.reportValidity(); text.setCustomValidity(''); });
+  });
+
+  // Section titles (several pages, or a directory): the title, and so
+  // the window switcher and the history entry, names the section being
+  // read as well as the file, and the entry's hash follows it, so back and
+  // forward between documents return to the right place.
+  if (config.sectionTitles) {
+    const fileTitle = document.title;
+    let titled = null;
+    let titleTimer = 0;
+    const nameSection = function() {
+      const h = window.scrollY > 0 ? currentSection() : null;
+      if (h === titled) return;
+      titled = h;
+      document.title = h ? h.textContent.trim() + ' · ' + fileTitle : fileTitle;
+      const hash = h ? '#' + h.id : '';
+      if (location.hash !== hash) history.replaceState(history.state, '', location.pathname + location.search + hash);
+    };
+    window.addEventListener('scroll', function() {
+      clearTimeout(titleTimer);
+      titleTimer = setTimeout(nameSection, 200);
+    }, {passive: true});
+  }
+
+  // Open in editor, at the innermost block with a data-line at the top of
+  // the window.
+  const editToggle = document.getElementById('editToggle');
+  editToggle.hidden = !config.openEditor;
+  function lineInView() {
+    let line = 1;
+    let scope = document.getElementById('content');
+    for (;;) {
+      const el = Array.from(scope.querySelectorAll('[data-line]')).find(function(el) {
+        return el.getBoundingClientRect().bottom > 0;
+      });
+      if (!el) return line;
+      line = el.dataset.line;
+      scope = el;
+    }
+  }
+  editToggle.addEventListener('click', function() {
+    fetch(pageURL('/api/edit?line=' + lineInView()), {method: 'POST', headers: {'Content-Type': 'application/json'}})
+      .then(function(r) {
+        if (!r.ok) return r.text().then(function(t) { showToast(t.trim()); });
+      });
+  });
+
+  // Timeline of dated headings ("## 2024-05-12") for meeting notes.
+  const timelineToggle = document.getElementById('timelineToggle');
+  const timelinePanel = document.getElementById('timelinePanel');
+  const timelineList = timelinePanel.querySelector('.timeline');
+  const timelinePicker = timelinePanel.querySelector('input[type="date"]');
+  function datedHeadings() {
+    return Array.from(document.querySelectorAll('.container [data-date]'));
+  }
+  function localISODate(d) {
+    return [d.getFullYear(), String(d.getMonth() + 1).padStart(2, '0'), String(d.getDate()).padStart(2, '0')].join('-');
+  }
+  function refreshTimeline() {
+    const headings = datedHeadings();
+    timelineToggle.hidden = headings.length === 0;
+    if (headings.length === 0) timelinePanel.hidden = true;
+    timelineList.innerHTML = '';
+    const today = localISODate(new Date());
+    headings.forEach(function(h) {
+      const li = document.createElement('li');
+      const a = document.createElement('a');
+      a.href = '#' + h.id;
+      a.textContent = h.textContent;
+      if (h.dataset.date === today) li.className = 'today';
+      li.appendChild(a);
+      timelineList.appendChild(li);
+    });
+  }
+  // jumpToDate scrolls to the section for date, or the nearest one after
+  // it (before it when the date is past the last entry).
+  function jumpToDate(date) {
+    const headings = datedHeadings().slice().sort(function(a, b) {
+      return a.dataset.date < b.dataset.date ? -1 : a.dataset.date > b.dataset.date ? 1 : 0;
+    });
+    if (headings.length === 0) return;
+    const target = headings.find(function(h) { return h.dataset.date >= date; }) || headings[headings.length - 1];
+    target.scrollIntoView({block: 'start'});
+    history.replaceState(null, '', '#' + target.id);
+  }
+  timelineToggle.addEventListener('click', function() {
+    timelinePanel.hidden = !timelinePanel.hidden;
+  });
+  timelinePicker.addEventListener('change', function() {
+    if (timelinePicker.value) jumpToDate(timelinePicker.value);
+  });
+  timelinePanel.querySelector('button').addEventListener('click', function() {
+    jumpToDate(localISODate(new Date()));
+  });
+  refreshTimeline();
+
+  // Accessibility: the server's audit of the document, each issue linked to
+  // the block it is in. It is fetched again on reload while the panel is open.
+  const a11yToggle = document.getElementById('a11yToggle');
+  const a11yPanel = document.getElementById('a11yPanel');
+  const a11yList = a11yPanel.querySelector('.a11y-list');
+  const a11yStatus = a11yPanel.querySelector('.a11y-status');
+  const a11yRules = {'image-alt': 'Alt text', 'link-text': 'Link text', 'contrast': 'Contrast', 'heading-order': 'Headings'};
+  // a11yBlock returns the last block starting at or before line.
+  function a11yBlock(line) {
+    let found = null;
+    document.querySelectorAll('#content [data-line]').forEach(function(el) {
+      if (Number(el.dataset.line) <= line) found = el;
+    });
+    return found;
+  }
+  function refreshA11y() {
+    if (!config.a11y || a11yPanel.hidden) return;
+    a11yPanel.querySelector('.a11y-json').href = pageURL('/api/a11y');
+    fetch(pageURL('/api/a11y')).then(r => r.json()).then(function(data) {
+      a11yList.innerHTML = '';
+      a11yStatus.textContent = data.issues.length === 0 ? 'No issues found'
+        : data.issues.length + (data.issues.length === 1 ? ' issue' : ' issues');
+      data.issues.forEach(function(issue) {
+        const li = document.createElement('li');
+        const b = document.createElement('button');
+        b.type = 'button';
+        b.textContent = 'Line ' + issue.line + ' · ' + (a11yRules[issue.rule] || issue.rule) + ': ' + issue.message;
+        b.addEventListener('click', function() {
+          const el = a11yBlock(issue.line);
+          if (!el) return;
+          el.scrollIntoView({block: 'center'});
+          el.classList.remove('a11y-flash');
+          void el.offsetWidth;
+          el.classList.add('a11y-flash');
+        });
+        li.appendChild(b);
+        a11yList.appendChild(li);
+      });
+    });
+  }
+  a11yToggle.hidden = !config.a11y;
+  a11yToggle.addEventListener('click', function() {
+    a11yPanel.hidden = !a11yPanel.hidden;
+    refreshA11y();
+  });
+
+  // --edit-tasks: ticking a task box rewrites its line in the file, and the
+  // reload that follows shows every open page the change.
+  const taskBoxes = '#content li[data-line] > input[type="checkbox"], #content li[data-line] > p > input[type="checkbox"]';
+  function enableTasks() {
+    if (!config.editTasks) return;
+    document.querySelectorAll(taskBoxes).forEach(function(box) {
+      box.disabled = false;
+      box.classList.add('task-editable');
+    });
+  }
+  document.getElementById('content').addEventListener('change', function(e) {
+    const box = e.target;
+    if (!config.editTasks || !box.matches(taskBoxes)) return;
+    box.disabled = true;
+    fetch(pageURL('/api/tasks/toggle'), {
+      method: 'POST',
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({line: Number(box.closest('li[data-line]').dataset.line), checked: box.checked})
+    }).then(function(r) {
+      if (!r.ok) return r.text().then(function(t) { throw new Error(t); });
+    }).catch(function(err) {
+      box.checked = !box.checked;
+      showToast(err.message.trim());
+    }).finally(function() { box.disabled = false; });
+  });
+  enableTasks();
+
+  // Task board: task list items as cards grouped by status tag or section.
+  // Dragging a card to another column rewrites the source (--editable).
+  const boardToggle = document.getElementById('boardToggle');
+  const board = document.getElementById('board');
+  const boardColumns = board.querySelector('.board-columns');
+  const boardGroup = board.querySelector('select[name="group"]');
+  const boardError = board.querySelector('.board-error');
+  let boardItems = [];
+  let boardStatuses = [];
+  function boardColumnsFor(items) {
+    const cols = [];
+    const byKey = {};
+    const add = function(key, title, target) {
+      if (!byKey[key]) { byKey[key] = {title: title, target: target, items: []}; cols.push(byKey[key]); }
+      return byKey[key];
+    };
+    if (boardGroup.value === 'status') {
+      boardStatuses.forEach(function(s) { add(s, s, {status: s}); });
+      items.forEach(function(it) { add(it.status, it.status, {status: it.status}).items.push(it); });
+    } else {
+      items.forEach(function(it) {
+        const key = it.file + '\n' + it.sectionLine;
+        const title = (it.section || '(no section)') + (config.searchable && it.file ? ' — ' + it.file.split(/[\\/]/).pop() : '');
+        add(key, title, {file: it.file, sectionLine: it.sectionLine}).items.push(it);
+      });
+    }
+    return cols;
+  }
+  function renderBoard() {
+    boardColumns.innerHTML = '';
+    boardColumnsFor(boardItems).forEach(function(col) {
+      const div = document.createElement('div');
+      div.className = 'board-column';
+      const h = document.createElement('h3');
+      h.textContent = col.title;
+      const ul = document.createElement('ul');
+      col.items.forEach(function(it) {
+        const li = document.createElement('li');
+        li.className = 'board-card' + (it.checked ? ' checked' : '');
+        li.textContent = it.text;
+        li.title = it.file ? it.file + ':' + it.line : 'line ' + it.line;
+        if (config.editable && it.file) {
+          li.draggable = true;
+          li.addEventListener('dragstart', function(e) {
+            e.dataTransfer.setData('text/plain', String(boardItems.indexOf(it)));
+          });
+        }
+        ul.appendChild(li);
+      });
+      div.append(h, ul);
+      if (config.editable) {
+        div.addEventListener('dragover', function(e) { e.preventDefault(); div.classList.add('drop'); });
+        div.addEventListener('dragleave', function() { div.classList.remove('drop'); });
+        div.addEventListener('drop', function(e) {
+          e.preventDefault();
+          div.classList.remove('drop');
+          const it = boardItems[Number(e.dataTransfer.getData('text/plain'))];
+          if (it) moveCard(it, col.target);
+        });
+      }
+      boardColumns.appendChild(div);
+    });
+  }
+  function moveCard(it, target) {
+    const body = {file: it.file, line: it.line, source: it.source};
+    if (target.status !== undefined) {
+      if (target.status === it.status) return;
+      body.status = target.status;
+    } else {
+      if (target.file !== it.file) { boardError.textContent = 'Cards can only move between sections of the same file.'; return; }
+      if (target.sectionLine === it.sectionLine) return;
+      body.sectionLine = target.sectionLine;
+    }
+    fetch('/api/board/move', {
+      method: 'POST',
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify(body)
+    }).then(function(r) {
+      if (!r.ok) return r.text().then(function(t) { throw new Error(t); });
+      boardError.textContent = '';
+      refreshBoard();
+    }).catch(function(err) { boardError.textContent = err.message; });
+  }
+  function refreshBoard() {
+    const hasTasks = document.querySelector('.container input[type="checkbox"]') !== null;
+    boardToggle.hidden = !hasTasks;
+    if (!hasTasks || board.hidden) return;
+    fetch('/api/board').then(r => r.json()).then(function(data) {
+      boardItems = data.items || [];
+      boardStatuses = data.statuses || [];
+      renderBoard();
+    });
+  }
+  boardToggle.addEventListener('click', function() {
+    board.hidden = !board.hidden;
+    refreshBoard();
+  });
+  board.querySelector('button[name="close"]').addEventListener('click', function() { board.hidden = true; });
+  boardGroup.addEventListener('change', renderBoard);
+  refreshBoard();
+
+  // Vim-style navigation. The --vim flag sets the default; the reader's
+  // choice is kept in localStorage ('mdview-vim').
+  function vimEnabled() {
+    const v = localStorage.getItem('mdview-vim');
+    return v === null ? config.vim : v === '1';
+  }
+  let vimPending = '';
+  let vimMatches = [];
+  const vimBar = document.createElement('input');
+  vimBar.className = 'vim-search';
+  vimBar.type = 'text';
+  vimBar.placeholder = '/search';
+  vimBar.hidden = true;
+  document.body.appendChild(vimBar);
+
+  function vimClear() {
+    vimMatches.forEach(function(m) {
+      const parent = m.parentNode;
+      if (!parent) return;
+      parent.replaceChild(document.createTextNode(m.textContent), m);
+      parent.normalize();
+    });
+    vimMatches = [];
+    setJumps('search', []);
+  }
+  function vimSearch(q) {
+    vimClear();
+    if (!q) return;
+    const needle = q.toLowerCase();
+    const walker = document.createTreeWalker(document.querySelector('.container'), NodeFilter.SHOW_TEXT);
+    const nodes = [];
+    while (walker.nextNode()) nodes.push(walker.currentNode);
+    nodes.forEach(function(n) {
+      let at;
+      while (n && (at = n.nodeValue.toLowerCase().indexOf(needle)) >= 0) {
+        const range = document.createRange();
+        range.setStart(n, at);
+        range.setEnd(n, at + q.length);
+        const mark = document.createElement('mark');
+        mark.className = 'vim-match';
+        range.surroundContents(mark);
+        vimMatches.push(mark);
+        n = mark.nextSibling && mark.nextSibling.nodeType === 3 ? mark.nextSibling : null;
+      }
+    });
+    setJumps('search', vimMatches.map(function(m) { return {el: m, label: 'Search “' + q + '”'}; }), true);
+  }
+  vimBar.addEventListener('keydown', function(e) {
+    if (e.key === 'Enter') {
+      vimSearch(vimBar.value);
+      vimBar.hidden = true;
+      vimBar.blur();
+    } else if (e.key === 'Escape') {
+      vimBar.hidden = true;
+      vimBar.blur();
+    }
+  });
+  document.addEventListener('keydown', function(e) {
+    if (!vimEnabled() || e.altKey || e.metaKey) return;
+    const t = e.target;
+    if (t.isContentEditable || /^(INPUT|TEXTAREA|SELECT)$/.test(t.tagName)) return;
+    const half = window.innerHeight / 2;
+    let handled = true;
+    if (e.ctrlKey) {
+      if (e.key === 'd') window.scrollBy(0, half);
+      else if (e.key === 'u') window.scrollBy(0, -half);
+      else handled = false;
+    } else if (e.key === 'j') window.scrollBy(0, 60);
+    else if (e.key === 'k') window.scrollBy(0, -60);
+    else if (e.key === 'G') window.scrollTo(0, document.body.scrollHeight);
+    else if (e.key === 'g') {
+      if (vimPending === 'g') { window.scrollTo(0, 0); vimPending = ''; }
+      else { vimPending = 'g'; setTimeout(function() { vimPending = ''; }, 500); }
+    }
+    else if (e.key === '/') { vimBar.hidden = false; vimBar.value = ''; vimBar.focus(); }
+    else if (e.key === 'Escape') vimClear();
+    else handled = false;
+    if (handled) e.preventDefault();
+  });
+
+  // Hover previews for links to other Markdown files or headings
+  const previewCache = {};
+  let previewTimer = null;
+  let previewEl = null;
+  function previewTarget(a) {
+    const url = new URL(a.href, location.href);
+    if (url.origin !== location.origin) return null;
+    const onPage = url.pathname === location.pathname;
+    if (!onPage && !/\.(md|markdown)$/i.test(url.pathname)) return null;
+    if (onPage && !url.hash) return null;
+    return 'path=' + encodeURIComponent(url.pathname) +
+      '&id=' + encodeURIComponent(decodeURIComponent(url.hash.slice(1)));
+  }
+  function hidePreview() {
+    clearTimeout(previewTimer);
+    if (previewEl) { previewEl.remove(); previewEl = null; }
+  }
+  function showPreview(a, data) {
+    hidePreview();
+    previewEl = document.createElement('div');
+    previewEl.className = 'link-preview';
+    const title = document.createElement('div');
+    title.className = 'link-preview-title';
+    title.textContent = data.title;
+    previewEl.appendChild(title);
+    const body = document.createElement('div');
+    body.innerHTML = data.html;
+    renderMath(body);
+    previewEl.appendChild(body);
+    document.body.appendChild(previewEl);
+    const rect = a.getBoundingClientRect();
+    previewEl.style.left = Math.max(8, Math.min(rect.left + window.scrollX,
+      window.scrollX + document.documentElement.clientWidth - previewEl.offsetWidth - 8)) + 'px';
+    previewEl.style.top = (rect.bottom + window.scrollY + 6) + 'px';
+  }
+  // A footnote reference previews its note from the page itself.
+  function footnotePreview(a) {
+    const note = document.getElementById(decodeURIComponent(a.hash.slice(1)));
+    if (!note) return null;
+    const copy = note.cloneNode(true);
+    copy.querySelectorAll('.footnote-backref').forEach(function(b) { b.remove(); });
+    return {title: 'Footnote ' + a.textContent, html: copy.innerHTML};
+  }
+  document.addEventListener('mouseover', function(e) {
+    const a = e.target.closest('.container a[href]');
+    if (!a) return;
+    if (a.classList.contains('footnote-ref')) {
+      clearTimeout(previewTimer);
+      previewTimer = setTimeout(function() {
+        const data = footnotePreview(a);
+        if (data && a.matches(':hover')) showPreview(a, data);
+      }, 150);
+      return;
+    }
+    const q = previewTarget(a);
+    if (!q) return;
+    clearTimeout(previewTimer);
+    previewTimer = setTimeout(function() {
+      if (previewCache[q]) { showPreview(a, previewCache[q]); return; }
+      fetch('/preview?' + q).then(function(r) {
+        if (!r.ok) throw new Error(r.statusText);
+        return r.json();
+      }).then(function(data) {
+        previewCache[q] = data;
+        if (a.matches(':hover')) showPreview(a, data);
+      }).catch(function() {});
+    }, 300);
+  });
+  document.addEventListener('mouseout', function(e) {
+    if (e.target.closest('.container a[href]')) hidePreview();
+  });
+
+  // Following a footnote and then its ↩ link returns to where the reader
+  // was, not just to the reference's line.
+  let footnoteReturn = null;
+  document.addEventListener('click', function(e) {
+    const a = e.target.closest('.container a.footnote-ref, .container a.footnote-backref');
+    if (!a || e.button !== 0 || e.metaKey || e.ctrlKey || e.shiftKey) return;
+    hidePreview();
+    if (a.classList.contains('footnote-ref')) {
+      footnoteReturn = {y: window.scrollY, url: location.href};
+      return;
+    }
+    if (!footnoteReturn) return;
+    e.preventDefault();
+    history.replaceState(history.state, '', footnoteReturn.url);
+    window.scrollTo(0, footnoteReturn.y);
+    footnoteReturn = null;
+  });
+
+  // Share a link to the selected text: a text fragment (#:~:text=) anchored
+  // to the nearest heading, so browsers without fragment support still land
+  // on the right section (and the fallback below highlights the text).
+  const shareBtn = document.createElement('button');
+  shareBtn.className = 'share-selection';
+  shareBtn.type = 'button';
+  shareBtn.textContent = '🔗 Copy link to selection';
+  shareBtn.hidden = true;
+  document.body.appendChild(shareBtn);
+
+  function fragmentEncode(s) {
+    return encodeURIComponent(s).replace(/-/g, '%2D').replace(/,/g, '%2C');
+  }
+  function selectionLink(sel) {
+    const words = sel.toString().trim().split(/\s+/);
+    let directive;
+    if (words.length > 8) {
+      directive = fragmentEncode(words.slice(0, 4).join(' ')) + ',' +
+        fragmentEncode(words.slice(-4).join(' '));
+    } else {
+      directive = fragmentEncode(words.join(' '));
+    }
+    let heading = null;
+    const node = sel.getRangeAt(0).startContainer;
+    const start = node.nodeType === 1 ? node : node.parentElement;
+    document.querySelectorAll('.container h1[id], .container h2[id], .container h3[id], .container h4[id], .container h5[id], .container h6[id]').forEach(function(h) {
+      if (h === start || (h.compareDocumentPosition(start) & Node.DOCUMENT_POSITION_FOLLOWING)) heading = h;
+    });
+    return location.origin + location.pathname + location.search + '#' +
+      (heading ? encodeURIComponent(heading.id) : '') + ':~:text=' + directive;
+  }
+  document.addEventListener('selectionchange', function() {
+    const sel = document.getSelection();
+    if (!sel || sel.isCollapsed || !sel.toString().trim() ||
+        !document.querySelector('.container').contains(sel.anchorNode)) {
+      shareBtn.hidden = true;
+      return;
+    }
+    const rect = sel.getRangeAt(0).getBoundingClientRect();
+    shareBtn.style.top = (rect.top + window.scrollY - 36) + 'px';
+    shareBtn.style.left = (rect.left + window.scrollX) + 'px';
+    shareBtn.hidden = false;
+  });
+  shareBtn.addEventListener('mousedown', function(e) { e.preventDefault(); });
+  shareBtn.addEventListener('click', function() {
+    const sel = document.getSelection();
+    if (!sel || sel.isCollapsed) return;
+    const link = selectionLink(sel);
+    navigator.clipboard.writeText(link).then(function() {
+      shareBtn.textContent = '✓ Link copied';
+      setTimeout(function() { shareBtn.textContent = '🔗 Copy link to selection'; }, 1500);
+    });
+  });
+
+  // Copy a section for Slack or Jira, from buttons shown on hovering its
+  // heading; the server converts the Markdown (/api/section).
+  const sectionCopy = document.createElement('div');
+  sectionCopy.className = 'section-copy';
+  sectionCopy.hidden = true;
+  [['slack', 'Slack'], ['jira', 'Jira']].forEach(function(f) {
+    const btn = document.createElement('button');
+    btn.type = 'button';
+    btn.value = f[0];
+    btn.textContent = '⧉ ' + f[1];
+    btn.title = 'Copy this section as ' + f[1] + ' formatting';
+    sectionCopy.appendChild(btn);
+  });
+  document.body.appendChild(sectionCopy);
+  let sectionHeading = null;
+  if (config.sectionCopy) {
+    document.addEventListener('mouseover', function(e) {
+      if (sectionCopy.contains(e.target)) return;
+      const h = e.target.closest('#content > h1[id], #content > h2[id], #content > h3[id], #content > h4[id]');
+      if (!h) { sectionCopy.hidden = true; return; }
+      if (h === sectionHeading && !sectionCopy.hidden) return;
+      sectionHeading = h;
+      const rect = h.getBoundingClientRect();
+      sectionCopy.hidden = false;
+      sectionCopy.style.top = (rect.top + window.scrollY + 4) + 'px';
+      sectionCopy.style.left = (rect.right + window.scrollX - sectionCopy.offsetWidth) + 'px';
+    });
+  }
+  sectionCopy.addEventListener('click', function(e) {
+    const btn = e.target.closest('button');
+    if (!btn || !sectionHeading) return;
+    const label = btn.textContent;
+    const q = new URLSearchParams({id: sectionHeading.id, format: btn.value});
+    fetch(pageURL('/api/section?' + q.toString())).then(function(res) {
+      if (!res.ok) throw new Error(res.statusText);
+      return res.text();
+    }).then(function(text) {
+      return navigator.clipboard.writeText(text);
+    }).then(function() {
+      btn.textContent = '✓ Copied';
+    }, function() {
+      btn.textContent = 'Copy failed';
+    }).then(function() {
+      setTimeout(function() { btn.textContent = label; }, 1500);
+    });
+  });
+
+  // Fallback for browsers that don't implement text fragments.
+  function highlightTextFragment() {
+    if (document.fragmentDirective) return;
+    const hash = location.hash;
+    const i = hash.indexOf(':~:text=');
+    if (i < 0) return;
+    const parts = hash.slice(i + 8).split('&')[0].split(',').map(decodeURIComponent);
+    const needle = parts[0];
+    const walker = document.createTreeWalker(document.querySelector('.container'), NodeFilter.SHOW_TEXT);
+    while (walker.nextNode()) {
+      const n = walker.currentNode;
+      const at = n.nodeValue.indexOf(needle);
+      if (at < 0) continue;
+      const range = document.createRange();
+      range.setStart(n, at);
+      range.setEnd(n, at + needle.length);
+      const mark = document.createElement('mark');
+      mark.className = 'text-fragment';
+      range.surroundContents(mark);
+      mark.scrollIntoView({block: 'center'});
+      return;
+    }
+  }
+  highlightTextFragment();
+
+  function formatDate(iso) {
+    const d = new Date(iso);
+    return d.toLocaleDateString(undefined, {year:'numeric',month:'short',day:'numeric'})
+      + ' at ' + d.toLocaleTimeString();
+  }
+{{- if .LiveReload}}
+{{template "reload.js" .}}
+{{- end}}
+})();
@@ -0,0 +1,204 @@
+  // SSE live reload
+
+  // A reload patches the content in place instead of replacing it, so the
+  // nodes that didn't change stay as they were: open <details>, the text
+  // selection, iframes and loaded images survive a save.
+  function morphChildren(from, to) {
+    const byId = {};
+    for (let n = from.firstChild; n; n = n.nextSibling) {
+      if (n.nodeType === 1 && n.id) byId[n.id] = n;
+    }
+    const used = new Set();
+    let cur = from.firstChild;
+    let next = to.firstChild;
+    while (next) {
+      const want = next;
+      next = next.nextSibling;
+      // Elements with an id are found wherever they moved; others are
+      // matched in order.
+      let match = null;
+      if (want.nodeType === 1 && want.id && byId[want.id] && !used.has(byId[want.id])) {
+        match = byId[want.id];
+      } else if (cur && sameKind(cur, want)) {
+        match = cur;
+      }
+      if (!match) {
+        from.insertBefore(want, cur);
+        continue;
+      }
+      used.add(match);
+      if (match === cur) cur = cur.nextSibling;
+      else from.insertBefore(match, cur);
+      morphNode(match, want);
+    }
+    while (cur) {
+      const n = cur.nextSibling;
+      from.removeChild(cur);
+      cur = n;
+    }
+  }
+  function sameKind(a, b) {
+    return a.nodeType === b.nodeType && a.nodeName === b.nodeName &&
+      (a.nodeType !== 1 || a.id === b.id);
+  }
+  function morphNode(from, to) {
+    if (from.nodeType !== 1) {
+      if (from.nodeValue !== to.nodeValue) from.nodeValue = to.nodeValue;
+      return;
+    }
+    // Typeset math is kept while its TeX is unchanged.
+    if ('typeset' in from.dataset && from.title === mathTeX(to) && from.className === to.className) return;
+    if (from.isEqualNode(to)) return;
+    for (const a of Array.from(from.attributes)) {
+      if (!to.hasAttribute(a.name) && !keepAttribute(from, a.name)) from.removeAttribute(a.name);
+    }
+    for (const a of Array.from(to.attributes)) {
+      if (from.getAttribute(a.name) !== a.value && !keepAttribute(from, a.name)) from.setAttribute(a.name, a.value);
+    }
+    syncFormState(from, to);
+    morphChildren(from, to);
+  }
+  // Once a control has been used, its checked, value and selected
+  // attributes no longer show; the live properties follow the source too.
+  function syncFormState(from, to) {
+    switch (from.tagName) {
+      case 'INPUT':
+        if (from.checked !== to.checked) from.checked = to.checked;
+        if (from.type !== 'file' && from.value !== to.value) from.value = to.value;
+        break;
+      case 'TEXTAREA':
+        if (from.value !== to.value) from.value = to.value;
+        break;
+      case 'OPTION':
+        if (from.selected !== to.selected) from.selected = to.selected;
+        break;
+    }
+  }
+  // The reader opening or closing a section beats the source.
+  function keepAttribute(el, name) {
+    return name === 'open' && el.tagName === 'DETAILS';
+  }
+
+  let reloading = false;
+  let reloadAgain = false;
+  function reloadContent() {
+    if (reloading) { reloadAgain = true; return; }
+    reloading = true;
+    fetch(pageURL('/raw')).then(r => r.json()).then(data => {
+      if (data.locked) { location.reload(); return; }
+      // A <template> parses without loading images or running anything.
+      const fresh = document.createElement('template');
+      fresh.innerHTML = data.html;
+      morphChildren(document.getElementById('content'), fresh.content);
+      renderMath(document.getElementById('content'));
+      restoreDetails();
+      refreshToc();
+      refreshOutline();
+      checkLinks();
+      refreshTimeline();
+      refreshBoard();
+      refreshA11y();
+      enableTasks();
+      for (const k in previewCache) delete previewCache[k];
+      const timeEl = document.querySelector('#lastModified time');
+      timeEl.setAttribute('datetime', data.lastModified);
+      timeEl.textContent = formatDate(data.lastModified);
+      showStream(data.stream);
+    }).finally(() => {
+      // Events that arrived mid-fetch collapse into one more refetch.
+      reloading = false;
+      if (reloadAgain) { reloadAgain = false; reloadContent(); }
+    });
+  }
+  // --follow: say when the producer has finished or failed.
+  const streamEl = document.getElementById('streamStatus');
+  function showStream(s) {
+    if (!s || !s.state || s.state === 'following') { streamEl.hidden = true; return; }
+    streamEl.classList.toggle('disconnected', s.state === 'error');
+    streamEl.textContent = s.state === 'error' ? 'Input failed: ' + s.error : 'Input closed — showing final output';
+    streamEl.hidden = false;
+  }
+  showStream(config.stream);
+
+  function onReload() {
+    if (settings.pauseReload) {
+      reloadPending = true;
+      return;
+    }
+    reloadContent();
+  }
+
+  // The --css stylesheet changed: load the new one, then drop the old, so
+  // the page never shows without it.
+  function onStyle(e) {
+    const old = document.getElementById('userStyle');
+    if (!old) return;
+    const link = old.cloneNode();
+    link.href = JSON.parse(e.data);
+    link.addEventListener('load', function() { old.remove(); });
+    old.after(link);
+  }
+
+  // Short notices from the server, e.g. after a profile switch.
+  const toastEl = document.getElementById('toast');
+  let toastTimer = null;
+  function onToast(e) {
+    showToast(JSON.parse(e.data));
+  }
+  function showToast(text) {
+    toastEl.textContent = text;
+    toastEl.hidden = false;
+    clearTimeout(toastTimer);
+    toastTimer = setTimeout(function() { toastEl.hidden = true; }, 4000);
+  }
+
+  // Reconnect with exponential backoff. After a restart on a new port the
+  // discovery service, when enabled, tells us where the document went.
+  const banner = document.getElementById('disconnected');
+  let evtSource = null;
+  let retryDelay = 1000;
+  let retryTimer = null;
+  let wasDisconnected = false;
+  function connect() {
+    clearTimeout(retryTimer);
+    evtSource = new EventSource('/events');
+    evtSource.addEventListener('reload', onReload);
+    evtSource.addEventListener('toast', onToast);
+    evtSource.addEventListener('style', onStyle);
+    if (config.chat) evtSource.addEventListener('chat', onChat);
+    evtSource.onopen = function() {
+      retryDelay = 1000;
+      banner.hidden = true;
+      if (wasDisconnected) {
+        wasDisconnected = false;
+        onReload();
+        if (config.chat) loadChat();
+      }
+    };
+    evtSource.onerror = function() {
+      evtSource.close();
+      wasDisconnected = true;
+      banner.hidden = false;
+      discover();
+      retryTimer = setTimeout(connect, retryDelay);
+      retryDelay = Math.min(retryDelay * 2, 30000);
+    };
+  }
+  function discover() {
+    if (!config.discoveryPort) return;
+    const q = encodeURIComponent(config.document);
+    fetch('http://localhost:' + config.discoveryPort + '/instances?document=' + q)
+      .then(r => r.ok ? r.json() : null)
+      .then(data => {
+        if (data && data.url && data.url !== location.origin) {
+          location.href = data.url + location.pathname + location.hash;
+        }
+      })
+      .catch(() => {});
+  }
+  banner.querySelector('button').addEventListener('click', function() {
+    retryDelay = 1000;
+    discover();
+    connect();
+  });
+  connect();
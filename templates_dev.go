@@ -0,0 +1,17 @@
+//go:build dev
+
+package main
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// loadTemplates parses the templates in the source tree this binary was
+// built from, so each page load picks up edits.
+func loadTemplates() (*template.Template, error) {
+	_, file, _, _ := runtime.Caller(0)
+	return parseTemplates(os.DirFS(filepath.Dir(file)))
+}
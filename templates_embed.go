@@ -0,0 +1,16 @@
+//go:build !dev
+
+package main
+
+import (
+	"embed"
+	"html/template"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var embeddedTemplates = template.Must(parseTemplates(templateFiles))
+
+// loadTemplates returns the templates embedded at build time.
+func loadTemplates() (*template.Template, error) { return embeddedTemplates, nil }
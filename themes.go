@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+
+// Themes are color palettes over the stylesheet's light and dark styles.
+// The page carries both: data-theme picks the base, so code, diagrams and
+// data trees get their dark-mode colors under Dracula too, and
+// data-palette the colors on top. --theme, or theme: in the config file,
+// is every page's default; the 🌓 toggle and the settings panel choose for
+// one browser, which keeps the choice in localStorage.
+
+// A theme is one palette.
+type theme struct {
+	Name   string `json:"name"`
+	Label  string `json:"label"`
+	Scheme string `json:"scheme"` // "light" or "dark"
+}
+
+var themes = []theme{
+	{"github", "GitHub", "light"},
+	{"github-dark", "GitHub Dark", "dark"},
+	{"solarized", "Solarized", "light"},
+	{"dracula", "Dracula", "dark"},
+	{"sepia", "Sepia", "light"},
+}
+
+// themeAliases are the names of the two styles there were before themes;
+// profiles and readers' settings still use them.
+var themeAliases = map[string]string{"light": "github", "dark": "github-dark"}
+
+// lookupTheme returns the theme called name.
+func lookupTheme(name string) (theme, bool) {
+	if alias, ok := themeAliases[name]; ok {
+		name = alias
+	}
+	for _, t := range themes {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return theme{}, false
+}
+
+// checkTheme reports an unknown theme name; "" follows the system.
+func checkTheme(name string) error {
+	if _, ok := lookupTheme(name); name != "" && !ok {
+		return fmt.Errorf("unknown theme %q", name)
+	}
+	return nil
+}
+
+var (
+	themeFlag   string // --theme
+	configTheme string // theme: in the config file, under mu
+)
+
+// defaultTheme returns the theme pages start with, "" to follow the system.
+// A profile's theme wins over it in the page.
+func defaultTheme() string {
+	if themeFlag != "" {
+		return themeFlag
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return configTheme
+}
+
+// pageTheme returns the theme named name for a page's root element, or nil
+// to follow the system.
+func pageTheme(name string) *theme {
+	if t, ok := lookupTheme(name); ok {
+		return &t
+	}
+	return nil
+}
+
+// profileTheme returns the theme a page starts with under profile p.
+func profileTheme(p profile) string {
+	if p.Theme != "" {
+		return p.Theme
+	}
+	return defaultTheme()
+}
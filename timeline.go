@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// datedHeading matches headings that start with an ISO date, the usual
+// convention for meeting notes: "## 2024-05-12" or "## 2024-05-12 Standup".
+var datedHeading = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})\b`)
+
+// timelineTransformer tags dated headings with data-date so the page can
+// offer a timeline with a date picker and "jump to today".
+type timelineTransformer struct{}
+
+func (t *timelineTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	src := reader.Source()
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		h, ok := n.(*ast.Heading)
+		if !ok {
+			continue
+		}
+		m := datedHeading.FindSubmatch(h.Text(src))
+		if m == nil {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", string(m[1])); err != nil {
+			continue
+		}
+		h.SetAttributeString("data-date", m[1])
+	}
+}
+
+var timelineAttributes = util.Prioritized(&timelineTransformer{}, 700)
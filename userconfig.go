@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ~/.config/mdview/config.toml (the user config directory, wherever the OS
+// keeps it) holds a user's defaults, so flags they always pass needn't be
+// repeated. Its keys are flag names, with - or _, set to what the flag
+// would be given; highlight, extensions and unsafe set the renderer's
+// defaults, which --config and the ⚙ menu can still change, and
+// [profiles.<name>] tables add profiles with the fields of profiles.go:
+//
+//	theme = "dracula"
+//	port = 6419
+//	browser = "firefox --new-window {url}"
+//	watch_interval = "500ms"
+//	highlight = "monokai"
+//	extensions = [
+//	  "table", "tasklist", "footnote",
+//	]
+//
+//	[profiles.print]
+//	theme = "light"
+//	font-size = 14
+//
+// A flag on the command line wins over the file, and a profile of the same
+// name in --config over one here.
+
+// userProfiles are the profiles the user config defines.
+var userProfiles map[string]profile
+
+// userConfigPath returns where the user config is, or "" when the OS has no
+// config directory.
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mdview", "config.toml")
+}
+
+// applyUserConfig sets the flags in fs not given on the command line, the
+// default renderer options and userProfiles from the config file at path.
+// A missing file is no error.
+func applyUserConfig(fs *flag.FlagSet, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		// ENOTDIR: $HOME, or a directory on the way, is a file.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	meta, err := toml.Decode(string(data), &settings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	renderer := defaultRenderer
+	var profiles map[string]profile
+	for _, k := range meta.Keys() {
+		name := k[0]
+		fail := func(err error) error { return fmt.Errorf("%s: %s: %w", path, name, err) }
+		if name == "profiles" {
+			// [profiles.x] alone doesn't list [profiles] as a key of its own.
+			if profiles == nil {
+				if profiles, err = decodeUserProfiles(data); err != nil {
+					return fail(err)
+				}
+			}
+			continue
+		}
+		if len(k) != 1 {
+			continue // inside a table
+		}
+		key := strings.ReplaceAll(name, "_", "-")
+		values, err := tomlFlagValues(settings[name])
+		if err != nil {
+			return fail(err)
+		}
+		switch key {
+		case "highlight":
+			renderer.Highlight = strings.Join(values, "")
+		case "extensions":
+			renderer.Extensions = values
+		case "unsafe":
+			if renderer.Unsafe, err = strconv.ParseBool(strings.Join(values, "")); err != nil {
+				return fail(err)
+			}
+		default:
+			if fs.Lookup(key) == nil {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+			if given[key] {
+				continue
+			}
+			for _, v := range values {
+				if err := fs.Set(key, v); err != nil {
+					return fail(err)
+				}
+			}
+		}
+	}
+	if err := renderer.validate(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, p := range profiles {
+		if err := p.renderer(renderer).validate(); err != nil {
+			return fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+		if err := checkTheme(p.Theme); err != nil {
+			return fmt.Errorf("%s: profile %s: %w", path, name, err)
+		}
+	}
+	defaultRenderer, userProfiles = renderer, profiles
+	return nil
+}
+
+// decodeUserProfiles reads the [profiles.<name>] tables of a user config.
+func decodeUserProfiles(data []byte) (map[string]profile, error) {
+	var c struct {
+		Profiles map[string]profile `toml:"profiles"`
+	}
+	meta, err := toml.Decode(string(data), &c)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range meta.Undecoded() {
+		if len(k) > 2 && k[0] == "profiles" {
+			return nil, fmt.Errorf("unknown profile setting %q", k[2])
+		}
+	}
+	return c.Profiles, nil
+}
+
+// tomlFlagValues turns a TOML value into flag arguments: one for a string,
+// number or boolean, one per element for an array.
+func tomlFlagValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		values := []string{}
+		for _, e := range v {
+			if _, ok := e.([]interface{}); ok {
+				return nil, fmt.Errorf("nested arrays are not supported")
+			}
+			ev, err := tomlFlagValues(e)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, ev...)
+		}
+		return values, nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("tables are only for profiles")
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
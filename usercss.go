@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// --css adds a stylesheet of the user's after the embedded one, to preview
+// documents with a site's own typography. It is served under /user-css/
+// with the fonts and images next to it, so its relative url()s work, and
+// watched like the inputs: an edit swaps the stylesheet in open pages
+// without touching the content. Exports inline it.
+
+// userCSSPath is --css, made absolute.
+var userCSSPath string
+
+// userCSSAssets are the files beside the stylesheet it may refer to.
+var userCSSAssets = map[string]bool{
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true,
+}
+
+// userCSSURL returns the stylesheet's URL for the page, with its
+// modification time so a change is a new URL; "" without --css.
+func userCSSURL() string {
+	if userCSSPath == "" {
+		return ""
+	}
+	var v int64
+	if info, err := os.Stat(userCSSPath); err == nil {
+		v = info.ModTime().UnixNano()
+	}
+	return fmt.Sprintf("/user-css/%s?v=%d", filepath.Base(userCSSPath), v)
+}
+
+// userCSS returns the stylesheet for exports, or nil.
+func userCSS() []byte {
+	if userCSSPath == "" {
+		return nil
+	}
+	css, err := os.ReadFile(userCSSPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mdview: %v\n", err)
+	}
+	return css
+}
+
+// handleUserCSS serves /user-css/: the stylesheet and its fonts and images.
+func handleUserCSS(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if userCSSPath == "" || strings.Contains(strings.TrimPrefix(r.URL.Path, "/user-css/"), "/") || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+	if name != filepath.Base(userCSSPath) && !userCSSAssets[strings.ToLower(path.Ext(name))] {
+		http.NotFound(w, r)
+		return
+	}
+	// Revalidated, so an edited font or image shows on the next load.
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, filepath.Join(filepath.Dir(userCSSPath), name))
+}
+
+// watchUserCSS tells open pages when the stylesheet changes.
+func watchUserCSS(ctx context.Context) {
+	defer recoverCrash()
+	s, _ := snapshotFile(userCSSPath)
+	var events <-chan fsnotify.Event
+	var poll <-chan time.Time
+	w, names := watchNative(map[string]*fileState{userCSSPath: &s})
+	if w != nil {
+		defer w.Close()
+		events = w.Events
+	} else {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	var quiet <-chan time.Time
+	changed := func(c bool, err error) {
+		if err == nil && c {
+			quiet = time.After(watchDebounce)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll:
+			changed(s.update(userCSSPath))
+		case ev := <-events:
+			if _, ok := names[filepath.Clean(ev.Name)]; ok && ev.Op != fsnotify.Chmod {
+				changed(s.reread(userCSSPath))
+			}
+		case <-quiet:
+			quiet = nil
+			bus.Publish(Event{Type: EventStyle, Data: userCSSURL()})
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Release builds set these with -ldflags, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// Plain "go build" leaves version at "dev" and takes the commit from the
+// module's VCS stamp.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+
+	// releasesURL is the GitHub API endpoint for the latest release; forks
+	// can point it elsewhere.
+	releasesURL = "https://api.github.com/repos/simonerom/mdview/releases/latest"
+	// releaseKey, when set, is the base64 Ed25519 public key that signs
+	// each release's checksums.txt (as checksums.txt.sig). Without it
+	// self-update can only tell that a download matches the checksums
+	// published beside it, not who published them, and says so.
+	releaseKey = ""
+)
+
+// unsignedWarning is shown by self-update in builds without releaseKey.
+const unsignedWarning = `WARNING: this build has no release signing key, so the download is only
+checked against the checksums.txt published next to it. Anyone able to
+publish a release can make both match. Build with -X main.releaseKey=<key>
+to require signed releases.`
+
+// buildInfo fills in commit and date from the VCS stamp when the linker
+// didn't.
+func buildInfo() (rev, date string) {
+	rev, date = commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value
+				if len(rev) > 12 {
+					rev = rev[:12]
+				}
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	return rev, date
+}
+
+// runVersion implements `mdview version`.
+func runVersion(argv []string) error {
+	fs := flag.NewFlagSet("mdview version", flag.ContinueOnError)
+	parseFlags(fs, argv)
+	rev, date := buildInfo()
+	fmt.Printf("mdview %s", version)
+	if rev != "" {
+		fmt.Printf(" (%s", rev)
+		if date != "" {
+			fmt.Printf(", %s", date)
+		}
+		fmt.Print(")")
+	}
+	fmt.Printf(" %s/%s %s\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	return nil
+}
+
+// compareVersions compares two versions like 1.4.0 or v1.5.0-rc.1 as in
+// semver, returning -1, 0 or 1; ok is false when either isn't one.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	pa, preA, okA := parseVersion(a)
+	pb, preB, okB := parseVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	// A pre-release comes before its release.
+	switch {
+	case preA == preB:
+		return 0, true
+	case preA == "":
+		return 1, true
+	case preB == "":
+		return -1, true
+	}
+	return comparePrerelease(preA, preB), true
+}
+
+// parseVersion splits [v]major.minor.patch[-pre][+build].
+func parseVersion(v string) (nums [3]int, pre string, ok bool) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return nums, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+	return nums, pre, true
+}
+
+// comparePrerelease orders pre-release identifiers: numbers numerically
+// and below words, and a shorter list first when one prefixes the other.
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errX := strconv.Atoi(as[i])
+		y, errY := strconv.Atoi(bs[i])
+		switch {
+		case errX == nil && errY == nil:
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// A release as returned by the GitHub API, with the fields self-update
+// needs.
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *release) asset(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+var updateClient = &http.Client{Timeout: 2 * time.Minute}
+
+func download(url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "mdview/"+version)
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// runSelfUpdate implements `mdview self-update`: fetch the latest release,
+// check the binary for this platform (mdview_<os>_<arch>[.exe]) against the
+// release's checksums.txt, and swap it in for the running executable.
+func runSelfUpdate(argv []string) error {
+	fs := flag.NewFlagSet("mdview self-update", flag.ContinueOnError)
+	checkOnly := fs.Bool("check", false, "only report whether a newer release exists")
+	force := fs.Bool("force", false, "install the latest release even if it is not newer (e.g. over a dev build)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdview self-update [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Replaces this binary with the latest release, if it is newer.\n\n")
+		if releaseKey == "" {
+			fmt.Fprintf(os.Stderr, "%s\n\n", unsignedWarning)
+		}
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, argv)
+
+	data, err := download(releasesURL, 1<<20)
+	if err != nil {
+		return fmt.Errorf("checking for updates: %w", err)
+	}
+	var rel release
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return fmt.Errorf("checking for updates: %w", err)
+	}
+	latest := strings.TrimPrefix(rel.Tag, "v")
+	current := strings.TrimPrefix(version, "v")
+	if _, _, ok := parseVersion(latest); !ok {
+		return fmt.Errorf("latest release %q is not a version number", rel.Tag)
+	}
+	// Only newer releases are offered, so a stale or tampered "latest"
+	// can't downgrade mdview; --force installs it anyway.
+	if cmp, ok := compareVersions(latest, current); ok && cmp <= 0 && !*force {
+		fmt.Printf("mdview %s is up to date (the latest release is %s).\n", current, latest)
+		return nil
+	}
+	if *checkOnly {
+		fmt.Printf("mdview %s is available (this is %s).\n", latest, current)
+		return nil
+	}
+	if _, _, ok := parseVersion(current); !ok && !*force {
+		return fmt.Errorf("this is a development build; use --force to replace it with release %s", latest)
+	}
+
+	name := fmt.Sprintf("mdview_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	binURL, sumsURL := rel.asset(name), rel.asset("checksums.txt")
+	if binURL == "" {
+		return fmt.Errorf("release %s has no build for %s/%s", rel.Tag, runtime.GOOS, runtime.GOARCH)
+	}
+	if sumsURL == "" {
+		return fmt.Errorf("release %s has no checksums.txt; not installing an unverified binary", rel.Tag)
+	}
+	sums, err := download(sumsURL, 1<<20)
+	if err != nil {
+		return err
+	}
+	if releaseKey != "" {
+		if err := verifySignature(&rel, sums); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "%s\n\n", unsignedWarning)
+	}
+	want, err := checksumFor(sums, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Downloading mdview %s...\n", latest)
+	bin, err := download(binURL, 256<<20)
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(bin); hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("%s: checksum mismatch, not installing", name)
+	}
+	if err := replaceExecutable(bin); err != nil {
+		return fmt.Errorf("installing: %w", err)
+	}
+	fmt.Printf("Updated mdview %s -> %s.\n", current, latest)
+	return nil
+}
+
+// verifySignature checks checksums.txt against its detached Ed25519
+// signature, raw or base64.
+func verifySignature(rel *release, sums []byte) error {
+	pub, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release key built into this binary")
+	}
+	sigURL := rel.asset("checksums.txt.sig")
+	if sigURL == "" {
+		return fmt.Errorf("release %s is not signed", rel.Tag)
+	}
+	sig, err := download(sigURL, 4<<10)
+	if err != nil {
+		return err
+	}
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err != nil {
+			return fmt.Errorf("release %s: malformed signature", rel.Tag)
+		}
+	}
+	if !ed25519.Verify(pub, sums, sig) {
+		return fmt.Errorf("release %s: bad signature on checksums.txt, not installing", rel.Tag)
+	}
+	return nil
+}
+
+// checksumFor finds name's SHA-256 in sha256sum-style output.
+func checksumFor(sums []byte, name string) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no entry for %s", name)
+}
+
+// replaceExecutable writes bin next to the running executable and renames
+// it into place. Windows won't overwrite a running binary, so the old one
+// is moved aside first.
+func replaceExecutable(bin []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".mdview-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			return errors.Join(err, os.Rename(old, exe))
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Input files are watched through the operating system's change
+// notifications (inotify, kqueue, ReadDirectoryChangesW). Network and FUSE
+// filesystems don't deliver those for changes made elsewhere, so files on
+// them, or everything with --poll, are polled instead.
+
+// watchPoll is --poll: always poll.
+var watchPoll bool
+
+// pollInterval is --watch-interval: how often polled files are stat'ed.
+var pollInterval = 100 * time.Millisecond
+
+// mtimeSlack covers filesystems with coarse timestamps (FAT has two-second
+// resolution): a file modified this recently is re-hashed on every poll, as
+// a second write may not have moved its mtime.
+const mtimeSlack = 2 * time.Second
+
+// A fileState is what the watcher last saw of an input file. Changes are
+// decided on the content hash; mtime and size only say when to re-hash.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// snapshotFile records the current state of path.
+func snapshotFile(path string) (fileState, error) {
+	var s fileState
+	_, err := s.update(path)
+	return s, err
+}
+
+// update refreshes s from path and reports whether the bytes changed, so a
+// touch or a checkout restoring identical content is not a change.
+func (s *fileState) update(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size && time.Since(s.modTime) > mtimeSlack {
+		return false, nil
+	}
+	return s.hashFile(path, info)
+}
+
+// reread is update without the mtime shortcut, for when a notification
+// says path was written.
+func (s *fileState) reread(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return s.hashFile(path, info)
+}
+
+func (s *fileState) hashFile(path string, info os.FileInfo) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	hash := sha256.Sum256(data)
+	if hash == s.hash {
+		return false, nil
+	}
+	s.hash = hash
+	return true, nil
+}
+
+// watchNative sets up notifications for the directories holding files (so
+// editors that save by renaming a new file into place are seen) and returns
+// the watcher with a map from the names its events carry to the keys of
+// files. It returns a nil watcher when the files have to be polled.
+func watchNative(files map[string]*fileState) (*fsnotify.Watcher, map[string]string) {
+	if watchPoll {
+		return nil, nil
+	}
+	names := make(map[string]string)
+	dirs := make(map[string]bool)
+	for abs := range files {
+		names[abs] = abs
+		dirs[filepath.Dir(abs)] = true
+		// A symlinked input changes where its target lives.
+		if target, err := filepath.EvalSymlinks(abs); err == nil && target != abs {
+			names[target] = abs
+			dirs[filepath.Dir(target)] = true
+		}
+	}
+	for dir := range dirs {
+		if fsType, ok := networkFilesystem(dir); ok {
+			fmt.Fprintf(os.Stderr, "mdview: %s is on a %s filesystem; polling for changes\n", dir, fsType)
+			return nil, nil
+		}
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mdview: file notifications unavailable (%v); polling for changes\n", err)
+		return nil, nil
+	}
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "mdview: watching %s: %v; polling for changes\n", dir, err)
+			w.Close()
+			return nil, nil
+		}
+	}
+	return w, names
+}
+
+// watchAlso adds path to w, which watchNative set up, recording its names
+// in names.
+func watchAlso(w *fsnotify.Watcher, names map[string]string, path string) {
+	names[path] = path
+	w.Add(filepath.Dir(path))
+	if target, err := filepath.EvalSymlinks(path); err == nil && target != path {
+		names[target] = path
+		w.Add(filepath.Dir(target))
+	}
+}
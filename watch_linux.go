@@ -0,0 +1,27 @@
+package main
+
+import "syscall"
+
+// Magic numbers of the filesystems that don't report remote changes
+// through inotify (see statfs(2)).
+var networkFilesystems = map[uint32]string{
+	0x6969:     "NFS",
+	0x517b:     "SMB",
+	0xff534d42: "CIFS",
+	0xfe534d42: "SMB2",
+	0x65735546: "FUSE",
+	0x01021997: "9P",
+	0x5346414f: "AFS",
+	0x00c36400: "Ceph",
+}
+
+// networkFilesystem reports the kind of filesystem dir is on when changes
+// to it need polling.
+func networkFilesystem(dir string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return "", false
+	}
+	name, ok := networkFilesystems[uint32(st.Type)]
+	return name, ok
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+// networkFilesystem is only detected on Linux; elsewhere --poll selects
+// polling.
+func networkFilesystem(dir string) (string, bool) { return "", false }
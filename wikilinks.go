@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// --wikilinks reads Obsidian's links between notes:
+//
+//	[[Page Name]]  [[Page Name|shown text]]  [[Page Name#Heading]]
+//	![[diagram.png]]  ![[diagram.png|300]]
+//
+// A name is looked up, case-insensitively and with or without .md, among
+// the files under the vault: the browsed directory, else --vault, else the
+// inputs' directory. When several files have the name the one nearest the
+// root wins, as in Obsidian, and a path ([[projects/Plan]]) narrows it
+// down. A link to a missing note is marked broken. Notes outside the
+// served directory can't be opened, so open mdview on the vault (or a
+// note at its root) to follow links between folders.
+
+var (
+	wikiLinks bool   // --wikilinks
+	wikiVault string // --vault, absolute
+)
+
+// vaultFlag sets --vault, which implies --wikilinks.
+type vaultFlag struct{}
+
+func (vaultFlag) String() string { return wikiVault }
+
+func (vaultFlag) Set(v string) error {
+	abs, err := filepath.Abs(v)
+	if err != nil {
+		return err
+	}
+	if !isDirectory(abs) {
+		return fmt.Errorf("%s is not a directory", v)
+	}
+	wikiVault, wikiLinks = abs, true
+	return nil
+}
+
+// wikiEmbedSize matches the size of an embedded image: 300 or 300x200.
+var wikiEmbedSize = regexp.MustCompile(`^(\d+)(?:x(\d+))?$`)
+
+// wikiIndex caches the vault's files, nearest the root first. It is read
+// again when a name isn't found, at most once a second, so new notes are
+// picked up.
+var wikiIndex struct {
+	sync.Mutex
+	root  string
+	built time.Time
+	paths []string
+}
+
+// wikiRoot returns the vault and where it is relative to the served root.
+func wikiRoot() (fsys fs.FS, root, prefix string, ok bool) {
+	switch {
+	case docStore != nil:
+		return docStore, dirRoot, "", true
+	case wikiVault != "" && baseDir != "":
+		rel, err := filepath.Rel(baseDir, wikiVault)
+		if err != nil {
+			return nil, "", "", false
+		}
+		return os.DirFS(wikiVault), wikiVault, filepath.ToSlash(rel), true
+	case baseDir != "":
+		return os.DirFS(baseDir), baseDir, "", true
+	}
+	return nil, "", "", false
+}
+
+// resolveWikiLink returns the served path of the file named name, or why
+// there is none.
+func resolveWikiLink(name string) (href, problem string) {
+	fsys, root, prefix, ok := wikiRoot()
+	if !ok {
+		return "", "no vault to look up " + name + " in"
+	}
+	key := strings.ToLower(strings.Trim(filepath.ToSlash(name), "/"))
+	wikiIndex.Lock()
+	defer wikiIndex.Unlock()
+	if wikiIndex.root != root {
+		wikiIndex.root, wikiIndex.paths, wikiIndex.built = root, nil, time.Time{}
+	}
+	rel := lookupWikiName(wikiIndex.paths, key)
+	if rel == "" && time.Since(wikiIndex.built) > time.Second {
+		wikiIndex.paths, wikiIndex.built = vaultFiles(fsys), time.Now()
+		rel = lookupWikiName(wikiIndex.paths, key)
+	}
+	if rel == "" {
+		return "", "no note named " + name
+	}
+	p := path.Join(prefix, rel)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", name + " is outside the served directory"
+	}
+	return "/" + p, ""
+}
+
+// lookupWikiName returns the first of paths named key.
+func lookupWikiName(paths []string, key string) string {
+	for _, p := range paths {
+		lp := strings.ToLower(p)
+		names := []string{lp}
+		if isMarkdown(lp) {
+			names = append(names, strings.TrimSuffix(lp, path.Ext(lp)))
+		}
+		for _, n := range names {
+			if n == key || strings.HasSuffix(n, "/"+key) {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// vaultFiles lists the files in fsys, shallowest first.
+func vaultFiles(fsys fs.FS) []string {
+	var paths []string
+	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != "." && skipDir(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	sort.SliceStable(paths, func(i, j int) bool {
+		return strings.Count(paths[i], "/") < strings.Count(paths[j], "/")
+	})
+	return paths
+}
+
+type wikiLinkParser struct{}
+
+func (p *wikiLinkParser) Trigger() []byte { return []byte{'!', '['} }
+
+func (p *wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if !wikiLinks {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	embed := line[0] == '!'
+	open := 2
+	if embed {
+		open = 3
+	}
+	if len(line) < open || !bytes.HasPrefix(line[open-2:], []byte("[[")) {
+		return nil
+	}
+	end := bytes.Index(line[open:], []byte("]]"))
+	if end <= 0 {
+		return nil
+	}
+	inner := string(line[open : open+end])
+	if strings.ContainsAny(inner, "[]\n") {
+		return nil
+	}
+	block.Advance(open + end + 2)
+
+	target, label := inner, ""
+	if i := strings.IndexByte(inner, '|'); i >= 0 {
+		// In a table the pipe is written \|.
+		target, label = strings.TrimSuffix(inner[:i], `\`), strings.TrimSpace(inner[i+1:])
+	}
+	name, heading := strings.TrimSpace(target), ""
+	if i := strings.IndexByte(name, '#'); i >= 0 {
+		name, heading = strings.TrimSpace(name[:i]), strings.TrimSpace(strings.TrimPrefix(name[i+1:], "^"))
+	}
+
+	href, problem := "", ""
+	if name != "" {
+		href, problem = resolveWikiLink(name)
+	}
+	dest := url.URL{Path: href}
+	if heading != "" {
+		dest.Fragment = slugify(heading, anchorStyle)
+	}
+
+	if embed && problem == "" && isImagePath(href) {
+		img := ast.NewImage(ast.NewLink())
+		img.Destination = []byte(dest.String())
+		alt := name
+		if m := wikiEmbedSize.FindStringSubmatch(label); m != nil {
+			img.SetAttributeString("width", []byte(m[1]))
+			if m[2] != "" {
+				img.SetAttributeString("height", []byte(m[2]))
+			}
+		} else if label != "" {
+			alt = label
+		}
+		img.AppendChild(img, ast.NewString([]byte(alt)))
+		return img
+	}
+
+	if label == "" {
+		label = name
+		switch {
+		case name == "":
+			label = heading
+		case heading != "":
+			label = name + " > " + heading
+		}
+	}
+	link := ast.NewLink()
+	link.AppendChild(link, ast.NewString([]byte(label)))
+	link.SetAttributeString("class", []byte("wikilink"))
+	if problem != "" {
+		// Where Obsidian would create the note.
+		missing := "/" + name
+		if path.Ext(name) == "" {
+			missing += ".md"
+		}
+		link.Destination = []byte((&url.URL{Path: missing}).String())
+		link.Title = []byte(problem)
+		link.SetAttributeString("class", []byte("wikilink broken-link"))
+		return link
+	}
+	link.Destination = []byte(dest.String())
+	return link
+}
+
+// isImagePath reports whether p names an image a page can show.
+func isImagePath(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".bmp":
+		return true
+	}
+	return false
+}
+
+type wikiLinkExtension struct{}
+
+// WikiLinks is a goldmark.Extender parsing [[wiki links]] with --wikilinks.
+var WikiLinks goldmark.Extender = &wikiLinkExtension{}
+
+func (e *wikiLinkExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		// Ahead of citations and the link parser, which also start at '['.
+		parser.WithInlineParsers(util.Prioritized(&wikiLinkParser{}, 140)),
+	)
+}